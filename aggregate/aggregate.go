@@ -0,0 +1,299 @@
+package aggregate
+
+import (
+	"errors"
+	"fmt"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"sort"
+	"time"
+)
+
+// MaxBuckets caps how many buckets a single series may produce, so a tiny interval over a long range can't exhaust memory.
+const MaxBuckets = 100000
+
+type Function string
+
+const (
+	Min   Function = "min"
+	Max   Function = "max"
+	Avg   Function = "avg"
+	Sum   Function = "sum"
+	Count Function = "count"
+	First Function = "first"
+	Last  Function = "last"
+)
+
+var functions = map[Function]struct{}{Min: {}, Max: {}, Avg: {}, Sum: {}, Count: {}, First: {}, Last: {}}
+
+// Fill decides what an interval without samples turns into.
+type Fill string
+
+const (
+	FillNone     Fill = "none"     // empty buckets are dropped
+	FillNull     Fill = "null"     // empty buckets are kept without values
+	FillZero     Fill = "zero"     // empty buckets are zero
+	FillPrevious Fill = "previous" // empty buckets carry the previous bucket forward
+	FillLinear   Fill = "linear"   // empty buckets are interpolated between their neighbours
+)
+
+// Request extends dto.HistoryRequest with the aggregation to apply to every matched point.
+type Request struct {
+	Filter        *string    `json:"filter"`
+	FilterHistory *string    `json:"filter_history"`
+	Functions     []Function `json:"functions"`
+	Interval      string     `json:"interval"` // Go duration, e.g. 15m or 1h
+	Start         *time.Time `json:"start,omitempty"`
+	End           *time.Time `json:"end,omitempty"`
+	Fill          Fill       `json:"fill,omitempty"`
+}
+
+type Bucket struct {
+	Timestamp time.Time             `json:"timestamp"`
+	Values    map[Function]*float64 `json:"values"`
+}
+
+type Series struct {
+	HostUUID    string    `json:"host_uuid"`
+	HostName    string    `json:"host_name"`
+	NetworkName string    `json:"network_name"`
+	DeviceName  string    `json:"device_name"`
+	PointUUID   string    `json:"point_uuid"`
+	PointName   string    `json:"point_name"`
+	Buckets     []*Bucket `json:"buckets"`
+}
+
+type Response struct {
+	Data []*Series `json:"data"`
+}
+
+func (r *Request) Validate() (time.Duration, error) {
+	if len(r.Functions) == 0 {
+		return 0, errors.New("at least one aggregate function is required")
+	}
+	for _, f := range r.Functions {
+		if _, ok := functions[f]; !ok {
+			return 0, fmt.Errorf("unsupported aggregate function: %s", f)
+		}
+	}
+	interval, err := time.ParseDuration(r.Interval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid interval: %s", err)
+	}
+	if interval <= 0 {
+		return 0, errors.New("interval must be positive")
+	}
+	switch r.Fill {
+	case "", FillNone, FillNull, FillZero, FillPrevious, FillLinear:
+	default:
+		return 0, fmt.Errorf("unsupported fill: %s", r.Fill)
+	}
+	return interval, nil
+}
+
+// HistoryRequest is the raw request the aggregation runs on.
+func (r *Request) HistoryRequest() *dto.HistoryRequest {
+	return &dto.HistoryRequest{Filter: r.Filter, FilterHistory: r.FilterHistory}
+}
+
+// Compute aggregates every point of a raw history response.
+func Compute(histories *dto.HistoryResponse, r *Request) (*Response, error) {
+	if _, err := r.Validate(); err != nil {
+		return nil, err
+	}
+	response := &Response{Data: []*Series{}}
+	if histories == nil {
+		return response, nil
+	}
+	for _, data := range histories.Data {
+		buckets, err := Aggregate(data.Values, r)
+		if err != nil {
+			return nil, err
+		}
+		response.Data = append(response.Data, &Series{
+			HostUUID:    data.HostUUID,
+			HostName:    data.HostName,
+			NetworkName: data.NetworkName,
+			DeviceName:  data.DeviceName,
+			PointUUID:   data.PointUUID,
+			PointName:   data.PointName,
+			Buckets:     buckets,
+		})
+	}
+	return response, nil
+}
+
+// Aggregate groups the samples in buckets of the request interval, aligned to the interval, and fills the gaps.
+func Aggregate(values []*dto.HistoryValue, r *Request) ([]*Bucket, error) {
+	interval, err := r.Validate()
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]*dto.HistoryValue, 0, len(values))
+	for _, v := range values {
+		if v == nil || (r.Start != nil && v.Timestamp.Before(*r.Start)) || (r.End != nil && !v.Timestamp.Before(*r.End)) {
+			continue
+		}
+		samples = append(samples, v)
+	}
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Timestamp.Before(samples[j].Timestamp) })
+
+	var start, end time.Time
+	if r.Start != nil {
+		start = r.Start.Truncate(interval)
+	} else if len(samples) > 0 {
+		start = samples[0].Timestamp.Truncate(interval)
+	}
+	if r.End != nil {
+		end = *r.End
+	} else if len(samples) > 0 {
+		end = samples[len(samples)-1].Timestamp.Add(time.Nanosecond)
+	}
+	if !start.Before(end) {
+		return []*Bucket{}, nil
+	}
+	count := end.Sub(start) / interval
+	if end.Sub(start)%interval != 0 {
+		count++
+	}
+	if count > MaxBuckets {
+		return nil, fmt.Errorf("interval %s over %s gives %d buckets, the max is %d", interval, end.Sub(start), count, MaxBuckets)
+	}
+
+	buckets := make([]*Bucket, 0, count)
+	i := 0
+	for ts := start; ts.Before(end); ts = ts.Add(interval) {
+		bucketEnd := ts.Add(interval)
+		var in []*dto.HistoryValue
+		for i < len(samples) && samples[i].Timestamp.Before(bucketEnd) {
+			in = append(in, samples[i])
+			i++
+		}
+		var bucket *Bucket
+		if len(in) > 0 {
+			bucket = &Bucket{Timestamp: ts, Values: reduce(in, r.Functions)}
+		} else {
+			bucket = &Bucket{Timestamp: ts, Values: nil}
+		}
+		buckets = append(buckets, bucket)
+	}
+	return fill(buckets, r.Functions, r.Fill), nil
+}
+
+func reduce(samples []*dto.HistoryValue, fns []Function) map[Function]*float64 {
+	min, max, sum := samples[0].Value, samples[0].Value, 0.0
+	for _, s := range samples {
+		if s.Value < min {
+			min = s.Value
+		}
+		if s.Value > max {
+			max = s.Value
+		}
+		sum += s.Value
+	}
+	count := float64(len(samples))
+	out := make(map[Function]*float64, len(fns))
+	for _, f := range fns {
+		var v float64
+		switch f {
+		case Min:
+			v = min
+		case Max:
+			v = max
+		case Avg:
+			v = sum / count
+		case Sum:
+			v = sum
+		case Count:
+			v = count
+		case First:
+			v = samples[0].Value
+		case Last:
+			v = samples[len(samples)-1].Value
+		}
+		out[f] = &v
+	}
+	return out
+}
+
+func fill(buckets []*Bucket, fns []Function, mode Fill) []*Bucket {
+	switch mode {
+	case FillNull:
+		for _, b := range buckets {
+			if b.Values == nil {
+				b.Values = map[Function]*float64{}
+				for _, f := range fns {
+					b.Values[f] = nil
+				}
+			}
+		}
+		return buckets
+	case FillZero:
+		for _, b := range buckets {
+			if b.Values == nil {
+				b.Values = constant(fns, 0)
+			}
+		}
+		return buckets
+	case FillPrevious:
+		var previous map[Function]*float64
+		filled := make([]*Bucket, 0, len(buckets))
+		for _, b := range buckets {
+			if b.Values == nil {
+				if previous == nil {
+					continue
+				}
+				b.Values = previous
+			}
+			previous = b.Values
+			filled = append(filled, b)
+		}
+		return filled
+	case FillLinear:
+		return interpolate(buckets, fns)
+	default:
+		filled := make([]*Bucket, 0, len(buckets))
+		for _, b := range buckets {
+			if b.Values != nil {
+				filled = append(filled, b)
+			}
+		}
+		return filled
+	}
+}
+
+// interpolate fills the empty buckets between two known ones, leading and trailing gaps are dropped.
+func interpolate(buckets []*Bucket, fns []Function) []*Bucket {
+	filled := make([]*Bucket, 0, len(buckets))
+	previous := -1
+	for i, b := range buckets {
+		if b.Values == nil {
+			continue
+		}
+		if previous >= 0 {
+			from, to := buckets[previous], b
+			span := float64(to.Timestamp.Sub(from.Timestamp))
+			for j := previous + 1; j < i; j++ {
+				ratio := float64(buckets[j].Timestamp.Sub(from.Timestamp)) / span
+				values := make(map[Function]*float64, len(fns))
+				for _, f := range fns {
+					v := *from.Values[f] + (*to.Values[f]-*from.Values[f])*ratio
+					values[f] = &v
+				}
+				buckets[j].Values = values
+				filled = append(filled, buckets[j])
+			}
+		}
+		filled = append(filled, b)
+		previous = i
+	}
+	return filled
+}
+
+func constant(fns []Function, value float64) map[Function]*float64 {
+	out := make(map[Function]*float64, len(fns))
+	for _, f := range fns {
+		v := value
+		out[f] = &v
+	}
+	return out
+}
@@ -0,0 +1,70 @@
+package aggregate
+
+import (
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func values(start time.Time, offsets []time.Duration, vs []float64) []*dto.HistoryValue {
+	var out []*dto.HistoryValue
+	for i, o := range offsets {
+		out = append(out, &dto.HistoryValue{Timestamp: start.Add(o), Value: vs[i]})
+	}
+	return out
+}
+
+func TestAggregate(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	samples := values(start,
+		[]time.Duration{0, 5 * time.Minute, 10 * time.Minute, 45 * time.Minute},
+		[]float64{1, 3, 5, 20})
+
+	buckets, err := Aggregate(samples, &Request{Functions: []Function{Min, Max, Avg, Count, First, Last}, Interval: "15m"})
+	assert.NoError(t, err)
+	assert.Len(t, buckets, 2)
+	assert.Equal(t, start, buckets[0].Timestamp)
+	assert.Equal(t, 1.0, *buckets[0].Values[Min])
+	assert.Equal(t, 5.0, *buckets[0].Values[Max])
+	assert.Equal(t, 3.0, *buckets[0].Values[Avg])
+	assert.Equal(t, 3.0, *buckets[0].Values[Count])
+	assert.Equal(t, 5.0, *buckets[0].Values[Last])
+	assert.Equal(t, start.Add(45*time.Minute), buckets[1].Timestamp)
+}
+
+func TestAggregateFill(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	samples := values(start, []time.Duration{0, 45 * time.Minute}, []float64{0, 30})
+
+	buckets, err := Aggregate(samples, &Request{Functions: []Function{Avg}, Interval: "15m", Fill: FillLinear})
+	assert.NoError(t, err)
+	assert.Len(t, buckets, 4)
+	assert.Equal(t, 10.0, *buckets[1].Values[Avg])
+	assert.Equal(t, 20.0, *buckets[2].Values[Avg])
+
+	buckets, err = Aggregate(samples, &Request{Functions: []Function{Avg}, Interval: "15m", Fill: FillPrevious})
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, *buckets[2].Values[Avg])
+
+	buckets, err = Aggregate(samples, &Request{Functions: []Function{Avg}, Interval: "15m", Fill: FillNull})
+	assert.NoError(t, err)
+	assert.Len(t, buckets, 4)
+	assert.Nil(t, buckets[1].Values[Avg])
+
+	_, err = Aggregate(samples, &Request{Functions: []Function{"median"}, Interval: "15m"})
+	assert.Error(t, err)
+}
+
+func TestAggregateMaxBuckets(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(365 * 24 * time.Hour)
+	samples := values(start, []time.Duration{0}, []float64{1})
+
+	_, err := Aggregate(samples, &Request{Functions: []Function{Avg}, Interval: "1s", Start: &start, End: &end})
+	assert.Error(t, err)
+
+	buckets, err := Aggregate(samples, &Request{Functions: []Function{Avg}, Interval: "1h", Start: &start, End: &end})
+	assert.NoError(t, err)
+	assert.Len(t, buckets, 1)
+}
@@ -0,0 +1,144 @@
+package alertrule
+
+import (
+	"fmt"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type fakeMarshaller struct {
+	nmodule.Marshaller
+	alerts   map[string]*model.Alert
+	onCreate func()
+}
+
+func (f *fakeMarshaller) CreateAlert(body *model.Alert, opts ...*nmodule.Opts) (*model.Alert, error) {
+	if f.onCreate != nil {
+		f.onCreate()
+	}
+	body.UUID = fmt.Sprintf("alt_%d", len(f.alerts))
+	f.alerts[body.UUID] = body
+	return body, nil
+}
+
+func (f *fakeMarshaller) GetAlert(uuid string, opts ...*nmodule.Opts) (*model.Alert, error) {
+	return f.alerts[uuid], nil
+}
+
+func (f *fakeMarshaller) UpdateAlertStatus(uuid string, body *dto.AlertStatus, opts ...*nmodule.Opts) (*model.Alert, error) {
+	f.alerts[uuid].Status = datatype.AlertStatus(body.Status)
+	return f.alerts[uuid], nil
+}
+
+func (f *fakeMarshaller) GetAlerts(opts ...*nmodule.Opts) ([]*model.Alert, error) {
+	var alerts []*model.Alert
+	for _, a := range f.alerts {
+		alerts = append(alerts, a)
+	}
+	return alerts, nil
+}
+
+func newEngine() (*Engine, *fakeMarshaller, *time.Time) {
+	m := &fakeMarshaller{alerts: map[string]*model.Alert{}}
+	e := New(m)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	e.now = func() time.Time { return now }
+	return e, m, &now
+}
+
+func TestThresholdHysteresisAndAutoClose(t *testing.T) {
+	e, m, now := newEngine()
+	high := 30.0
+	assert.NoError(t, e.AddRule(&Rule{Name: "hot", Kind: Threshold, PointUUID: "pnt", High: &high, Hysteresis: 2,
+		AutoClose: true}))
+
+	assert.NoError(t, e.Update("pnt", 31, *now))
+	uuid, ok := e.Active("hot")
+	assert.True(t, ok)
+	assert.Equal(t, []*model.Tag{{Tag: "alert-rule:hot"}}, m.alerts[uuid].Tags)
+
+	assert.NoError(t, e.Update("pnt", 29, *now))
+	assert.NoError(t, e.Update("pnt", 31, *now))
+	assert.Len(t, m.alerts, 1, "within hysteresis and de-duplicated")
+
+	assert.NoError(t, e.Update("pnt", 27.5, *now))
+	_, ok = e.Active("hot")
+	assert.False(t, ok)
+	assert.Equal(t, datatype.AlertStatusClosed, m.alerts[uuid].Status)
+}
+
+func TestRateOfChange(t *testing.T) {
+	e, m, now := newEngine()
+	assert.NoError(t, e.AddRule(&Rule{Name: "jump", Kind: RateOfChange, PointUUID: "pnt", Rate: 5, RatePer: time.Minute}))
+	assert.NoError(t, e.Update("pnt", 10, *now))
+	assert.NoError(t, e.Update("pnt", 12, now.Add(time.Minute)))
+	assert.Empty(t, m.alerts)
+	assert.NoError(t, e.Update("pnt", 20, now.Add(2*time.Minute)))
+	assert.Len(t, m.alerts, 1)
+}
+
+func TestStaleAndTimeInState(t *testing.T) {
+	e, m, now := newEngine()
+	assert.NoError(t, e.AddRule(&Rule{Name: "stale", Kind: Stale, PointUUID: "a", StaleAfter: time.Minute, AutoClose: true}))
+	assert.NoError(t, e.AddRule(&Rule{Name: "open", Kind: TimeInState, PointUUID: "b", State: true, For: 10 * time.Minute}))
+
+	assert.NoError(t, e.Update("b", 1, *now))
+	*now = now.Add(2 * time.Minute)
+	assert.NoError(t, e.Check())
+	_, ok := e.Active("stale")
+	assert.True(t, ok)
+	assert.Equal(t, datatype.AlertTypeFlatLine, m.alerts["alt_0"].Type)
+	_, ok = e.Active("open")
+	assert.False(t, ok)
+
+	assert.NoError(t, e.Update("a", 1, *now))
+	_, ok = e.Active("stale")
+	assert.False(t, ok)
+
+	*now = now.Add(9 * time.Minute)
+	assert.NoError(t, e.Check())
+	_, ok = e.Active("open")
+	assert.True(t, ok)
+}
+
+func TestLoadKeepsOpenAlerts(t *testing.T) {
+	e, m, now := newEngine()
+	m.alerts["alt_x"] = &model.Alert{UUID: "alt_x", Status: datatype.AlertStatusActive,
+		Tags: []*model.Tag{{Tag: Tag("alarm")}}}
+	assert.NoError(t, e.AddRule(&Rule{Name: "alarm", Kind: Boolean, PointUUID: "pnt", State: true}))
+	assert.NoError(t, e.Load())
+	assert.NoError(t, e.Update("pnt", 1, *now))
+	assert.Len(t, m.alerts, 1)
+	uuid, _ := e.Active("alarm")
+	assert.Equal(t, "alt_x", uuid)
+}
+
+func TestRearmAfterClosedOnHost(t *testing.T) {
+	e, m, now := newEngine()
+	assert.NoError(t, e.AddRule(&Rule{Name: "alarm", Kind: Boolean, PointUUID: "pnt", State: true}))
+	// the engine must not hold its lock while it calls the host
+	m.onCreate = func() { e.Active("alarm") }
+
+	assert.NoError(t, e.Update("pnt", 1, *now))
+	uuid, ok := e.Active("alarm")
+	assert.True(t, ok)
+
+	// still in alarm, closing it on the host doesn't re-arm the rule yet
+	m.alerts[uuid].Status = datatype.AlertStatusClosed
+	assert.NoError(t, e.Check())
+	_, ok = e.Active("alarm")
+	assert.True(t, ok)
+
+	assert.NoError(t, e.Update("pnt", 0, *now))
+	assert.NoError(t, e.Check())
+	_, ok = e.Active("alarm")
+	assert.False(t, ok)
+
+	assert.NoError(t, e.Update("pnt", 1, *now))
+	assert.Len(t, m.alerts, 2)
+}
@@ -0,0 +1,287 @@
+package alertrule
+
+import (
+	"fmt"
+	"github.com/NubeIO/lib-module-go/loop"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/nargs"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ruleState struct {
+	rule         *Rule
+	alertUUID    string // set while an alert raised by the rule is open
+	pendingSince time.Time
+	met          bool // the condition held on the last evaluation
+	busy         bool // a host call for the rule is in flight
+	hasValue     bool
+	lastValue    float64
+	lastTime     time.Time
+	lastUpdate   time.Time
+}
+
+// action is a host call decided under the lock and made after it is released.
+type action struct {
+	state     *ruleState
+	raise     *model.Alert // the alert to create, otherwise alertUUID is closed or, with refresh, looked up
+	alertUUID string
+	refresh   bool
+}
+
+func (s *ruleState) active() bool {
+	return s.alertUUID != ""
+}
+
+// Engine evaluates rules against point updates and raises and clears alerts through the marshaller.
+type Engine struct {
+	marshaller nmodule.Marshaller
+
+	mutex sync.Mutex
+	rules map[string]*ruleState // by rule name
+	loop  loop.Loop
+	now   func() time.Time
+}
+
+func New(marshaller nmodule.Marshaller) *Engine {
+	return &Engine{
+		marshaller: marshaller,
+		rules:      map[string]*ruleState{},
+		now:        time.Now,
+	}
+}
+
+// AddRule adds or replaces a rule, the open alert of a replaced rule is kept.
+func (e *Engine) AddRule(rule *Rule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	state := &ruleState{rule: rule, lastUpdate: e.now()}
+	if old, ok := e.rules[rule.Name]; ok {
+		state.alertUUID = old.alertUUID
+	}
+	e.rules[rule.Name] = state
+	return nil
+}
+
+func (e *Engine) RemoveRule(name string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	delete(e.rules, name)
+}
+
+// Load picks up the open alerts of the rules from the host, so a restart doesn't raise them again.
+func (e *Engine) Load() error {
+	alerts, err := e.marshaller.GetAlerts(&nmodule.Opts{Args: &nargs.Args{WithTags: true}})
+	if err != nil {
+		return err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for _, alert := range alerts {
+		if alert.Status == datatype.AlertStatusClosed {
+			continue
+		}
+		for _, tag := range alert.Tags {
+			if !strings.HasPrefix(tag.Tag, Tag("")) {
+				continue
+			}
+			if state, ok := e.rules[strings.TrimPrefix(tag.Tag, Tag(""))]; ok {
+				state.alertUUID = alert.UUID
+			}
+		}
+	}
+	return nil
+}
+
+// Update evaluates the rules of the point against a new value.
+func (e *Engine) Update(pointUUID string, value float64, timestamp time.Time) error {
+	e.mutex.Lock()
+	if timestamp.IsZero() {
+		timestamp = e.now()
+	}
+	var actions []*action
+	for _, state := range e.rules {
+		if state.rule.PointUUID != pointUUID {
+			continue
+		}
+		rule := state.rule
+		rate := math.NaN()
+		if state.hasValue && timestamp.After(state.lastTime) {
+			rate = (value - state.lastValue) / float64(timestamp.Sub(state.lastTime)) * float64(rule.ratePer())
+		}
+		state.hasValue = true
+		state.lastValue = value
+		state.lastTime = timestamp
+		state.lastUpdate = e.now()
+
+		met := false
+		if rule.Kind != Stale {
+			met = rule.evaluate(state.active(), value, rate)
+		}
+		if a := e.decide(state, met, fmt.Sprintf("value %g", value)); a != nil {
+			actions = append(actions, a)
+		}
+	}
+	e.mutex.Unlock()
+	return e.run(actions)
+}
+
+// Check evaluates the time based conditions: stale values and conditions waiting for their For duration. The open
+// alerts of rules without AutoClose are looked up once the condition cleared, so the rule fires again after the alert
+// was closed on the host.
+func (e *Engine) Check() error {
+	e.mutex.Lock()
+	now := e.now()
+	var actions []*action
+	for _, state := range e.rules {
+		var a *action
+		switch {
+		case state.rule.Kind == Stale:
+			met := now.Sub(state.lastUpdate) > state.rule.StaleAfter
+			a = e.decide(state, met, fmt.Sprintf("no update since %s", state.lastUpdate.Format(time.RFC3339)))
+		case !state.pendingSince.IsZero() && !state.active():
+			a = e.decide(state, true, fmt.Sprintf("value %g", state.lastValue))
+		case state.active() && !state.met && !state.rule.AutoClose && !state.busy:
+			state.busy = true
+			a = &action{state: state, alertUUID: state.alertUUID, refresh: true}
+		}
+		if a != nil {
+			actions = append(actions, a)
+		}
+	}
+	e.mutex.Unlock()
+	return e.run(actions)
+}
+
+// decide updates the rule state and returns the host call raising or clearing its alert, it must be called with the
+// lock held.
+func (e *Engine) decide(state *ruleState, met bool, detail string) *action {
+	now := e.now()
+	state.met = met
+	if state.busy {
+		return nil
+	}
+	if !met {
+		state.pendingSince = time.Time{}
+		if state.active() && state.rule.AutoClose {
+			state.busy = true
+			return &action{state: state, alertUUID: state.alertUUID}
+		}
+		return nil
+	}
+	if state.active() {
+		return nil
+	}
+	if state.pendingSince.IsZero() {
+		state.pendingSince = now
+	}
+	if now.Sub(state.pendingSince) < state.rule.For {
+		return nil
+	}
+	state.busy = true
+	return &action{state: state, raise: e.alert(state.rule, detail)}
+}
+
+func (e *Engine) alert(rule *Rule, detail string) *model.Alert {
+	body := rule.Body
+	if body == "" {
+		body = fmt.Sprintf("%s: %s", rule.title(), detail)
+	}
+	return &model.Alert{
+		EntityType: datatype.AlertEntityTypePoint,
+		EntityUUID: rule.PointUUID,
+		Type:       rule.alertType(),
+		Status:     datatype.AlertStatusActive,
+		Severity:   rule.Severity,
+		Title:      rule.title(),
+		Body:       body,
+		Tags:       []*model.Tag{{Tag: Tag(rule.Name)}},
+	}
+}
+
+// run makes the host calls without the lock and records their outcome, a failed call is decided again on the next
+// update or check.
+func (e *Engine) run(actions []*action) error {
+	var firstErr error
+	for _, a := range actions {
+		alertUUID, err := e.call(a)
+		e.mutex.Lock()
+		a.state.busy = false
+		if err == nil {
+			a.state.alertUUID = alertUUID
+			if a.raise != nil {
+				a.state.pendingSince = time.Time{}
+			}
+			// a rule replaced meanwhile keeps the alert, like AddRule does
+			if current, ok := e.rules[a.state.rule.Name]; ok && current != a.state {
+				current.alertUUID = alertUUID
+			}
+		}
+		e.mutex.Unlock()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// call returns the uuid of the alert left open for the rule.
+func (e *Engine) call(a *action) (string, error) {
+	if a.raise != nil {
+		alert, err := e.marshaller.CreateAlert(a.raise)
+		if err != nil {
+			return "", err
+		}
+		return alert.UUID, nil
+	}
+	if a.refresh {
+		alert, err := e.marshaller.GetAlert(a.alertUUID)
+		if err != nil {
+			return a.alertUUID, err
+		}
+		if alert.Status == datatype.AlertStatusClosed {
+			return "", nil
+		}
+		return a.alertUUID, nil
+	}
+	_, err := e.marshaller.UpdateAlertStatus(a.alertUUID, &dto.AlertStatus{
+		Status: string(datatype.AlertStatusClosed),
+	})
+	if err != nil {
+		return a.alertUUID, err
+	}
+	return "", nil
+}
+
+// Active returns the uuid of the open alert of a rule.
+func (e *Engine) Active(ruleName string) (string, bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	state, ok := e.rules[ruleName]
+	if !ok || !state.active() {
+		return "", false
+	}
+	return state.alertUUID, true
+}
+
+// Start calls Check every tick until Stop.
+func (e *Engine) Start(tick time.Duration) error {
+	return e.loop.Start(tick, false, func() {
+		if err := e.Check(); err != nil {
+			log.Errorf("alertrule: %s", err)
+		}
+	})
+}
+
+func (e *Engine) Stop() {
+	e.loop.Stop()
+}
@@ -0,0 +1,124 @@
+package alertrule
+
+import (
+	"errors"
+	"fmt"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
+	"math"
+	"time"
+)
+
+type Kind string
+
+const (
+	Threshold    Kind = "threshold"      // value above High or below Low
+	RateOfChange Kind = "rate-of-change" // value changes faster than Rate per RatePer
+	Stale        Kind = "stale"          // no update within StaleAfter
+	Boolean      Kind = "boolean"        // value is in State
+	TimeInState  Kind = "time-in-state"  // value is in State for at least For
+)
+
+type Rule struct {
+	Name      string // unique, alerts are tagged with it to de-duplicate them
+	Kind      Kind
+	PointUUID string
+	Severity  datatype.AlertSeverity
+	Title     string // defaults to the rule name
+	Body      string
+
+	High       *float64
+	Low        *float64
+	Hysteresis float64 // how far back past the limit a threshold or rate has to go to clear
+	Rate       float64
+	RatePer    time.Duration // a minute when zero
+	StaleAfter time.Duration
+	State      bool          // the alarm state of boolean and time-in-state rules, a value != 0 is true
+	For        time.Duration // the condition must hold this long before the alert is raised
+	AutoClose  bool          // close the alert once the condition clears
+}
+
+func (r *Rule) Validate() error {
+	if r.Name == "" {
+		return errors.New("rule name is required")
+	}
+	if r.PointUUID == "" {
+		return fmt.Errorf("rule %s has no point", r.Name)
+	}
+	if r.Hysteresis < 0 || r.For < 0 {
+		return fmt.Errorf("rule %s can't have a negative hysteresis or duration", r.Name)
+	}
+	switch r.Kind {
+	case Threshold:
+		if r.High == nil && r.Low == nil {
+			return fmt.Errorf("threshold rule %s needs a high or low limit", r.Name)
+		}
+		if r.High != nil && r.Low != nil && *r.Low >= *r.High {
+			return fmt.Errorf("threshold rule %s has low limit above high limit", r.Name)
+		}
+	case RateOfChange:
+		if r.Rate <= 0 {
+			return fmt.Errorf("rate of change rule %s needs a positive rate", r.Name)
+		}
+	case Stale:
+		if r.StaleAfter <= 0 {
+			return fmt.Errorf("stale rule %s needs a stale after duration", r.Name)
+		}
+	case Boolean:
+	case TimeInState:
+		if r.For <= 0 {
+			return fmt.Errorf("time in state rule %s needs a duration", r.Name)
+		}
+	default:
+		return fmt.Errorf("rule %s has unknown kind %s", r.Name, r.Kind)
+	}
+	return nil
+}
+
+// evaluate returns whether the condition is met for the value. rate is the change per RatePer since the previous
+// value, NaN when there is none.
+func (r *Rule) evaluate(active bool, value, rate float64) bool {
+	switch r.Kind {
+	case Threshold:
+		if active {
+			return (r.High != nil && value > *r.High-r.Hysteresis) || (r.Low != nil && value < *r.Low+r.Hysteresis)
+		}
+		return (r.High != nil && value > *r.High) || (r.Low != nil && value < *r.Low)
+	case RateOfChange:
+		if math.IsNaN(rate) {
+			return active
+		}
+		if active {
+			return math.Abs(rate) > r.Rate-r.Hysteresis
+		}
+		return math.Abs(rate) > r.Rate
+	case Boolean, TimeInState:
+		return (value != 0) == r.State
+	}
+	return false
+}
+
+func (r *Rule) ratePer() time.Duration {
+	if r.RatePer <= 0 {
+		return time.Minute
+	}
+	return r.RatePer
+}
+
+func (r *Rule) alertType() datatype.AlertType {
+	if r.Kind == Stale {
+		return datatype.AlertTypeFlatLine
+	}
+	return datatype.AlertTypeThreshold
+}
+
+func (r *Rule) title() string {
+	if r.Title == "" {
+		return r.Name
+	}
+	return r.Title
+}
+
+// Tag is the alert tag which links an alert to its rule.
+func Tag(ruleName string) string {
+	return "alert-rule:" + ruleName
+}
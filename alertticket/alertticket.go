@@ -0,0 +1,302 @@
+package alertticket
+
+import (
+	"errors"
+	"fmt"
+	"github.com/NubeIO/lib-module-go/loop"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/lib-module-go/ticketflow"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/nargs"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// The alert side of the link is kept in alert meta-tags, the ticket side is Ticket.AlertUUID as tickets have no tags.
+const (
+	TicketMetaTag = "ticket_uuid"
+	StatusMetaTag = "ticket_alert_status" // the alert status last mirrored to the ticket
+)
+
+var defaultPriorities = map[datatype.AlertSeverity]datatype.TicketPriority{
+	datatype.AlertSeverityCrucial: datatype.TicketPriorityCritical,
+	datatype.AlertSeverityWarning: datatype.TicketPriorityHigh,
+	datatype.AlertSeverityMinor:   datatype.TicketPriorityMedium,
+	datatype.AlertSeverityInfo:    datatype.TicketPriorityLow,
+}
+
+type Config struct {
+	Issuer      string                                             // owner of the tickets and comments
+	Priorities  map[datatype.AlertSeverity]datatype.TicketPriority // defaults by severity when nil
+	OpenFor     []datatype.AlertSeverity                           // Check opens tickets for new alerts of these severities
+	CloseStatus datatype.TicketStatus                              // CLOSED when empty
+	Flow        *ticketflow.Manager                                // closes tickets through its workflow, default when nil
+}
+
+// link is what the linker wrote to the host for an alert, alerts loaded before the write don't show it yet.
+type link struct {
+	ticketUUID string
+	status     datatype.AlertStatus // last mirrored to the ticket, empty when unknown
+}
+
+// Linker opens tickets from alerts and keeps them in step with the alert: status changes become ticket comments and
+// the ticket is closed through the workflow when the alert is closed.
+type Linker struct {
+	marshaller nmodule.Marshaller
+	config     *Config
+	flow       *ticketflow.Manager
+
+	mutex sync.Mutex
+	busy  map[string]chan struct{} // alerts whose ticket is being handled, closed once done
+	links map[string]*link         // by alert uuid
+	loop  loop.Loop
+}
+
+func New(marshaller nmodule.Marshaller, config *Config) *Linker {
+	flow := config.Flow
+	if flow == nil {
+		// the default workflow is valid
+		flow, _ = ticketflow.New(marshaller, &ticketflow.Config{Owner: config.Issuer})
+	}
+	return &Linker{
+		marshaller: marshaller,
+		config:     config,
+		flow:       flow,
+		busy:       map[string]chan struct{}{},
+		links:      map[string]*link{},
+	}
+}
+
+// claim makes the caller the only one handling the alert until release is called. It waits for the current holder
+// when wait is set, otherwise it reports false when the alert is taken.
+func (l *Linker) claim(alertUUID string, wait bool) (release func(), ok bool) {
+	l.mutex.Lock()
+	for {
+		done, taken := l.busy[alertUUID]
+		if !taken {
+			break
+		}
+		l.mutex.Unlock()
+		if !wait {
+			return nil, false
+		}
+		<-done
+		l.mutex.Lock()
+	}
+	done := make(chan struct{})
+	l.busy[alertUUID] = done
+	l.mutex.Unlock()
+	return func() {
+		l.mutex.Lock()
+		delete(l.busy, alertUUID)
+		l.mutex.Unlock()
+		close(done)
+	}, true
+}
+
+// link returns the ticket and the last mirrored status of the alert, the writes of this linker win over the
+// meta-tags of the alert as it may have been loaded before them.
+func (l *Linker) link(alert *model.Alert) (string, datatype.AlertStatus, bool) {
+	ticketUUID, linked := TicketUUID(alert)
+	status, _ := metaTag(alert, StatusMetaTag)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if k, ok := l.links[alert.UUID]; ok {
+		ticketUUID, linked = k.ticketUUID, true
+		if k.status != "" {
+			status = string(k.status)
+		}
+	}
+	return ticketUUID, datatype.AlertStatus(status), linked
+}
+
+func (l *Linker) setLink(alertUUID, ticketUUID string, status datatype.AlertStatus) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.links[alertUUID] = &link{ticketUUID: ticketUUID, status: status}
+}
+
+// TicketUUID returns the ticket linked to the alert.
+func TicketUUID(alert *model.Alert) (string, bool) {
+	value, ok := metaTag(alert, TicketMetaTag)
+	return value, ok && value != ""
+}
+
+func metaTag(alert *model.Alert, key string) (string, bool) {
+	for _, tag := range alert.MetaTags {
+		if tag.Key == key {
+			return tag.Value, true
+		}
+	}
+	return "", false
+}
+
+// Open returns the ticket linked to the alert, a ticket is created and linked when there is none. The alert must be
+// loaded with its meta-tags.
+func (l *Linker) Open(alert *model.Alert) (*model.Ticket, error) {
+	release, _ := l.claim(alert.UUID, true)
+	defer release()
+	return l.open(alert)
+}
+
+func (l *Linker) open(alert *model.Alert) (*model.Ticket, error) {
+	if ticketUUID, _, ok := l.link(alert); ok {
+		return l.marshaller.GetTicket(ticketUUID)
+	}
+	priorities := l.config.Priorities
+	if priorities == nil {
+		priorities = defaultPriorities
+	}
+	priority, ok := priorities[alert.Severity]
+	if !ok {
+		priority = datatype.TicketPriorityMedium
+	}
+	alertUUID := alert.UUID
+	ticket, err := l.marshaller.CreateTicket(&model.Ticket{
+		AlertUUID: &alertUUID,
+		Issuer:    l.config.Issuer,
+		Subject:   alert.Title,
+		Content:   alert.Body,
+		Priority:  priority,
+		Status:    datatype.TicketStatusNew,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// link straight away, a ticket without the meta-tag would be opened again by the next Check
+	metaTags := []*model.AlertMetaTag{
+		{AlertUUID: alert.UUID, Key: TicketMetaTag, Value: ticket.UUID},
+		{AlertUUID: alert.UUID, Key: StatusMetaTag, Value: string(alert.Status)},
+	}
+	if err = l.marshaller.UpsertAlertMetaTags(alert.UUID, metaTags); err != nil {
+		if deleteErr := l.marshaller.DeleteTicket(ticket.UUID); deleteErr != nil {
+			log.Errorf("alertticket: failed to delete unlinked ticket %s: %s", ticket.UUID, deleteErr)
+		}
+		return nil, err
+	}
+	alert.MetaTags = append(alert.MetaTags, metaTags...)
+	l.setLink(alert.UUID, ticket.UUID, alert.Status)
+	var teams []*string
+	for _, t := range alert.Teams {
+		teamUUID := t.TeamUUID
+		teams = append(teams, &teamUUID)
+	}
+	if len(teams) > 0 {
+		if _, err = l.marshaller.UpsertTicketTeams(ticket.UUID, teams); err != nil {
+			log.Errorf("alertticket: failed to add the alert teams to ticket %s: %s", ticket.UUID, err)
+		}
+	}
+	return ticket, nil
+}
+
+// Sync mirrors a status change of a linked alert to its ticket. It does nothing for alerts without a ticket.
+func (l *Linker) Sync(alert *model.Alert) error {
+	release, _ := l.claim(alert.UUID, true)
+	defer release()
+	return l.sync(alert)
+}
+
+func (l *Linker) sync(alert *model.Alert) error {
+	ticketUUID, last, ok := l.link(alert)
+	if !ok || last == alert.Status {
+		return nil
+	}
+	comment := fmt.Sprintf("Alert %s changed from %s to %s", alert.Title, last, alert.Status)
+	closed := false
+	if alert.Status == datatype.AlertStatusClosed {
+		status := l.config.CloseStatus
+		if status == "" {
+			status = datatype.TicketStatusClosed
+		}
+		err := l.flow.SetStatus(ticketUUID, status, comment)
+		if err == nil {
+			closed = true
+		} else if errors.Is(err, ticketflow.ErrTransition) {
+			log.Warnf("alertticket: ticket %s is left open: %s", ticketUUID, err)
+		} else {
+			return err
+		}
+	}
+	if !closed {
+		_, err := l.marshaller.CreateTicketComment(&model.TicketComment{
+			TicketUUID: ticketUUID,
+			Owner:      l.config.Issuer,
+			Content:    comment,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	err := l.marshaller.UpsertAlertMetaTags(alert.UUID, []*model.AlertMetaTag{
+		{AlertUUID: alert.UUID, Key: StatusMetaTag, Value: string(alert.Status)},
+	})
+	if err != nil {
+		return err
+	}
+	l.setLink(alert.UUID, ticketUUID, alert.Status)
+	return nil
+}
+
+// Check opens tickets for new alerts of the OpenFor severities and syncs all linked alerts. Alerts handled by an Open
+// or Sync meanwhile are left to the next Check.
+func (l *Linker) Check() error {
+	alerts, err := l.marshaller.GetAlerts(&nmodule.Opts{Args: &nargs.Args{WithTeams: true, WithMetaTags: true}})
+	if err != nil {
+		return err
+	}
+	listed := map[string]bool{}
+	for _, alert := range alerts {
+		listed[alert.UUID] = true
+	}
+	l.mutex.Lock()
+	for alertUUID := range l.links {
+		if !listed[alertUUID] {
+			delete(l.links, alertUUID)
+		}
+	}
+	l.mutex.Unlock()
+	var firstErr error
+	for _, alert := range alerts {
+		release, ok := l.claim(alert.UUID, false)
+		if !ok {
+			continue
+		}
+		if _, _, linked := l.link(alert); !linked && alert.Status == datatype.AlertStatusActive && l.opensFor(alert) {
+			_, err = l.open(alert)
+		} else {
+			err = l.sync(alert)
+		}
+		release()
+		if err != nil {
+			log.Errorf("alertticket: alert %s: %s", alert.UUID, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (l *Linker) opensFor(alert *model.Alert) bool {
+	for _, severity := range l.config.OpenFor {
+		if severity == alert.Severity {
+			return true
+		}
+	}
+	return false
+}
+
+// Start calls Check every tick until Stop.
+func (l *Linker) Start(tick time.Duration) error {
+	return l.loop.Start(tick, false, func() {
+		if err := l.Check(); err != nil {
+			log.Errorf("alertticket: %s", err)
+		}
+	})
+}
+
+func (l *Linker) Stop() {
+	l.loop.Stop()
+}
@@ -0,0 +1,179 @@
+package alertticket
+
+import (
+	"errors"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/lib-module-go/ticketflow"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type fakeMarshaller struct {
+	nmodule.Marshaller
+	alert     *model.Alert
+	tickets   map[string]*model.Ticket
+	comments  []*model.TicketComment
+	failTags  bool
+	failTeams bool
+	created   int
+}
+
+func (f *fakeMarshaller) GetAlerts(opts ...*nmodule.Opts) ([]*model.Alert, error) {
+	return []*model.Alert{f.alert}, nil
+}
+
+func (f *fakeMarshaller) CreateTicket(body *model.Ticket, opts ...*nmodule.Opts) (*model.Ticket, error) {
+	f.created++
+	body.UUID = "tkt"
+	f.tickets[body.UUID] = body
+	return body, nil
+}
+
+func (f *fakeMarshaller) GetTicket(uuid string, opts ...*nmodule.Opts) (*model.Ticket, error) {
+	return f.tickets[uuid], nil
+}
+
+func (f *fakeMarshaller) DeleteTicket(uuid string, opts ...*nmodule.Opts) error {
+	delete(f.tickets, uuid)
+	return nil
+}
+
+func (f *fakeMarshaller) UpsertTicketTeams(uuid string, teamUUIDs []*string, opts ...*nmodule.Opts) ([]*model.TicketTeam, error) {
+	if f.failTeams {
+		return nil, errors.New("teams unavailable")
+	}
+	for _, t := range teamUUIDs {
+		f.tickets[uuid].Teams = append(f.tickets[uuid].Teams, &model.TicketTeam{TicketUUID: uuid, TeamUUID: *t})
+	}
+	return f.tickets[uuid].Teams, nil
+}
+
+func (f *fakeMarshaller) UpsertTicketStatus(uuid string, body *dto.TicketStatus, opts ...*nmodule.Opts) error {
+	f.tickets[uuid].Status = datatype.TicketStatus(body.Status)
+	return nil
+}
+
+func (f *fakeMarshaller) CreateTicketComment(body *model.TicketComment, opts ...*nmodule.Opts) (*model.TicketComment, error) {
+	f.comments = append(f.comments, body)
+	return body, nil
+}
+
+func (f *fakeMarshaller) UpsertAlertMetaTags(uuid string, body []*model.AlertMetaTag, opts ...*nmodule.Opts) error {
+	if f.failTags {
+		return errors.New("meta-tags unavailable")
+	}
+	// the host upserts by key, keep a copy so Open's in memory update isn't counted twice
+	tags := map[string]*model.AlertMetaTag{}
+	var keys []string
+	for _, tag := range append(append([]*model.AlertMetaTag{}, f.alert.MetaTags...), body...) {
+		if _, ok := tags[tag.Key]; !ok {
+			keys = append(keys, tag.Key)
+		}
+		tags[tag.Key] = tag
+	}
+	f.alert.MetaTags = nil
+	for _, key := range keys {
+		f.alert.MetaTags = append(f.alert.MetaTags, tags[key])
+	}
+	return nil
+}
+
+func TestAlertTicketLifecycle(t *testing.T) {
+	m := &fakeMarshaller{tickets: map[string]*model.Ticket{}, alert: &model.Alert{UUID: "alt", Title: "hot",
+		Status: datatype.AlertStatusActive, Severity: datatype.AlertSeverityCrucial,
+		Teams: []*model.AlertTeam{{AlertUUID: "alt", TeamUUID: "ops"}}}}
+	linker := New(m, &Config{Issuer: "rubix", OpenFor: []datatype.AlertSeverity{datatype.AlertSeverityCrucial}})
+
+	assert.NoError(t, linker.Check())
+	ticket := m.tickets["tkt"]
+	assert.Equal(t, "alt", *ticket.AlertUUID)
+	assert.Equal(t, datatype.TicketPriorityCritical, ticket.Priority)
+	assert.Equal(t, "ops", ticket.Teams[0].TeamUUID)
+	ticketUUID, ok := TicketUUID(m.alert)
+	assert.True(t, ok)
+	assert.Equal(t, "tkt", ticketUUID)
+
+	assert.NoError(t, linker.Check())
+	assert.Len(t, m.tickets, 1)
+	assert.Empty(t, m.comments)
+
+	again, err := linker.Open(m.alert)
+	assert.NoError(t, err)
+	assert.Equal(t, ticket, again)
+
+	m.alert.Status = datatype.AlertStatusAcknowledged
+	assert.NoError(t, linker.Check())
+	assert.Len(t, m.comments, 1)
+	assert.Equal(t, "Alert hot changed from active to acknowledged", m.comments[0].Content)
+
+	m.alert.Status = datatype.AlertStatusClosed
+	assert.NoError(t, linker.Check())
+	assert.Len(t, m.comments, 2)
+	assert.Equal(t, datatype.TicketStatusClosed, ticket.Status)
+	assert.NoError(t, linker.Check())
+	assert.Len(t, m.comments, 2)
+}
+
+func TestOpenLinksBeforeTeams(t *testing.T) {
+	m := &fakeMarshaller{tickets: map[string]*model.Ticket{}, failTeams: true, alert: &model.Alert{UUID: "alt",
+		Status: datatype.AlertStatusActive, Severity: datatype.AlertSeverityCrucial,
+		Teams: []*model.AlertTeam{{AlertUUID: "alt", TeamUUID: "ops"}}}}
+	linker := New(m, &Config{OpenFor: []datatype.AlertSeverity{datatype.AlertSeverityCrucial}})
+
+	// the teams are best-effort, the ticket is linked and not opened again
+	assert.NoError(t, linker.Check())
+	ticketUUID, ok := TicketUUID(m.alert)
+	assert.True(t, ok)
+	assert.Equal(t, "tkt", ticketUUID)
+	assert.NoError(t, linker.Check())
+	assert.Len(t, m.tickets, 1)
+
+	// a ticket which can't be linked is deleted
+	m = &fakeMarshaller{tickets: map[string]*model.Ticket{}, failTags: true, alert: &model.Alert{UUID: "alt",
+		Status: datatype.AlertStatusActive, Severity: datatype.AlertSeverityCrucial}}
+	linker = New(m, &Config{OpenFor: []datatype.AlertSeverity{datatype.AlertSeverityCrucial}})
+	assert.Error(t, linker.Check())
+	assert.Empty(t, m.tickets)
+}
+
+func TestCloseFollowsWorkflow(t *testing.T) {
+	m := &fakeMarshaller{tickets: map[string]*model.Ticket{}, alert: &model.Alert{UUID: "alt", Title: "hot",
+		Status: datatype.AlertStatusActive, Severity: datatype.AlertSeverityCrucial}}
+	workflow := ticketflow.DefaultWorkflow()
+	workflow.Transitions[datatype.TicketStatusNew] = []datatype.TicketStatus{datatype.TicketStatusReplied}
+	flow, err := ticketflow.New(m, &ticketflow.Config{Workflow: workflow})
+	assert.NoError(t, err)
+	linker := New(m, &Config{Flow: flow, OpenFor: []datatype.AlertSeverity{datatype.AlertSeverityCrucial}})
+	assert.NoError(t, linker.Check())
+
+	// a NEW ticket can't be closed in this workflow, the change is only commented
+	m.alert.Status = datatype.AlertStatusClosed
+	assert.NoError(t, linker.Check())
+	assert.Equal(t, datatype.TicketStatusNew, m.tickets["tkt"].Status)
+	assert.Len(t, m.comments, 1)
+	assert.NoError(t, linker.Check())
+	assert.Len(t, m.comments, 1)
+}
+
+func TestStaleAlertIsNotOpenedTwice(t *testing.T) {
+	m := &fakeMarshaller{tickets: map[string]*model.Ticket{}, alert: &model.Alert{UUID: "alt", Title: "hot",
+		Status: datatype.AlertStatusActive, Severity: datatype.AlertSeverityCrucial}}
+	linker := New(m, &Config{OpenFor: []datatype.AlertSeverity{datatype.AlertSeverityCrucial}})
+
+	// loaded before the ticket was linked, e.g. by a Check running next to Open
+	stale := *m.alert
+	_, err := linker.Open(m.alert)
+	assert.NoError(t, err)
+	ticket, err := linker.Open(&stale)
+	assert.NoError(t, err)
+	assert.Equal(t, "tkt", ticket.UUID)
+	assert.Equal(t, 1, m.created)
+
+	stale.Status = datatype.AlertStatusAcknowledged
+	assert.NoError(t, linker.Sync(&stale))
+	assert.NoError(t, linker.Sync(&stale))
+	assert.Len(t, m.comments, 1)
+}
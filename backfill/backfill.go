@@ -0,0 +1,347 @@
+package backfill
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TrendReader is implemented by protocol modules whose devices keep trend logs.
+type TrendReader interface {
+	SupportsTrendLog(pointUUID string) bool
+	ReadTrendLog(pointUUID string, timestamps []time.Time) ([]*model.PointHistory, error)
+}
+
+type Status string
+
+const (
+	StatusPending     Status = "pending"
+	StatusRunning     Status = "running"
+	StatusDone        Status = "done"
+	StatusFailed      Status = "failed"
+	StatusUnsupported Status = "unsupported"
+)
+
+type Progress struct {
+	PointUUID string    `json:"point_uuid"`
+	Status    Status    `json:"status"`
+	Missing   int       `json:"missing"`
+	Requested int       `json:"requested"`
+	Recovered int       `json:"recovered"`
+	Cursor    time.Time `json:"cursor"` // last timestamp handled, a re-run resumes after it
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type Config struct {
+	Workers   int    // points backfilled in parallel
+	ChunkSize int    // timestamps asked from the device per ReadTrendLog call
+	Retries   int    // extra attempts per chunk before the point is failed
+	SubDir    string // directory created inside the module dir, backfill when empty
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Workers:   2,
+		ChunkSize: 100,
+		Retries:   2,
+	}
+}
+
+const cursorFile = "cursors.json"
+
+// timestampLayouts are tried in order on the values of GetPointHistoriesMissingTimestamps.
+var timestampLayouts = []string{time.RFC3339Nano, time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05"}
+
+type Backfill struct {
+	marshaller nmodule.Marshaller
+	reader     TrendReader
+	config     *Config
+
+	mutex    sync.Mutex
+	progress map[string]*Progress
+	cursors  map[string]time.Time
+	running  bool
+	dir      string // cursors are only kept in memory when empty
+
+	saveMutex sync.Mutex
+}
+
+// New keeps the cursors in memory, use Open to resume them after a restart.
+func New(marshaller nmodule.Marshaller, reader TrendReader, config *Config) *Backfill {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	return &Backfill{
+		marshaller: marshaller,
+		reader:     reader,
+		config:     config,
+		progress:   make(map[string]*Progress),
+		cursors:    make(map[string]time.Time),
+	}
+}
+
+// Open keeps the cursors inside the module dir returned by CreateModuleDir.
+func Open(marshaller nmodule.Marshaller, reader TrendReader, moduleName string, config *Config) (*Backfill, error) {
+	moduleDir, err := marshaller.CreateModuleDir(moduleName)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		config = DefaultConfig()
+	}
+	subDir := config.SubDir
+	if subDir == "" {
+		subDir = "backfill"
+	}
+	return OpenDir(marshaller, reader, filepath.Join(*moduleDir, subDir), config)
+}
+
+// OpenDir keeps the cursors in dir and loads the ones of the previous runs.
+func OpenDir(marshaller nmodule.Marshaller, reader TrendReader, dir string, config *Config) (*Backfill, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	b := New(marshaller, reader, config)
+	b.dir = dir
+	data, err := os.ReadFile(filepath.Join(dir, cursorFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err = json.Unmarshal(data, &b.cursors); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// Run scans the points for missing timestamps and backfills them, it blocks until every point is processed.
+func (b *Backfill) Run(pointUUIDs []string) ([]*Progress, error) {
+	b.mutex.Lock()
+	if b.running {
+		b.mutex.Unlock()
+		return nil, errors.New("backfill is already running")
+	}
+	b.running = true
+	for _, uuid := range pointUUIDs {
+		b.progress[uuid] = &Progress{PointUUID: uuid, Status: StatusPending, Cursor: b.cursors[uuid], UpdatedAt: time.Now()}
+	}
+	b.mutex.Unlock()
+	defer func() {
+		b.mutex.Lock()
+		b.running = false
+		b.mutex.Unlock()
+	}()
+
+	workers := b.config.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for uuid := range jobs {
+				b.point(uuid)
+			}
+		}()
+	}
+	for _, uuid := range pointUUIDs {
+		jobs <- uuid
+	}
+	close(jobs)
+	wg.Wait()
+
+	out := make([]*Progress, 0, len(pointUUIDs))
+	for _, uuid := range pointUUIDs {
+		out = append(out, b.Progress(uuid))
+	}
+	return out, nil
+}
+
+func (b *Backfill) point(uuid string) {
+	if !b.reader.SupportsTrendLog(uuid) {
+		b.update(uuid, func(p *Progress) { p.Status = StatusUnsupported })
+		return
+	}
+	b.update(uuid, func(p *Progress) { p.Status = StatusRunning })
+
+	raw, err := b.marshaller.GetPointHistoriesMissingTimestamps(uuid)
+	if err != nil {
+		b.fail(uuid, err)
+		return
+	}
+	timestamps, err := parseTimestamps(raw)
+	if err != nil {
+		b.fail(uuid, err)
+		return
+	}
+	b.update(uuid, func(p *Progress) { p.Missing = len(timestamps) })
+	// timestamps up to the cursor were already asked, the device had nothing more for them
+	cursor := b.cursor(uuid)
+	skip := sort.Search(len(timestamps), func(i int) bool { return timestamps[i].After(cursor) })
+	timestamps = timestamps[skip:]
+
+	chunkSize := b.config.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = len(timestamps)
+	}
+	for start := 0; start < len(timestamps); start += chunkSize {
+		end := start + chunkSize
+		if end > len(timestamps) {
+			end = len(timestamps)
+		}
+		recovered, err := b.chunk(uuid, timestamps[start:end])
+		if err != nil {
+			b.fail(uuid, err)
+			return
+		}
+		if err = b.advance(uuid, timestamps[end-1]); err != nil {
+			b.fail(uuid, err)
+			return
+		}
+		b.update(uuid, func(p *Progress) {
+			p.Requested += end - start
+			p.Recovered += recovered
+			p.Cursor = timestamps[end-1]
+		})
+	}
+	b.update(uuid, func(p *Progress) { p.Status = StatusDone })
+}
+
+func (b *Backfill) chunk(uuid string, timestamps []time.Time) (int, error) {
+	var err error
+	for attempt := 0; attempt <= b.config.Retries; attempt++ {
+		var histories []*model.PointHistory
+		histories, err = b.reader.ReadTrendLog(uuid, timestamps)
+		if err != nil {
+			log.Warnf("backfill: trend log read of point %s failed (attempt %d): %s", uuid, attempt+1, err)
+			continue
+		}
+		for _, h := range histories {
+			h.PointUUID = uuid
+		}
+		if len(histories) == 0 {
+			return 0, nil
+		}
+		if _, err = b.marshaller.CreatePointHistories(histories); err != nil {
+			log.Warnf("backfill: writing histories of point %s failed (attempt %d): %s", uuid, attempt+1, err)
+			continue
+		}
+		return len(histories), nil
+	}
+	return 0, err
+}
+
+func (b *Backfill) cursor(uuid string) time.Time {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.cursors[uuid]
+}
+
+// advance moves the cursor of the point past a written chunk and persists it.
+func (b *Backfill) advance(uuid string, ts time.Time) error {
+	b.mutex.Lock()
+	b.cursors[uuid] = ts
+	b.mutex.Unlock()
+	return b.save()
+}
+
+// save writes the cursors through a temp file, so a crash keeps the previous ones.
+func (b *Backfill) save() error {
+	if b.dir == "" {
+		return nil
+	}
+	b.saveMutex.Lock()
+	defer b.saveMutex.Unlock()
+	b.mutex.Lock()
+	data, err := json.Marshal(b.cursors)
+	b.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(b.dir, cursorFile)
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (b *Backfill) fail(uuid string, err error) {
+	b.update(uuid, func(p *Progress) {
+		p.Status = StatusFailed
+		p.Error = err.Error()
+	})
+}
+
+func (b *Backfill) update(uuid string, fn func(p *Progress)) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	p, ok := b.progress[uuid]
+	if !ok {
+		p = &Progress{PointUUID: uuid}
+		b.progress[uuid] = p
+	}
+	fn(p)
+	p.UpdatedAt = time.Now()
+}
+
+// Progress returns a copy of the point progress, nil when the point was never backfilled.
+func (b *Backfill) Progress(pointUUID string) *Progress {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if p, ok := b.progress[pointUUID]; ok {
+		c := *p
+		return &c
+	}
+	return nil
+}
+
+// Failed returns the points of the last runs which could not be backfilled, so they can be run again.
+func (b *Backfill) Failed() []*Progress {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	var out []*Progress
+	for _, p := range b.progress {
+		if p.Status == StatusFailed {
+			c := *p
+			out = append(out, &c)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].PointUUID < out[j].PointUUID })
+	return out
+}
+
+func parseTimestamps(raw []string) ([]time.Time, error) {
+	out := make([]time.Time, 0, len(raw))
+	for _, s := range raw {
+		ts, err := parseTimestamp(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, ts)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Before(out[j]) })
+	return out, nil
+}
+
+func parseTimestamp(s string) (time.Time, error) {
+	for _, layout := range timestampLayouts {
+		if ts, err := time.Parse(layout, s); err == nil {
+			return ts, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid missing timestamp: %s", s)
+}
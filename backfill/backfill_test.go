@@ -0,0 +1,126 @@
+package backfill
+
+import (
+	"errors"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type fakeMarshaller struct {
+	nmodule.Marshaller
+	mutex   sync.Mutex
+	missing []string
+	written []*model.PointHistory
+}
+
+func (f *fakeMarshaller) GetPointHistoriesMissingTimestamps(pointUUID string, opts ...*nmodule.Opts) ([]string, error) {
+	return f.missing, nil
+}
+
+func (f *fakeMarshaller) CreatePointHistories(histories []*model.PointHistory, opts ...*nmodule.Opts) (bool, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.written = append(f.written, histories...)
+	return true, nil
+}
+
+// fakeReader has no samples for odd minutes, so the host keeps listing those as missing.
+type fakeReader struct {
+	mutex  sync.Mutex
+	chunks [][]time.Time
+	failAt time.Time
+}
+
+func (r *fakeReader) SupportsTrendLog(pointUUID string) bool {
+	return true
+}
+
+func (r *fakeReader) ReadTrendLog(pointUUID string, timestamps []time.Time) ([]*model.PointHistory, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.chunks = append(r.chunks, timestamps)
+	var out []*model.PointHistory
+	for _, ts := range timestamps {
+		if ts.Equal(r.failAt) {
+			return nil, errors.New("device busy")
+		}
+		if ts.Minute()%2 == 0 {
+			value := float64(ts.Minute())
+			out = append(out, &model.PointHistory{Value: &value, Timestamp: ts})
+		}
+	}
+	return out, nil
+}
+
+func missing(n int) []string {
+	var out []string
+	for i := 0; i < n; i++ {
+		out = append(out, start.Add(time.Duration(i)*time.Minute).Format(time.RFC3339))
+	}
+	return out
+}
+
+func TestChunking(t *testing.T) {
+	m := &fakeMarshaller{missing: missing(5)}
+	r := &fakeReader{}
+	b := New(m, r, &Config{Workers: 1, ChunkSize: 2})
+
+	progress, err := b.Run([]string{"pnt"})
+	assert.NoError(t, err)
+	assert.Equal(t, StatusDone, progress[0].Status)
+	assert.Equal(t, 5, progress[0].Requested)
+	assert.Equal(t, 3, progress[0].Recovered)
+	assert.Len(t, r.chunks, 3)
+	assert.Len(t, r.chunks[2], 1)
+	assert.Len(t, m.written, 3)
+	assert.Equal(t, "pnt", m.written[0].PointUUID)
+}
+
+func TestResumeAfterFailure(t *testing.T) {
+	dir := t.TempDir()
+	m := &fakeMarshaller{missing: missing(6)}
+	r := &fakeReader{failAt: start.Add(2 * time.Minute)}
+	b, err := OpenDir(m, r, dir, &Config{Workers: 1, ChunkSize: 2})
+	assert.NoError(t, err)
+
+	progress, err := b.Run([]string{"pnt"})
+	assert.NoError(t, err)
+	assert.Equal(t, StatusFailed, progress[0].Status)
+	assert.Equal(t, start.Add(time.Minute), progress[0].Cursor)
+	assert.Len(t, b.Failed(), 1)
+
+	// a new instance picks up after the last written chunk
+	r = &fakeReader{}
+	b, err = OpenDir(m, r, dir, &Config{Workers: 1, ChunkSize: 2})
+	assert.NoError(t, err)
+	progress, err = b.Run([]string{"pnt"})
+	assert.NoError(t, err)
+	assert.Equal(t, StatusDone, progress[0].Status)
+	assert.Equal(t, 4, progress[0].Requested)
+	assert.Equal(t, start.Add(2*time.Minute), r.chunks[0][0])
+	assert.Len(t, m.written, 3)
+}
+
+func TestRerunIsIdempotent(t *testing.T) {
+	m := &fakeMarshaller{missing: missing(4)}
+	r := &fakeReader{}
+	b := New(m, r, &Config{Workers: 1, ChunkSize: 10})
+
+	_, err := b.Run([]string{"pnt"})
+	assert.NoError(t, err)
+	assert.Len(t, m.written, 2)
+
+	// the odd minutes are still listed as missing, they are not asked again
+	progress, err := b.Run([]string{"pnt"})
+	assert.NoError(t, err)
+	assert.Equal(t, StatusDone, progress[0].Status)
+	assert.Equal(t, 0, progress[0].Requested)
+	assert.Len(t, r.chunks, 1)
+	assert.Len(t, m.written, 2)
+}
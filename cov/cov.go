@@ -0,0 +1,195 @@
+package cov
+
+import (
+	"github.com/NubeIO/lib-module-go/loop"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	Deadband        float64       // absolute change required to send, point cov wins when set
+	DeadbandPercent float64       // change required to send as a percentage of the last sent value
+	MinInterval     time.Duration // values changing faster than this are held back and sent once it elapses
+	MaxInterval     time.Duration // a value is sent on update once this elapsed even if it didn't change
+	Heartbeat       time.Duration // the last value is republished by Start once this elapsed, 0 disables
+}
+
+type pointState struct {
+	config   *Config
+	sent     bool
+	lastSent float64
+	lastTime time.Time
+	pending  *float64
+}
+
+// Filter sits between a module's poll results and the host, the Marshaller is only called for real changes.
+type Filter struct {
+	marshaller nmodule.Marshaller
+	config     *Config
+	now        func() time.Time
+
+	mutex  sync.Mutex
+	points map[string]*pointState
+	loop   loop.Loop
+}
+
+func New(marshaller nmodule.Marshaller, config *Config) *Filter {
+	if config == nil {
+		config = &Config{}
+	}
+	return &Filter{
+		marshaller: marshaller,
+		config:     config,
+		now:        time.Now,
+		points:     make(map[string]*pointState),
+	}
+}
+
+// SetPoint applies the point cov as its absolute deadband on top of the filter config.
+func (f *Filter) SetPoint(point *model.Point) {
+	config := *f.config
+	if point.COV != nil {
+		config.Deadband = *point.COV
+	}
+	f.SetPointConfig(point.UUID, &config)
+}
+
+func (f *Filter) SetPointConfig(pointUUID string, config *Config) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.state(pointUUID).config = config
+}
+
+// RemovePoint forgets the point, the next update of it is always sent.
+func (f *Filter) RemovePoint(pointUUID string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.points, pointUUID)
+}
+
+func (f *Filter) state(pointUUID string) *pointState {
+	ps, ok := f.points[pointUUID]
+	if !ok {
+		ps = &pointState{config: f.config}
+		f.points[pointUUID] = ps
+	}
+	return ps
+}
+
+// Update reports whether the value was sent to the host.
+func (f *Filter) Update(pointUUID string, value float64) (bool, error) {
+	f.mutex.Lock()
+	ps := f.state(pointUUID)
+	now := f.now()
+	if !ps.shouldSend(value, now) {
+		// back inside the deadband, a value held by MinInterval is stale now
+		ps.pending = nil
+		f.mutex.Unlock()
+		return false, nil
+	}
+	if ps.sent && ps.config.MinInterval > 0 && now.Sub(ps.lastTime) < ps.config.MinInterval {
+		ps.pending = &value
+		f.mutex.Unlock()
+		return false, nil
+	}
+	f.mutex.Unlock()
+	// only a value the host accepted counts as sent, a failed one is sent again on the next update
+	if err := f.send(pointUUID, value); err != nil {
+		return false, err
+	}
+	f.mutex.Lock()
+	ps.markSent(value, now)
+	f.mutex.Unlock()
+	return true, nil
+}
+
+func (ps *pointState) shouldSend(value float64, now time.Time) bool {
+	if !ps.sent {
+		return true
+	}
+	if ps.config.MaxInterval > 0 && now.Sub(ps.lastTime) >= ps.config.MaxInterval {
+		return true
+	}
+	return Changed(ps.lastSent, value, ps.config.Deadband, ps.config.DeadbandPercent)
+}
+
+func (ps *pointState) markSent(value float64, now time.Time) {
+	ps.sent = true
+	ps.lastSent = value
+	ps.lastTime = now
+	ps.pending = nil
+}
+
+// Changed reports whether value moved out of the deadband around last, with no deadband every difference counts.
+func Changed(last, value, deadband, deadbandPercent float64) bool {
+	delta := math.Abs(value - last)
+	if deadband <= 0 && deadbandPercent <= 0 {
+		return delta > 0
+	}
+	if deadband > 0 && delta >= deadband {
+		return true
+	}
+	if deadbandPercent > 0 && delta >= math.Abs(last)*deadbandPercent/100 && delta > 0 {
+		return true
+	}
+	return false
+}
+
+func (f *Filter) send(pointUUID string, value float64) error {
+	_, err := f.marshaller.PointWrite(pointUUID, &dto.PointWriter{
+		OriginalValue: &value,
+		PollState:     datatype.PointStatePollOk,
+	})
+	return err
+}
+
+// Flush sends the values held back by MinInterval and republishes the ones due for a heartbeat.
+func (f *Filter) Flush() {
+	type update struct {
+		ps        *pointState
+		pointUUID string
+		value     float64
+	}
+	var updates []update
+	f.mutex.Lock()
+	now := f.now()
+	for uuid, ps := range f.points {
+		if !ps.sent {
+			continue
+		}
+		elapsed := now.Sub(ps.lastTime)
+		if ps.pending != nil && elapsed >= ps.config.MinInterval {
+			updates = append(updates, update{ps, uuid, *ps.pending})
+		} else if ps.config.Heartbeat > 0 && elapsed >= ps.config.Heartbeat {
+			updates = append(updates, update{ps, uuid, ps.lastSent})
+		}
+	}
+	f.mutex.Unlock()
+	for _, u := range updates {
+		if err := f.send(u.pointUUID, u.value); err != nil {
+			// the pending value or the heartbeat stays due for the next Flush
+			log.Errorf("cov: failed to send value of point %s: %s", u.pointUUID, err)
+			continue
+		}
+		f.mutex.Lock()
+		if u.ps.pending == nil || *u.ps.pending == u.value {
+			u.ps.markSent(u.value, now)
+		}
+		f.mutex.Unlock()
+	}
+}
+
+// Start calls Flush every tick until Stop.
+func (f *Filter) Start(tick time.Duration) error {
+	return f.loop.Start(tick, false, f.Flush)
+}
+
+func (f *Filter) Stop() {
+	f.loop.Stop()
+}
@@ -0,0 +1,104 @@
+package cov
+
+import (
+	"errors"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type fakeMarshaller struct {
+	nmodule.Marshaller
+	written []float64
+	fail    bool
+}
+
+func (f *fakeMarshaller) PointWrite(uuid string, body *dto.PointWriter, opts ...*nmodule.Opts) (*dto.PointWriteResponse, error) {
+	if f.fail {
+		return nil, errors.New("host unavailable")
+	}
+	f.written = append(f.written, *body.OriginalValue)
+	return &dto.PointWriteResponse{}, nil
+}
+
+func TestChanged(t *testing.T) {
+	assert.False(t, Changed(10, 10, 0, 0))
+	assert.True(t, Changed(10, 10.01, 0, 0))
+	assert.False(t, Changed(10, 10.4, 0.5, 0))
+	assert.True(t, Changed(10, 10.5, 0.5, 0))
+	assert.False(t, Changed(100, 104, 0, 5))
+	assert.True(t, Changed(100, 95, 0, 5))
+	assert.True(t, Changed(0, 0.1, 0, 5))
+}
+
+func TestDeadbandAndIntervals(t *testing.T) {
+	m := &fakeMarshaller{}
+	f := New(m, &Config{MinInterval: 10 * time.Second, MaxInterval: time.Minute, Heartbeat: 5 * time.Minute})
+	now := time.Unix(0, 0)
+	f.now = func() time.Time { return now }
+	cov := 1.0
+	f.SetPoint(&model.Point{CommonUUID: model.CommonUUID{UUID: "pnt"}, COV: &cov})
+
+	sent, _ := f.Update("pnt", 20)
+	assert.True(t, sent)
+
+	now = now.Add(20 * time.Second)
+	sent, _ = f.Update("pnt", 20.5)
+	assert.False(t, sent, "inside the deadband")
+
+	sent, _ = f.Update("pnt", 22)
+	assert.True(t, sent)
+
+	now = now.Add(time.Second)
+	sent, _ = f.Update("pnt", 25)
+	assert.False(t, sent, "held back by the min interval")
+	f.Flush()
+	assert.Equal(t, []float64{20, 22}, m.written)
+
+	now = now.Add(10 * time.Second)
+	f.Flush()
+	assert.Equal(t, []float64{20, 22, 25}, m.written)
+
+	now = now.Add(time.Minute)
+	sent, _ = f.Update("pnt", 25)
+	assert.True(t, sent, "max interval elapsed")
+
+	now = now.Add(5 * time.Minute)
+	f.Flush()
+	assert.Equal(t, []float64{20, 22, 25, 25, 25}, m.written)
+}
+
+func TestFailedSendIsRetried(t *testing.T) {
+	m := &fakeMarshaller{fail: true}
+	f := New(m, &Config{Deadband: 1})
+	sent, err := f.Update("pnt", 20)
+	assert.Error(t, err)
+	assert.False(t, sent)
+
+	m.fail = false
+	sent, err = f.Update("pnt", 20)
+	assert.NoError(t, err)
+	assert.True(t, sent)
+	assert.Equal(t, []float64{20}, m.written)
+}
+
+func TestPendingClearedInsideDeadband(t *testing.T) {
+	m := &fakeMarshaller{}
+	f := New(m, &Config{Deadband: 1, MinInterval: 10 * time.Second})
+	now := time.Unix(0, 0)
+	f.now = func() time.Time { return now }
+
+	_, _ = f.Update("pnt", 20)
+	now = now.Add(time.Second)
+	sent, _ := f.Update("pnt", 25)
+	assert.False(t, sent, "held back by the min interval")
+	sent, _ = f.Update("pnt", 20.2)
+	assert.False(t, sent, "back inside the deadband")
+
+	now = now.Add(10 * time.Second)
+	f.Flush()
+	assert.Equal(t, []float64{20}, m.written)
+}
@@ -0,0 +1,434 @@
+package discovery
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/NubeIO/lib-module-go/loop"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/lib-networking/scanner"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/nargs"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MacMetaTag is the device meta-tag holding the mac address of a device, scanner results carry no mac so it is
+// resolved through Config.ResolveMAC.
+const MacMetaTag = "mac_address"
+
+const snapshotFile = "snapshot.json"
+
+type ChangeType string
+
+const (
+	Appeared    ChangeType = "appeared"
+	Disappeared ChangeType = "disappeared"
+	Changed     ChangeType = "changed" // the ip or the open ports changed or the entry became known or unknown
+)
+
+// Entry is an address found by the scans. Entries with a mac are tracked by mac, so a new ip is a change.
+type Entry struct {
+	IP         string    `json:"ip"`
+	MAC        string    `json:"mac,omitempty"`
+	Ports      []string  `json:"ports"`
+	HostUUID   string    `json:"host_uuid,omitempty"`   // the known host at the address
+	DeviceUUID string    `json:"device_uuid,omitempty"` // the known device at the address
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+	Missed     int       `json:"missed"` // scans in a row the entry wasn't found
+}
+
+func (e *Entry) key() string {
+	if e.MAC != "" {
+		return "mac:" + e.MAC
+	}
+	return "ip:" + e.IP
+}
+
+func (e *Entry) Known() bool {
+	return e.HostUUID != "" || e.DeviceUUID != ""
+}
+
+type Change struct {
+	Type     ChangeType `json:"type"`
+	Entry    *Entry     `json:"entry"`
+	Previous *Entry     `json:"previous,omitempty"` // for Changed
+}
+
+type Config struct {
+	Scan         *dto.Scanner
+	HostUUID     *string // scans from and matches the devices of a remote host, the local host when nil
+	SubDir       string  // directory created inside the module dir, discovery when empty
+	MissedScans  int     // scans an entry must be missing before it disappears, 2 when zero
+	ResolveMAC   func(ip string) string
+	MqttTopic    string // publishes each change as json when set
+	AlertUnknown bool   // raises an alert on GatewayUUID for an unknown entry, closed once it disappears or is known
+	GatewayUUID  string
+	Severity     datatype.AlertSeverity // of the alerts, warning when empty
+}
+
+// Pipeline runs the scanner, diffs the results against the previous scan and reports the changes. The last scan and
+// the changes which couldn't be reported yet are kept in the module dir, so after a restart changes are neither lost
+// nor reported again, except for the ones a crash interrupted.
+type Pipeline struct {
+	marshaller nmodule.Marshaller
+	dir        string
+	config     *Config
+
+	scanMutex sync.Mutex
+	mutex     sync.Mutex
+	entries   map[string]*Entry
+	pending   []*Change // changes not reported yet
+	loop      loop.Loop
+	now       func() time.Time
+}
+
+type snapshot struct {
+	Entries []*Entry  `json:"entries"`
+	Pending []*Change `json:"pending,omitempty"`
+}
+
+// Open keeps the scans inside the module dir returned by CreateModuleDir.
+func Open(marshaller nmodule.Marshaller, moduleName string, config *Config) (*Pipeline, error) {
+	moduleDir, err := marshaller.CreateModuleDir(moduleName)
+	if err != nil {
+		return nil, err
+	}
+	subDir := config.SubDir
+	if subDir == "" {
+		subDir = "discovery"
+	}
+	return OpenDir(marshaller, filepath.Join(*moduleDir, subDir), config)
+}
+
+// OpenDir keeps the scans in dir and loads the previous scan.
+func OpenDir(marshaller nmodule.Marshaller, dir string, config *Config) (*Pipeline, error) {
+	if config.Scan == nil {
+		return nil, errors.New("scan is required")
+	}
+	if config.AlertUnknown && config.GatewayUUID == "" {
+		return nil, errors.New("alerts need a gateway uuid")
+	}
+	if config.MissedScans <= 0 {
+		config.MissedScans = 2
+	}
+	if config.Severity == "" {
+		config.Severity = datatype.AlertSeverityWarning
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	p := &Pipeline{
+		marshaller: marshaller,
+		dir:        dir,
+		config:     config,
+		entries:    map[string]*Entry{},
+		now:        time.Now,
+	}
+	data, err := os.ReadFile(filepath.Join(dir, snapshotFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		var snap snapshot
+		if err = json.Unmarshal(data, &snap); err != nil {
+			return nil, fmt.Errorf("discovery: corrupt snapshot: %s", err)
+		}
+		for _, e := range snap.Entries {
+			p.entries[e.key()] = e
+		}
+		p.pending = snap.Pending
+	}
+	return p, nil
+}
+
+// Scan runs the scanner and returns the changes since the previous scan. The changes are saved before they are
+// reported, the ones which fail are reported again by the next scan.
+func (p *Pipeline) Scan() ([]*Change, error) {
+	p.scanMutex.Lock()
+	defer p.scanMutex.Unlock()
+	hosts, err := p.marshaller.RunScanner(p.config.Scan, p.opts())
+	if err != nil {
+		return nil, err
+	}
+	known, err := p.known()
+	if err != nil {
+		return nil, err
+	}
+	p.mutex.Lock()
+	changes := p.diff(hosts, known)
+	pending := append(append([]*Change{}, p.pending...), changes...)
+	p.pending = pending
+	err = p.save()
+	p.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	alerts := &openAlerts{marshaller: p.marshaller}
+	var failed []*Change
+	for _, change := range pending {
+		if err = p.report(change, alerts); err != nil {
+			log.Errorf("discovery: failed to report %s %s: %s", change.Type, change.Entry.IP, err)
+			failed = append(failed, change)
+		}
+	}
+	p.mutex.Lock()
+	p.pending = failed
+	err = p.save()
+	p.mutex.Unlock()
+	return changes, err
+}
+
+func (p *Pipeline) opts() *nmodule.Opts {
+	return &nmodule.Opts{HostUUID: p.config.HostUUID}
+}
+
+// known maps addresses to hosts and devices: keys are "ip:<ip>" and "mac:<mac>".
+type knownEntry struct {
+	hostUUID   string
+	deviceUUID string
+}
+
+func (p *Pipeline) known() (map[string]knownEntry, error) {
+	known := map[string]knownEntry{}
+	hosts, err := p.marshaller.GetHosts()
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range hosts {
+		for _, ip := range []string{h.IP, h.VirtualIP} {
+			if ip != "" {
+				known["ip:"+ip] = knownEntry{hostUUID: h.UUID}
+			}
+		}
+	}
+	opts := p.opts()
+	opts.Args = &nargs.Args{WithMetaTags: true}
+	devices, err := p.marshaller.GetDevices(nil, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range devices {
+		if d.Host != "" {
+			k := known["ip:"+d.Host]
+			k.deviceUUID = d.UUID
+			known["ip:"+d.Host] = k
+		}
+		for _, tag := range d.MetaTags {
+			if tag.Key == MacMetaTag && tag.Value != "" {
+				known["mac:"+normalizeMAC(tag.Value)] = knownEntry{deviceUUID: d.UUID}
+			}
+		}
+	}
+	return known, nil
+}
+
+// diff updates the entries with the scan results, it must be called with the lock held.
+func (p *Pipeline) diff(hosts *scanner.Hosts, known map[string]knownEntry) []*Change {
+	now := p.now()
+	var changes []*Change
+	seen := map[string]bool{}
+	for _, h := range hosts.Hosts {
+		entry := &Entry{IP: h.IP, FirstSeen: now, LastSeen: now}
+		if p.config.ResolveMAC != nil {
+			entry.MAC = normalizeMAC(p.config.ResolveMAC(h.IP))
+		}
+		for _, port := range h.Ports {
+			entry.Ports = append(entry.Ports, port.Port)
+		}
+		sort.Strings(entry.Ports)
+		k, ok := known["mac:"+entry.MAC]
+		if !ok || entry.MAC == "" {
+			k = known["ip:"+entry.IP]
+		}
+		entry.HostUUID, entry.DeviceUUID = k.hostUUID, k.deviceUUID
+
+		key := entry.key()
+		seen[key] = true
+		previous, ok := p.entries[key]
+		if !ok {
+			p.entries[key] = entry
+			changes = append(changes, &Change{Type: Appeared, Entry: entry})
+			continue
+		}
+		entry.FirstSeen = previous.FirstSeen
+		p.entries[key] = entry
+		if previous.IP != entry.IP || strings.Join(previous.Ports, ",") != strings.Join(entry.Ports, ",") ||
+			previous.Known() != entry.Known() {
+			changes = append(changes, &Change{Type: Changed, Entry: entry, Previous: previous})
+		}
+	}
+	for key, entry := range p.entries {
+		if seen[key] {
+			continue
+		}
+		entry.Missed++
+		if entry.Missed >= p.config.MissedScans {
+			delete(p.entries, key)
+			changes = append(changes, &Change{Type: Disappeared, Entry: entry})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Entry.IP < changes[j].Entry.IP })
+	return changes
+}
+
+// save writes the entries and the pending changes through a temp file, so a crash keeps the previous scan.
+func (p *Pipeline) save() error {
+	data, err := json.Marshal(&snapshot{Entries: p.list(), Pending: p.pending})
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(p.dir, snapshotFile)
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (p *Pipeline) list() []*Entry {
+	entries := make([]*Entry, 0, len(p.entries))
+	for _, e := range p.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].IP < entries[j].IP })
+	return entries
+}
+
+// Entries returns the addresses of the last scans, including the ones missing for fewer than MissedScans scans.
+func (p *Pipeline) Entries() []*Entry {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.list()
+}
+
+func (p *Pipeline) report(change *Change, alerts *openAlerts) error {
+	if p.config.MqttTopic != "" {
+		payload, err := json.Marshal(change)
+		if err != nil {
+			return err
+		}
+		if err = p.marshaller.Publish(p.config.MqttTopic, datatype.AtLeastOnce, false, string(payload)); err != nil {
+			return err
+		}
+	}
+	if !p.config.AlertUnknown {
+		return nil
+	}
+	entry := change.Entry
+	tag := Tag(entry)
+	alertUUID, err := alerts.find(tag)
+	if err != nil {
+		return err
+	}
+	if change.Type == Disappeared || entry.Known() {
+		if alertUUID == "" {
+			return nil
+		}
+		_, err = p.marshaller.UpdateAlertStatus(alertUUID, &dto.AlertStatus{Status: string(datatype.AlertStatusClosed)})
+		if err == nil {
+			delete(alerts.byTag, tag)
+		}
+		return err
+	}
+	raise := change.Type == Appeared || change.Type == Changed && change.Previous.Known()
+	if !raise || alertUUID != "" {
+		return nil
+	}
+	address := entry.IP
+	if entry.MAC != "" {
+		address = fmt.Sprintf("%s (%s)", entry.IP, entry.MAC)
+	}
+	alert, err := p.marshaller.CreateAlert(&model.Alert{
+		EntityType: datatype.AlertEntityTypeGateway,
+		EntityUUID: p.config.GatewayUUID,
+		Type:       datatype.AlertTypeFault,
+		Status:     datatype.AlertStatusActive,
+		Severity:   p.config.Severity,
+		Title:      "Unknown device " + address,
+		Body:       fmt.Sprintf("Unknown device %s found with open ports %s", address, strings.Join(entry.Ports, ", ")),
+		Tags:       []*model.Tag{{Tag: tag}},
+	})
+	if err != nil {
+		return err
+	}
+	alerts.byTag[tag] = alert.UUID
+	return nil
+}
+
+// openAlerts maps the tags of the open discovery alerts to their uuids, it is loaded on first use.
+type openAlerts struct {
+	marshaller nmodule.Marshaller
+	byTag      map[string]string
+}
+
+func (a *openAlerts) find(tag string) (string, error) {
+	if a.byTag == nil {
+		alerts, err := a.marshaller.GetAlerts(&nmodule.Opts{Args: &nargs.Args{WithTags: true}})
+		if err != nil {
+			return "", err
+		}
+		a.byTag = map[string]string{}
+		for _, alert := range alerts {
+			if alert.Status == datatype.AlertStatusClosed {
+				continue
+			}
+			for _, t := range alert.Tags {
+				if strings.HasPrefix(t.Tag, tagPrefix) {
+					a.byTag[t.Tag] = alert.UUID
+				}
+			}
+		}
+	}
+	return a.byTag[tag], nil
+}
+
+const tagPrefix = "discovery:"
+
+// Tag is the alert tag of an unknown entry.
+func Tag(entry *Entry) string {
+	return tagPrefix + entry.key()
+}
+
+func normalizeMAC(mac string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(mac), "-", ":"))
+}
+
+// ARPTable resolves macs from /proc/net/arp, which only knows the local network of the host the module runs on.
+func ARPTable(ip string) string {
+	f, err := os.Open("/proc/net/arp")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 4 && fields[0] == ip && fields[3] != "00:00:00:00:00:00" {
+			return fields[3]
+		}
+	}
+	return ""
+}
+
+// Start scans every interval until Stop.
+func (p *Pipeline) Start(interval time.Duration) error {
+	return p.loop.Start(interval, false, func() {
+		if _, err := p.Scan(); err != nil {
+			log.Errorf("discovery: %s", err)
+		}
+	})
+}
+
+func (p *Pipeline) Stop() {
+	p.loop.Stop()
+}
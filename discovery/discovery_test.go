@@ -0,0 +1,187 @@
+package discovery
+
+import (
+	"errors"
+	"fmt"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/lib-networking/scanner"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type fakeMarshaller struct {
+	nmodule.Marshaller
+	hosts       []scanner.Host
+	alerts      []*model.Alert
+	published   []string
+	failPublish bool
+	knownMAC    string // a second device with this mac
+}
+
+func (f *fakeMarshaller) RunScanner(body *dto.Scanner, opts ...*nmodule.Opts) (*scanner.Hosts, error) {
+	return &scanner.Hosts{Hosts: f.hosts}, nil
+}
+
+func (f *fakeMarshaller) GetHosts(opts ...*nmodule.Opts) ([]*model.Host, error) {
+	return []*model.Host{{UUID: "hos_1", IP: "10.0.0.2"}}, nil
+}
+
+func (f *fakeMarshaller) GetDevices(body *dto.Filter, opts ...*nmodule.Opts) ([]*model.Device, error) {
+	device := &model.Device{MetaTags: []*model.DeviceMetaTag{{Key: MacMetaTag, Value: "AA-BB-CC-00-00-03"}}}
+	device.UUID = "dev_1"
+	devices := []*model.Device{device}
+	if f.knownMAC != "" {
+		device = &model.Device{MetaTags: []*model.DeviceMetaTag{{Key: MacMetaTag, Value: f.knownMAC}}}
+		device.UUID = "dev_2"
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+func (f *fakeMarshaller) Publish(topic string, qos datatype.QOS, retain bool, payload string, opts ...*nmodule.Opts) error {
+	if f.failPublish {
+		return errors.New("broker offline")
+	}
+	f.published = append(f.published, payload)
+	return nil
+}
+
+func (f *fakeMarshaller) CreateAlert(body *model.Alert, opts ...*nmodule.Opts) (*model.Alert, error) {
+	body.UUID = fmt.Sprintf("alt_%d", len(f.alerts))
+	f.alerts = append(f.alerts, body)
+	return body, nil
+}
+
+func (f *fakeMarshaller) GetAlerts(opts ...*nmodule.Opts) ([]*model.Alert, error) {
+	return f.alerts, nil
+}
+
+func (f *fakeMarshaller) UpdateAlertStatus(uuid string, body *dto.AlertStatus, opts ...*nmodule.Opts) (*model.Alert, error) {
+	for _, alert := range f.alerts {
+		if alert.UUID == uuid {
+			alert.Status = datatype.AlertStatus(body.Status)
+			return alert, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+var macs = map[string]string{"10.0.0.3": "aa:bb:cc:00:00:03", "10.0.0.9": "aa:bb:cc:00:00:09"}
+
+func open(t *testing.T, m *fakeMarshaller, dir string) *Pipeline {
+	p, err := OpenDir(m, dir, &Config{
+		Scan:         &dto.Scanner{Iface: "eth0"},
+		ResolveMAC:   func(ip string) string { return macs[ip] },
+		MqttTopic:    "discovery",
+		AlertUnknown: true,
+		GatewayUUID:  "hos_1",
+	})
+	assert.NoError(t, err)
+	return p
+}
+
+func TestScan(t *testing.T) {
+	dir := t.TempDir()
+	m := &fakeMarshaller{hosts: []scanner.Host{
+		{IP: "10.0.0.2", Ports: []scanner.PortList{{Port: "22"}}},
+		{IP: "10.0.0.3"},
+		{IP: "10.0.0.9", Ports: []scanner.PortList{{Port: "80"}}},
+	}}
+	p := open(t, m, dir)
+	changes, err := p.Scan()
+	assert.NoError(t, err)
+	assert.Len(t, changes, 3)
+	entries := p.Entries()
+	assert.Equal(t, "hos_1", entries[0].HostUUID)
+	assert.Equal(t, "dev_1", entries[1].DeviceUUID)
+	assert.False(t, entries[2].Known())
+	assert.Len(t, m.published, 3)
+	assert.Len(t, m.alerts, 1)
+	assert.Equal(t, "discovery:mac:aa:bb:cc:00:00:09", m.alerts[0].Tags[0].Tag)
+
+	// the snapshot survives a restart, the device moved to a new ip and the host is missing for one scan
+	macs["10.0.0.4"] = macs["10.0.0.3"]
+	m.hosts = []scanner.Host{{IP: "10.0.0.4"}, {IP: "10.0.0.9", Ports: []scanner.PortList{{Port: "80"}}}}
+	p = open(t, m, dir)
+	changes, err = p.Scan()
+	assert.NoError(t, err)
+	assert.Len(t, changes, 1)
+	assert.Equal(t, Changed, changes[0].Type)
+	assert.Equal(t, "10.0.0.3", changes[0].Previous.IP)
+	assert.Equal(t, time.Duration(0), changes[0].Entry.FirstSeen.Sub(changes[0].Previous.FirstSeen))
+	assert.Len(t, m.alerts, 1)
+
+	changes, err = p.Scan()
+	assert.NoError(t, err)
+	assert.Len(t, changes, 1)
+	assert.Equal(t, Disappeared, changes[0].Type)
+	assert.Equal(t, "10.0.0.2", changes[0].Entry.IP)
+	assert.Len(t, p.Entries(), 2)
+}
+
+func TestOpenDir(t *testing.T) {
+	_, err := OpenDir(&fakeMarshaller{}, t.TempDir(), &Config{Scan: &dto.Scanner{}, AlertUnknown: true})
+	assert.Error(t, err)
+}
+
+func TestUnknownAlerts(t *testing.T) {
+	m := &fakeMarshaller{hosts: []scanner.Host{{IP: "10.0.0.9"}}}
+	p := open(t, m, t.TempDir())
+	_, err := p.Scan()
+	assert.NoError(t, err)
+	assert.Len(t, m.alerts, 1)
+	assert.Equal(t, datatype.AlertTypeFault, m.alerts[0].Type)
+
+	// a lost snapshot doesn't raise the open alert again
+	p = open(t, m, t.TempDir())
+	_, err = p.Scan()
+	assert.NoError(t, err)
+	assert.Len(t, m.alerts, 1)
+
+	// the alert is closed once the device is known, and once an unknown device is gone
+	m.knownMAC = "aa:bb:cc:00:00:09"
+	changes, err := p.Scan()
+	assert.NoError(t, err)
+	assert.Equal(t, Changed, changes[0].Type)
+	assert.Equal(t, datatype.AlertStatusClosed, m.alerts[0].Status)
+
+	m.knownMAC = ""
+	_, err = p.Scan()
+	assert.NoError(t, err)
+	assert.Len(t, m.alerts, 2, "unknown again")
+	m.hosts = nil
+	_, _ = p.Scan()
+	_, err = p.Scan()
+	assert.NoError(t, err)
+	assert.Equal(t, datatype.AlertStatusClosed, m.alerts[1].Status)
+	_, err = p.Scan()
+	assert.NoError(t, err)
+	assert.Len(t, m.alerts, 2)
+}
+
+func TestUnreportedChangesAreKept(t *testing.T) {
+	dir := t.TempDir()
+	m := &fakeMarshaller{hosts: []scanner.Host{{IP: "10.0.0.2"}}, failPublish: true}
+	p := open(t, m, dir)
+	changes, err := p.Scan()
+	assert.NoError(t, err)
+	assert.Len(t, changes, 1)
+	assert.Empty(t, m.published)
+
+	// the change is reported after a restart although the scan finds nothing new
+	m.failPublish = false
+	p = open(t, m, dir)
+	changes, err = p.Scan()
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+	assert.Len(t, m.published, 1)
+	assert.Contains(t, m.published[0], `"type":"appeared"`)
+
+	_, err = p.Scan()
+	assert.NoError(t, err)
+	assert.Len(t, m.published, 1)
+}
@@ -0,0 +1,410 @@
+package escalation
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/NubeIO/lib-module-go/loop"
+	"github.com/NubeIO/lib-module-go/mail"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/nargs"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// StepMetaTag is the alert meta-tag holding the last fired step, so a restart doesn't fire the steps again.
+	StepMetaTag = "escalation_step"
+	// TicketMetaTag links the alert to its ticket, an alert already linked to a ticket doesn't get another one.
+	TicketMetaTag = "ticket_uuid"
+)
+
+var defaultTemplate = func() *mail.Template {
+	t, err := mail.ParseTemplate("{{with index .Alerts 0}}[{{.Severity}}] {{.Title}}{{end}}",
+		"{{with index .Alerts 0}}{{.Body}}{{end}}")
+	if err != nil {
+		panic(err)
+	}
+	return t
+}()
+
+type stepState struct {
+	fired     time.Time
+	notified  time.Time
+	published time.Time // after notified while the email of the notification is still due
+}
+
+type alertState struct {
+	policy    *Policy
+	firstSeen time.Time
+	steps     []*stepState // only changed by Check
+}
+
+// due are the steps of an alert to run, collected under the lock.
+type due struct {
+	alert  *model.Alert
+	state  *alertState
+	fire   []int // steps whose one-off actions are due
+	notify []int // steps whose notification is due
+}
+
+// Escalator walks active, unacknowledged alerts through the steps of the first matching policy.
+type Escalator struct {
+	marshaller nmodule.Marshaller
+
+	checkMutex sync.Mutex
+	mutex      sync.Mutex
+	mailer     *mail.Mailer
+	policies   []*Policy
+	onCall     map[string]*OnCall // by team uuid
+	alerts     map[string]*alertState
+	loop       loop.Loop
+	now        func() time.Time
+}
+
+func New(marshaller nmodule.Marshaller) *Escalator {
+	return &Escalator{
+		marshaller: marshaller,
+		mailer:     mail.New(marshaller, &mail.Config{}),
+		onCall:     map[string]*OnCall{},
+		alerts:     map[string]*alertState{},
+		now:        time.Now,
+	}
+}
+
+// AddPolicy appends a policy, alerts use the first policy which matches them.
+func (e *Escalator) AddPolicy(policy *Policy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for _, p := range e.policies {
+		if p.Name == policy.Name {
+			return fmt.Errorf("policy %s already exists", policy.Name)
+		}
+	}
+	e.policies = append(e.policies, policy)
+	return nil
+}
+
+// SetMailer replaces the mailer of the notifications, e.g. to share its rate limit with other emails of the module.
+func (e *Escalator) SetMailer(mailer *mail.Mailer) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.mailer = mailer
+}
+
+func (e *Escalator) SetOnCall(onCall *OnCall) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.onCall[onCall.TeamUUID] = onCall
+}
+
+// Check runs the due steps of all active alerts. The steps are collected under the lock and run without it, a step
+// only counts as done once the host accepted it.
+func (e *Escalator) Check() error {
+	e.checkMutex.Lock()
+	defer e.checkMutex.Unlock()
+	alerts, err := e.marshaller.GetAlerts(&nmodule.Opts{Args: &nargs.Args{WithTeams: true, WithMetaTags: true}})
+	if err != nil {
+		return err
+	}
+	e.mutex.Lock()
+	now := e.now()
+	active := map[string]bool{}
+	var dues []*due
+	for _, alert := range alerts {
+		if alert.Status != datatype.AlertStatusActive {
+			continue
+		}
+		active[alert.UUID] = true
+		if d := e.due(alert, now); d != nil {
+			dues = append(dues, d)
+		}
+	}
+	for uuid := range e.alerts {
+		if !active[uuid] {
+			delete(e.alerts, uuid)
+		}
+	}
+	onCall := make(map[string]*OnCall, len(e.onCall))
+	for teamUUID, o := range e.onCall {
+		onCall[teamUUID] = o
+	}
+	mailer := e.mailer
+	e.mutex.Unlock()
+
+	var firstErr error
+	for _, d := range dues {
+		if err = e.run(d, onCall, mailer, now); err != nil {
+			log.Errorf("escalation: alert %s: %s", d.alert.UUID, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// due returns the steps of the alert to run, nil when there are none. The caller holds the mutex.
+func (e *Escalator) due(alert *model.Alert, now time.Time) *due {
+	state, ok := e.alerts[alert.UUID]
+	if !ok {
+		policy := e.policy(alert)
+		if policy == nil {
+			return nil
+		}
+		state = &alertState{policy: policy, firstSeen: now, steps: make([]*stepState, len(policy.Steps))}
+		for i := range state.steps {
+			state.steps[i] = &stepState{}
+		}
+		if fired, ok := firedStep(alert); ok {
+			for i := 0; i <= fired && i < len(state.steps); i++ {
+				state.steps[i] = &stepState{fired: now, notified: now}
+			}
+		}
+		e.alerts[alert.UUID] = state
+	}
+	since := state.firstSeen
+	if alert.CreatedAt != nil {
+		since = *alert.CreatedAt
+	}
+	age := now.Sub(since)
+	d := &due{alert: alert, state: state}
+	last := -1
+	for i, step := range state.policy.Steps {
+		if age < step.After {
+			break
+		}
+		last = i
+		if state.steps[i].fired.IsZero() {
+			d.fire = append(d.fire, i)
+		}
+	}
+	quiet := state.policy.QuietHours.active(now, alert.Severity)
+	for i := 0; i <= last; i++ {
+		step, st := state.policy.Steps[i], state.steps[i]
+		if !step.notifies() || quiet {
+			continue
+		}
+		if st.notified.IsZero() || (i == last && step.Repeat > 0 && now.Sub(st.notified) >= step.Repeat) {
+			d.notify = append(d.notify, i)
+		}
+	}
+	if len(d.fire) == 0 && len(d.notify) == 0 {
+		return nil
+	}
+	return d
+}
+
+// run calls the host for the due steps of an alert and stops at the first failure, the failed step is due again on
+// the next Check.
+func (e *Escalator) run(d *due, onCall map[string]*OnCall, mailer *mail.Mailer, now time.Time) error {
+	for _, i := range d.fire {
+		if err := e.fire(d.alert, d.state.policy.Steps[i], i); err != nil {
+			return err
+		}
+		d.state.steps[i].fired = now
+	}
+	for _, i := range d.notify {
+		st := d.state.steps[i]
+		if err := e.notify(d.alert, d.state.policy.Steps[i], st, onCall, mailer, now); err != nil {
+			return err
+		}
+		st.notified = now
+	}
+	return nil
+}
+
+func (e *Escalator) policy(alert *model.Alert) *Policy {
+	for _, p := range e.policies {
+		if p.matches(alert) {
+			return p
+		}
+	}
+	return nil
+}
+
+func firedStep(alert *model.Alert) (int, bool) {
+	for _, tag := range alert.MetaTags {
+		if tag.Key == StepMetaTag {
+			step, err := strconv.Atoi(tag.Value)
+			return step, err == nil
+		}
+	}
+	return 0, false
+}
+
+// fire runs the one-off actions of a step: escalating to the team and opening a ticket. The step is recorded in the
+// alert meta-tags together with the ticket, a ticket which can't be recorded is deleted so the next Check doesn't open
+// a second one.
+func (e *Escalator) fire(alert *model.Alert, step *Step, index int) error {
+	if step.TeamUUID != "" && !hasTeam(alert, step.TeamUUID) {
+		teams := []*string{&step.TeamUUID}
+		for _, t := range alert.Teams {
+			teamUUID := t.TeamUUID
+			teams = append(teams, &teamUUID)
+		}
+		alertTeams, err := e.marshaller.UpdateAlertTeams(alert.UUID, teams)
+		if err != nil {
+			return err
+		}
+		alert.Teams = alertTeams
+	}
+	metaTags := []*model.AlertMetaTag{{AlertUUID: alert.UUID, Key: StepMetaTag, Value: strconv.Itoa(index)}}
+	ticketUUID, linked := metaTag(alert, TicketMetaTag)
+	var ticket *model.Ticket
+	if step.CreateTicket && !linked {
+		priority := step.TicketPriority
+		if priority == "" {
+			priority = datatype.TicketPriorityHigh
+		}
+		alertUUID := alert.UUID
+		var err error
+		ticket, err = e.marshaller.CreateTicket(&model.Ticket{
+			AlertUUID: &alertUUID,
+			Issuer:    "escalation",
+			Subject:   alert.Title,
+			Content:   alert.Body,
+			Priority:  priority,
+			Status:    datatype.TicketStatusNew,
+		})
+		if err != nil {
+			return err
+		}
+		ticketUUID = ticket.UUID
+		metaTags = append(metaTags, &model.AlertMetaTag{AlertUUID: alert.UUID, Key: TicketMetaTag, Value: ticketUUID})
+	}
+	if err := e.marshaller.UpsertAlertMetaTags(alert.UUID, metaTags); err != nil {
+		if ticket != nil {
+			if deleteErr := e.marshaller.DeleteTicket(ticket.UUID); deleteErr != nil {
+				log.Errorf("escalation: failed to delete unrecorded ticket %s: %s", ticket.UUID, deleteErr)
+			}
+		}
+		return err
+	}
+	alert.MetaTags = append(alert.MetaTags, metaTags...)
+	if step.CreateTicket && (ticket != nil || step.TeamUUID != "") {
+		if teams := teamUUIDs(alert, step); len(teams) > 0 {
+			if _, err := e.marshaller.UpsertTicketTeams(ticketUUID, teams); err != nil {
+				log.Errorf("escalation: failed to add the teams to ticket %s: %s", ticketUUID, err)
+			}
+		}
+	}
+	return nil
+}
+
+func metaTag(alert *model.Alert, key string) (string, bool) {
+	for _, tag := range alert.MetaTags {
+		if tag.Key == key && tag.Value != "" {
+			return tag.Value, true
+		}
+	}
+	return "", false
+}
+
+func hasTeam(alert *model.Alert, teamUUID string) bool {
+	for _, t := range alert.Teams {
+		if t.TeamUUID == teamUUID {
+			return true
+		}
+	}
+	return false
+}
+
+// teamUUIDs returns the team of the step, or the teams of the alert when the step has none.
+func teamUUIDs(alert *model.Alert, step *Step) []*string {
+	if step.TeamUUID != "" {
+		teamUUID := step.TeamUUID
+		return []*string{&teamUUID}
+	}
+	var teams []*string
+	for _, t := range alert.Teams {
+		teamUUID := t.TeamUUID
+		teams = append(teams, &teamUUID)
+	}
+	return teams
+}
+
+// notify publishes and emails the notification of a step, a notification whose email failed isn't published again.
+func (e *Escalator) notify(alert *model.Alert, step *Step, st *stepState, onCall map[string]*OnCall,
+	mailer *mail.Mailer, now time.Time) error {
+	if step.MqttTopic != "" && (st.published.IsZero() || !st.published.After(st.notified)) {
+		payload, err := json.Marshal(alert)
+		if err != nil {
+			return err
+		}
+		if err = e.marshaller.Publish(step.MqttTopic, datatype.AtLeastOnce, false, string(payload)); err != nil {
+			return err
+		}
+		st.published = now
+	}
+	if !step.Email {
+		return nil
+	}
+	recipients, err := e.recipients(teamUUIDs(alert, step), onCall, now)
+	if err != nil {
+		return err
+	}
+	if len(recipients) == 0 {
+		log.Warnf("escalation: alert %s has nobody to email", alert.UUID)
+		return nil
+	}
+	template := step.EmailTemplate
+	if template == nil {
+		template = defaultTemplate
+	}
+	_, err = mailer.Send(&mail.Message{
+		To:       recipients,
+		Template: template,
+		Data:     &mail.Data{Alerts: []*model.Alert{alert}},
+	})
+	if err == mail.ErrRateLimited {
+		log.Warnf("escalation: emails of alert %s are rate limited", alert.UUID)
+		return nil
+	}
+	return err
+}
+
+// recipients returns the emails of the on-call members of the teams, or of all members when nobody is on call.
+func (e *Escalator) recipients(teamUUIDs []*string, onCall map[string]*OnCall, now time.Time) ([]string, error) {
+	seen := map[string]bool{}
+	var emails []string
+	for _, teamUUID := range teamUUIDs {
+		team, err := e.marshaller.GetTeam(*teamUUID, &nmodule.Opts{Args: &nargs.Args{WithMembers: true}})
+		if err != nil {
+			return nil, err
+		}
+		members := map[string]bool{}
+		for _, m := range onCall[*teamUUID].members(now) {
+			members[m] = true
+		}
+		for _, member := range team.Members {
+			if len(members) > 0 && !members[member.UUID] {
+				continue
+			}
+			if member.Email != "" && !seen[member.Email] {
+				seen[member.Email] = true
+				emails = append(emails, member.Email)
+			}
+		}
+	}
+	return emails, nil
+}
+
+// Start calls Check every tick until Stop.
+func (e *Escalator) Start(tick time.Duration) error {
+	return e.loop.Start(tick, false, func() {
+		if err := e.Check(); err != nil {
+			log.Errorf("escalation: %s", err)
+		}
+	})
+}
+
+func (e *Escalator) Stop() {
+	e.loop.Stop()
+}
@@ -0,0 +1,196 @@
+package escalation
+
+import (
+	"errors"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type fakeMarshaller struct {
+	nmodule.Marshaller
+	alert     *model.Alert
+	emails    []*model.Email
+	published []string
+	tickets   []*model.Ticket
+	failTags  bool
+	failEmail bool
+}
+
+func (f *fakeMarshaller) GetAlerts(opts ...*nmodule.Opts) ([]*model.Alert, error) {
+	return []*model.Alert{f.alert}, nil
+}
+
+func (f *fakeMarshaller) GetTeam(uuid string, opts ...*nmodule.Opts) (*model.Team, error) {
+	return &model.Team{UUID: uuid, Members: []*model.Member{
+		{CommonUUID: model.CommonUUID{UUID: uuid + "_1"}, Email: uuid + "_1@nube.io"},
+		{CommonUUID: model.CommonUUID{UUID: uuid + "_2"}, Email: uuid + "_2@nube.io"},
+	}}, nil
+}
+
+func (f *fakeMarshaller) SendEmail(body *model.Email, opts ...*nmodule.Opts) (*model.Email, error) {
+	if f.failEmail {
+		return nil, errors.New("smtp unavailable")
+	}
+	f.emails = append(f.emails, body)
+	return body, nil
+}
+
+func (f *fakeMarshaller) Publish(topic string, qos datatype.QOS, retain bool, payload string, opts ...*nmodule.Opts) error {
+	f.published = append(f.published, topic)
+	return nil
+}
+
+func (f *fakeMarshaller) UpdateAlertTeams(uuid string, teamUUIDs []*string, opts ...*nmodule.Opts) ([]*model.AlertTeam, error) {
+	var teams []*model.AlertTeam
+	for _, t := range teamUUIDs {
+		teams = append(teams, &model.AlertTeam{AlertUUID: uuid, TeamUUID: *t})
+	}
+	return teams, nil
+}
+
+func (f *fakeMarshaller) CreateTicket(body *model.Ticket, opts ...*nmodule.Opts) (*model.Ticket, error) {
+	body.UUID = "tkt"
+	f.tickets = append(f.tickets, body)
+	return body, nil
+}
+
+func (f *fakeMarshaller) DeleteTicket(uuid string, opts ...*nmodule.Opts) error {
+	for i, t := range f.tickets {
+		if t.UUID == uuid {
+			f.tickets = append(f.tickets[:i], f.tickets[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (f *fakeMarshaller) UpsertTicketTeams(uuid string, teamUUIDs []*string, opts ...*nmodule.Opts) ([]*model.TicketTeam, error) {
+	return nil, nil
+}
+
+func (f *fakeMarshaller) UpsertAlertMetaTags(uuid string, body []*model.AlertMetaTag, opts ...*nmodule.Opts) error {
+	if f.failTags {
+		return errors.New("meta-tags unavailable")
+	}
+	f.alert.MetaTags = body
+	return nil
+}
+
+func TestEscalation(t *testing.T) {
+	created := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC) // a monday
+	m := &fakeMarshaller{alert: &model.Alert{UUID: "alt", Status: datatype.AlertStatusActive,
+		Severity: datatype.AlertSeverityMinor, Title: "AHU offline", Body: "no response from 10.0.0.5",
+		CreatedAt: &created, Teams: []*model.AlertTeam{{AlertUUID: "alt", TeamUUID: "ops"}}}}
+	e := New(m)
+	now := created
+	e.now = func() time.Time { return now }
+	assert.NoError(t, e.AddPolicy(&Policy{
+		Name: "default",
+		Steps: []*Step{
+			{Email: true, Repeat: 10 * time.Minute},
+			{After: 30 * time.Minute, TeamUUID: "managers", Email: true, MqttTopic: "alerts", CreateTicket: true},
+		},
+		QuietHours: &QuietHours{Start: 22 * time.Hour, End: 7 * time.Hour},
+	}))
+	e.SetOnCall(&OnCall{TeamUUID: "ops", Shifts: []*Shift{
+		{Weekdays: []time.Weekday{time.Monday}, Start: 9 * time.Hour, End: 17 * time.Hour, MemberUUIDs: []string{"ops_2"}},
+	}})
+
+	assert.NoError(t, e.Check())
+	assert.Len(t, m.emails, 1)
+	assert.Equal(t, "ops_2@nube.io", m.emails[0].To, "only the on-call member")
+	assert.Equal(t, "["+string(datatype.AlertSeverityMinor)+"] AHU offline", m.emails[0].Subject)
+	assert.Equal(t, "no response from 10.0.0.5", m.emails[0].Body)
+
+	now = created.Add(5 * time.Minute)
+	assert.NoError(t, e.Check())
+	assert.Len(t, m.emails, 1)
+	now = created.Add(10 * time.Minute)
+	assert.NoError(t, e.Check())
+	assert.Len(t, m.emails, 2, "re-notified")
+
+	now = created.Add(30 * time.Minute)
+	assert.NoError(t, e.Check())
+	assert.Len(t, m.emails, 3)
+	assert.Equal(t, "managers_1@nube.io;managers_2@nube.io", m.emails[2].To)
+	assert.Equal(t, []string{"alerts"}, m.published)
+	assert.Len(t, m.tickets, 1)
+	assert.Equal(t, datatype.TicketPriorityHigh, m.tickets[0].Priority, "high when the step has no priority")
+	assert.Len(t, m.alert.Teams, 2)
+	assert.Equal(t, "1", m.alert.MetaTags[0].Value)
+
+	now = created.Add(40 * time.Minute)
+	assert.NoError(t, e.Check())
+	assert.Len(t, m.emails, 3, "only the last step repeats and it doesn't")
+
+	// a restart doesn't fire the steps again
+	e2 := New(m)
+	e2.now = e.now
+	assert.NoError(t, e2.AddPolicy(e.policies[0]))
+	assert.NoError(t, e2.Check())
+	assert.Len(t, m.tickets, 1)
+	assert.Len(t, m.emails, 3)
+}
+
+func TestQuietHours(t *testing.T) {
+	created := time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC)
+	m := &fakeMarshaller{alert: &model.Alert{UUID: "alt", Status: datatype.AlertStatusActive, CreatedAt: &created,
+		Teams: []*model.AlertTeam{{AlertUUID: "alt", TeamUUID: "ops"}}}}
+	e := New(m)
+	now := created
+	e.now = func() time.Time { return now }
+	assert.NoError(t, e.AddPolicy(&Policy{Name: "p", Steps: []*Step{{Email: true}},
+		QuietHours: &QuietHours{Start: 22 * time.Hour, End: 7 * time.Hour}}))
+	assert.NoError(t, e.Check())
+	assert.Empty(t, m.emails)
+	now = created.Add(8 * time.Hour)
+	assert.NoError(t, e.Check())
+	assert.Len(t, m.emails, 1)
+}
+
+func TestTicketIsRecordedWithTheStep(t *testing.T) {
+	created := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	m := &fakeMarshaller{failTags: true, alert: &model.Alert{UUID: "alt", Status: datatype.AlertStatusActive,
+		CreatedAt: &created}}
+	e := New(m)
+	e.now = func() time.Time { return created }
+	assert.NoError(t, e.AddPolicy(&Policy{Name: "p", Steps: []*Step{{CreateTicket: true}}}))
+
+	// a ticket which can't be recorded is deleted instead of opened again on the next check
+	assert.Error(t, e.Check())
+	assert.Empty(t, m.tickets)
+	m.failTags = false
+	assert.NoError(t, e.Check())
+	assert.NoError(t, e.Check())
+	assert.Len(t, m.tickets, 1)
+	assert.Equal(t, TicketMetaTag, m.alert.MetaTags[1].Key)
+	assert.Equal(t, "tkt", m.alert.MetaTags[1].Value)
+}
+
+func TestFailedEmailIsNotPublishedAgain(t *testing.T) {
+	created := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	m := &fakeMarshaller{failEmail: true, alert: &model.Alert{UUID: "alt", Status: datatype.AlertStatusActive,
+		CreatedAt: &created, Teams: []*model.AlertTeam{{AlertUUID: "alt", TeamUUID: "ops"}}}}
+	e := New(m)
+	now := created
+	e.now = func() time.Time { return now }
+	assert.NoError(t, e.AddPolicy(&Policy{Name: "p", Steps: []*Step{{Email: true, MqttTopic: "alerts",
+		Repeat: 10 * time.Minute}}}))
+
+	assert.Error(t, e.Check())
+	assert.Error(t, e.Check())
+	assert.Equal(t, []string{"alerts"}, m.published)
+	m.failEmail = false
+	assert.NoError(t, e.Check())
+	assert.Len(t, m.emails, 1)
+	assert.Len(t, m.published, 1)
+
+	now = now.Add(10 * time.Minute)
+	assert.NoError(t, e.Check())
+	assert.Len(t, m.emails, 2)
+	assert.Len(t, m.published, 2)
+}
@@ -0,0 +1,171 @@
+package escalation
+
+import (
+	"errors"
+	"fmt"
+	"github.com/NubeIO/lib-module-go/mail"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"time"
+)
+
+// Step fires once the alert is unacknowledged for After, its notifications repeat every Repeat until acknowledged.
+type Step struct {
+	After          time.Duration
+	Repeat         time.Duration // 0 notifies once
+	TeamUUID       string        // escalates the alert to the team, its on-call members are notified
+	Email          bool
+	EmailTemplate  *mail.Template // rendered with the alert as Alerts[0], the severity, title and body when nil
+	MqttTopic      string         // publishes the alert as json when set
+	CreateTicket   bool
+	TicketPriority datatype.TicketPriority
+}
+
+func (s *Step) notifies() bool {
+	return s.Email || s.MqttTopic != ""
+}
+
+type Policy struct {
+	Name       string
+	Severities []datatype.AlertSeverity // empty matches all
+	Types      []datatype.AlertType     // empty matches all
+	Steps      []*Step                  // ordered by After
+	QuietHours *QuietHours
+}
+
+func (p *Policy) Validate() error {
+	if p.Name == "" {
+		return errors.New("policy name is required")
+	}
+	if len(p.Steps) == 0 {
+		return fmt.Errorf("policy %s has no steps", p.Name)
+	}
+	for i, s := range p.Steps {
+		if i > 0 && s.After < p.Steps[i-1].After {
+			return fmt.Errorf("steps of policy %s must be ordered by after", p.Name)
+		}
+		if s.Repeat < 0 || s.After < 0 {
+			return fmt.Errorf("policy %s has a negative duration", p.Name)
+		}
+	}
+	if p.QuietHours != nil {
+		return p.QuietHours.validate()
+	}
+	return nil
+}
+
+func (p *Policy) matches(alert *model.Alert) bool {
+	return (len(p.Severities) == 0 || containsSeverity(p.Severities, alert.Severity)) &&
+		(len(p.Types) == 0 || containsType(p.Types, alert.Type))
+}
+
+func containsSeverity(severities []datatype.AlertSeverity, severity datatype.AlertSeverity) bool {
+	for _, s := range severities {
+		if s == severity {
+			return true
+		}
+	}
+	return false
+}
+
+func containsType(types []datatype.AlertType, alertType datatype.AlertType) bool {
+	for _, t := range types {
+		if t == alertType {
+			return true
+		}
+	}
+	return false
+}
+
+// QuietHours hold back email and mqtt notifications, they go out when the quiet hours end. Escalation to teams and
+// tickets are not held back.
+type QuietHours struct {
+	Start    time.Duration // time of day, e.g. 22h
+	End      time.Duration // time of day, e.g. 7h, before Start when the quiet hours span midnight
+	Location *time.Location
+	Except   []datatype.AlertSeverity // severities which notify anyway
+}
+
+func (q *QuietHours) validate() error {
+	if q.Start < 0 || q.Start >= 24*time.Hour || q.End < 0 || q.End >= 24*time.Hour {
+		return errors.New("quiet hours must be a time of day")
+	}
+	return nil
+}
+
+func (q *QuietHours) active(now time.Time, severity datatype.AlertSeverity) bool {
+	if q == nil || q.Start == q.End || containsSeverity(q.Except, severity) {
+		return false
+	}
+	return inWindow(now, q.Location, q.Start, q.End)
+}
+
+// inWindow reports whether the time of day of now is within start and end, the window may span midnight.
+func inWindow(now time.Time, location *time.Location, start, end time.Duration) bool {
+	if location != nil {
+		now = now.In(location)
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	t := now.Sub(midnight)
+	if start < end {
+		return t >= start && t < end
+	}
+	return t >= start || t < end
+}
+
+// Shift puts members on call for a time of day on some weekdays.
+type Shift struct {
+	Weekdays    []time.Weekday // empty is every day
+	Start       time.Duration
+	End         time.Duration // before Start when the shift spans midnight, equal to Start for the whole day
+	MemberUUIDs []string
+}
+
+// OnCall is the roster of a team, all members of the team are notified when no shift covers the time.
+type OnCall struct {
+	TeamUUID string
+	Location *time.Location
+	Shifts   []*Shift
+}
+
+func (o *OnCall) members(now time.Time) []string {
+	if o == nil {
+		return nil
+	}
+	local := now
+	if o.Location != nil {
+		local = now.In(o.Location)
+	}
+	seen := map[string]bool{}
+	var members []string
+	for _, shift := range o.Shifts {
+		if len(shift.Weekdays) > 0 && !containsWeekday(shift.Weekdays, local, shift.Start, shift.End) {
+			continue
+		}
+		if shift.Start != shift.End && !inWindow(local, nil, shift.Start, shift.End) {
+			continue
+		}
+		for _, m := range shift.MemberUUIDs {
+			if !seen[m] {
+				seen[m] = true
+				members = append(members, m)
+			}
+		}
+	}
+	return members
+}
+
+// containsWeekday checks the weekday the shift started on, which is the day before for the part after midnight.
+func containsWeekday(weekdays []time.Weekday, local time.Time, start, end time.Duration) bool {
+	day := local.Weekday()
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	if start > end && local.Sub(midnight) < end {
+		day = (day + 6) % 7
+	}
+	for _, w := range weekdays {
+		if w == day {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+var csvHeader = []string{"history_id", "host_uuid", "point_uuid", "value", "timestamp"}
+
+type CSVConfig struct {
+	Dir      string
+	Prefix   string
+	MaxRows  int           // rows per file before it is rotated, 0 disables
+	MaxAge   time.Duration // age of a file before it is rotated, 0 disables
+	FileMode os.FileMode
+}
+
+// CSVSink appends histories to csv files in Dir and starts a new file on rotation.
+type CSVSink struct {
+	config  *CSVConfig
+	file    *os.File
+	writer  *csv.Writer
+	rows    int
+	created time.Time
+}
+
+func NewCSVSink(config *CSVConfig) (*CSVSink, error) {
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, err
+	}
+	return &CSVSink{config: config}, nil
+}
+
+func (s *CSVSink) Name() string {
+	return "csv"
+}
+
+func (s *CSVSink) Write(histories []*model.History) error {
+	for _, h := range histories {
+		if s.file == nil || s.due() {
+			if err := s.rotate(); err != nil {
+				return err
+			}
+		}
+		value := ""
+		if h.Value != nil {
+			value = strconv.FormatFloat(*h.Value, 'f', -1, 64)
+		}
+		if err := s.writer.Write([]string{
+			strconv.Itoa(h.HistoryID), h.HostUUID, h.PointUUID, value, h.Timestamp.UTC().Format(time.RFC3339Nano),
+		}); err != nil {
+			return err
+		}
+		s.rows++
+	}
+	return s.sync()
+}
+
+func (s *CSVSink) due() bool {
+	if s.config.MaxRows > 0 && s.rows >= s.config.MaxRows {
+		return true
+	}
+	return s.config.MaxAge > 0 && time.Since(s.created) >= s.config.MaxAge
+}
+
+func (s *CSVSink) rotate() error {
+	if err := s.Close(); err != nil {
+		return err
+	}
+	prefix := s.config.Prefix
+	if prefix == "" {
+		prefix = "histories"
+	}
+	mode := s.config.FileMode
+	if mode == 0 {
+		mode = 0644
+	}
+	now := time.Now().UTC()
+	name := fmt.Sprintf("%s-%s.csv", prefix, now.Format("20060102T150405.000000000"))
+	f, err := os.OpenFile(filepath.Join(s.config.Dir, name), os.O_CREATE|os.O_WRONLY|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	s.file = f
+	s.writer = csv.NewWriter(f)
+	s.rows = 0
+	s.created = now
+	return s.writer.Write(csvHeader)
+}
+
+func (s *CSVSink) sync() error {
+	if s.writer == nil {
+		return nil
+	}
+	s.writer.Flush()
+	if err := s.writer.Error(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *CSVSink) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.sync()
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	s.file = nil
+	s.writer = nil
+	return err
+}
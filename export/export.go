@@ -0,0 +1,145 @@
+package export
+
+import (
+	"encoding/json"
+	"github.com/NubeIO/lib-module-go/loop"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/lib-module-go/pgsync"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Sink receives the histories in id order, a batch counts as exported once Write returns without error.
+type Sink interface {
+	Name() string
+	Write(histories []*model.History) error
+	Close() error
+}
+
+// Checkpoint stores the id of the last exported history, the export resumes after it.
+type Checkpoint interface {
+	Load() (int, error)
+	Save(last *model.History) error
+}
+
+type Config struct {
+	FetchSize int           // histories fetched from the host per round trip
+	BatchSize int           // histories written and checkpointed together
+	Interval  time.Duration // how often Start runs an export
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		FetchSize: 10000,
+		BatchSize: 1000,
+		Interval:  5 * time.Minute,
+	}
+}
+
+// Exporter feeds a Sink from the same history cursor as the postgres sync and checkpoints every written batch, so a
+// crashed export replays at most one batch.
+type Exporter struct {
+	marshaller nmodule.Marshaller
+	sink       Sink
+	checkpoint Checkpoint
+	config     *Config
+
+	exportMutex sync.Mutex
+	loop        loop.Loop
+}
+
+func New(marshaller nmodule.Marshaller, sink Sink, checkpoint Checkpoint, config *Config) *Exporter {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	return &Exporter{
+		marshaller: marshaller,
+		sink:       sink,
+		checkpoint: checkpoint,
+		config:     config,
+	}
+}
+
+// Export writes every history after the checkpoint and returns how many were exported.
+func (e *Exporter) Export() (int, error) {
+	e.exportMutex.Lock()
+	defer e.exportMutex.Unlock()
+
+	lastSyncID, err := e.checkpoint.Load()
+	if err != nil {
+		return 0, err
+	}
+	cursor := &pgsync.Cursor{Marshaller: e.marshaller, FetchSize: e.config.FetchSize, BatchSize: e.config.BatchSize}
+	return cursor.Each(lastSyncID, func(batch []*model.History) error {
+		if err := e.sink.Write(batch); err != nil {
+			return err
+		}
+		return e.checkpoint.Save(batch[len(batch)-1])
+	})
+}
+
+// Start runs Export every Interval until Stop.
+func (e *Exporter) Start() error {
+	return e.loop.Start(e.config.Interval, true, func() {
+		if _, err := e.Export(); err != nil {
+			log.Errorf("export %s: %s", e.sink.Name(), err)
+		}
+	})
+}
+
+// Stop ends the export loop and closes the sink.
+func (e *Exporter) Stop() error {
+	e.loop.Stop()
+	return e.sink.Close()
+}
+
+// DataDir returns a sub directory of the module dir, creating it when needed.
+func DataDir(marshaller nmodule.Marshaller, moduleName, subDir string) (string, error) {
+	moduleDir, err := marshaller.CreateModuleDir(moduleName)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(*moduleDir, subDir)
+	return dir, os.MkdirAll(dir, 0755)
+}
+
+type fileCheckpointState struct {
+	LastSyncID int       `json:"last_sync_id"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// FileCheckpoint keeps the cursor of one sink in a json file, typically next to the exported files.
+type FileCheckpoint struct {
+	Path string
+}
+
+func (c *FileCheckpoint) Load() (int, error) {
+	data, err := os.ReadFile(c.Path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var state fileCheckpointState
+	if err = json.Unmarshal(data, &state); err != nil {
+		return 0, err
+	}
+	return state.LastSyncID, nil
+}
+
+func (c *FileCheckpoint) Save(last *model.History) error {
+	data, err := json.Marshal(&fileCheckpointState{LastSyncID: last.HistoryID, Timestamp: last.Timestamp})
+	if err != nil {
+		return err
+	}
+	tmp := c.Path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.Path)
+}
@@ -0,0 +1,111 @@
+package export
+
+import (
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeMarshaller struct {
+	nmodule.Marshaller
+	histories []*model.History
+}
+
+func (f *fakeMarshaller) GetHistoriesForPostgresSync(opts ...*nmodule.Opts) ([]*model.History, error) {
+	idGt, _ := strconv.Atoi(*opts[0].Args.IdGt)
+	var out []*model.History
+	for _, h := range f.histories {
+		if h.HistoryID > idGt && len(out) < *opts[0].Args.Limit {
+			out = append(out, h)
+		}
+	}
+	return out, nil
+}
+
+func TestLineProtocol(t *testing.T) {
+	value := 21.5
+	line := LineProtocol("rubix history", &model.History{HostUUID: "hst", PointUUID: "pnt,1", Value: &value,
+		Timestamp: time.Unix(1700000000, 0)})
+	assert.Equal(t, `rubix\ history,host_uuid=hst,point_uuid=pnt\,1 value=21.5 1700000000000`, line)
+	assert.Empty(t, LineProtocol("history", &model.History{}))
+
+	// empty tags are left out rather than written as host_uuid=
+	line = LineProtocol("history", &model.History{PointUUID: "pnt", Value: &value, Timestamp: time.Unix(1, 0)})
+	assert.Equal(t, "history,point_uuid=pnt value=21.5 1000", line)
+
+	for _, v := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		v := v
+		assert.Empty(t, LineProtocol("history", &model.History{PointUUID: "pnt", Value: &v}))
+	}
+}
+
+func TestParquetRoundTrip(t *testing.T) {
+	var histories []*model.History
+	for i := 1; i <= 3; i++ {
+		h := &model.History{HistoryID: i, HostUUID: "hst", PointUUID: "pnt-" + strconv.Itoa(i),
+			Timestamp: time.Unix(1700000000, int64(i)*int64(time.Millisecond))}
+		if i != 2 {
+			value := float64(i) / 2
+			h.Value = &value
+		}
+		histories = append(histories, h)
+	}
+
+	data, err := EncodeParquet(histories)
+	assert.NoError(t, err)
+	file, err := buffer.NewBufferFile(data)
+	assert.NoError(t, err)
+	pr, err := reader.NewParquetReader(file, new(parquetRow), 1)
+	assert.NoError(t, err)
+	defer pr.ReadStop()
+	assert.Equal(t, int64(3), pr.GetNumRows())
+
+	rows := make([]parquetRow, 3)
+	assert.NoError(t, pr.Read(&rows))
+	for i, h := range histories {
+		assert.Equal(t, int64(h.HistoryID), rows[i].HistoryID)
+		assert.Equal(t, h.HostUUID, rows[i].HostUUID)
+		assert.Equal(t, h.PointUUID, rows[i].PointUUID)
+		assert.Equal(t, h.Value, rows[i].Value)
+		assert.Equal(t, h.Timestamp.UnixMilli(), rows[i].Timestamp)
+	}
+}
+
+func TestExportToCSVWithCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	m := &fakeMarshaller{}
+	for i := 1; i <= 5; i++ {
+		value := float64(i)
+		m.histories = append(m.histories, &model.History{HistoryID: i, HostUUID: "hst", PointUUID: "pnt",
+			Value: &value, Timestamp: time.Unix(int64(i), 0)})
+	}
+	sink, err := NewCSVSink(&CSVConfig{Dir: dir, MaxRows: 3})
+	assert.NoError(t, err)
+	checkpoint := &FileCheckpoint{Path: filepath.Join(dir, "csv.checkpoint")}
+	exporter := New(m, sink, checkpoint, &Config{FetchSize: 2, BatchSize: 2})
+
+	exported, err := exporter.Export()
+	assert.NoError(t, err)
+	assert.Equal(t, 5, exported)
+	last, _ := checkpoint.Load()
+	assert.Equal(t, 5, last)
+
+	exported, err = exporter.Export()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, exported)
+	assert.NoError(t, exporter.Stop())
+
+	files, _ := filepath.Glob(filepath.Join(dir, "histories-*.csv"))
+	assert.Len(t, files, 2)
+	data, _ := os.ReadFile(files[0])
+	assert.Equal(t, 4, strings.Count(string(data), "\n"), "header and 3 rows")
+}
@@ -0,0 +1,112 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type InfluxConfig struct {
+	WriteURL    string // e.g. http://influx:8086/api/v2/write?org=nube&bucket=rubix&precision=ms
+	Token       string // sent as "Authorization: Token <token>" when set
+	Measurement string
+	Timeout     time.Duration
+}
+
+// InfluxSink posts histories as InfluxDB line protocol, the write URL must use millisecond precision.
+type InfluxSink struct {
+	config *InfluxConfig
+	client *http.Client
+}
+
+func NewInfluxSink(config *InfluxConfig) *InfluxSink {
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	return &InfluxSink{config: config, client: &http.Client{Timeout: timeout}}
+}
+
+func (s *InfluxSink) Name() string {
+	return "influx"
+}
+
+func (s *InfluxSink) Write(histories []*model.History) error {
+	var body bytes.Buffer
+	for _, h := range histories {
+		if line := LineProtocol(s.measurement(), h); line != "" {
+			body.WriteString(line)
+			body.WriteByte('\n')
+		}
+	}
+	if body.Len() == 0 {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodPost, s.config.WriteURL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.config.Token != "" {
+		req.Header.Set("Authorization", "Token "+s.config.Token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("influx write failed with %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (s *InfluxSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+func (s *InfluxSink) measurement() string {
+	if s.config.Measurement == "" {
+		return "history"
+	}
+	return s.config.Measurement
+}
+
+// LineProtocol formats one history in millisecond precision. Histories without a finite value are skipped and empty
+// tags are left out, influx rejects both.
+func LineProtocol(measurement string, h *model.History) string {
+	if h.Value == nil || math.IsNaN(*h.Value) || math.IsInf(*h.Value, 0) {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(escapeMeasurement(measurement))
+	for _, tag := range [][2]string{{"host_uuid", h.HostUUID}, {"point_uuid", h.PointUUID}} {
+		if tag[1] != "" {
+			fmt.Fprintf(&b, ",%s=%s", tag[0], escapeTag(tag[1]))
+		}
+	}
+	fmt.Fprintf(&b, " value=%s %d", strconv.FormatFloat(*h.Value, 'f', -1, 64),
+		h.Timestamp.UnixNano()/int64(time.Millisecond))
+	return b.String()
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+)
+
+func escapeMeasurement(s string) string {
+	return measurementEscaper.Replace(s)
+}
+
+func escapeTag(s string) string {
+	return tagEscaper.Replace(s)
+}
@@ -0,0 +1,104 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/xitongsys/parquet-go/writer"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type ParquetConfig struct {
+	Dir    string
+	Prefix string
+}
+
+// ParquetSink writes every batch to its own parquet file, the file is renamed in place once complete.
+type ParquetSink struct {
+	config *ParquetConfig
+}
+
+func NewParquetSink(config *ParquetConfig) (*ParquetSink, error) {
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, err
+	}
+	return &ParquetSink{config: config}, nil
+}
+
+func (s *ParquetSink) Name() string {
+	return "parquet"
+}
+
+func (s *ParquetSink) Write(histories []*model.History) error {
+	if len(histories) == 0 {
+		return nil
+	}
+	prefix := s.config.Prefix
+	if prefix == "" {
+		prefix = "histories"
+	}
+	name := fmt.Sprintf("%s-%d-%d.parquet", prefix, histories[0].HistoryID, histories[len(histories)-1].HistoryID)
+	path := filepath.Join(s.config.Dir, name)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err = writeParquet(f, histories); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *ParquetSink) Close() error {
+	return nil
+}
+
+// parquetRow is the schema of the parquet files, the timestamp is in milliseconds.
+type parquetRow struct {
+	HistoryID int64    `parquet:"name=history_id, type=INT64"`
+	HostUUID  string   `parquet:"name=host_uuid, type=BYTE_ARRAY, convertedtype=UTF8"`
+	PointUUID string   `parquet:"name=point_uuid, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Value     *float64 `parquet:"name=value, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Timestamp int64    `parquet:"name=timestamp, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+}
+
+// EncodeParquet returns the histories as a parquet file with the columns history_id, host_uuid, point_uuid, value and
+// timestamp (milliseconds).
+func EncodeParquet(histories []*model.History) ([]byte, error) {
+	var b bytes.Buffer
+	if err := writeParquet(&b, histories); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func writeParquet(w io.Writer, histories []*model.History) error {
+	pw, err := writer.NewParquetWriterFromWriter(w, new(parquetRow), 1)
+	if err != nil {
+		return err
+	}
+	for _, h := range histories {
+		row := parquetRow{
+			HistoryID: int64(h.HistoryID),
+			HostUUID:  h.HostUUID,
+			PointUUID: h.PointUUID,
+			Value:     h.Value,
+			Timestamp: h.Timestamp.UnixNano() / int64(time.Millisecond),
+		}
+		if err = pw.Write(row); err != nil {
+			return err
+		}
+	}
+	return pw.WriteStop()
+}
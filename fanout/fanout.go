@@ -0,0 +1,190 @@
+package fanout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/nargs"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrOffline = errors.New("host is offline")
+	ErrTimeout = errors.New("host timed out")
+)
+
+// Func makes the call for one host, opts routes marshaller calls to it, e.g.
+//
+//	func(ctx context.Context, host *model.Host, opts *nmodule.Opts) (*systats.System, error) {
+//		return marshaller.GetSystem(opts)
+//	}
+type Func[T any] func(ctx context.Context, host *model.Host, opts *nmodule.Opts) (T, error)
+
+type Config struct {
+	Concurrency int           // hosts called at once, 8 when zero
+	Timeout     time.Duration // per host, none when zero
+	SkipOffline bool          // hosts which are not online fail with ErrOffline without a call
+}
+
+type Result[T any] struct {
+	Host     *model.Host
+	Value    T
+	Err      error
+	Duration time.Duration
+}
+
+// Report holds a result for every host, in the order of the hosts.
+type Report[T any] struct {
+	Results []*Result[T]
+}
+
+func (r *Report[T]) Succeeded() []*Result[T] {
+	var results []*Result[T]
+	for _, result := range r.Results {
+		if result.Err == nil {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+func (r *Report[T]) Failed() []*Result[T] {
+	var results []*Result[T]
+	for _, result := range r.Results {
+		if result.Err != nil {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// Values returns the values of the hosts which succeeded, by host uuid.
+func (r *Report[T]) Values() map[string]T {
+	values := map[string]T{}
+	for _, result := range r.Succeeded() {
+		values[result.Host.UUID] = result.Value
+	}
+	return values
+}
+
+// Err returns nil when all hosts succeeded, otherwise an error listing the failed hosts.
+func (r *Report[T]) Err() error {
+	failed := r.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	messages := make([]string, len(failed))
+	for i, result := range failed {
+		messages[i] = fmt.Sprintf("%s: %s", hostName(result.Host), result.Err)
+	}
+	return fmt.Errorf("%d of %d hosts failed: %s", len(failed), len(r.Results), strings.Join(messages, "; "))
+}
+
+func hostName(host *model.Host) string {
+	if host.Name == "" {
+		return host.UUID
+	}
+	return host.Name
+}
+
+// Run calls fn for each host with at most Concurrency calls at once. A call which times out is reported with
+// ErrTimeout and its context is cancelled, marshaller calls ignore the context so they may still finish in the
+// background. Such a call keeps its slot until fn returns, so there are never more than Concurrency calls running.
+// Hosts not reached before ctx is done are reported with the error of ctx.
+func Run[T any](ctx context.Context, hosts []*model.Host, config *Config, fn Func[T]) *Report[T] {
+	if config == nil {
+		config = &Config{}
+	}
+	concurrency := config.Concurrency
+	if concurrency <= 0 {
+		concurrency = 8
+	}
+	report := &Report[T]{Results: make([]*Result[T], len(hosts))}
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		result := &Result[T]{Host: host}
+		report.Results[i] = result
+		if config.SkipOffline && (host.IsOnline == nil || !*host.IsOnline) {
+			result.Err = ErrOffline
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			result.Err = ctx.Err()
+			continue
+		case slots <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(result *Result[T]) {
+			defer wg.Done()
+			call(ctx, config.Timeout, result, fn, func() { <-slots })
+		}(result)
+	}
+	wg.Wait()
+	return report
+}
+
+// call records the outcome of fn in result, release is called once fn returns, which can be after call on a timeout.
+func call[T any](ctx context.Context, timeout time.Duration, result *Result[T], fn Func[T], release func()) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	type outcome struct {
+		value T
+		err   error
+	}
+	done := make(chan outcome, 1)
+	start := time.Now()
+	hostUUID := result.Host.UUID
+	go func() {
+		defer release()
+		var o outcome
+		defer func() {
+			if r := recover(); r != nil {
+				o.err = fmt.Errorf("panic: %v", r)
+			}
+			done <- o
+		}()
+		o.value, o.err = fn(ctx, result.Host, &nmodule.Opts{HostUUID: &hostUUID})
+	}()
+	select {
+	case o := <-done:
+		result.Value, result.Err = o.value, o.err
+	case <-ctx.Done():
+		result.Err = ctx.Err()
+		if errors.Is(result.Err, context.DeadlineExceeded) {
+			result.Err = ErrTimeout
+		}
+	}
+	result.Duration = time.Since(start)
+}
+
+// All runs fn on every host from GetHosts.
+func All[T any](ctx context.Context, marshaller nmodule.Marshaller, config *Config, fn Func[T]) (*Report[T], error) {
+	hosts, err := marshaller.GetHosts()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Name < hosts[j].Name })
+	return Run(ctx, hosts, config, fn), nil
+}
+
+// Group runs fn on the hosts of a group.
+func Group[T any](ctx context.Context, marshaller nmodule.Marshaller, groupUUID string, config *Config,
+	fn Func[T]) (*Report[T], error) {
+	group, err := marshaller.GetGroup(groupUUID, &nmodule.Opts{Args: &nargs.Args{WithHosts: true}})
+	if err != nil {
+		return nil, err
+	}
+	hosts := group.Hosts
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Name < hosts[j].Name })
+	return Run(ctx, hosts, config, fn), nil
+}
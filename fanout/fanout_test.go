@@ -0,0 +1,121 @@
+package fanout
+
+import (
+	"context"
+	"errors"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeMarshaller struct {
+	nmodule.Marshaller
+	hosts []*model.Host
+}
+
+func (f *fakeMarshaller) GetHosts(opts ...*nmodule.Opts) ([]*model.Host, error) {
+	return f.hosts, nil
+}
+
+func (f *fakeMarshaller) GetGroup(uuid string, opts ...*nmodule.Opts) (*model.Group, error) {
+	if !opts[0].Args.WithHosts {
+		return &model.Group{UUID: uuid}, nil
+	}
+	return &model.Group{UUID: uuid, Hosts: f.hosts[:2]}, nil
+}
+
+func newHosts(n int) []*model.Host {
+	online := true
+	var hosts []*model.Host
+	for i := 0; i < n; i++ {
+		hosts = append(hosts, &model.Host{UUID: string(rune('a' + i)), Name: string(rune('z' - i)), IsOnline: &online})
+	}
+	return hosts
+}
+
+func TestRunConcurrency(t *testing.T) {
+	var running, peak int32
+	report := Run(context.Background(), newHosts(10), &Config{Concurrency: 3},
+		func(ctx context.Context, host *model.Host, opts *nmodule.Opts) (string, error) {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return *opts.HostUUID, nil
+		})
+	assert.NoError(t, report.Err())
+	assert.Equal(t, int32(3), peak)
+	assert.Len(t, report.Values(), 10)
+	assert.Equal(t, "c", report.Results[2].Value)
+}
+
+func TestRunFailures(t *testing.T) {
+	hosts := newHosts(4)
+	hosts[1].IsOnline = nil
+	report := Run(context.Background(), hosts, &Config{Timeout: 20 * time.Millisecond, SkipOffline: true},
+		func(ctx context.Context, host *model.Host, opts *nmodule.Opts) (int, error) {
+			switch host.UUID {
+			case "c":
+				time.Sleep(200 * time.Millisecond)
+			case "d":
+				return 0, errors.New("unauthorized")
+			}
+			return 1, nil
+		})
+	assert.Len(t, report.Succeeded(), 1)
+	assert.Equal(t, ErrOffline, report.Results[1].Err)
+	assert.Equal(t, ErrTimeout, report.Results[2].Err)
+	assert.Less(t, report.Results[2].Duration, 200*time.Millisecond)
+	assert.EqualError(t, report.Err(), "3 of 4 hosts failed: y: host is offline; x: host timed out; w: unauthorized")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report = Run(ctx, hosts, nil, func(ctx context.Context, host *model.Host, opts *nmodule.Opts) (int, error) {
+		return 1, nil
+	})
+	assert.Len(t, report.Failed(), 4)
+	assert.True(t, errors.Is(report.Results[0].Err, context.Canceled))
+}
+
+func TestTimedOutCallKeepsSlot(t *testing.T) {
+	release := make(chan struct{})
+	var started int32
+	report := Run(context.Background(), newHosts(2), &Config{Concurrency: 1, Timeout: 10 * time.Millisecond},
+		func(ctx context.Context, host *model.Host, opts *nmodule.Opts) (int, error) {
+			atomic.AddInt32(&started, 1)
+			if host.UUID == "a" {
+				go func() {
+					time.Sleep(50 * time.Millisecond)
+					assert.Equal(t, int32(1), atomic.LoadInt32(&started), "b waits for a to return")
+					close(release)
+				}()
+				<-release // ignores ctx like a marshaller call
+			}
+			return 1, nil
+		})
+	assert.Equal(t, ErrTimeout, report.Results[0].Err)
+	assert.NoError(t, report.Results[1].Err)
+}
+
+func TestAllAndGroup(t *testing.T) {
+	m := &fakeMarshaller{hosts: newHosts(3)}
+	names := func(ctx context.Context, host *model.Host, opts *nmodule.Opts) (string, error) {
+		return host.Name, nil
+	}
+	report, err := All(context.Background(), m, nil, names)
+	assert.NoError(t, err)
+	assert.Equal(t, "x", report.Results[0].Value)
+	assert.Len(t, report.Results, 3)
+
+	report, err = Group(context.Background(), m, "grp", nil, names)
+	assert.NoError(t, err)
+	assert.Len(t, report.Results, 2)
+}
@@ -0,0 +1,312 @@
+package fault
+
+import (
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"sync"
+	"time"
+)
+
+type Kind string
+
+const (
+	Network Kind = "network"
+	Device  Kind = "device"
+	Point   Kind = "point"
+)
+
+type Config struct {
+	FailThreshold    int           // consecutive failures before a fault is raised
+	RecoverThreshold int           // consecutive successes before a fault is cleared
+	MinHold          time.Duration // a raised or cleared fault is kept at least this long to damp flapping
+	NetworkRollup    float64       // fraction of faulted devices that faults the network, 0 disables the roll-up
+	WithDescendants  bool          // raise device and network faults on their descendants too
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		FailThreshold:    3,
+		RecoverThreshold: 3,
+		MinHold:          30 * time.Second,
+		NetworkRollup:    1,
+	}
+}
+
+type entity struct {
+	kind       Kind
+	uuid       string
+	parentUUID string
+	inFault    bool
+	failures   int
+	successes  int
+	changedAt  time.Time
+	message    string
+	rolledUp   bool
+	reported   bool // the fault state the host has, the entity is retried while it differs from inFault
+}
+
+// Manager keeps the fault state of networks, devices and points, and only calls the Marshaller when a fault is raised
+// or cleared.
+type Manager struct {
+	marshaller nmodule.Marshaller
+	config     *Config
+	now        func() time.Time
+
+	mutex    sync.Mutex
+	entities map[string]*entity
+}
+
+func New(marshaller nmodule.Marshaller, config *Config) *Manager {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	if config.FailThreshold < 1 {
+		config.FailThreshold = 1
+	}
+	if config.RecoverThreshold < 1 {
+		config.RecoverThreshold = 1
+	}
+	return &Manager{
+		marshaller: marshaller,
+		config:     config,
+		now:        time.Now,
+		entities:   make(map[string]*entity),
+	}
+}
+
+func (m *Manager) RegisterNetwork(networkUUID string) {
+	m.register(Network, networkUUID, "")
+}
+
+func (m *Manager) RegisterDevice(networkUUID, deviceUUID string) {
+	m.register(Device, deviceUUID, networkUUID)
+}
+
+func (m *Manager) RegisterPoint(deviceUUID, pointUUID string) {
+	m.register(Point, pointUUID, deviceUUID)
+}
+
+// Load registers the network with its devices and points.
+func (m *Manager) Load(network *model.Network) {
+	m.RegisterNetwork(network.UUID)
+	for _, device := range network.Devices {
+		m.RegisterDevice(network.UUID, device.UUID)
+		for _, point := range device.Points {
+			m.RegisterPoint(device.UUID, point.UUID)
+		}
+	}
+}
+
+func (m *Manager) register(kind Kind, uuid, parentUUID string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if e, ok := m.entities[uuid]; ok {
+		e.parentUUID = parentUUID
+		return
+	}
+	m.entities[uuid] = &entity{kind: kind, uuid: uuid, parentUUID: parentUUID}
+}
+
+// Remove forgets the entity, the roll-up of the network is recomputed when it was a device.
+func (m *Manager) Remove(uuid string) error {
+	m.mutex.Lock()
+	e, ok := m.entities[uuid]
+	if !ok {
+		m.mutex.Unlock()
+		return nil
+	}
+	delete(m.entities, uuid)
+	var changes []entity
+	if e.kind == Device {
+		m.rollup(e.parentUUID)
+		changes = m.pending(e.parentUUID)
+	}
+	m.mutex.Unlock()
+	return m.apply(changes)
+}
+
+func (m *Manager) InFault(uuid string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if e, ok := m.entities[uuid]; ok {
+		return e.inFault
+	}
+	return false
+}
+
+// Ok records a successful operation on the entity, the fault is cleared after RecoverThreshold of them.
+func (m *Manager) Ok(uuid string) error {
+	return m.record(uuid, nil)
+}
+
+// Fail records a failed operation on the entity, the fault is raised after FailThreshold of them.
+func (m *Manager) Fail(uuid string, err error) error {
+	return m.record(uuid, err)
+}
+
+func (m *Manager) record(uuid string, err error) error {
+	m.mutex.Lock()
+	e, ok := m.entities[uuid]
+	if !ok {
+		m.mutex.Unlock()
+		return nil
+	}
+	m.transition(e, err)
+	uuids := []string{uuid}
+	if e.kind == Device {
+		m.rollup(e.parentUUID)
+		uuids = append(uuids, e.parentUUID)
+	}
+	changes := m.pending(uuids...)
+	m.mutex.Unlock()
+	return m.apply(changes)
+}
+
+// Retry writes the faults the host didn't accept yet.
+func (m *Manager) Retry() error {
+	m.mutex.Lock()
+	var uuids []string
+	for uuid := range m.entities {
+		uuids = append(uuids, uuid)
+	}
+	changes := m.pending(uuids...)
+	m.mutex.Unlock()
+	return m.apply(changes)
+}
+
+// pending returns copies of the entities whose fault state isn't written to the host yet.
+func (m *Manager) pending(uuids ...string) []entity {
+	var changes []entity
+	for _, uuid := range uuids {
+		if e, ok := m.entities[uuid]; ok && e.inFault != e.reported {
+			changes = append(changes, *e)
+		}
+	}
+	return changes
+}
+
+// transition applies the result of an operation on the entity, a fault it raises or clears is its own and not the
+// roll-up's.
+func (m *Manager) transition(e *entity, err error) bool {
+	now := m.now()
+	held := now.Sub(e.changedAt) >= m.config.MinHold
+	if err != nil {
+		e.failures++
+		e.successes = 0
+		e.message = err.Error()
+		if !e.inFault && e.failures >= m.config.FailThreshold && held {
+			e.inFault = true
+			e.rolledUp = false
+			e.changedAt = now
+			return true
+		}
+		return false
+	}
+	e.successes++
+	e.failures = 0
+	if e.inFault && e.successes >= m.config.RecoverThreshold && held {
+		e.inFault = false
+		e.rolledUp = false
+		e.message = ""
+		e.changedAt = now
+		return true
+	}
+	return false
+}
+
+// rollup re-evaluates the network health from its devices, only faults raised by the roll-up are cleared by it. The
+// network is held by MinHold like any other entity.
+func (m *Manager) rollup(networkUUID string) {
+	network, ok := m.entities[networkUUID]
+	if !ok || m.config.NetworkRollup <= 0 {
+		return
+	}
+	now := m.now()
+	if now.Sub(network.changedAt) < m.config.MinHold {
+		return
+	}
+	var total, faulted int
+	for _, e := range m.entities {
+		if e.kind == Device && e.parentUUID == networkUUID {
+			total++
+			if e.inFault {
+				faulted++
+			}
+		}
+	}
+	unhealthy := total > 0 && float64(faulted)/float64(total) >= m.config.NetworkRollup
+	if unhealthy && !network.inFault {
+		network.inFault = true
+		network.rolledUp = true
+		network.message = "all devices are in fault"
+		if m.config.NetworkRollup < 1 {
+			network.message = "too many devices are in fault"
+		}
+		network.changedAt = now
+	} else if !unhealthy && network.inFault && network.rolledUp {
+		network.inFault = false
+		network.rolledUp = false
+		network.message = ""
+		network.changedAt = now
+	}
+}
+
+// apply writes the changes, an entity only counts as reported once the host accepted it.
+func (m *Manager) apply(changes []entity) error {
+	for i := range changes {
+		c := &changes[i]
+		if err := m.write(c, c.inFault); err != nil {
+			return err
+		}
+		m.mutex.Lock()
+		if e, ok := m.entities[c.uuid]; ok {
+			e.reported = c.inFault
+		}
+		m.mutex.Unlock()
+	}
+	return nil
+}
+
+func (m *Manager) write(e *entity, inFault bool) error {
+	body := &model.CommonFault{
+		InFault:      false,
+		MessageLevel: dto.MessageLevel.Info,
+		MessageCode:  dto.CommonFaultCode.Ok,
+		LastOk:       m.now().UTC(),
+	}
+	if inFault {
+		body = &model.CommonFault{
+			InFault:      true,
+			MessageLevel: dto.MessageLevel.Fail,
+			MessageCode:  faultCode(e.kind),
+			Message:      e.message,
+			LastFail:     m.now().UTC(),
+		}
+	}
+	switch e.kind {
+	case Network:
+		if m.config.WithDescendants {
+			return m.marshaller.UpdateNetworkDescendantsFault(e.uuid, body, true)
+		}
+		return m.marshaller.UpdateNetworkFault(e.uuid, body)
+	case Device:
+		if m.config.WithDescendants {
+			return m.marshaller.UpdateDeviceDescendantsFault(e.uuid, body)
+		}
+		return m.marshaller.UpdateDeviceFault(e.uuid, body)
+	default:
+		return m.marshaller.UpdatePointFault(e.uuid, body)
+	}
+}
+
+func faultCode(kind Kind) string {
+	switch kind {
+	case Network:
+		return dto.CommonFaultCode.NetworkError
+	case Device:
+		return dto.CommonFaultCode.DeviceError
+	default:
+		return dto.CommonFaultCode.PointError
+	}
+}
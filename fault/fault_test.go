@@ -0,0 +1,140 @@
+package fault
+
+import (
+	"errors"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type fakeMarshaller struct {
+	nmodule.Marshaller
+	writes []string
+	fail   bool
+}
+
+func (f *fakeMarshaller) UpdateNetworkFault(uuid string, body *model.CommonFault, opts ...*nmodule.Opts) error {
+	return f.write(uuid, body)
+}
+
+func (f *fakeMarshaller) UpdateDeviceFault(uuid string, body *model.CommonFault, opts ...*nmodule.Opts) error {
+	return f.write(uuid, body)
+}
+
+func (f *fakeMarshaller) write(uuid string, body *model.CommonFault) error {
+	if f.fail {
+		return errors.New("host unavailable")
+	}
+	f.writes = append(f.writes, faultString(uuid, body))
+	return nil
+}
+
+func faultString(uuid string, body *model.CommonFault) string {
+	if body.InFault {
+		return uuid + ":fault"
+	}
+	return uuid + ":ok"
+}
+
+func TestDebounceAndRollup(t *testing.T) {
+	m := &fakeMarshaller{}
+	manager := New(m, &Config{FailThreshold: 2, RecoverThreshold: 2, MinHold: time.Minute, NetworkRollup: 1})
+	now := time.Unix(0, 0)
+	manager.now = func() time.Time { return now }
+	manager.Load(&model.Network{
+		CommonUUID: model.CommonUUID{UUID: "net"},
+		Devices: []*model.Device{
+			{CommonUUID: model.CommonUUID{UUID: "dev1"}},
+			{CommonUUID: model.CommonUUID{UUID: "dev2"}},
+		},
+	})
+
+	timeout := errors.New("timeout")
+	_ = manager.Fail("dev1", timeout)
+	assert.Empty(t, m.writes, "below the fail threshold")
+	_ = manager.Fail("dev1", timeout)
+	_ = manager.Fail("dev1", timeout)
+	assert.Equal(t, []string{"dev1:fault"}, m.writes, "no redundant fault writes")
+
+	_ = manager.Fail("dev2", timeout)
+	_ = manager.Fail("dev2", timeout)
+	assert.Equal(t, []string{"dev1:fault", "dev2:fault", "net:fault"}, m.writes)
+
+	_ = manager.Ok("dev1")
+	_ = manager.Ok("dev1")
+	assert.Len(t, m.writes, 3, "held by min hold")
+
+	now = now.Add(time.Minute)
+	_ = manager.Ok("dev1")
+	assert.Equal(t, []string{"dev1:fault", "dev2:fault", "net:fault", "dev1:ok", "net:ok"}, m.writes)
+	assert.False(t, manager.InFault("net"))
+	assert.True(t, manager.InFault("dev2"))
+}
+
+func network(devices ...string) *model.Network {
+	n := &model.Network{CommonUUID: model.CommonUUID{UUID: "net"}}
+	for _, uuid := range devices {
+		n.Devices = append(n.Devices, &model.Device{CommonUUID: model.CommonUUID{UUID: uuid}})
+	}
+	return n
+}
+
+func TestFailedWriteIsRetried(t *testing.T) {
+	m := &fakeMarshaller{fail: true}
+	manager := New(m, &Config{NetworkRollup: 0})
+	manager.Load(network("dev"))
+	assert.Error(t, manager.Fail("dev", errors.New("timeout")))
+	assert.True(t, manager.InFault("dev"))
+
+	m.fail = false
+	assert.NoError(t, manager.Retry())
+	assert.Equal(t, []string{"dev:fault"}, m.writes)
+	assert.NoError(t, manager.Retry())
+	assert.Len(t, m.writes, 1)
+}
+
+func TestRollupHoldAndRemove(t *testing.T) {
+	m := &fakeMarshaller{}
+	manager := New(m, &Config{MinHold: time.Minute, NetworkRollup: 0.5})
+	now := time.Unix(0, 0)
+	manager.now = func() time.Time { return now }
+	manager.Load(network("dev1", "dev2"))
+
+	timeout := errors.New("timeout")
+	_ = manager.Fail("dev1", timeout)
+	assert.True(t, manager.InFault("net"))
+
+	now = now.Add(2 * time.Minute)
+	_ = manager.Ok("dev1")
+	assert.False(t, manager.InFault("net"))
+	_ = manager.Fail("dev2", timeout)
+	assert.False(t, manager.InFault("net"), "held by min hold")
+
+	now = now.Add(time.Minute)
+	_ = manager.Fail("dev2", timeout)
+	assert.True(t, manager.InFault("net"))
+
+	now = now.Add(time.Minute)
+	assert.NoError(t, manager.Remove("dev2"))
+	assert.False(t, manager.InFault("net"))
+	assert.Equal(t, "net:ok", m.writes[len(m.writes)-1])
+}
+
+func TestClearedRollupThenFail(t *testing.T) {
+	m := &fakeMarshaller{}
+	manager := New(m, &Config{NetworkRollup: 1})
+	manager.Load(network("dev"))
+
+	timeout := errors.New("timeout")
+	_ = manager.Fail("dev", timeout)
+	assert.True(t, manager.InFault("net"))
+	// the network clears the rolled up fault itself, a later fault of its own isn't the roll-up's
+	_ = manager.Ok("net")
+	_ = manager.Ok("dev")
+	_ = manager.Fail("net", timeout)
+	_ = manager.Ok("dev")
+	assert.True(t, manager.InFault("net"))
+	assert.Equal(t, []string{"dev:fault", "net:fault", "net:ok", "dev:ok", "net:fault"}, m.writes)
+}
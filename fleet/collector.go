@@ -0,0 +1,216 @@
+package fleet
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/NubeIO/lib-module-go/fanout"
+	"github.com/NubeIO/lib-module-go/loop"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/lib-module-go/router"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	Fanout       *fanout.Config
+	Thresholds   *Thresholds // DefaultThresholds when nil
+	TopProcesses int         // processes kept per host, 5 when zero
+	History      bool        // stores the metrics as histories
+}
+
+type Summary struct {
+	Time        time.Time `json:"time"`
+	Hosts       int       `json:"hosts"`
+	Healthy     int       `json:"healthy"`
+	Unhealthy   int       `json:"unhealthy"` // reachable with a failed call or a threshold exceeded
+	Unreachable int       `json:"unreachable"`
+	Samples     []*Sample `json:"samples"`
+}
+
+// Collector samples the system stats of all hosts and keeps the latest sample of each.
+type Collector struct {
+	marshaller nmodule.Marshaller
+	config     *Config
+
+	mutex   sync.Mutex
+	samples map[string]*Sample // by host uuid
+	loop    loop.Loop
+	now     func() time.Time
+}
+
+func New(marshaller nmodule.Marshaller, config *Config) *Collector {
+	if config == nil {
+		config = &Config{}
+	}
+	if config.Thresholds == nil {
+		config.Thresholds = DefaultThresholds()
+	}
+	if config.TopProcesses <= 0 {
+		config.TopProcesses = 5
+	}
+	return &Collector{
+		marshaller: marshaller,
+		config:     config,
+		samples:    map[string]*Sample{},
+		now:        time.Now,
+	}
+}
+
+// Collect samples every host from GetHosts and returns the summary.
+func (c *Collector) Collect(ctx context.Context) (*Summary, error) {
+	report, err := fanout.All(ctx, c.marshaller, c.config.Fanout,
+		func(ctx context.Context, host *model.Host, opts *nmodule.Opts) (*Sample, error) {
+			return c.sample(host, opts), nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	samples := map[string]*Sample{}
+	var histories []*model.History
+	for _, result := range report.Results {
+		sample := result.Value
+		if result.Err != nil {
+			// timed out or skipped, the sample may still be running
+			sample = &Sample{HostUUID: result.Host.UUID, HostName: result.Host.Name, Time: c.now(),
+				Errors: []string{result.Err.Error()}}
+		}
+		samples[sample.HostUUID] = sample
+		histories = append(histories, sample.histories()...)
+	}
+	if c.config.History && len(histories) > 0 {
+		if ok, err := c.marshaller.CreateHistories(histories); err != nil {
+			log.Errorf("fleet: failed to store histories: %s", err)
+		} else if !ok {
+			log.Errorf("fleet: host didn't store the histories")
+		}
+	}
+	c.mutex.Lock()
+	c.samples = samples
+	c.mutex.Unlock()
+	return c.Summary(), nil
+}
+
+func (c *Collector) sample(host *model.Host, opts *nmodule.Opts) *Sample {
+	s := &Sample{HostUUID: host.UUID, HostName: host.Name, Time: c.now()}
+	system, err := c.marshaller.GetSystem(opts)
+	if err != nil {
+		s.Errors = append(s.Errors, "system: "+err.Error())
+		return s
+	}
+	s.Reachable = true
+	s.System = system
+
+	before := c.now()
+	hostTime, err := c.marshaller.HostTime(opts)
+	if err != nil {
+		s.Errors = append(s.Errors, "time: "+err.Error())
+	} else {
+		// compare against the middle of the round trip
+		after := c.now()
+		s.ClockSkew = hostTime.DateStamp.Sub(before.Add(after.Sub(before) / 2))
+		s.setMetric(MetricClockSkew, s.ClockSkew.Seconds())
+	}
+	if memory, err := c.marshaller.GetMemoryUsage(opts); err != nil {
+		s.Errors = append(s.Errors, "memory: "+err.Error())
+	} else {
+		s.MemoryPercent = memory.MemoryPercentageUsed
+		s.setMetric(MetricMemory, s.MemoryPercent)
+	}
+	if swap, err := c.marshaller.GetSwap(opts); err != nil {
+		s.Errors = append(s.Errors, "swap: "+err.Error())
+	} else {
+		s.SwapPercent = swap.PercentageUsed
+		s.setMetric(MetricSwap, s.SwapPercent)
+	}
+	if disks, err := c.marshaller.DiscUsagePretty(opts); err != nil {
+		s.Errors = append(s.Errors, "disk: "+err.Error())
+	} else {
+		s.Disks = disks
+		for _, d := range disks {
+			if percent, ok := parsePercent(d.Usage.Usage); ok && percent >= s.DiskPercent {
+				s.DiskPercent = percent
+				s.DiskMount = d.MountedOn
+			}
+		}
+		s.setMetric(MetricDisk, s.DiskPercent)
+	}
+	if processes, err := c.marshaller.GetTopProcesses(opts); err != nil {
+		s.Errors = append(s.Errors, "processes: "+err.Error())
+	} else {
+		if len(processes) > c.config.TopProcesses {
+			processes = processes[:c.config.TopProcesses]
+		}
+		s.TopProcesses = processes
+	}
+	s.check(c.config.Thresholds)
+	return s
+}
+
+// Summary returns the latest samples, unhealthy hosts first.
+func (c *Collector) Summary() *Summary {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	summary := &Summary{Time: c.now(), Hosts: len(c.samples)}
+	for _, s := range c.samples {
+		switch {
+		case !s.Reachable:
+			summary.Unreachable++
+		case s.Healthy():
+			summary.Healthy++
+		default:
+			summary.Unhealthy++
+		}
+		summary.Samples = append(summary.Samples, s)
+	}
+	rank := func(s *Sample) int {
+		switch {
+		case !s.Reachable:
+			return 1
+		case s.Healthy():
+			return 2
+		}
+		return 0
+	}
+	sort.Slice(summary.Samples, func(i, j int) bool {
+		a, b := summary.Samples[i], summary.Samples[j]
+		if rank(a) != rank(b) {
+			return rank(a) < rank(b)
+		}
+		return a.HostName < b.HostName
+	})
+	return summary
+}
+
+// Handler returns the summary as json, e.g. on GET /api/fleet/health. With the unhealthy query param set to true
+// only unhealthy and unreachable hosts are listed.
+func (c *Collector) Handler() router.HandlerFunc {
+	return func(_ *nmodule.Module, r *router.Request) ([]byte, error) {
+		summary := c.Summary()
+		if r.QueryParams.Get("unhealthy") == "true" {
+			var samples []*Sample
+			for _, s := range summary.Samples {
+				if !s.Healthy() {
+					samples = append(samples, s)
+				}
+			}
+			summary.Samples = samples
+		}
+		return json.Marshal(summary)
+	}
+}
+
+// Start collects every interval until Stop.
+func (c *Collector) Start(interval time.Duration) error {
+	return c.loop.Start(interval, false, func() {
+		if _, err := c.Collect(context.Background()); err != nil {
+			log.Errorf("fleet: %s", err)
+		}
+	})
+}
+
+func (c *Collector) Stop() {
+	c.loop.Stop()
+}
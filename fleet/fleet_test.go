@@ -0,0 +1,122 @@
+package fleet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/NubeIO/lib-date/datelib"
+	"github.com/NubeIO/lib-module-go/nhttp"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/lib-module-go/router"
+	systats "github.com/NubeIO/lib-system"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type fakeMarshaller struct {
+	nmodule.Marshaller
+	histories []*model.History
+}
+
+func (f *fakeMarshaller) GetHosts(opts ...*nmodule.Opts) ([]*model.Host, error) {
+	return []*model.Host{{UUID: "ok", Name: "a"}, {UUID: "full", Name: "b"}, {UUID: "down", Name: "c"}}, nil
+}
+
+func (f *fakeMarshaller) GetSystem(opts ...*nmodule.Opts) (*systats.System, error) {
+	if *opts[0].HostUUID == "down" {
+		return nil, errors.New("host is offline")
+	}
+	return &systats.System{HostName: *opts[0].HostUUID}, nil
+}
+
+func (f *fakeMarshaller) HostTime(opts ...*nmodule.Opts) (*datelib.Time, error) {
+	stamp := time.Now()
+	if *opts[0].HostUUID == "full" {
+		stamp = stamp.Add(-5 * time.Minute)
+	}
+	return &datelib.Time{DateStamp: stamp}, nil
+}
+
+func (f *fakeMarshaller) GetMemoryUsage(opts ...*nmodule.Opts) (*dto.MemoryUsage, error) {
+	return &dto.MemoryUsage{MemoryPercentageUsed: 40}, nil
+}
+
+func (f *fakeMarshaller) GetSwap(opts ...*nmodule.Opts) (*systats.Swap, error) {
+	if *opts[0].HostUUID == "ok" {
+		return &systats.Swap{PercentageUsed: 10}, nil
+	}
+	return nil, errors.New("no swap")
+}
+
+func (f *fakeMarshaller) DiscUsagePretty(opts ...*nmodule.Opts) ([]*dto.Disk, error) {
+	usage := "95%"
+	if *opts[0].HostUUID == "ok" {
+		usage = "40%"
+	}
+	return []*dto.Disk{
+		{MountedOn: "/", Usage: dto.DiskUsage{Usage: usage}},
+		{MountedOn: "/boot", Usage: dto.DiskUsage{Usage: "20%"}},
+	}, nil
+}
+
+func (f *fakeMarshaller) GetTopProcesses(opts ...*nmodule.Opts) ([]*systats.Process, error) {
+	return []*systats.Process{{Pid: 1}, {Pid: 2}, {Pid: 3}}, nil
+}
+
+func (f *fakeMarshaller) CreateHistories(histories []*model.History, opts ...*nmodule.Opts) (bool, error) {
+	f.histories = append(f.histories, histories...)
+	return true, nil
+}
+
+func TestCollect(t *testing.T) {
+	m := &fakeMarshaller{}
+	c := New(m, &Config{TopProcesses: 2, History: true})
+	summary, err := c.Collect(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, summary.Hosts)
+	assert.Equal(t, 1, summary.Healthy)
+	assert.Equal(t, 1, summary.Unhealthy)
+	assert.Equal(t, 1, summary.Unreachable)
+
+	full := summary.Samples[0]
+	assert.Equal(t, "full", full.HostUUID)
+	assert.Equal(t, 95.0, full.DiskPercent)
+	assert.Equal(t, "/", full.DiskMount)
+	assert.Len(t, full.Warnings, 2)
+	assert.InDelta(t, -300, full.ClockSkew.Seconds(), 1)
+	assert.Equal(t, []string{"swap: no swap"}, full.Errors)
+	assert.Len(t, full.TopProcesses, 2)
+	assert.Equal(t, "down", summary.Samples[1].HostUUID)
+	assert.Equal(t, "ok", summary.Samples[2].HostUUID)
+
+	// the swap of full couldn't be read, it has no history rather than a zero
+	assert.Len(t, m.histories, 7)
+	var swaps []string
+	for _, h := range m.histories {
+		if h.PointUUID == MetricPointUUID(MetricSwap) {
+			swaps = append(swaps, h.HostUUID)
+		}
+	}
+	assert.Equal(t, []string{"ok"}, swaps)
+	assert.Equal(t, "fleet_memory_percent", m.histories[0].PointUUID)
+}
+
+func TestHandler(t *testing.T) {
+	c := New(&fakeMarshaller{}, &Config{Thresholds: &Thresholds{DiskPercent: 99}})
+	_, err := c.Collect(context.Background())
+	assert.NoError(t, err)
+	r := router.NewRouter()
+	r.Handle(nhttp.GET, "/api/fleet/health", c.Handler())
+
+	res, err := r.CallHandler(nil, nhttp.GET, "/api/fleet/health?unhealthy=true", nil, nil)
+	assert.NoError(t, err)
+	var summary Summary
+	assert.NoError(t, json.Unmarshal(res, &summary))
+	assert.Equal(t, 1, summary.Healthy)
+	assert.Len(t, summary.Samples, 2)
+	assert.Equal(t, "full", summary.Samples[0].HostUUID, "under the threshold but its swap couldn't be read")
+	assert.Equal(t, "down", summary.Samples[1].HostUUID)
+}
@@ -0,0 +1,115 @@
+package fleet
+
+import (
+	"fmt"
+	systats "github.com/NubeIO/lib-system"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metrics are stored as histories of the host under MetricPointUUID.
+const (
+	MetricMemory    = "memory_percent"
+	MetricSwap      = "swap_percent"
+	MetricDisk      = "disk_percent" // of the fullest mount
+	MetricClockSkew = "clock_skew_seconds"
+)
+
+// MetricPointUUID is the point uuid the histories of a metric are stored under. There is no such point, histories
+// only have a unique index on point uuid, host uuid and timestamp and no foreign key, here and in the postgres sync.
+func MetricPointUUID(metric string) string {
+	return "fleet_" + metric
+}
+
+// Thresholds mark a host unhealthy, zero turns a check off.
+type Thresholds struct {
+	DiskPercent   float64
+	MemoryPercent float64
+	SwapPercent   float64
+	ClockSkew     time.Duration // difference of HostTime to the time of this host
+}
+
+// DefaultThresholds flags disks over 90% and clocks off by more than a minute.
+func DefaultThresholds() *Thresholds {
+	return &Thresholds{DiskPercent: 90, ClockSkew: time.Minute}
+}
+
+type Sample struct {
+	HostUUID      string             `json:"host_uuid"`
+	HostName      string             `json:"host_name"`
+	Time          time.Time          `json:"time"`
+	Reachable     bool               `json:"reachable"`
+	System        *systats.System    `json:"system,omitempty"`
+	MemoryPercent float64            `json:"memory_percent"`
+	SwapPercent   float64            `json:"swap_percent"`
+	DiskPercent   float64            `json:"disk_percent"`
+	DiskMount     string             `json:"disk_mount,omitempty"`
+	Disks         []*dto.Disk        `json:"disks,omitempty"`
+	ClockSkew     time.Duration      `json:"clock_skew"`
+	TopProcesses  []*systats.Process `json:"top_processes,omitempty"`
+	Errors        []string           `json:"errors,omitempty"`   // calls which failed
+	Warnings      []string           `json:"warnings,omitempty"` // thresholds exceeded
+
+	read map[string]float64 // the metrics which were read, by metric
+}
+
+// Healthy reports whether the host was reachable, every call succeeded and no threshold was exceeded.
+func (s *Sample) Healthy() bool {
+	return s.Reachable && len(s.Errors) == 0 && len(s.Warnings) == 0
+}
+
+func (s *Sample) setMetric(metric string, value float64) {
+	if s.read == nil {
+		s.read = map[string]float64{}
+	}
+	s.read[metric] = value
+}
+
+func (s *Sample) check(t *Thresholds) {
+	if t == nil {
+		return
+	}
+	if t.DiskPercent > 0 && s.DiskPercent > t.DiskPercent {
+		s.Warnings = append(s.Warnings, fmt.Sprintf("disk %s is %.0f%% full", s.DiskMount, s.DiskPercent))
+	}
+	if t.MemoryPercent > 0 && s.MemoryPercent > t.MemoryPercent {
+		s.Warnings = append(s.Warnings, fmt.Sprintf("memory is %.0f%% used", s.MemoryPercent))
+	}
+	if t.SwapPercent > 0 && s.SwapPercent > t.SwapPercent {
+		s.Warnings = append(s.Warnings, fmt.Sprintf("swap is %.0f%% used", s.SwapPercent))
+	}
+	skew := s.ClockSkew
+	if skew < 0 {
+		skew = -skew
+	}
+	if t.ClockSkew > 0 && skew > t.ClockSkew {
+		s.Warnings = append(s.Warnings, fmt.Sprintf("clock is off by %s", s.ClockSkew.Round(time.Second)))
+	}
+}
+
+// histories returns the metrics which were read, a failed call stores nothing rather than a zero.
+func (s *Sample) histories() []*model.History {
+	var histories []*model.History
+	for _, metric := range []string{MetricMemory, MetricSwap, MetricDisk, MetricClockSkew} {
+		value, ok := s.read[metric]
+		if !ok {
+			continue
+		}
+		histories = append(histories, &model.History{
+			PointUUID: MetricPointUUID(metric),
+			HostUUID:  s.HostUUID,
+			Value:     &value,
+			Timestamp: s.Time,
+		})
+	}
+	return histories
+}
+
+// parsePercent reads usages like "45%".
+func parsePercent(s string) (float64, bool) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "%")), 64)
+	return v, err == nil
+}
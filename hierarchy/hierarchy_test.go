@@ -0,0 +1,173 @@
+package hierarchy
+
+import (
+	"errors"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeMarshaller struct {
+	nmodule.Marshaller
+	mutex     sync.Mutex
+	loads     int
+	networks  map[string]int // loads by host
+	offline   bool
+	duplicate bool // adds a second host named rc
+}
+
+func (f *fakeMarshaller) GetLocations(opts ...*nmodule.Opts) ([]*model.Location, error) {
+	f.loads++
+	location := &model.Location{}
+	location.UUID, location.Name = "loc", "Sydney"
+	return []*model.Location{location}, nil
+}
+
+func (f *fakeMarshaller) GetGroups(opts ...*nmodule.Opts) ([]*model.Group, error) {
+	return []*model.Group{
+		{UUID: "grp", Name: "Level 1/2", LocationUUID: "loc"},
+		{UUID: "orphan", Name: "Orphan", LocationUUID: "gone"},
+	}, nil
+}
+
+func (f *fakeMarshaller) GetHosts(opts ...*nmodule.Opts) ([]*model.Host, error) {
+	hosts := []*model.Host{
+		{UUID: "hst", Name: "rc", GroupUUID: "grp"},
+		{UUID: "off", Name: "edge", GroupUUID: "grp"},
+	}
+	if f.duplicate {
+		hosts = append(hosts, &model.Host{UUID: "dup", Name: "rc", GroupUUID: "grp"})
+	}
+	return hosts, nil
+}
+
+func (f *fakeMarshaller) GetNetworks(body *dto.Filter, opts ...*nmodule.Opts) ([]*model.Network, error) {
+	hostUUID := *opts[0].HostUUID
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.networks[hostUUID]++
+	if hostUUID == "off" && f.offline {
+		return nil, errors.New("host is offline")
+	}
+	point := &model.Point{DeviceUUID: hostUUID + "-dev"}
+	point.UUID, point.Name = hostUUID+"-pnt", "temp"
+	device := &model.Device{NetworkUUID: hostUUID + "-net", Points: []*model.Point{point}}
+	device.UUID, device.Name = hostUUID+"-dev", "ahu"
+	network := &model.Network{Devices: []*model.Device{device}}
+	network.UUID, network.Name = hostUUID+"-net", "modbus"
+	return []*model.Network{network}, nil
+}
+
+func TestLookup(t *testing.T) {
+	m := &fakeMarshaller{networks: map[string]int{}, offline: true}
+	nav := New(m, time.Minute)
+
+	node, err := nav.Lookup("Sydney/Level 1%2F2/rc/modbus/ahu/temp")
+	assert.NoError(t, err)
+	assert.Equal(t, KindPoint, node.Kind)
+	assert.Equal(t, "hst-pnt", node.Point.UUID)
+	assert.Equal(t, "rc", node.Host.Name)
+	assert.Equal(t, "Sydney", node.Location.Name)
+
+	node, err = nav.Lookup("/Sydney/Level 1%2F2/")
+	assert.NoError(t, err)
+	assert.Equal(t, KindGroup, node.Kind)
+	assert.Len(t, node.Group.Hosts, 2)
+	assert.Equal(t, "edge", node.Group.Hosts[0].Name)
+
+	_, err = nav.Lookup("Sydney/Level 1%2F2/edge/modbus")
+	assert.Error(t, err)
+	_, err = nav.Lookup("Sydney/Orphan")
+	assert.Error(t, err)
+
+	node, ok, err := nav.Find("hst-dev")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, JoinPath("Sydney", "Level 1/2", "rc", "modbus", "ahu"), node.Path)
+
+	tree, _ := nav.Tree()
+	assert.Error(t, tree.HostErrors["off"])
+	var kinds []Kind
+	assert.NoError(t, tree.Walk(func(n *Node) error {
+		kinds = append(kinds, n.Kind)
+		return nil
+	}))
+	assert.Equal(t, []Kind{KindLocation, KindGroup, KindHost, KindHost, KindNetwork, KindDevice, KindPoint}, kinds)
+}
+
+func TestInvalidate(t *testing.T) {
+	m := &fakeMarshaller{networks: map[string]int{}, offline: true}
+	nav := New(m, time.Minute)
+	now := time.Now()
+	nav.now = func() time.Time { return now }
+
+	old, err := nav.Tree()
+	assert.NoError(t, err)
+	_, _ = nav.Tree()
+	assert.Equal(t, 1, m.loads)
+
+	m.offline = false
+	nav.InvalidateHost("off")
+	tree, err := nav.Tree()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, m.loads)
+	assert.Equal(t, 1, m.networks["hst"])
+	assert.Equal(t, 2, m.networks["off"])
+	assert.Empty(t, tree.HostErrors)
+	_, err = tree.Lookup("Sydney/Level 1%2F2/edge/modbus/ahu/temp")
+	assert.NoError(t, err)
+	_, err = old.Lookup("Sydney/Level 1%2F2/edge/modbus")
+	assert.Error(t, err)
+
+	now = now.Add(time.Minute)
+	_, _ = nav.Tree()
+	assert.Equal(t, 2, m.loads)
+	nav.Invalidate()
+	_, _ = nav.Tree()
+	assert.Equal(t, 3, m.loads)
+}
+
+func TestAmbiguousPath(t *testing.T) {
+	m := &fakeMarshaller{networks: map[string]int{}, duplicate: true}
+	nav := New(m, 0)
+
+	_, err := nav.Lookup("Sydney/Level 1%2F2/rc")
+	assert.True(t, errors.Is(err, ErrAmbiguous))
+	_, err = nav.Lookup("Sydney/Level 1%2F2/rc/modbus/ahu/temp")
+	assert.True(t, errors.Is(err, ErrAmbiguous))
+	node, err := nav.Lookup("Sydney/Level 1%2F2/edge/modbus/ahu/temp")
+	assert.NoError(t, err)
+	assert.Equal(t, "off-pnt", node.Point.UUID)
+	node, ok, err := nav.Find("dup-pnt")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "dup", node.Host.UUID)
+}
+
+func TestConcurrentReload(t *testing.T) {
+	m := &fakeMarshaller{networks: map[string]int{}}
+	nav := New(m, 0)
+	old, err := nav.Tree()
+	assert.NoError(t, err)
+
+	// trees handed out are read while hosts are reloaded
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				nav.InvalidateHost("hst")
+				_, err := nav.Lookup("Sydney/Level 1%2F2/rc/modbus/ahu/temp")
+				assert.NoError(t, err)
+				_ = old.Walk(func(n *Node) error { return nil })
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, m.loads)
+}
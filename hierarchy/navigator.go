@@ -0,0 +1,254 @@
+package hierarchy
+
+import (
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/nargs"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Navigator loads the tree through the marshaller and caches it until it expires or is invalidated.
+type Navigator struct {
+	marshaller nmodule.Marshaller
+	ttl        time.Duration
+
+	mutex      sync.Mutex
+	tree       *Tree
+	loadedAt   time.Time
+	staleHosts map[string]bool // hosts whose networks are reloaded on the next access
+	loading    chan struct{}   // closed when the running load is done, nil when none runs
+	generation int             // bumped by Invalidate, a tree loaded meanwhile isn't cached
+	now        func() time.Time
+}
+
+// New caches the tree for ttl, zero keeps it until it is invalidated.
+func New(marshaller nmodule.Marshaller, ttl time.Duration) *Navigator {
+	return &Navigator{
+		marshaller: marshaller,
+		ttl:        ttl,
+		staleHosts: map[string]bool{},
+		now:        time.Now,
+	}
+}
+
+// Tree returns the cached tree, loading it when there is none. The tree must not be modified. The host is called
+// without holding the mutex, concurrent calls wait for the running load.
+func (n *Navigator) Tree() (*Tree, error) {
+	n.mutex.Lock()
+	for n.loading != nil {
+		loading := n.loading
+		n.mutex.Unlock()
+		<-loading
+		n.mutex.Lock()
+	}
+	expired := n.tree == nil || (n.ttl > 0 && n.now().Sub(n.loadedAt) >= n.ttl)
+	if !expired && len(n.staleHosts) == 0 {
+		defer n.mutex.Unlock()
+		return n.tree, nil
+	}
+	loading := make(chan struct{})
+	n.loading = loading
+	generation := n.generation
+	old, stale := n.tree, n.staleHosts
+	n.staleHosts = map[string]bool{}
+	n.mutex.Unlock()
+
+	var tree *Tree
+	var err error
+	if expired {
+		tree, err = n.load()
+	} else {
+		tree = n.reloadHosts(old, stale)
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.loading = nil
+	close(loading)
+	if err != nil {
+		return nil, err
+	}
+	if n.generation == generation {
+		n.tree = tree
+		if expired {
+			n.loadedAt = n.now()
+		}
+	}
+	return tree, nil
+}
+
+func (n *Navigator) Lookup(path string) (*Node, error) {
+	tree, err := n.Tree()
+	if err != nil {
+		return nil, err
+	}
+	return tree.Lookup(path)
+}
+
+func (n *Navigator) Find(uuid string) (*Node, bool, error) {
+	tree, err := n.Tree()
+	if err != nil {
+		return nil, false, err
+	}
+	node, ok := tree.Find(uuid)
+	return node, ok, nil
+}
+
+// Invalidate drops the cached tree, the next access loads it again.
+func (n *Navigator) Invalidate() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.tree = nil
+	n.generation++
+}
+
+// InvalidateHost reloads only the networks, devices and points of a host on the next access.
+func (n *Navigator) InvalidateHost(hostUUID string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.staleHosts[hostUUID] = true
+}
+
+// Watch invalidates the tree on every message on the topic filter, e.g. the topic the host announces model changes
+// on.
+func (n *Navigator) Watch(topic string) (*nmodule.Subscription, error) {
+	return n.marshaller.Subscribe(topic, datatype.AtMostOnce, nmodule.RetainDontSend, func(*nmodule.MqttMessage) {
+		n.Invalidate()
+	})
+}
+
+func (n *Navigator) load() (*Tree, error) {
+	locations, err := n.marshaller.GetLocations()
+	if err != nil {
+		return nil, err
+	}
+	groups, err := n.marshaller.GetGroups()
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := n.marshaller.GetHosts()
+	if err != nil {
+		return nil, err
+	}
+	tree := &Tree{HostErrors: map[string]error{}}
+	byLocation := map[string]*model.Location{}
+	for _, l := range locations {
+		l.Groups = nil
+		byLocation[l.UUID] = l
+		tree.Locations = append(tree.Locations, l)
+	}
+	byGroup := map[string]*model.Group{}
+	for _, g := range groups {
+		l, ok := byLocation[g.LocationUUID]
+		if !ok {
+			log.Warnf("hierarchy: group %s has no location", g.UUID)
+			continue
+		}
+		g.Hosts = nil
+		byGroup[g.UUID] = g
+		l.Groups = append(l.Groups, g)
+	}
+	var attached []*model.Host
+	for _, h := range hosts {
+		g, ok := byGroup[h.GroupUUID]
+		if !ok {
+			log.Warnf("hierarchy: host %s has no group", h.UUID)
+			continue
+		}
+		g.Hosts = append(g.Hosts, h)
+		attached = append(attached, h)
+	}
+	sort.Slice(tree.Locations, func(i, j int) bool { return tree.Locations[i].Name < tree.Locations[j].Name })
+	for _, l := range tree.Locations {
+		sort.Slice(l.Groups, func(i, j int) bool { return l.Groups[i].Name < l.Groups[j].Name })
+		for _, g := range l.Groups {
+			sort.Slice(g.Hosts, func(i, j int) bool { return g.Hosts[i].Name < g.Hosts[j].Name })
+		}
+	}
+	n.loadNetworks(tree, attached)
+	tree.index()
+	return tree, nil
+}
+
+// loadConcurrency is how many hosts loadNetworks calls at once.
+const loadConcurrency = 8
+
+// loadNetworks loads the networks of the hosts concurrently, a host which can't be reached keeps no networks and is
+// listed in HostErrors. The networks, devices and points are sorted by name.
+func (n *Navigator) loadNetworks(tree *Tree, hosts []*model.Host) {
+	type result struct {
+		networks []*model.Network
+		err      error
+	}
+	results := make([]result, len(hosts))
+	slots := make(chan struct{}, loadConcurrency)
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, hostUUID string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			results[i].networks, results[i].err = n.marshaller.GetNetworks(nil, &nmodule.Opts{
+				Args:     &nargs.Args{WithDevices: true, WithPoints: true},
+				HostUUID: &hostUUID,
+			})
+		}(i, host.UUID)
+	}
+	wg.Wait()
+	for i, host := range hosts {
+		if err := results[i].err; err != nil {
+			log.Warnf("hierarchy: failed to load networks of host %s: %s", host.UUID, err)
+			tree.HostErrors[host.UUID] = err
+			host.Networks = nil
+			continue
+		}
+		delete(tree.HostErrors, host.UUID)
+		host.Networks = results[i].networks
+		sort.Slice(host.Networks, func(i, j int) bool { return host.Networks[i].Name < host.Networks[j].Name })
+		for _, network := range host.Networks {
+			devices := network.Devices
+			sort.Slice(devices, func(i, j int) bool { return devices[i].Name < devices[j].Name })
+			for _, device := range devices {
+				points := device.Points
+				sort.Slice(points, func(i, j int) bool { return points[i].Name < points[j].Name })
+			}
+		}
+	}
+}
+
+// reloadHosts returns a copy of the tree with the networks of the stale hosts reloaded, earlier trees handed out stay
+// unchanged.
+func (n *Navigator) reloadHosts(old *Tree, stale map[string]bool) *Tree {
+	tree := &Tree{HostErrors: map[string]error{}}
+	for uuid, err := range old.HostErrors {
+		tree.HostErrors[uuid] = err
+	}
+	var reload []*model.Host
+	for _, l := range old.Locations {
+		location := *l
+		location.Groups = nil
+		for _, g := range l.Groups {
+			group := *g
+			group.Hosts = nil
+			for _, h := range g.Hosts {
+				host := h
+				if stale[h.UUID] {
+					copied := *h
+					host = &copied
+					reload = append(reload, host)
+				}
+				group.Hosts = append(group.Hosts, host)
+			}
+			location.Groups = append(location.Groups, &group)
+		}
+		tree.Locations = append(tree.Locations, &location)
+	}
+	n.loadNetworks(tree, reload)
+	tree.index()
+	return tree
+}
@@ -0,0 +1,204 @@
+package hierarchy
+
+import (
+	"errors"
+	"fmt"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"net/url"
+	"strings"
+)
+
+var ErrAmbiguous = errors.New("path is ambiguous")
+
+type Kind string
+
+const (
+	KindLocation Kind = "location"
+	KindGroup    Kind = "group"
+	KindHost     Kind = "host"
+	KindNetwork  Kind = "network"
+	KindDevice   Kind = "device"
+	KindPoint    Kind = "point"
+)
+
+// Node is an entry of the tree with its ancestors, the fields below Kind are nil.
+type Node struct {
+	Kind     Kind
+	Path     string
+	Location *model.Location
+	Group    *model.Group
+	Host     *model.Host
+	Network  *model.Network
+	Device   *model.Device
+	Point    *model.Point
+}
+
+// UUID returns the uuid of the entry of the node.
+func (n *Node) UUID() string {
+	switch n.Kind {
+	case KindLocation:
+		return n.Location.UUID
+	case KindGroup:
+		return n.Group.UUID
+	case KindHost:
+		return n.Host.UUID
+	case KindNetwork:
+		return n.Network.UUID
+	case KindDevice:
+		return n.Device.UUID
+	default:
+		return n.Point.UUID
+	}
+}
+
+// Tree is the location, group, host, network, device and point hierarchy. The models are linked through their
+// Groups, Hosts, Networks, Devices and Points fields.
+type Tree struct {
+	Locations  []*model.Location
+	HostErrors map[string]error // hosts whose networks couldn't be loaded, by host uuid
+
+	byPath    map[string]*Node
+	byUUID    map[string]*Node
+	ambiguous map[string]int // paths taken by more than one node, with the number of extra nodes
+}
+
+var pathEscaper = strings.NewReplacer("%", "%25", "/", "%2F")
+
+// JoinPath builds a path from names, a / in a name is escaped as %2F.
+func JoinPath(names ...string) string {
+	escaped := make([]string, len(names))
+	for i, name := range names {
+		escaped[i] = pathEscaper.Replace(name)
+	}
+	return strings.Join(escaped, "/")
+}
+
+func splitPath(path string) ([]string, error) {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil, nil
+	}
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		name, err := url.PathUnescape(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path %s: %s", path, err)
+		}
+		segments[i] = name
+	}
+	return segments, nil
+}
+
+// Lookup returns the node of a path like site/group/host/network/device/point, shorter paths return the node at
+// their depth.
+func (t *Tree) Lookup(path string) (*Node, error) {
+	segments, err := splitPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) == 0 || len(segments) > 6 {
+		return nil, fmt.Errorf("invalid path %s", path)
+	}
+	key := JoinPath(segments...)
+	if extra := t.ambiguous[key]; extra > 0 {
+		return nil, fmt.Errorf("%w: %s matches %d entries, use Find with a uuid", ErrAmbiguous, path, extra+1)
+	}
+	node, ok := t.byPath[key]
+	if !ok {
+		return nil, fmt.Errorf("%s not found", path)
+	}
+	return node, nil
+}
+
+// Find returns the node of a location, group, host, network, device or point uuid.
+func (t *Tree) Find(uuid string) (*Node, bool) {
+	node, ok := t.byUUID[uuid]
+	return node, ok
+}
+
+// Walk calls fn for every node, parents before their children, until fn returns an error.
+func (t *Tree) Walk(fn func(*Node) error) error {
+	for _, location := range t.Locations {
+		if err := t.walk(t.byUUID[location.UUID], fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Tree) walk(node *Node, fn func(*Node) error) error {
+	if err := fn(node); err != nil {
+		return err
+	}
+	var children []string
+	switch node.Kind {
+	case KindLocation:
+		for _, g := range node.Location.Groups {
+			children = append(children, g.UUID)
+		}
+	case KindGroup:
+		for _, h := range node.Group.Hosts {
+			children = append(children, h.UUID)
+		}
+	case KindHost:
+		for _, n := range node.Host.Networks {
+			children = append(children, n.UUID)
+		}
+	case KindNetwork:
+		for _, d := range node.Network.Devices {
+			children = append(children, d.UUID)
+		}
+	case KindDevice:
+		for _, p := range node.Device.Points {
+			children = append(children, p.UUID)
+		}
+	}
+	for _, uuid := range children {
+		if err := t.walk(t.byUUID[uuid], fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// index rebuilds the path and uuid lookups, the children are already sorted by name.
+func (t *Tree) index() {
+	t.byPath = map[string]*Node{}
+	t.byUUID = map[string]*Node{}
+	t.ambiguous = map[string]int{}
+	for _, l := range t.Locations {
+		t.add(&Node{Kind: KindLocation, Location: l}, l.Name)
+		for _, g := range l.Groups {
+			t.add(&Node{Kind: KindGroup, Location: l, Group: g}, l.Name, g.Name)
+			for _, h := range g.Hosts {
+				t.add(&Node{Kind: KindHost, Location: l, Group: g, Host: h}, l.Name, g.Name, h.Name)
+				t.indexHost(l, g, h)
+			}
+		}
+	}
+}
+
+func (t *Tree) indexHost(l *model.Location, g *model.Group, h *model.Host) {
+	for _, n := range h.Networks {
+		t.add(&Node{Kind: KindNetwork, Location: l, Group: g, Host: h, Network: n}, l.Name, g.Name, h.Name, n.Name)
+		for _, d := range n.Devices {
+			t.add(&Node{Kind: KindDevice, Location: l, Group: g, Host: h, Network: n, Device: d},
+				l.Name, g.Name, h.Name, n.Name, d.Name)
+			for _, p := range d.Points {
+				t.add(&Node{Kind: KindPoint, Location: l, Group: g, Host: h, Network: n, Device: d, Point: p},
+					l.Name, g.Name, h.Name, n.Name, d.Name, p.Name)
+			}
+		}
+	}
+}
+
+// add indexes the node, a path already taken by a sibling with the same name is recorded as ambiguous.
+func (t *Tree) add(node *Node, names ...string) {
+	node.Path = JoinPath(names...)
+	if _, ok := t.byPath[node.Path]; ok {
+		t.ambiguous[node.Path]++
+	} else {
+		t.byPath[node.Path] = node
+	}
+	t.byUUID[node.UUID()] = node
+}
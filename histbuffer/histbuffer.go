@@ -0,0 +1,513 @@
+package histbuffer
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	kindHistory      = "history"
+	kindPointHistory = "point_history"
+
+	activeExt = ".open"
+	sealedExt = ".seg"
+	ackExt    = ".ack"
+
+	// DeadLetterFile holds the records the host rejected MaxAttempts times, one json record per line.
+	DeadLetterFile = "dead-letter.jsonl"
+)
+
+type Config struct {
+	SubDir        string        // directory created inside the module dir
+	BatchSize     int           // records sent per CreateHistories/CreatePointHistories call
+	SegmentSize   int           // records per segment file before a new one is started
+	FlushInterval time.Duration // how often Start delivers the buffer
+	BackoffBase   time.Duration // first retry delay after a failed delivery
+	BackoffMax    time.Duration // retry delay cap
+	MaxAttempts   int           // failed deliveries of a batch before it is dead-lettered, 0 retries forever
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		SubDir:        "history-buffer",
+		BatchSize:     500,
+		SegmentSize:   5000,
+		FlushInterval: 30 * time.Second,
+		BackoffBase:   5 * time.Second,
+		BackoffMax:    5 * time.Minute,
+		MaxAttempts:   10,
+	}
+}
+
+type record struct {
+	Key          string              `json:"key"`
+	Kind         string              `json:"kind"`
+	History      *model.History      `json:"history,omitempty"`
+	PointHistory *model.PointHistory `json:"point_history,omitempty"`
+}
+
+// Buffer is a durable store-and-forward queue for histories. Records are appended to segment files and a segment is
+// only removed once the host accepted all of its records, so delivery is at-least-once across module restarts.
+type Buffer struct {
+	marshaller nmodule.Marshaller
+	dir        string
+	config     *Config
+
+	mutex       sync.Mutex
+	active      *os.File
+	activeSeq   int
+	activeCount int
+	nextSeq     int
+	pending     map[string]struct{}
+
+	flushMutex sync.Mutex
+	attempts   map[int]int     // failed deliveries of the next batch of a segment, guarded by flushMutex
+	unbatched  map[string]bool // kinds whose batch api the host doesn't provide, guarded by flushMutex
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// Open creates the buffer inside the module dir returned by CreateModuleDir.
+func Open(marshaller nmodule.Marshaller, moduleName string, config *Config) (*Buffer, error) {
+	moduleDir, err := marshaller.CreateModuleDir(moduleName)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		config = DefaultConfig()
+	}
+	return OpenDir(marshaller, filepath.Join(*moduleDir, config.SubDir), config)
+}
+
+// OpenDir creates the buffer in dir and recovers the segments left by a previous run.
+func OpenDir(marshaller nmodule.Marshaller, dir string, config *Config) (*Buffer, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	b := &Buffer{
+		marshaller: marshaller,
+		dir:        dir,
+		config:     config,
+		pending:    make(map[string]struct{}),
+		attempts:   make(map[int]int),
+		unbatched:  make(map[string]bool),
+	}
+	if err := b.recover(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *Buffer) recover() error {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, activeExt) {
+			// the module stopped while appending, the segment is sealed as it is
+			sealed := strings.TrimSuffix(name, activeExt) + sealedExt
+			if err := os.Rename(filepath.Join(b.dir, name), filepath.Join(b.dir, sealed)); err != nil {
+				return err
+			}
+		}
+	}
+	segments, err := b.segments()
+	if err != nil {
+		return err
+	}
+	sealed := map[int]bool{}
+	for _, seq := range segments {
+		sealed[seq] = true
+		records, err := b.readSegment(seq)
+		if err != nil {
+			return err
+		}
+		ack, err := b.readAck(seq)
+		if err != nil {
+			return err
+		}
+		if ack > len(records) {
+			ack = len(records)
+		}
+		for _, r := range records[ack:] {
+			b.pending[r.Key] = struct{}{}
+		}
+		if seq >= b.nextSeq {
+			b.nextSeq = seq + 1
+		}
+	}
+	for _, entry := range entries {
+		// an ack left by a crash while its segment was removed, it must not apply to a new segment of that seq
+		seq, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), ackExt))
+		if !strings.HasSuffix(entry.Name(), ackExt) || err != nil || sealed[seq] {
+			continue
+		}
+		if err = os.Remove(b.path(seq, ackExt)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if seq >= b.nextSeq {
+			b.nextSeq = seq + 1
+		}
+	}
+	return nil
+}
+
+func HistoryKey(h *model.History) string {
+	return fmt.Sprintf("%s|%s|%s|%d", kindHistory, h.HostUUID, h.PointUUID, h.Timestamp.UnixNano())
+}
+
+func PointHistoryKey(h *model.PointHistory) string {
+	return fmt.Sprintf("%s|%s|%d", kindPointHistory, h.PointUUID, h.Timestamp.UnixNano())
+}
+
+// AddHistories persists the histories, records already waiting in the buffer are skipped.
+func (b *Buffer) AddHistories(histories []*model.History) error {
+	records := make([]*record, 0, len(histories))
+	for _, h := range histories {
+		records = append(records, &record{Key: HistoryKey(h), Kind: kindHistory, History: h})
+	}
+	return b.append(records)
+}
+
+// AddPointHistories persists the point histories, records already waiting in the buffer are skipped.
+func (b *Buffer) AddPointHistories(histories []*model.PointHistory) error {
+	records := make([]*record, 0, len(histories))
+	for _, h := range histories {
+		records = append(records, &record{Key: PointHistoryKey(h), Kind: kindPointHistory, PointHistory: h})
+	}
+	return b.append(records)
+}
+
+func (b *Buffer) append(records []*record) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, r := range records {
+		if _, ok := b.pending[r.Key]; ok {
+			continue
+		}
+		if b.active == nil || (b.config.SegmentSize > 0 && b.activeCount >= b.config.SegmentSize) {
+			if err := b.rotate(); err != nil {
+				return err
+			}
+		}
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if _, err = b.active.Write(append(line, '\n')); err != nil {
+			return err
+		}
+		b.activeCount++
+		b.pending[r.Key] = struct{}{}
+	}
+	if b.active != nil {
+		return b.active.Sync()
+	}
+	return nil
+}
+
+// rotate seals the active segment and starts a new one, the caller holds the mutex.
+func (b *Buffer) rotate() error {
+	if err := b.seal(); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(b.path(b.nextSeq, activeExt), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	b.active = f
+	b.activeSeq = b.nextSeq
+	b.activeCount = 0
+	b.nextSeq++
+	return nil
+}
+
+func (b *Buffer) seal() error {
+	if b.active == nil {
+		return nil
+	}
+	if err := b.active.Close(); err != nil {
+		return err
+	}
+	b.active = nil
+	return os.Rename(b.path(b.activeSeq, activeExt), b.path(b.activeSeq, sealedExt))
+}
+
+// Len returns the number of records waiting for delivery.
+func (b *Buffer) Len() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.pending)
+}
+
+// Flush delivers every buffered record in order and stops at the first failure, delivered records are acknowledged
+// on disk so they are not sent again. A batch failing MaxAttempts times is moved to the DeadLetterFile, so a batch the
+// host keeps rejecting doesn't block the records behind it.
+func (b *Buffer) Flush() error {
+	b.flushMutex.Lock()
+	defer b.flushMutex.Unlock()
+
+	b.mutex.Lock()
+	err := b.seal()
+	b.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	segments, err := b.segments()
+	if err != nil {
+		return err
+	}
+	for _, seq := range segments {
+		if err = b.deliver(seq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Buffer) deliver(seq int) error {
+	records, err := b.readSegment(seq)
+	if err != nil {
+		return err
+	}
+	ack, err := b.readAck(seq)
+	if err != nil {
+		return err
+	}
+	for ack < len(records) {
+		batch := b.batch(records[ack:])
+		if err = b.send(batch); err != nil {
+			b.attempts[seq]++
+			if b.config.MaxAttempts <= 0 || b.attempts[seq] < b.config.MaxAttempts {
+				return err
+			}
+			log.Errorf("history buffer: dead-lettering %d records after %d failed deliveries: %s", len(batch),
+				b.attempts[seq], err)
+			if err = b.deadLetter(batch); err != nil {
+				return err
+			}
+		}
+		delete(b.attempts, seq)
+		ack += len(batch)
+		if err = b.writeAck(seq, ack); err != nil {
+			return err
+		}
+		b.mutex.Lock()
+		for _, r := range batch {
+			delete(b.pending, r.Key)
+		}
+		b.mutex.Unlock()
+	}
+	// the ack goes first, a segment left without it is only delivered again
+	if err = os.Remove(b.path(seq, ackExt)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Remove(b.path(seq, sealedExt))
+}
+
+func (b *Buffer) deadLetter(batch []*record) error {
+	f, err := os.OpenFile(filepath.Join(b.dir, DeadLetterFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, r := range batch {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if _, err = f.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	return f.Sync()
+}
+
+// batch takes up to BatchSize leading records of the same kind.
+func (b *Buffer) batch(records []*record) []*record {
+	n := 1
+	for n < len(records) && n < b.config.BatchSize && records[n].Kind == records[0].Kind {
+		n++
+	}
+	return records[:n]
+}
+
+// send delivers the batch with the keys of its records, so the host can skip records of a redelivered batch. A host
+// without the batch api gets the records through CreateHistories or CreatePointHistories.
+func (b *Buffer) send(batch []*record) error {
+	keys := make([]string, 0, len(batch))
+	for _, r := range batch {
+		keys = append(keys, r.Key)
+	}
+	kind := batch[0].Kind
+	var ok bool
+	var err error
+	if kind == kindPointHistory {
+		histories := make([]*model.PointHistory, 0, len(batch))
+		for _, r := range batch {
+			histories = append(histories, r.PointHistory)
+		}
+		if !b.unbatched[kind] {
+			err = b.marshaller.CreatePointHistoriesBatch(&nmodule.PointHistoryBatch{Keys: keys, PointHistories: histories})
+			if !errors.Is(err, nmodule.ErrEndpointNotSupported) {
+				return err
+			}
+			log.Warnf("history buffer: %s, sending point histories without dedup keys", err)
+			b.unbatched[kind] = true
+		}
+		ok, err = b.marshaller.CreatePointHistories(histories)
+	} else {
+		histories := make([]*model.History, 0, len(batch))
+		for _, r := range batch {
+			histories = append(histories, r.History)
+		}
+		if !b.unbatched[kind] {
+			err = b.marshaller.CreateHistoriesBatch(&nmodule.HistoryBatch{Keys: keys, Histories: histories})
+			if !errors.Is(err, nmodule.ErrEndpointNotSupported) {
+				return err
+			}
+			log.Warnf("history buffer: %s, sending histories without dedup keys", err)
+			b.unbatched[kind] = true
+		}
+		ok, err = b.marshaller.CreateHistories(histories)
+	}
+	if err == nil && !ok {
+		err = errors.New("host didn't store the histories")
+	}
+	return err
+}
+
+// Start flushes the buffer every FlushInterval, retrying failed deliveries with an exponential back-off.
+func (b *Buffer) Start() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.stop != nil {
+		return errors.New("history buffer is already running")
+	}
+	b.stop = make(chan struct{})
+	b.done = make(chan struct{})
+	go b.run(b.stop, b.done)
+	return nil
+}
+
+func (b *Buffer) run(stop, done chan struct{}) {
+	defer close(done)
+	wait := b.config.FlushInterval
+	backoff := time.Duration(0)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+		if err := b.Flush(); err != nil {
+			if backoff == 0 {
+				backoff = b.config.BackoffBase
+			} else if backoff *= 2; backoff > b.config.BackoffMax {
+				backoff = b.config.BackoffMax
+			}
+			log.Warnf("history buffer: delivery failed, retrying in %s: %s", backoff, err)
+			wait = backoff
+			continue
+		}
+		backoff = 0
+		wait = b.config.FlushInterval
+	}
+}
+
+// Stop ends the flush loop and closes the active segment, the records stay on disk for the next Open.
+func (b *Buffer) Stop() error {
+	b.mutex.Lock()
+	stop, done := b.stop, b.done
+	b.stop, b.done = nil, nil
+	b.mutex.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.seal()
+}
+
+func (b *Buffer) path(seq int, ext string) string {
+	return filepath.Join(b.dir, fmt.Sprintf("%020d%s", seq, ext))
+}
+
+func (b *Buffer) segments() ([]int, error) {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []int
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, sealedExt) {
+			continue
+		}
+		seq, err := strconv.Atoi(strings.TrimSuffix(name, sealedExt))
+		if err != nil {
+			continue
+		}
+		segments = append(segments, seq)
+	}
+	sort.Ints(segments)
+	return segments, nil
+}
+
+func (b *Buffer) readSegment(seq int) ([]*record, error) {
+	f, err := os.Open(b.path(seq, sealedExt))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var records []*record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var r *record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// a torn write at the end of a crashed segment
+			log.Warnf("history buffer: skipping unreadable record in segment %d: %s", seq, err)
+			continue
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
+
+func (b *Buffer) readAck(seq int) (int, error) {
+	data, err := os.ReadFile(b.path(seq, ackExt))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+func (b *Buffer) writeAck(seq, ack int) error {
+	tmp := b.path(seq, ackExt) + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(ack)), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.path(seq, ackExt))
+}
@@ -0,0 +1,156 @@
+package histbuffer
+
+import (
+	"errors"
+	"fmt"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fakeMarshaller struct {
+	nmodule.Marshaller
+	fail           bool
+	reject         string // point uuid the host always rejects
+	histories      []*model.History
+	pointHistories []*model.PointHistory
+	keys           []string
+	noBatch        bool // an older host without the batch api
+	batchCalls     int
+}
+
+func (f *fakeMarshaller) CreateHistoriesBatch(batch *nmodule.HistoryBatch, opts ...*nmodule.Opts) error {
+	f.batchCalls++
+	if f.noBatch {
+		return fmt.Errorf("%w: /api/histories/batch", nmodule.ErrEndpointNotSupported)
+	}
+	if f.fail {
+		return errors.New("host is restarting")
+	}
+	for _, h := range batch.Histories {
+		if h.PointUUID == f.reject {
+			return errors.New("invalid history")
+		}
+	}
+	f.histories = append(f.histories, batch.Histories...)
+	f.keys = append(f.keys, batch.Keys...)
+	return nil
+}
+
+func (f *fakeMarshaller) CreatePointHistoriesBatch(batch *nmodule.PointHistoryBatch, opts ...*nmodule.Opts) error {
+	if f.fail {
+		return errors.New("host is restarting")
+	}
+	f.pointHistories = append(f.pointHistories, batch.PointHistories...)
+	f.keys = append(f.keys, batch.Keys...)
+	return nil
+}
+
+func (f *fakeMarshaller) CreateHistories(histories []*model.History, opts ...*nmodule.Opts) (bool, error) {
+	f.histories = append(f.histories, histories...)
+	return true, nil
+}
+
+func TestBufferSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	m := &fakeMarshaller{fail: true}
+	config := &Config{BatchSize: 2, SegmentSize: 3}
+	b, err := OpenDir(m, dir, config)
+	assert.NoError(t, err)
+
+	ts := time.Unix(1700000000, 0)
+	value := 1.0
+	var histories []*model.History
+	for i := 0; i < 4; i++ {
+		histories = append(histories, &model.History{PointUUID: "pnt", HostUUID: "hst", Value: &value,
+			Timestamp: ts.Add(time.Duration(i) * time.Minute)})
+	}
+	assert.NoError(t, b.AddHistories(histories))
+	assert.NoError(t, b.AddHistories(histories[:1]), "duplicates are skipped")
+	assert.NoError(t, b.AddPointHistories([]*model.PointHistory{{PointUUID: "pnt", Value: &value, Timestamp: ts}}))
+	assert.Equal(t, 5, b.Len())
+	assert.Error(t, b.Flush())
+	assert.NoError(t, b.Stop())
+
+	m.fail = false
+	b, err = OpenDir(m, dir, config)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, b.Len())
+	assert.NoError(t, b.Flush())
+	assert.Equal(t, 0, b.Len())
+	assert.Len(t, m.histories, 4)
+	assert.Len(t, m.pointHistories, 1)
+	assert.Equal(t, HistoryKey(histories[0]), m.keys[0])
+
+	assert.NoError(t, b.Flush())
+	assert.Len(t, m.histories, 4, "delivered records are not sent again")
+}
+
+func TestRejectedBatchIsDeadLettered(t *testing.T) {
+	dir := t.TempDir()
+	m := &fakeMarshaller{reject: "bad"}
+	b, err := OpenDir(m, dir, &Config{BatchSize: 1, SegmentSize: 10, MaxAttempts: 2})
+	assert.NoError(t, err)
+
+	value := 1.0
+	ts := time.Unix(1700000000, 0)
+	assert.NoError(t, b.AddHistories([]*model.History{
+		{PointUUID: "bad", Value: &value, Timestamp: ts},
+		{PointUUID: "good", Value: &value, Timestamp: ts},
+	}))
+	assert.Error(t, b.Flush())
+	assert.Empty(t, m.histories)
+	assert.NoError(t, b.Flush())
+	assert.Len(t, m.histories, 1)
+	assert.Equal(t, "good", m.histories[0].PointUUID)
+	assert.Equal(t, 0, b.Len())
+
+	data, err := os.ReadFile(filepath.Join(dir, DeadLetterFile))
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"point_uuid":"bad"`)
+}
+
+func TestOrphanAckIsRemoved(t *testing.T) {
+	dir := t.TempDir()
+	m := &fakeMarshaller{}
+	b, err := OpenDir(m, dir, nil)
+	assert.NoError(t, err)
+	// left by a crash after the segment was removed
+	assert.NoError(t, os.WriteFile(b.path(0, ackExt), []byte("1"), 0644))
+
+	b, err = OpenDir(m, dir, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, b.nextSeq)
+	_, err = os.Stat(b.path(0, ackExt))
+	assert.True(t, os.IsNotExist(err))
+
+	value := 1.0
+	ts := time.Unix(1700000000, 0)
+	assert.NoError(t, b.AddHistories([]*model.History{
+		{PointUUID: "pnt", Value: &value, Timestamp: ts},
+		{PointUUID: "pnt", Value: &value, Timestamp: ts.Add(time.Minute)},
+	}))
+	assert.NoError(t, b.Flush())
+	assert.Len(t, m.histories, 2)
+}
+
+func TestHostWithoutBatchAPI(t *testing.T) {
+	m := &fakeMarshaller{noBatch: true}
+	b, err := OpenDir(m, t.TempDir(), &Config{BatchSize: 1, SegmentSize: 10})
+	assert.NoError(t, err)
+
+	value := 1.0
+	ts := time.Unix(1700000000, 0)
+	assert.NoError(t, b.AddHistories([]*model.History{
+		{PointUUID: "pnt", Value: &value, Timestamp: ts},
+		{PointUUID: "pnt", Value: &value, Timestamp: ts.Add(time.Minute)},
+	}))
+	assert.NoError(t, b.Flush())
+	assert.Len(t, m.histories, 2)
+	assert.Equal(t, 1, m.batchCalls, "the batch api isn't tried again")
+	assert.Equal(t, 0, b.Len())
+}
@@ -0,0 +1,274 @@
+package ical
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/NubeIO/lib-module-go/schedule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The X- properties keep what a calendar has no place for, so an exported schedule imports back unchanged.
+const (
+	propKind   = "X-NUBE-KIND"
+	propKey    = "X-NUBE-KEY"
+	propValue  = "X-NUBE-VALUE"
+	propColor  = "X-NUBE-COLOR"
+	propEnable = "X-NUBE-ENABLE"
+
+	kindWeekly    = "WEEKLY"
+	kindEvent     = "EVENT"
+	kindException = "EXCEPTION"
+)
+
+// dateRange is the element type of the dates of dto.Events and dto.Exception.
+type dateRange = struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// Export writes the weekly entries, events and exceptions of a schedule as an RFC 5545 calendar. Weekly entries
+// become weekly recurring VEVENTs starting the week the schedule was created, with an EXDATE for each occurrence an
+// exception holds off. Times carry the IANA name of the schedule timezone as TZID along with its VTIMEZONE.
+func Export(s *model.Schedule, location *time.Location) ([]byte, error) {
+	parsed, err := schedule.Parse(s, location)
+	if err != nil {
+		return nil, err
+	}
+	var data dto.ScheduleData
+	if len(s.Schedule) > 0 {
+		if err = json.Unmarshal(s.Schedule, &data); err != nil {
+			return nil, err
+		}
+	}
+	anchor := s.CreatedAt
+	if anchor.IsZero() {
+		anchor = time.Now()
+	}
+	return ExportData(s.UUID, s.Name, &data, parsed.Location, anchor)
+}
+
+// ExportData writes schedule data as a calendar, weekly entries start the week of anchor.
+func ExportData(uuid, name string, data *dto.ScheduleData, location *time.Location, anchor time.Time) ([]byte, error) {
+	if location == nil {
+		location = time.UTC
+	}
+	anchor = anchor.In(location)
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	w := &writer{}
+	w.line("BEGIN", "VCALENDAR")
+	w.line("VERSION", "2.0")
+	w.line("PRODID", "-//NubeIO//lib-module-go//EN")
+	w.line("CALSCALE", "GREGORIAN")
+	if name != "" {
+		w.line("X-WR-CALNAME", escapeText(name))
+	}
+	w.line("X-WR-TIMEZONE", location.String())
+	if location != time.UTC {
+		first, last := span(data, location, anchor)
+		writeTimezone(w, location, first, last)
+	}
+
+	var holidays [][2]time.Time
+	for _, key := range sortedKeys(data.Schedules.Exception) {
+		e := data.Schedules.Exception[key]
+		if e.Enable != nil && !*e.Enable {
+			continue
+		}
+		for _, d := range e.Dates {
+			start, end, err := parseRange(d, location)
+			if err != nil {
+				return nil, fmt.Errorf("exception %s: %s", key, err)
+			}
+			holidays = append(holidays, [2]time.Time{start, end})
+		}
+	}
+
+	for _, key := range sortedKeys(data.Schedules.Weekly) {
+		entry := data.Schedules.Weekly[key]
+		if err := writeWeekly(w, uuid, key, entry, location, anchor, holidays, stamp); err != nil {
+			return nil, fmt.Errorf("weekly %s: %s", key, err)
+		}
+	}
+	for _, key := range sortedKeys(data.Schedules.Events) {
+		e := data.Schedules.Events[key]
+		if err := writeDated(w, uuid, key, kindEvent, e.Name, e.Dates, e.Value, e.Color, e.Enable, location, stamp); err != nil {
+			return nil, fmt.Errorf("event %s: %s", key, err)
+		}
+	}
+	for _, key := range sortedKeys(data.Schedules.Exception) {
+		e := data.Schedules.Exception[key]
+		if err := writeDated(w, uuid, key, kindException, e.Name, e.Dates, e.Value, e.Color, e.Enable, location, stamp); err != nil {
+			return nil, fmt.Errorf("exception %s: %s", key, err)
+		}
+	}
+	w.line("END", "VCALENDAR")
+	return w.buf.Bytes(), nil
+}
+
+// span returns the first and the last time of the events and exceptions, weekly entries start at anchor. Dates which
+// don't parse are left to the writers to report.
+func span(data *dto.ScheduleData, location *time.Location, anchor time.Time) (first, last time.Time) {
+	first, last = anchor, anchor
+	var dates []dateRange
+	for _, e := range data.Schedules.Events {
+		dates = append(dates, e.Dates...)
+	}
+	for _, e := range data.Schedules.Exception {
+		dates = append(dates, e.Dates...)
+	}
+	for _, d := range dates {
+		start, end, err := parseRange(d, location)
+		if err != nil {
+			continue
+		}
+		if start.Before(first) {
+			first = start
+		}
+		if end.After(last) {
+			last = end
+		}
+	}
+	return first, last
+}
+
+func writeWeekly(w *writer, uuid, key string, entry dto.Weekly, location *time.Location, anchor time.Time,
+	holidays [][2]time.Time, stamp string) error {
+	days := map[time.Weekday]bool{}
+	var byDay []string
+	for _, day := range entry.Days {
+		weekday, err := schedule.ParseWeekday(day)
+		if err != nil {
+			return err
+		}
+		if !days[weekday] {
+			days[weekday] = true
+			byDay = append(byDay, dayNames[weekday])
+		}
+	}
+	if len(days) == 0 {
+		return nil
+	}
+	sort.Slice(byDay, func(i, j int) bool { return (icalDays[byDay[i]]+6)%7 < (icalDays[byDay[j]]+6)%7 })
+	start, err := schedule.ParseTimeOfDay(entry.Start)
+	if err != nil {
+		return err
+	}
+	end, err := schedule.ParseTimeOfDay(entry.End)
+	if err != nil {
+		return err
+	}
+	first := anchor
+	for !days[first.Weekday()] {
+		first = first.AddDate(0, 0, 1)
+	}
+	dtStart := wallClock(first, start, location)
+	dtEnd := wallClock(first, end, location)
+	if end <= start {
+		dtEnd = wallClock(first.AddDate(0, 0, 1), end, location)
+	}
+	var exDates []string
+	for _, h := range holidays {
+		for day := h[0].AddDate(0, 0, -1); day.Before(h[1]); day = day.AddDate(0, 0, 1) {
+			occurrence := wallClock(day, start, location)
+			if days[day.Weekday()] && !occurrence.Before(h[0]) && occurrence.Before(h[1]) && !occurrence.Before(dtStart) {
+				exDates = append(exDates, formatDateTime(occurrence, location))
+			}
+		}
+	}
+	w.line("BEGIN", "VEVENT")
+	w.line("UID", key+"@"+uuid)
+	w.line("DTSTAMP", stamp)
+	w.line("SUMMARY", escapeText(entry.Name))
+	w.line("DTSTART", formatDateTime(dtStart, location), tzParams(location)...)
+	w.line("DTEND", formatDateTime(dtEnd, location), tzParams(location)...)
+	w.line("RRULE", "FREQ=WEEKLY;BYDAY="+strings.Join(byDay, ","))
+	if len(exDates) > 0 {
+		w.line("EXDATE", strings.Join(exDates, ","), tzParams(location)...)
+	}
+	writeExtra(w, kindWeekly, key, entry.Value, entry.Color, entry.Enable)
+	w.line("END", "VEVENT")
+	return nil
+}
+
+func writeDated(w *writer, uuid, key, kind, name string, dates []dateRange, value float64, color string, enable *bool,
+	location *time.Location, stamp string) error {
+	for i, d := range dates {
+		start, end, err := parseRange(d, location)
+		if err != nil {
+			return err
+		}
+		w.line("BEGIN", "VEVENT")
+		w.line("UID", fmt.Sprintf("%s-%d@%s", key, i, uuid))
+		w.line("DTSTAMP", stamp)
+		w.line("SUMMARY", escapeText(name))
+		if isMidnight(start) && isMidnight(end) {
+			w.line("DTSTART", start.Format("20060102"), "VALUE", "DATE")
+			w.line("DTEND", end.Format("20060102"), "VALUE", "DATE")
+		} else {
+			w.line("DTSTART", formatDateTime(start, location), tzParams(location)...)
+			w.line("DTEND", formatDateTime(end, location), tzParams(location)...)
+		}
+		writeExtra(w, kind, key, value, color, enable)
+		w.line("END", "VEVENT")
+	}
+	return nil
+}
+
+func writeExtra(w *writer, kind, key string, value float64, color string, enable *bool) {
+	w.line(propKind, kind)
+	w.line(propKey, escapeText(key))
+	w.line(propValue, strconv.FormatFloat(value, 'f', -1, 64))
+	if color != "" {
+		w.line(propColor, escapeText(color))
+	}
+	if enable != nil && !*enable {
+		w.line(propEnable, "FALSE")
+	}
+}
+
+func parseRange(d dateRange, location *time.Location) (time.Time, time.Time, error) {
+	start, err := schedule.ParseDate(d.Start, location)
+	if err != nil {
+		return start, start, err
+	}
+	end, err := schedule.ParseDate(d.End, location)
+	return start.In(location), end.In(location), err
+}
+
+func isMidnight(t time.Time) bool {
+	return t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0
+}
+
+// wallClock returns the time of day on the date, keeping the wall clock across DST changes.
+func wallClock(date time.Time, timeOfDay time.Duration, location *time.Location) time.Time {
+	h, m, s := int(timeOfDay/time.Hour), int(timeOfDay%time.Hour/time.Minute), int(timeOfDay%time.Minute/time.Second)
+	return time.Date(date.Year(), date.Month(), date.Day(), h, m, s, 0, location)
+}
+
+func formatDateTime(t time.Time, location *time.Location) string {
+	if location == time.UTC {
+		return t.UTC().Format("20060102T150405Z")
+	}
+	return t.In(location).Format("20060102T150405")
+}
+
+func tzParams(location *time.Location) []string {
+	if location == time.UTC {
+		return nil
+	}
+	return []string{"TZID", location.String()}
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,69 @@
+package ical
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/lib-module-go/router"
+	"github.com/NubeIO/lib-module-go/schedule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"time"
+)
+
+// ExportHandler serves the schedule of the uuid path param as a calendar, e.g. on GET /api/schedules/:uuid/ics.
+// Schedules without a timezone are exported in location.
+func ExportHandler(marshaller nmodule.Marshaller, location *time.Location) router.HandlerFunc {
+	return func(_ *nmodule.Module, r *router.Request) ([]byte, error) {
+		uuid := r.PathParams["uuid"]
+		if uuid == "" {
+			return nil, errors.New("schedule uuid is required")
+		}
+		s, err := marshaller.GetSchedule(uuid)
+		if err != nil {
+			return nil, err
+		}
+		return Export(s, location)
+	}
+}
+
+// ImportHandler writes a calendar uploaded as the request body to the schedule of the uuid path param, e.g. on
+// POST /api/schedules/:uuid/ics. The entries replace the ones of the schedule unless the merge query param is true,
+// either way they are converted to the timezone of the schedule. It responds with the written schedule data.
+func ImportHandler(marshaller nmodule.Marshaller, location *time.Location) router.HandlerFunc {
+	return func(_ *nmodule.Module, r *router.Request) ([]byte, error) {
+		uuid := r.PathParams["uuid"]
+		if uuid == "" {
+			return nil, errors.New("schedule uuid is required")
+		}
+		s, err := marshaller.GetSchedule(uuid)
+		if err != nil {
+			return nil, err
+		}
+		loc, err := schedule.Location(s, location)
+		if err != nil {
+			return nil, err
+		}
+		var current dto.ScheduleData
+		if len(s.Schedule) > 0 {
+			if err = json.Unmarshal(s.Schedule, &current); err != nil {
+				return nil, err
+			}
+		}
+		imported, err := Import(r.Body, &Options{Location: loc, Timezone: loc})
+		if err != nil {
+			return nil, err
+		}
+		data := imported
+		if r.QueryParams.Get("merge") == "true" {
+			Merge(&current, imported)
+			data = &current
+		} else if len(current.Config) > 0 {
+			data.Config = current.Config
+		}
+		written, err := marshaller.ScheduleWrite(uuid, data)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(written)
+	}
+}
@@ -0,0 +1,177 @@
+package ical
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// property is a content line of a calendar, e.g. DTSTART;TZID=Europe/Berlin:20240101T080000.
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+func (p *property) param(name string) string {
+	return p.params[name]
+}
+
+// component is a BEGIN/END block, only VEVENTs, VTIMEZONEs and the VCALENDAR are read.
+type component struct {
+	name       string
+	properties []*property
+	children   []*component
+}
+
+func (c *component) get(name string) *property {
+	for _, p := range c.properties {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func (c *component) all(name string) []*property {
+	var properties []*property
+	for _, p := range c.properties {
+		if p.name == name {
+			properties = append(properties, p)
+		}
+	}
+	return properties
+}
+
+func (c *component) value(name string) string {
+	if p := c.get(name); p != nil {
+		return p.value
+	}
+	return ""
+}
+
+// parse reads the first VCALENDAR of the data.
+func parse(data []byte) (*component, error) {
+	// unfold: a line starting with a space or tab continues the previous one
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	data = bytes.ReplaceAll(data, []byte("\n "), nil)
+	data = bytes.ReplaceAll(data, []byte("\n\t"), nil)
+	var stack []*component
+	var calendar *component
+	for i, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		p, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+1, err)
+		}
+		switch p.name {
+		case "BEGIN":
+			c := &component{name: strings.ToUpper(p.value)}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, c)
+			}
+			stack = append(stack, c)
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].name != strings.ToUpper(p.value) {
+				return nil, fmt.Errorf("line %d: unexpected END:%s", i+1, p.value)
+			}
+			c := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 && c.name == "VCALENDAR" {
+				calendar = c
+			}
+		default:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("line %d: %s outside of a component", i+1, p.name)
+			}
+			c := stack[len(stack)-1]
+			c.properties = append(c.properties, p)
+		}
+		if calendar != nil {
+			return calendar, nil
+		}
+	}
+	return nil, errors.New("no VCALENDAR found")
+}
+
+func parseLine(line string) (*property, error) {
+	p := &property{params: map[string]string{}}
+	// the value starts at the first colon outside of a quoted parameter value
+	quoted := false
+	colon := -1
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return nil, fmt.Errorf("missing colon in %q", line)
+	}
+	p.value = line[colon+1:]
+	parts := splitParams(line[:colon])
+	p.name = strings.ToUpper(parts[0])
+	for _, param := range parts[1:] {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid parameter %q", param)
+		}
+		p.params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+	}
+	return p, nil
+}
+
+func splitParams(s string) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i, r := range s {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ';' && !quoted {
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// writer writes folded content lines ending in CRLF.
+type writer struct {
+	buf bytes.Buffer
+}
+
+func (w *writer) line(name, value string, params ...string) {
+	line := name
+	for i := 0; i+1 < len(params); i += 2 {
+		line += ";" + params[i] + "=" + params[i+1]
+	}
+	line += ":" + value
+	// fold at 75 octets without splitting a utf-8 sequence
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		w.buf.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		limit = 74 // after the leading space
+	}
+	w.buf.WriteString(line + "\r\n")
+}
+
+func escapeText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+func unescapeText(s string) string {
+	return strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n").Replace(s)
+}
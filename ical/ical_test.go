@@ -0,0 +1,230 @@
+package ical
+
+import (
+	"encoding/json"
+	"github.com/NubeIO/lib-module-go/nhttp"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/lib-module-go/router"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+const scheduleJSON1 = `{
+	"schedules": {
+		"weekly": {
+			"a": {"name": "office, main", "days": ["friday", "monday"], "start": "08:00", "end": "17:00", "value": 21, "color": "#fff"},
+			"b": {"name": "night", "days": ["sunday"], "start": "22:00", "end": "00:00", "value": 18, "enable": false}
+		},
+		"events": {
+			"e": {"name": "open day", "dates": [{"start": "2024-03-16T10:00:00-04:00", "end": "2024-03-16T14:00:00-04:00"}], "value": 19}
+		},
+		"exception": {
+			"x": {"name": "holiday", "dates": [{"start": "2024-03-18", "end": "2024-03-19"}], "value": 12}
+		}
+	},
+	"config": {"timezone": "America/New_York"}
+}`
+
+func newSchedule() *model.Schedule {
+	s := &model.Schedule{Schedule: []byte(scheduleJSON1)}
+	s.UUID = "sch"
+	s.Name = "Level 1"
+	s.CreatedAt = time.Date(2024, 3, 12, 9, 0, 0, 0, time.UTC)
+	return s
+}
+
+func TestExportImport(t *testing.T) {
+	data, err := Export(newSchedule(), nil)
+	assert.NoError(t, err)
+	ics := string(data)
+	assert.Contains(t, ics, "DTSTART;TZID=America/New_York:20240315T080000\r\n")
+	assert.Contains(t, ics, "RRULE:FREQ=WEEKLY;BYDAY=MO,FR\r\n")
+	assert.Contains(t, ics, "EXDATE;TZID=America/New_York:20240318T080000\r\n")
+	assert.Contains(t, ics, "SUMMARY:office\\, main\r\n")
+	assert.Contains(t, ics, "DTSTART;VALUE=DATE:20240318\r\n")
+	assert.Contains(t, ics, "DTEND;TZID=America/New_York:20240318T000000\r\n")
+	assert.Contains(t, ics, "BEGIN:VTIMEZONE\r\nTZID:America/New_York\r\nBEGIN:STANDARD\r\nDTSTART:20240101T000000\r\n")
+	assert.Contains(t, ics, "DTSTART:20240310T020000\r\nTZOFFSETFROM:-0500\r\nTZOFFSETTO:-0400\r\n"+
+		"RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=2SU\r\nTZNAME:EDT\r\n")
+	assert.Contains(t, ics, "DTSTART:20241103T020000\r\nTZOFFSETFROM:-0400\r\nTZOFFSETTO:-0500\r\n"+
+		"RRULE:FREQ=YEARLY;BYMONTH=11;BYDAY=1SU\r\nTZNAME:EST\r\n")
+	for _, line := range strings.Split(ics, "\r\n") {
+		assert.LessOrEqual(t, len(line), 75)
+	}
+
+	imported, err := Import(data, nil)
+	assert.NoError(t, err)
+	var original dto.ScheduleData
+	assert.NoError(t, json.Unmarshal([]byte(scheduleJSON1), &original))
+	assert.Equal(t, original.Schedules.Weekly["a"].Name, imported.Schedules.Weekly["a"].Name)
+	assert.Equal(t, []string{"monday", "friday"}, imported.Schedules.Weekly["a"].Days)
+	assert.Equal(t, "17:00", imported.Schedules.Weekly["a"].End)
+	assert.Equal(t, "#fff", imported.Schedules.Weekly["a"].Color)
+	assert.Equal(t, "24:00", imported.Schedules.Weekly["b"].End)
+	assert.False(t, *imported.Schedules.Weekly["b"].Enable)
+	assert.Equal(t, original.Schedules.Events, imported.Schedules.Events)
+	assert.Equal(t, original.Schedules.Exception, imported.Schedules.Exception)
+	assert.JSONEq(t, `{"timezone": "America/New_York"}`, string(imported.Config))
+}
+
+const holidays = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Calendar App//EN\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:thanksgiving@example.com\r\n" +
+	"SUMMARY:Thanksgiving\r\n" +
+	"DTSTART;VALUE=DATE:20221124\r\n" +
+	"DTEND;VALUE=DATE:20221126\r\n" +
+	"RRULE:FREQ=YEARLY;BYMONTH=11;BYDAY=4TH\r\n" +
+	"EXDATE;VALUE=DATE:20241128\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:standup@example.com\r\n" +
+	"SUMMARY:Stand\r\n" +
+	" up\r\n" +
+	"DTSTART;TZID=Europe/Berlin:20240102T093000\r\n" +
+	"DURATION:PT15M\r\n" +
+	"RRULE:FREQ=WEEKLY;BYDAY=TU,TH\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:review@example.com\r\n" +
+	"SUMMARY:Review\r\n" +
+	"DTSTART:20240131T150000Z\r\n" +
+	"DTEND:20240131T160000Z\r\n" +
+	"RRULE:FREQ=MONTHLY;BYMONTHDAY=-1;COUNT=3\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestImportCalendar(t *testing.T) {
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	data, err := Import([]byte(holidays), &Options{
+		Location: berlin,
+		From:     time.Date(2024, 1, 1, 0, 0, 0, 0, berlin),
+		To:       time.Date(2026, 1, 1, 0, 0, 0, 0, berlin),
+	})
+	assert.NoError(t, err)
+
+	thanksgiving := data.Schedules.Exception["thanksgiving@example.com"]
+	assert.Equal(t, "Thanksgiving", thanksgiving.Name)
+	assert.Equal(t, []dateRange{{Start: "2025-11-27", End: "2025-11-29"}}, thanksgiving.Dates)
+
+	standup := data.Schedules.Weekly["standup@example.com"]
+	assert.Equal(t, "Standup", standup.Name)
+	assert.Equal(t, []string{"tuesday", "thursday"}, standup.Days)
+	assert.Equal(t, "09:30", standup.Start)
+	assert.Equal(t, "09:45", standup.End)
+
+	review := data.Schedules.Events["review@example.com"]
+	assert.Equal(t, []dateRange{
+		{Start: "2024-01-31T16:00:00+01:00", End: "2024-01-31T17:00:00+01:00"},
+		{Start: "2024-02-29T16:00:00+01:00", End: "2024-02-29T17:00:00+01:00"},
+		{Start: "2024-03-31T17:00:00+02:00", End: "2024-03-31T18:00:00+02:00"},
+	}, review.Dates)
+
+	_, err = Import([]byte("BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART:20240101T000000Z\r\nDTEND:20240101T010000Z\r\n"+
+		"RRULE:FREQ=HOURLY\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"), nil)
+	assert.Error(t, err)
+	_, err = Import([]byte("BEGIN:VEVENT\r\nEND:VEVENT\r\n"), nil)
+	assert.Error(t, err)
+}
+
+const outlook = "BEGIN:VCALENDAR\r\n" +
+	"BEGIN:VTIMEZONE\r\nTZID:W. Europe Standard Time\r\n" +
+	"BEGIN:STANDARD\r\nDTSTART:16010101T030000\r\nTZOFFSETFROM:+0200\r\nTZOFFSETTO:+0100\r\n" +
+	"RRULE:FREQ=YEARLY;BYDAY=-1SU;BYMONTH=10\r\nEND:STANDARD\r\n" +
+	"BEGIN:DAYLIGHT\r\nDTSTART:16010101T020000\r\nTZOFFSETFROM:+0100\r\nTZOFFSETTO:+0200\r\n" +
+	"RRULE:FREQ=YEARLY;BYDAY=-1SU;BYMONTH=3\r\nEND:DAYLIGHT\r\nEND:VTIMEZONE\r\n" +
+	"BEGIN:VTIMEZONE\r\nTZID:Site Time\r\n" +
+	"BEGIN:STANDARD\r\nDTSTART:16010101T000000\r\nTZOFFSETFROM:+0530\r\nTZOFFSETTO:+0530\r\nEND:STANDARD\r\n" +
+	"END:VTIMEZONE\r\n" +
+	"BEGIN:VEVENT\r\nUID:windows\r\nDTSTART;TZID=W. Europe Standard Time:20240701T080000\r\n" +
+	"DTEND;TZID=W. Europe Standard Time:20240701T090000\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:fixed\r\nDTSTART;TZID=Site Time:20240701T080000\r\n" +
+	"DTEND;TZID=Site Time:20240701T090000\r\nEND:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestImportTimezones(t *testing.T) {
+	data, err := Import([]byte(outlook), &Options{Timezone: time.UTC, From: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)})
+	assert.NoError(t, err)
+	assert.Equal(t, []dateRange{{Start: "2024-07-01T06:00:00Z", End: "2024-07-01T07:00:00Z"}},
+		data.Schedules.Events["windows"].Dates, "a Windows name is mapped to its IANA timezone")
+	assert.Equal(t, []dateRange{{Start: "2024-07-01T02:30:00Z", End: "2024-07-01T03:30:00Z"}},
+		data.Schedules.Events["fixed"].Dates, "a VTIMEZONE without daylight saving time is a fixed offset")
+
+	custom := strings.ReplaceAll(outlook, "W. Europe Standard Time", "Customized Time Zone")
+	_, err = Import([]byte(custom), nil)
+	assert.Error(t, err, "a VTIMEZONE with daylight saving time which names no known timezone")
+	_, err = Import([]byte(strings.Replace(custom, "TZID:Customized Time Zone\r\n",
+		"TZID:Customized Time Zone\r\nX-LIC-LOCATION:Europe/Berlin\r\n", 1)), nil)
+	assert.NoError(t, err)
+
+	for windows, iana := range windowsZones {
+		_, err = time.LoadLocation(iana)
+		assert.NoError(t, err, windows)
+	}
+}
+
+func TestImportConvertsWeekly(t *testing.T) {
+	ny, _ := time.LoadLocation("America/New_York")
+	calendar := "BEGIN:VCALENDAR\r\nX-WR-TIMEZONE:Europe/Berlin\r\nBEGIN:VEVENT\r\nUID:early\r\n" +
+		"DTSTART:20240102T020000\r\nDURATION:PT1H\r\nRRULE:FREQ=WEEKLY;BYDAY=TU,TH\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	data, err := Import([]byte(calendar), &Options{Timezone: ny})
+	assert.NoError(t, err)
+	early := data.Schedules.Weekly["early"]
+	assert.Equal(t, []string{"monday", "wednesday"}, early.Days)
+	assert.Equal(t, "20:00", early.Start)
+	assert.Equal(t, "21:00", early.End)
+	assert.JSONEq(t, `{"timezone": "America/New_York"}`, string(data.Config))
+
+	_, err = Import([]byte(strings.Replace(calendar, "T020000", "T053000", 1)), &Options{Timezone: ny})
+	assert.Error(t, err, "23:30 to 00:30 crosses midnight")
+}
+
+type fakeMarshaller struct {
+	nmodule.Marshaller
+	schedule *model.Schedule
+	written  *dto.ScheduleData
+}
+
+func (f *fakeMarshaller) GetSchedule(uuid string, opts ...*nmodule.Opts) (*model.Schedule, error) {
+	return f.schedule, nil
+}
+
+func (f *fakeMarshaller) ScheduleWrite(uuid string, body *dto.ScheduleData, opts ...*nmodule.Opts) (*dto.ScheduleData, error) {
+	f.written = body
+	return body, nil
+}
+
+func TestHandlers(t *testing.T) {
+	m := &fakeMarshaller{schedule: newSchedule()}
+	r := router.NewRouter()
+	r.Handle(nhttp.GET, "/api/schedules/:uuid/ics", ExportHandler(m, nil))
+	r.Handle(nhttp.POST, "/api/schedules/:uuid/ics", ImportHandler(m, nil))
+
+	ics, err := r.CallHandler(nil, nhttp.GET, "/api/schedules/sch/ics", nil, nil)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(ics), "BEGIN:VCALENDAR\r\n"))
+
+	upload := []byte(strings.Replace(holidays, "RRULE:FREQ=YEARLY;BYMONTH=11;BYDAY=4TH\r\n", "", 1))
+	_, err = r.CallHandler(nil, nhttp.POST, "/api/schedules/sch/ics?merge=true", nil, upload)
+	assert.NoError(t, err)
+	assert.Len(t, m.written.Schedules.Exception, 2)
+	assert.Len(t, m.written.Schedules.Weekly, 3)
+	assert.Equal(t, []dateRange{{Start: "2022-11-24", End: "2022-11-26"}},
+		m.written.Schedules.Exception["thanksgiving@example.com"].Dates)
+
+	_, err = r.CallHandler(nil, nhttp.POST, "/api/schedules/sch/ics", nil, upload)
+	assert.NoError(t, err)
+	assert.Len(t, m.written.Schedules.Exception, 1)
+	assert.JSONEq(t, `{"timezone": "America/New_York"}`, string(m.written.Config))
+	standup := m.written.Schedules.Weekly["standup@example.com"]
+	assert.Equal(t, "03:30", standup.Start, "09:30 in Berlin")
+
+	m.schedule.Schedule = []byte(`{"timezone": "Mars/Olympus_Mons"}`)
+	_, err = r.CallHandler(nil, nhttp.POST, "/api/schedules/sch/ics", nil, upload)
+	assert.Error(t, err, "an invalid schedule timezone isn't replaced by the default")
+}
@@ -0,0 +1,301 @@
+package ical
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Options struct {
+	Location *time.Location // of times without a timezone when the calendar has no X-WR-TIMEZONE, UTC when nil
+	Timezone *time.Location // of the entries and the config, the timezone of the calendar when nil
+	From     time.Time      // recurring events are expanded from From until To, today when zero
+	To       time.Time      // two years after From when zero
+}
+
+// Import reads the VEVENTs of a calendar into schedule data. Events exported by this package keep their kind, key and
+// value. Other events are taken as:
+//   - weekly entries when they repeat every week without an end or EXDATE
+//   - exceptions when they are all day, as calendar apps keep holidays
+//   - events otherwise
+//
+// Recurring events and exceptions are expanded into their dates between From and To. Weekly entries are converted to
+// Timezone as of their first occurrence.
+func Import(data []byte, options *Options) (*dto.ScheduleData, error) {
+	if options == nil {
+		options = &Options{}
+	}
+	calendar, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	zones := newTimezones(calendar)
+	location := options.Location
+	if tz := calendar.value("X-WR-TIMEZONE"); tz != "" {
+		if location, err = zones.location(tz); err != nil {
+			return nil, err
+		}
+	}
+	if location == nil {
+		location = time.UTC
+	}
+	from := options.From
+	if from.IsZero() {
+		now := time.Now().In(location)
+		from = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	}
+	to := options.To
+	if to.IsZero() {
+		to = from.AddDate(2, 0, 0)
+	}
+	timezone := options.Timezone
+	if timezone == nil {
+		timezone = location
+	}
+	config, err := json.Marshal(map[string]string{"timezone": timezone.String()})
+	if err != nil {
+		return nil, err
+	}
+	result := &dto.ScheduleData{
+		Schedules: dto.Schedules{
+			Events:    map[string]dto.Events{},
+			Weekly:    map[string]dto.Weekly{},
+			Exception: map[string]dto.Exception{},
+		},
+		Config: config,
+	}
+	for i, event := range calendar.children {
+		if event.name != "VEVENT" {
+			continue
+		}
+		if err = importEvent(result, event, i, location, timezone, zones, from, to); err != nil {
+			return nil, fmt.Errorf("VEVENT %s: %s", event.value("UID"), err)
+		}
+	}
+	return result, nil
+}
+
+func importEvent(result *dto.ScheduleData, event *component, index int, location, timezone *time.Location,
+	zones *timezones, from, to time.Time) error {
+	key := unescapeText(event.value(propKey))
+	if key == "" {
+		key = event.value("UID")
+	}
+	if key == "" {
+		key = fmt.Sprintf("event-%d", index)
+	}
+	name := unescapeText(event.value("SUMMARY"))
+	color := unescapeText(event.value(propColor))
+	var value float64
+	if v := event.value(propValue); v != "" {
+		var err error
+		if value, err = strconv.ParseFloat(v, 64); err != nil {
+			return fmt.Errorf("invalid %s %s", propValue, v)
+		}
+	}
+	var enable *bool
+	if strings.EqualFold(event.value(propEnable), "FALSE") {
+		disabled := false
+		enable = &disabled
+	}
+
+	dtStart := event.get("DTSTART")
+	if dtStart == nil {
+		return fmt.Errorf("missing DTSTART")
+	}
+	start, allDay, err := parseDateTime(dtStart.value, dtStart, location, zones)
+	if err != nil {
+		return err
+	}
+	var length func(time.Time) time.Time
+	if dtEnd := event.get("DTEND"); dtEnd != nil {
+		end, _, err := parseDateTime(dtEnd.value, dtEnd, location, zones)
+		if err != nil {
+			return err
+		}
+		if allDay {
+			days := int(end.Sub(start).Hours()/24 + 0.5)
+			length = func(t time.Time) time.Time { return t.AddDate(0, 0, days) }
+		} else {
+			duration := end.Sub(start)
+			length = func(t time.Time) time.Time { return t.Add(duration) }
+		}
+	} else if duration := event.value("DURATION"); duration != "" {
+		days, d, err := parseDuration(duration)
+		if err != nil {
+			return err
+		}
+		length = func(t time.Time) time.Time { return t.AddDate(0, 0, days).Add(d) }
+	} else if allDay {
+		length = func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }
+	} else {
+		return nil // an instant has no period a schedule can hold
+	}
+	if !length(start).After(start) {
+		return fmt.Errorf("ends before it starts")
+	}
+
+	var rule *rrule
+	if r := event.value("RRULE"); r != "" {
+		if rule, err = parseRRule(r, location); err != nil {
+			return err
+		}
+	}
+	exDates := event.all("EXDATE")
+	kind := strings.ToUpper(event.value(propKind))
+	if kind == "" {
+		switch {
+		case rule != nil && rule.simpleWeekly() && !allDay && len(exDates) == 0:
+			kind = kindWeekly
+		case allDay:
+			kind = kindException
+		default:
+			kind = kindEvent
+		}
+	}
+
+	if kind == kindWeekly {
+		// the EXDATEs of an exported weekly entry mirror the exceptions, which are imported themselves
+		if rule == nil || !rule.simpleWeekly() || allDay {
+			return fmt.Errorf("a weekly entry needs a timed event repeating every week")
+		}
+		return importWeekly(result, key, name, color, value, enable, start, length(start), rule, timezone)
+	}
+
+	starts := []time.Time{start}
+	if rule != nil {
+		starts = nil
+		for _, s := range rule.expand(start, to) {
+			if !length(s).After(from) {
+				continue
+			}
+			skip, err := excluded(s, exDates, allDay, location, zones)
+			if err != nil {
+				return err
+			}
+			if !skip {
+				starts = append(starts, s)
+			}
+		}
+	}
+	var dates []dateRange
+	for _, s := range starts {
+		if allDay {
+			dates = append(dates, dateRange{Start: s.Format("2006-01-02"), End: length(s).Format("2006-01-02")})
+		} else {
+			dates = append(dates, dateRange{
+				Start: s.In(timezone).Format(time.RFC3339),
+				End:   length(s).In(timezone).Format(time.RFC3339),
+			})
+		}
+	}
+	switch kind {
+	case kindEvent:
+		e := result.Schedules.Events[key]
+		e.Name, e.Value, e.Color, e.Enable = name, value, color, enable
+		e.Dates = append(e.Dates, dates...)
+		result.Schedules.Events[key] = e
+	case kindException:
+		e := result.Schedules.Exception[key]
+		e.Name, e.Value, e.Color, e.Enable = name, value, color, enable
+		e.Dates = append(e.Dates, dates...)
+		result.Schedules.Exception[key] = e
+	default:
+		return fmt.Errorf("unknown %s %s", propKind, kind)
+	}
+	return nil
+}
+
+// importWeekly converts each day of the rule to timezone, start and end keep the wall clock of the event's own
+// timezone from one day to the next.
+func importWeekly(result *dto.ScheduleData, key, name, color string, value float64, enable *bool, start,
+	end time.Time, rule *rrule, timezone *time.Location) error {
+	duration := end.Sub(start)
+	if duration > 24*time.Hour {
+		return fmt.Errorf("a weekly entry can't be longer than a day")
+	}
+	weekdays := []time.Weekday{start.Weekday()}
+	if len(rule.byDay) > 0 {
+		weekdays = nil
+		for _, b := range rule.byDay {
+			weekdays = append(weekdays, b.weekday)
+		}
+	}
+	var days []string
+	var startOfDay, endOfDay string
+	for i, weekday := range weekdays {
+		s := start.AddDate(0, 0, (int(weekday)-int(start.Weekday())+7)%7)
+		e := s.Add(duration).In(timezone)
+		s = s.In(timezone)
+		dayStart, dayEnd := s.Format("15:04"), e.Format("15:04")
+		if dayEnd == "00:00" && e.After(s) {
+			dayEnd = "24:00"
+		} else if e.YearDay() != s.YearDay() {
+			return fmt.Errorf("a weekly entry can't cross midnight in %s", timezone)
+		}
+		if i == 0 {
+			startOfDay, endOfDay = dayStart, dayEnd
+		} else if dayStart != startOfDay || dayEnd != endOfDay {
+			return fmt.Errorf("the days of a weekly entry have different times in %s", timezone)
+		}
+		days = append(days, strings.ToLower(s.Weekday().String()))
+	}
+	result.Schedules.Weekly[key] = dto.Weekly{
+		Name:   name,
+		Days:   days,
+		Start:  startOfDay,
+		End:    endOfDay,
+		Value:  value,
+		Color:  color,
+		Enable: enable,
+	}
+	return nil
+}
+
+func excluded(start time.Time, exDates []*property, allDay bool, location *time.Location,
+	zones *timezones) (bool, error) {
+	for _, p := range exDates {
+		for _, v := range strings.Split(p.value, ",") {
+			t, exAllDay, err := parseDateTime(v, p, location, zones)
+			if err != nil {
+				return false, err
+			}
+			if allDay || exAllDay {
+				if t.Format("20060102") == start.Format("20060102") {
+					return true, nil
+				}
+			} else if t.Equal(start) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// Merge adds the entries of src to dst, an entry of src replaces the one with the same key in dst.
+func Merge(dst, src *dto.ScheduleData) {
+	if dst.Schedules.Events == nil {
+		dst.Schedules.Events = map[string]dto.Events{}
+	}
+	if dst.Schedules.Weekly == nil {
+		dst.Schedules.Weekly = map[string]dto.Weekly{}
+	}
+	if dst.Schedules.Exception == nil {
+		dst.Schedules.Exception = map[string]dto.Exception{}
+	}
+	for k, v := range src.Schedules.Events {
+		dst.Schedules.Events[k] = v
+	}
+	for k, v := range src.Schedules.Weekly {
+		dst.Schedules.Weekly[k] = v
+	}
+	for k, v := range src.Schedules.Exception {
+		dst.Schedules.Exception[k] = v
+	}
+	if len(dst.Config) == 0 {
+		dst.Config = src.Config
+	}
+}
@@ -0,0 +1,321 @@
+package ical
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var icalDays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday,
+	"FR": time.Friday, "SA": time.Saturday,
+}
+
+var dayNames = map[time.Weekday]string{
+	time.Sunday: "SU", time.Monday: "MO", time.Tuesday: "TU", time.Wednesday: "WE", time.Thursday: "TH",
+	time.Friday: "FR", time.Saturday: "SA",
+}
+
+// maxPeriods bounds the expansion of a recurrence.
+const maxPeriods = 10000
+
+type byDay struct {
+	n       int // the nth weekday of the month, negative counts from the end, 0 is every one
+	weekday time.Weekday
+}
+
+// rrule is the subset of RFC 5545 recurrence rules which holiday and opening hour calendars use.
+type rrule struct {
+	freq       string
+	interval   int
+	count      int
+	until      time.Time
+	byDay      []byDay
+	byMonthDay []int
+	byMonth    []time.Month
+}
+
+func parseRRule(value string, location *time.Location) (*rrule, error) {
+	r := &rrule{interval: 1}
+	for _, part := range strings.Split(value, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid RRULE part %q", part)
+		}
+		key, val := strings.ToUpper(kv[0]), kv[1]
+		var err error
+		switch key {
+		case "FREQ":
+			r.freq = strings.ToUpper(val)
+		case "INTERVAL":
+			r.interval, err = strconv.Atoi(val)
+			if err == nil && r.interval < 1 {
+				err = fmt.Errorf("invalid INTERVAL %s", val)
+			}
+		case "COUNT":
+			r.count, err = strconv.Atoi(val)
+		case "UNTIL":
+			r.until, _, err = parseDateTime(val, nil, location, nil)
+		case "BYDAY":
+			for _, d := range strings.Split(val, ",") {
+				b, e := parseByDay(d)
+				if e != nil {
+					return nil, e
+				}
+				r.byDay = append(r.byDay, b)
+			}
+		case "BYMONTHDAY":
+			for _, d := range strings.Split(val, ",") {
+				n, e := strconv.Atoi(d)
+				if e != nil || n == 0 || n < -31 || n > 31 {
+					return nil, fmt.Errorf("invalid BYMONTHDAY %s", d)
+				}
+				r.byMonthDay = append(r.byMonthDay, n)
+			}
+		case "BYMONTH":
+			for _, m := range strings.Split(val, ",") {
+				n, e := strconv.Atoi(m)
+				if e != nil || n < 1 || n > 12 {
+					return nil, fmt.Errorf("invalid BYMONTH %s", m)
+				}
+				r.byMonth = append(r.byMonth, time.Month(n))
+			}
+		case "WKST":
+		default:
+			return nil, fmt.Errorf("unsupported RRULE part %s", key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch r.freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+	default:
+		return nil, fmt.Errorf("unsupported FREQ %q", r.freq)
+	}
+	for _, b := range r.byDay {
+		if b.n != 0 && r.freq != "MONTHLY" && !(r.freq == "YEARLY" && len(r.byMonth) > 0) {
+			return nil, fmt.Errorf("BYDAY with an ordinal needs FREQ=MONTHLY or BYMONTH")
+		}
+	}
+	return r, nil
+}
+
+func parseByDay(s string) (byDay, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if len(s) < 2 {
+		return byDay{}, fmt.Errorf("invalid BYDAY %s", s)
+	}
+	weekday, ok := icalDays[s[len(s)-2:]]
+	if !ok {
+		return byDay{}, fmt.Errorf("invalid BYDAY %s", s)
+	}
+	b := byDay{weekday: weekday}
+	if prefix := s[:len(s)-2]; prefix != "" {
+		n, err := strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -5 || n > 5 {
+			return byDay{}, fmt.Errorf("invalid BYDAY %s", s)
+		}
+		b.n = n
+	}
+	return b, nil
+}
+
+// simpleWeekly reports whether the rule repeats every week forever, which a weekly schedule entry can hold.
+func (r *rrule) simpleWeekly() bool {
+	return r.freq == "WEEKLY" && r.interval == 1 && r.count == 0 && r.until.IsZero() && len(r.byMonthDay) == 0 &&
+		len(r.byMonth) == 0
+}
+
+// expand returns the starts of the occurrences from start, which are before to. The wall clock time of start is
+// kept across DST changes.
+func (r *rrule) expand(start, to time.Time) []time.Time {
+	var starts []time.Time
+	n := 0
+	for period := 0; period < maxPeriods; period++ {
+		var dates []time.Time
+		switch r.freq {
+		case "DAILY":
+			day := start.AddDate(0, 0, period*r.interval)
+			if r.matchesDay(day) {
+				dates = []time.Time{day}
+			}
+		case "WEEKLY":
+			// weeks start on monday
+			monday := start.AddDate(0, 0, -((int(start.Weekday())+6)%7)+period*7*r.interval)
+			for i := 0; i < 7; i++ {
+				day := monday.AddDate(0, 0, i)
+				if (len(r.byDay) == 0 && day.Weekday() == start.Weekday()) || r.hasWeekday(day.Weekday()) {
+					if len(r.byMonth) == 0 || r.hasMonth(day.Month()) {
+						dates = append(dates, day)
+					}
+				}
+			}
+		case "MONTHLY":
+			first := time.Date(start.Year(), start.Month()+time.Month(period*r.interval), 1, start.Hour(),
+				start.Minute(), start.Second(), 0, start.Location())
+			if len(r.byMonth) == 0 || r.hasMonth(first.Month()) {
+				dates = r.monthDates(first, start.Day())
+			}
+		case "YEARLY":
+			year := start.Year() + period*r.interval
+			months := r.byMonth
+			if len(months) == 0 {
+				months = []time.Month{start.Month()}
+			}
+			for _, m := range months {
+				first := time.Date(year, m, 1, start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+				dates = append(dates, r.monthDates(first, start.Day())...)
+			}
+		}
+		sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+		for _, d := range dates {
+			if d.Before(start) {
+				continue
+			}
+			if (!r.until.IsZero() && d.After(r.until)) || !d.Before(to) || (r.count > 0 && n >= r.count) {
+				return starts
+			}
+			n++
+			starts = append(starts, d)
+		}
+	}
+	return starts
+}
+
+func (r *rrule) matchesDay(day time.Time) bool {
+	return (len(r.byDay) == 0 || r.hasWeekday(day.Weekday())) && (len(r.byMonth) == 0 || r.hasMonth(day.Month())) &&
+		(len(r.byMonthDay) == 0 || containsMonthDay(r.byMonthDay, day))
+}
+
+func (r *rrule) hasWeekday(weekday time.Weekday) bool {
+	for _, b := range r.byDay {
+		if b.weekday == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *rrule) hasMonth(month time.Month) bool {
+	for _, m := range r.byMonth {
+		if m == month {
+			return true
+		}
+	}
+	return false
+}
+
+func containsMonthDay(monthDays []int, day time.Time) bool {
+	last := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	for _, d := range monthDays {
+		if d == day.Day() || last+d+1 == day.Day() {
+			return true
+		}
+	}
+	return false
+}
+
+// monthDates returns the days of the month from first which match the rule, day of month when the rule has no days.
+func (r *rrule) monthDates(first time.Time, dayOfMonth int) []time.Time {
+	last := first.AddDate(0, 1, -1).Day()
+	var dates []time.Time
+	if len(r.byDay) == 0 && len(r.byMonthDay) == 0 {
+		if dayOfMonth <= last {
+			dates = append(dates, first.AddDate(0, 0, dayOfMonth-1))
+		}
+		return dates
+	}
+	for d := 1; d <= last; d++ {
+		day := first.AddDate(0, 0, d-1)
+		if len(r.byMonthDay) > 0 && !containsMonthDay(r.byMonthDay, day) {
+			continue
+		}
+		if len(r.byDay) > 0 && !r.matchesByDay(day, last) {
+			continue
+		}
+		dates = append(dates, day)
+	}
+	return dates
+}
+
+func (r *rrule) matchesByDay(day time.Time, last int) bool {
+	for _, b := range r.byDay {
+		if b.weekday != day.Weekday() {
+			continue
+		}
+		nth := (day.Day()-1)/7 + 1
+		nthFromEnd := -((last-day.Day())/7 + 1)
+		if b.n == 0 || b.n == nth || b.n == nthFromEnd {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDateTime reads a DATE or DATE-TIME value. A trailing Z is UTC, a TZID is resolved by zones and anything else
+// is in location. Recurrences repeat the wall clock of the timezone the value is in. allDay is set for DATE values.
+func parseDateTime(value string, p *property, location *time.Location, zones *timezones) (t time.Time, allDay bool,
+	err error) {
+	if p != nil && p.param("TZID") != "" {
+		if location, err = zones.location(p.param("TZID")); err != nil {
+			return t, false, err
+		}
+	}
+	switch {
+	case len(value) == 8:
+		t, err = time.ParseInLocation("20060102", value, location)
+		return t, true, err
+	case strings.HasSuffix(value, "Z"):
+		t, err = time.Parse("20060102T150405Z", value)
+		return t, false, err
+	default:
+		t, err = time.ParseInLocation("20060102T150405", value, location)
+		return t, false, err
+	}
+}
+
+// parseDuration reads the week, day and time parts of a DURATION, e.g. P1D or PT1H30M.
+func parseDuration(value string) (days int, d time.Duration, err error) {
+	s := strings.TrimPrefix(strings.ToUpper(value), "+")
+	if !strings.HasPrefix(s, "P") {
+		return 0, 0, fmt.Errorf("invalid DURATION %s", value)
+	}
+	s = s[1:]
+	inTime := false
+	num := ""
+	for _, c := range s {
+		switch {
+		case c == 'T':
+			inTime = true
+		case c >= '0' && c <= '9':
+			num += string(c)
+		default:
+			n, e := strconv.Atoi(num)
+			if e != nil {
+				return 0, 0, fmt.Errorf("invalid DURATION %s", value)
+			}
+			num = ""
+			switch {
+			case c == 'W' && !inTime:
+				days += 7 * n
+			case c == 'D' && !inTime:
+				days += n
+			case c == 'H' && inTime:
+				d += time.Duration(n) * time.Hour
+			case c == 'M' && inTime:
+				d += time.Duration(n) * time.Minute
+			case c == 'S' && inTime:
+				d += time.Duration(n) * time.Second
+			default:
+				return 0, 0, fmt.Errorf("invalid DURATION %s", value)
+			}
+		}
+	}
+	if num != "" {
+		return 0, 0, fmt.Errorf("invalid DURATION %s", value)
+	}
+	return days, d, nil
+}
@@ -0,0 +1,360 @@
+package ical
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// windowsZones maps the Windows timezone names Outlook and Exchange send as TZID to IANA names, as the CLDR
+// windowsZones table does for the 001 territory.
+var windowsZones = map[string]string{
+	"Dateline Standard Time":          "Etc/GMT+12",
+	"UTC-11":                          "Etc/GMT+11",
+	"Aleutian Standard Time":          "America/Adak",
+	"Hawaiian Standard Time":          "Pacific/Honolulu",
+	"Marquesas Standard Time":         "Pacific/Marquesas",
+	"Alaskan Standard Time":           "America/Anchorage",
+	"UTC-09":                          "Etc/GMT+9",
+	"Pacific Standard Time (Mexico)":  "America/Tijuana",
+	"UTC-08":                          "Etc/GMT+8",
+	"Pacific Standard Time":           "America/Los_Angeles",
+	"US Mountain Standard Time":       "America/Phoenix",
+	"Mountain Standard Time (Mexico)": "America/Mazatlan",
+	"Mountain Standard Time":          "America/Denver",
+	"Yukon Standard Time":             "America/Whitehorse",
+	"Central America Standard Time":   "America/Guatemala",
+	"Central Standard Time":           "America/Chicago",
+	"Easter Island Standard Time":     "Pacific/Easter",
+	"Central Standard Time (Mexico)":  "America/Mexico_City",
+	"Canada Central Standard Time":    "America/Regina",
+	"SA Pacific Standard Time":        "America/Bogota",
+	"Eastern Standard Time (Mexico)":  "America/Cancun",
+	"Eastern Standard Time":           "America/New_York",
+	"Haiti Standard Time":             "America/Port-au-Prince",
+	"Cuba Standard Time":              "America/Havana",
+	"US Eastern Standard Time":        "America/Indiana/Indianapolis",
+	"Turks And Caicos Standard Time":  "America/Grand_Turk",
+	"Paraguay Standard Time":          "America/Asuncion",
+	"Atlantic Standard Time":          "America/Halifax",
+	"Venezuela Standard Time":         "America/Caracas",
+	"Central Brazilian Standard Time": "America/Cuiaba",
+	"SA Western Standard Time":        "America/La_Paz",
+	"Pacific SA Standard Time":        "America/Santiago",
+	"Newfoundland Standard Time":      "America/St_Johns",
+	"Tocantins Standard Time":         "America/Araguaina",
+	"E. South America Standard Time":  "America/Sao_Paulo",
+	"SA Eastern Standard Time":        "America/Cayenne",
+	"Argentina Standard Time":         "America/Argentina/Buenos_Aires",
+	"Greenland Standard Time":         "America/Godthab",
+	"Montevideo Standard Time":        "America/Montevideo",
+	"Magallanes Standard Time":        "America/Punta_Arenas",
+	"Saint Pierre Standard Time":      "America/Miquelon",
+	"Bahia Standard Time":             "America/Bahia",
+	"UTC-02":                          "Etc/GMT+2",
+	"Azores Standard Time":            "Atlantic/Azores",
+	"Cape Verde Standard Time":        "Atlantic/Cape_Verde",
+	"UTC":                             "Etc/UTC",
+	"GMT Standard Time":               "Europe/London",
+	"Greenwich Standard Time":         "Atlantic/Reykjavik",
+	"Sao Tome Standard Time":          "Africa/Sao_Tome",
+	"Morocco Standard Time":           "Africa/Casablanca",
+	"W. Europe Standard Time":         "Europe/Berlin",
+	"Central Europe Standard Time":    "Europe/Budapest",
+	"Romance Standard Time":           "Europe/Paris",
+	"Central European Standard Time":  "Europe/Warsaw",
+	"W. Central Africa Standard Time": "Africa/Lagos",
+	"Jordan Standard Time":            "Asia/Amman",
+	"GTB Standard Time":               "Europe/Bucharest",
+	"Middle East Standard Time":       "Asia/Beirut",
+	"Egypt Standard Time":             "Africa/Cairo",
+	"E. Europe Standard Time":         "Europe/Chisinau",
+	"Syria Standard Time":             "Asia/Damascus",
+	"West Bank Standard Time":         "Asia/Hebron",
+	"South Africa Standard Time":      "Africa/Johannesburg",
+	"FLE Standard Time":               "Europe/Kiev",
+	"Israel Standard Time":            "Asia/Jerusalem",
+	"South Sudan Standard Time":       "Africa/Juba",
+	"Kaliningrad Standard Time":       "Europe/Kaliningrad",
+	"Sudan Standard Time":             "Africa/Khartoum",
+	"Libya Standard Time":             "Africa/Tripoli",
+	"Namibia Standard Time":           "Africa/Windhoek",
+	"Arabic Standard Time":            "Asia/Baghdad",
+	"Turkey Standard Time":            "Europe/Istanbul",
+	"Arab Standard Time":              "Asia/Riyadh",
+	"Belarus Standard Time":           "Europe/Minsk",
+	"Russian Standard Time":           "Europe/Moscow",
+	"E. Africa Standard Time":         "Africa/Nairobi",
+	"Volgograd Standard Time":         "Europe/Volgograd",
+	"Iran Standard Time":              "Asia/Tehran",
+	"Arabian Standard Time":           "Asia/Dubai",
+	"Astrakhan Standard Time":         "Europe/Astrakhan",
+	"Azerbaijan Standard Time":        "Asia/Baku",
+	"Russia Time Zone 3":              "Europe/Samara",
+	"Mauritius Standard Time":         "Indian/Mauritius",
+	"Saratov Standard Time":           "Europe/Saratov",
+	"Georgian Standard Time":          "Asia/Tbilisi",
+	"Caucasus Standard Time":          "Asia/Yerevan",
+	"Afghanistan Standard Time":       "Asia/Kabul",
+	"West Asia Standard Time":         "Asia/Tashkent",
+	"Ekaterinburg Standard Time":      "Asia/Yekaterinburg",
+	"Pakistan Standard Time":          "Asia/Karachi",
+	"Qyzylorda Standard Time":         "Asia/Qyzylorda",
+	"India Standard Time":             "Asia/Calcutta",
+	"Sri Lanka Standard Time":         "Asia/Colombo",
+	"Nepal Standard Time":             "Asia/Katmandu",
+	"Central Asia Standard Time":      "Asia/Almaty",
+	"Bangladesh Standard Time":        "Asia/Dhaka",
+	"Omsk Standard Time":              "Asia/Omsk",
+	"Myanmar Standard Time":           "Asia/Rangoon",
+	"SE Asia Standard Time":           "Asia/Bangkok",
+	"Altai Standard Time":             "Asia/Barnaul",
+	"W. Mongolia Standard Time":       "Asia/Hovd",
+	"North Asia Standard Time":        "Asia/Krasnoyarsk",
+	"N. Central Asia Standard Time":   "Asia/Novosibirsk",
+	"Tomsk Standard Time":             "Asia/Tomsk",
+	"China Standard Time":             "Asia/Shanghai",
+	"North Asia East Standard Time":   "Asia/Irkutsk",
+	"Singapore Standard Time":         "Asia/Singapore",
+	"W. Australia Standard Time":      "Australia/Perth",
+	"Taipei Standard Time":            "Asia/Taipei",
+	"Ulaanbaatar Standard Time":       "Asia/Ulaanbaatar",
+	"Aus Central W. Standard Time":    "Australia/Eucla",
+	"Transbaikal Standard Time":       "Asia/Chita",
+	"Tokyo Standard Time":             "Asia/Tokyo",
+	"North Korea Standard Time":       "Asia/Pyongyang",
+	"Korea Standard Time":             "Asia/Seoul",
+	"Yakutsk Standard Time":           "Asia/Yakutsk",
+	"Cen. Australia Standard Time":    "Australia/Adelaide",
+	"AUS Central Standard Time":       "Australia/Darwin",
+	"E. Australia Standard Time":      "Australia/Brisbane",
+	"AUS Eastern Standard Time":       "Australia/Sydney",
+	"West Pacific Standard Time":      "Pacific/Port_Moresby",
+	"Tasmania Standard Time":          "Australia/Hobart",
+	"Vladivostok Standard Time":       "Asia/Vladivostok",
+	"Lord Howe Standard Time":         "Australia/Lord_Howe",
+	"Bougainville Standard Time":      "Pacific/Bougainville",
+	"Russia Time Zone 10":             "Asia/Srednekolymsk",
+	"Magadan Standard Time":           "Asia/Magadan",
+	"Norfolk Standard Time":           "Pacific/Norfolk",
+	"Sakhalin Standard Time":          "Asia/Sakhalin",
+	"Central Pacific Standard Time":   "Pacific/Guadalcanal",
+	"Russia Time Zone 11":             "Asia/Kamchatka",
+	"New Zealand Standard Time":       "Pacific/Auckland",
+	"UTC+12":                          "Etc/GMT-12",
+	"Fiji Standard Time":              "Pacific/Fiji",
+	"Chatham Islands Standard Time":   "Pacific/Chatham",
+	"UTC+13":                          "Etc/GMT-13",
+	"Tonga Standard Time":             "Pacific/Tongatapu",
+	"Samoa Standard Time":             "Pacific/Apia",
+	"Line Islands Standard Time":      "Pacific/Kiritimati",
+}
+
+// timezones resolves the TZIDs of a calendar.
+type timezones struct {
+	definitions map[string]*component // VTIMEZONEs by TZID
+	locations   map[string]*time.Location
+}
+
+func newTimezones(calendar *component) *timezones {
+	z := &timezones{definitions: map[string]*component{}, locations: map[string]*time.Location{}}
+	for _, c := range calendar.children {
+		if c.name == "VTIMEZONE" {
+			z.definitions[c.value("TZID")] = c
+		}
+	}
+	return z
+}
+
+// location returns the timezone of a TZID: an IANA name, a Windows name or the X-LIC-LOCATION of its VTIMEZONE. A
+// VTIMEZONE without daylight saving time is read as a fixed offset, others have to name a known timezone.
+func (z *timezones) location(tzid string) (*time.Location, error) {
+	if location, ok := z.locations[tzid]; ok {
+		return location, nil
+	}
+	location, err := z.resolve(tzid)
+	if err != nil {
+		return nil, err
+	}
+	z.locations[tzid] = location
+	return location, nil
+}
+
+func (z *timezones) resolve(tzid string) (*time.Location, error) {
+	names := []string{tzid, windowsZones[tzid]}
+	definition := z.definitions[tzid]
+	if definition != nil {
+		names = append(names, definition.value("X-LIC-LOCATION"))
+	}
+	for _, name := range names {
+		// LoadLocation reads "" as UTC and "Local" as the timezone of the host, neither is meant here
+		if name == "" || name == "Local" {
+			continue
+		}
+		if location, err := time.LoadLocation(name); err == nil {
+			return location, nil
+		}
+	}
+	if definition != nil {
+		offsets := map[int]bool{}
+		for _, observance := range definition.children {
+			if observance.name != "STANDARD" && observance.name != "DAYLIGHT" {
+				continue
+			}
+			offset, err := parseOffset(observance.value("TZOFFSETTO"))
+			if err != nil {
+				return nil, fmt.Errorf("VTIMEZONE %s: %s", tzid, err)
+			}
+			offsets[offset] = true
+		}
+		if len(offsets) == 1 {
+			for offset := range offsets {
+				return time.FixedZone(tzid, offset), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unknown TZID %s", tzid)
+}
+
+// parseOffset reads a UTC-OFFSET value, e.g. +0100 or -053000, as seconds east of UTC.
+func parseOffset(value string) (int, error) {
+	if (len(value) != 5 && len(value) != 7) || (value[0] != '+' && value[0] != '-') {
+		return 0, fmt.Errorf("invalid UTC offset %s", value)
+	}
+	offset := 0
+	for i, unit := range []int{3600, 60, 1} {
+		if 1+2*i >= len(value) {
+			break
+		}
+		n, err := strconv.Atoi(value[1+2*i : 3+2*i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid UTC offset %s", value)
+		}
+		offset += n * unit
+	}
+	if value[0] == '-' {
+		offset = -offset
+	}
+	return offset, nil
+}
+
+func formatOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign, offset = "-", -offset
+	}
+	s := fmt.Sprintf("%s%02d%02d", sign, offset/3600, offset/60%60)
+	if offset%60 != 0 {
+		s += fmt.Sprintf("%02d", offset%60)
+	}
+	return s
+}
+
+// transition is a change of the UTC offset of a timezone.
+type transition struct {
+	at       time.Time
+	from, to int // offsets in seconds
+	name     string
+	dst      bool
+}
+
+// start is the local time the transition happens at, in the offset before it.
+func (t *transition) start() time.Time {
+	return t.at.In(time.FixedZone("", t.from))
+}
+
+// rule is the yearly recurrence of the transition, by the weekday of its month, e.g. BYMONTH=3;BYDAY=-1SU.
+func (t *transition) rule() string {
+	start := t.start()
+	n := (start.Day()-1)/7 + 1
+	if start.AddDate(0, 0, 7).Month() != start.Month() {
+		n = -1
+	}
+	return fmt.Sprintf("FREQ=YEARLY;BYMONTH=%d;BYDAY=%d%s", start.Month(), n, dayNames[start.Weekday()])
+}
+
+// repeats reports whether next is the same transition a year later.
+func (t *transition) repeats(next *transition) bool {
+	return next.start().Year() == t.start().Year()+1 && next.rule() == t.rule() &&
+		next.start().Format("150405") == t.start().Format("150405") && next.from == t.from && next.to == t.to &&
+		next.name == t.name && next.dst == t.dst
+}
+
+// transitions returns the offset changes of location after from and up to to.
+func transitions(location *time.Location, from, to time.Time) []*transition {
+	var result []*transition
+	_, offset := from.In(location).Zone()
+	for day := from; day.Before(to); day = day.Add(24 * time.Hour) {
+		next := day.Add(24 * time.Hour)
+		if _, o := next.In(location).Zone(); o == offset {
+			continue
+		}
+		// the offset changes within the day, find the second it does
+		low, high := day.Unix(), next.Unix()
+		for high-low > 1 {
+			middle := (low + high) / 2
+			if _, o := time.Unix(middle, 0).In(location).Zone(); o == offset {
+				low = middle
+			} else {
+				high = middle
+			}
+		}
+		t := time.Unix(high, 0).In(location)
+		name, changed := t.Zone()
+		result = append(result, &transition{at: t.UTC(), from: offset, to: changed, name: name, dst: t.IsDST()})
+		offset = changed
+	}
+	return result
+}
+
+// timezoneLookahead is how many years after the last time of a calendar the transitions of its timezone have to
+// follow the same yearly rule for the VTIMEZONE to repeat the rule without an end.
+const timezoneLookahead = 10
+
+// writeTimezone writes the VTIMEZONE of location for times from first on. Transitions are written one by one until
+// the timezone follows a yearly rule through the lookahead, from there on the rule repeats. Without such a rule the
+// offset after the last transition of the lookahead is kept.
+func writeTimezone(w *writer, location *time.Location, first, last time.Time) {
+	from := time.Date(first.In(location).Year(), 1, 1, 0, 0, 0, 0, location)
+	to := time.Date(last.In(location).Year()+1+timezoneLookahead, 1, 1, 0, 0, 0, 0, location)
+	changes := transitions(location, from, to)
+	repeatFrom := len(changes)
+	if n := len(changes); n >= 4 && changes[n-1].at.Year() == to.Year()-1 {
+		repeatFrom = n - 2
+		for repeatFrom > 0 && changes[repeatFrom-1].repeats(changes[repeatFrom+1]) {
+			repeatFrom--
+		}
+		if repeatFrom > n-4 {
+			repeatFrom = n
+		}
+	}
+	w.line("BEGIN", "VTIMEZONE")
+	w.line("TZID", location.String())
+	name, offset := from.Zone()
+	observance(w, &transition{at: from, from: offset, to: offset, name: name, dst: from.IsDST()}, "")
+	for i, t := range changes {
+		if i < repeatFrom {
+			observance(w, t, "")
+		} else if i < repeatFrom+2 {
+			observance(w, t, t.rule())
+		}
+	}
+	w.line("END", "VTIMEZONE")
+}
+func observance(w *writer, t *transition, rule string) {
+	kind := "STANDARD"
+	if t.dst {
+		kind = "DAYLIGHT"
+	}
+	w.line("BEGIN", kind)
+	w.line("DTSTART", t.start().Format("20060102T150405"))
+	w.line("TZOFFSETFROM", formatOffset(t.from))
+	w.line("TZOFFSETTO", formatOffset(t.to))
+	if rule != "" {
+		w.line("RRULE", rule)
+	}
+	if t.name != "" {
+		w.line("TZNAME", escapeText(t.name))
+	}
+	w.line("END", kind)
+}
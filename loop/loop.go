@@ -0,0 +1,62 @@
+package loop
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrRunning = errors.New("already running")
+
+// Loop calls a function every interval in its own goroutine between Start and Stop, the zero value is ready to use.
+type Loop struct {
+	mutex sync.Mutex
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// Start calls fn every interval, first right away when immediate is set. Calls never overlap.
+func (l *Loop) Start(interval time.Duration, immediate bool, fn func()) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.stop != nil {
+		return ErrRunning
+	}
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		if immediate {
+			fn()
+		}
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	}(l.stop, l.done)
+	return nil
+}
+
+// Stop waits for a running call of fn to return, it does nothing when the loop isn't running.
+func (l *Loop) Stop() {
+	l.mutex.Lock()
+	stop, done := l.stop, l.done
+	l.stop, l.done = nil, nil
+	l.mutex.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+func (l *Loop) Running() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.stop != nil
+}
@@ -0,0 +1,24 @@
+package loop
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoop(t *testing.T) {
+	var l Loop
+	var calls int32
+	assert.NoError(t, l.Start(time.Hour, true, func() { atomic.AddInt32(&calls, 1) }))
+	assert.Equal(t, ErrRunning, l.Start(time.Hour, true, func() {}))
+	assert.True(t, l.Running())
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&calls) == 1 }, time.Second, time.Millisecond)
+	l.Stop()
+	l.Stop()
+	assert.False(t, l.Running())
+
+	assert.NoError(t, l.Start(time.Millisecond, false, func() { atomic.AddInt32(&calls, 1) }))
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&calls) >= 3 }, time.Second, time.Millisecond)
+	l.Stop()
+}
@@ -0,0 +1,79 @@
+package mail
+
+import (
+	"encoding/csv"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	htmltemplate "html/template"
+	"io"
+	"strconv"
+	"time"
+)
+
+type Attachment interface {
+	Name() string
+	Write(w io.Writer) error
+}
+
+type csvReport struct {
+	name      string
+	histories []*model.History
+	names     map[string]string
+}
+
+// CSVReport attaches histories as csv, points are used to add the point names.
+func CSVReport(name string, histories []*model.History, points []*model.Point) Attachment {
+	names := map[string]string{}
+	for _, p := range points {
+		names[p.UUID] = p.Name
+	}
+	return &csvReport{name: name, histories: histories, names: names}
+}
+
+func (r *csvReport) Name() string {
+	return r.name
+}
+
+func (r *csvReport) Write(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"timestamp", "point_uuid", "point_name", "value"}); err != nil {
+		return err
+	}
+	for _, h := range r.histories {
+		value := ""
+		if h.Value != nil {
+			value = strconv.FormatFloat(*h.Value, 'f', -1, 64)
+		}
+		record := []string{h.Timestamp.UTC().Format(time.RFC3339), h.PointUUID, r.names[h.PointUUID], value}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+type htmlSnapshot struct {
+	name     string
+	template *htmltemplate.Template
+	data     *Data
+}
+
+// HTMLSnapshot attaches a rendered html page, e.g. a table of point values, for clients which can't open a pdf.
+func HTMLSnapshot(name, html string, data *Data) (Attachment, error) {
+	t, err := htmltemplate.New("snapshot").Funcs(funcs).Parse(html)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		data = &Data{}
+	}
+	return &htmlSnapshot{name: name, template: t, data: data}, nil
+}
+
+func (s *htmlSnapshot) Name() string {
+	return s.name
+}
+
+func (s *htmlSnapshot) Write(w io.Writer) error {
+	return s.template.Execute(w, s.data)
+}
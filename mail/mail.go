@@ -0,0 +1,226 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrRateLimited = errors.New("all recipients are rate limited")
+
+type Config struct {
+	RateLimit     int           // emails per recipient within RatePer, 0 disables
+	RatePer       time.Duration // window of the rate limit, an hour when zero
+	AttachmentTTL time.Duration // attachments of emails the host is still sending are removed after it, a day when zero
+}
+
+type Message struct {
+	To          []string
+	Cc          []string
+	Bcc         []string
+	Template    *Template
+	Data        *Data
+	Attachments []Attachment
+}
+
+// Mailer renders templated emails, writes their attachments to the attachment dir of the host and sends them.
+type Mailer struct {
+	marshaller nmodule.Marshaller
+	config     *Config
+
+	mutex       sync.Mutex
+	sent        map[string][]time.Time // by lower cased recipient
+	attachments map[string]time.Time   // files of emails still sending, by path
+	now         func() time.Time
+}
+
+func New(marshaller nmodule.Marshaller, config *Config) *Mailer {
+	return &Mailer{
+		marshaller:  marshaller,
+		config:      config,
+		sent:        map[string][]time.Time{},
+		attachments: map[string]time.Time{},
+		now:         time.Now,
+	}
+}
+
+// Send sends the message to the recipients which are within the rate limit, the others are left out. It returns
+// ErrRateLimited when nobody is left. The attachment files are removed once the host is done with them, or after
+// AttachmentTTL when the host still reports the email as sending.
+func (m *Mailer) Send(message *Message) (*model.Email, error) {
+	subject, body, err := message.Template.Render(message.Data)
+	if err != nil {
+		return nil, err
+	}
+	now := m.now()
+	m.expireAttachments(now)
+	to, cc, bcc := m.allow(now, message.To, message.Cc, message.Bcc)
+	if len(to)+len(cc)+len(bcc) == 0 {
+		return nil, ErrRateLimited
+	}
+	recipients := append(append(append([]string{}, to...), cc...), bcc...)
+	if len(to) == 0 {
+		to, cc = cc, nil
+		if len(to) == 0 {
+			to, bcc = bcc, nil
+		}
+	}
+	email := &model.Email{To: strings.Join(to, ";"), Subject: subject, Body: body}
+	if len(cc) > 0 {
+		s := strings.Join(cc, ";")
+		email.Cc = &s
+	}
+	if len(bcc) > 0 {
+		s := strings.Join(bcc, ";")
+		email.Bcc = &s
+	}
+	var paths []string
+	if len(message.Attachments) > 0 {
+		if paths, err = m.writeAttachments(message.Attachments); err != nil {
+			m.release(now, recipients)
+			return nil, err
+		}
+		for _, path := range paths {
+			email.Attachments = append(email.Attachments, &model.EmailAttachment{Path: path})
+		}
+	}
+	sent, err := m.marshaller.SendEmail(email)
+	if err != nil {
+		removeFiles(paths)
+		m.release(now, recipients)
+		return nil, err
+	}
+	if sent != nil && sent.Status == datatype.EmailStatusSending {
+		m.keepAttachments(now, paths)
+	} else {
+		removeFiles(paths)
+	}
+	return sent, nil
+}
+
+func (m *Mailer) keepAttachments(now time.Time, paths []string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, path := range paths {
+		m.attachments[path] = now
+	}
+}
+
+// expireAttachments removes the attachments kept for longer than AttachmentTTL.
+func (m *Mailer) expireAttachments(now time.Time) {
+	ttl := m.config.AttachmentTTL
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	m.mutex.Lock()
+	var expired []string
+	for path, written := range m.attachments {
+		if now.Sub(written) >= ttl {
+			expired = append(expired, path)
+			delete(m.attachments, path)
+		}
+	}
+	m.mutex.Unlock()
+	removeFiles(expired)
+}
+
+func removeFiles(paths []string) {
+	for _, path := range paths {
+		_ = os.Remove(path)
+	}
+}
+
+// allow filters the recipients by the rate limit and records a send for the ones which are kept.
+func (m *Mailer) allow(now time.Time, lists ...[]string) (to, cc, bcc []string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	per := m.config.RatePer
+	if per <= 0 {
+		per = time.Hour
+	}
+	seen := map[string]bool{}
+	allowed := make([][]string, len(lists))
+	for i, list := range lists {
+		for _, recipient := range list {
+			key := strings.ToLower(strings.TrimSpace(recipient))
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			if m.config.RateLimit > 0 {
+				var recent []time.Time
+				for _, t := range m.sent[key] {
+					if now.Sub(t) < per {
+						recent = append(recent, t)
+					}
+				}
+				if len(recent) >= m.config.RateLimit {
+					m.sent[key] = recent
+					continue
+				}
+				m.sent[key] = append(recent, now)
+			}
+			allowed[i] = append(allowed[i], strings.TrimSpace(recipient))
+		}
+	}
+	return allowed[0], allowed[1], allowed[2]
+}
+
+// release forgets the send recorded at now, the email didn't go out.
+func (m *Mailer) release(now time.Time, recipients []string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, recipient := range recipients {
+		key := strings.ToLower(recipient)
+		sent := m.sent[key]
+		for i := len(sent) - 1; i >= 0; i-- {
+			if sent[i].Equal(now) {
+				m.sent[key] = append(sent[:i], sent[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+func (m *Mailer) writeAttachments(attachments []Attachment) ([]string, error) {
+	dir, err := m.marshaller.GetAttachmentDir()
+	if err != nil {
+		return nil, err
+	}
+	if dir == nil || *dir == "" {
+		return nil, errors.New("host has no attachment dir")
+	}
+	prefix := m.now().UTC().Format("20060102T150405.000000000")
+	var paths []string
+	for i, attachment := range attachments {
+		path := filepath.Join(*dir, fmt.Sprintf("%s-%d-%s", prefix, i, filepath.Base(attachment.Name())))
+		if err = writeAttachment(path, attachment); err != nil {
+			removeFiles(paths)
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+func writeAttachment(path string, attachment Attachment) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	err = attachment.Write(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+	}
+	return err
+}
@@ -0,0 +1,109 @@
+package mail
+
+import (
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeMarshaller struct {
+	nmodule.Marshaller
+	dir     string
+	sent    []*model.Email
+	status  datatype.EmailStatus
+	reports []string // attachment contents at the time of sending
+}
+
+func (f *fakeMarshaller) SendEmail(body *model.Email, opts ...*nmodule.Opts) (*model.Email, error) {
+	f.sent = append(f.sent, body)
+	for _, a := range body.Attachments {
+		data, _ := os.ReadFile(a.Path)
+		f.reports = append(f.reports, string(data))
+	}
+	sent := *body
+	sent.Status = f.status
+	return &sent, nil
+}
+
+func (f *fakeMarshaller) GetAttachmentDir(opts ...*nmodule.Opts) (*string, error) {
+	return &f.dir, nil
+}
+
+func TestSendWithAttachments(t *testing.T) {
+	m := &fakeMarshaller{dir: t.TempDir(), status: datatype.EmailStatusSent}
+	mailer := New(m, &Config{})
+	template, err := ParseTemplate("{{len .Points}} points", `{{range .Points}}{{.Name}}: {{value .PresentValue}}{{end}}`)
+	assert.NoError(t, err)
+	value := 21.5
+	points := []*model.Point{{CommonUUID: model.CommonUUID{UUID: "pnt_1"}, Name: "<temp>", PresentValue: &value}}
+	snapshot, err := HTMLSnapshot("snapshot.html", `<p>{{range .Points}}{{.Name}}{{end}}</p>`, &Data{Points: points})
+	assert.NoError(t, err)
+
+	_, err = mailer.Send(&Message{
+		To:       []string{"a@nube.io"},
+		Template: template,
+		Data:     &Data{Points: points},
+		Attachments: []Attachment{
+			CSVReport("report.csv", []*model.History{{PointUUID: "pnt_1", Value: &value, Timestamp: time.Unix(0, 0)}}, points),
+			snapshot,
+		},
+	})
+	assert.NoError(t, err)
+	email := m.sent[0]
+	assert.Equal(t, "1 points", email.Subject)
+	assert.Equal(t, "<temp>: 21.5", email.Body)
+	assert.Len(t, email.Attachments, 2)
+	assert.Equal(t, "timestamp,point_uuid,point_name,value\n1970-01-01T00:00:00Z,pnt_1,<temp>,21.5\n", m.reports[0])
+	assert.True(t, strings.HasSuffix(email.Attachments[1].Path, "snapshot.html"))
+	// the host sent the email, the files are gone
+	files, _ := os.ReadDir(m.dir)
+	assert.Empty(t, files)
+}
+
+func TestAttachmentsOfSendingEmailsExpire(t *testing.T) {
+	m := &fakeMarshaller{dir: t.TempDir(), status: datatype.EmailStatusSending}
+	mailer := New(m, &Config{AttachmentTTL: time.Hour})
+	now := time.Now()
+	mailer.now = func() time.Time { return now }
+	template, _ := ParseTemplate("report", "")
+	message := &Message{To: []string{"a@nube.io"}, Template: template,
+		Attachments: []Attachment{CSVReport("report.csv", nil, nil)}}
+
+	_, err := mailer.Send(message)
+	assert.NoError(t, err)
+	files, _ := os.ReadDir(m.dir)
+	assert.Len(t, files, 1)
+
+	now = now.Add(time.Hour)
+	m.status = datatype.EmailStatusSent
+	_, err = mailer.Send(message)
+	assert.NoError(t, err)
+	files, _ = os.ReadDir(m.dir)
+	assert.Empty(t, files)
+}
+
+func TestRateLimitPerRecipient(t *testing.T) {
+	m := &fakeMarshaller{}
+	mailer := New(m, &Config{RateLimit: 1, RatePer: time.Hour})
+	now := time.Now()
+	mailer.now = func() time.Time { return now }
+	template, _ := ParseTemplate("alert", "body")
+
+	_, err := mailer.Send(&Message{To: []string{"a@nube.io"}, Template: template})
+	assert.NoError(t, err)
+	_, err = mailer.Send(&Message{To: []string{"A@nube.io"}, Cc: []string{"b@nube.io"}, Template: template})
+	assert.NoError(t, err)
+	assert.Equal(t, "b@nube.io", m.sent[1].To)
+	assert.Nil(t, m.sent[1].Cc)
+	_, err = mailer.Send(&Message{To: []string{"a@nube.io", "b@nube.io"}, Template: template})
+	assert.Equal(t, ErrRateLimited, err)
+
+	now = now.Add(time.Hour)
+	_, err = mailer.Send(&Message{To: []string{"a@nube.io"}, Template: template})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,78 @@
+package mail
+
+import (
+	"bytes"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Data is bound to the templates, Values holds anything else a module wants to show.
+type Data struct {
+	Points    []*model.Point
+	Alerts    []*model.Alert
+	Histories []*model.History
+	Values    map[string]interface{}
+}
+
+var funcs = map[string]interface{}{
+	"value": func(v *float64) string {
+		if v == nil {
+			return "-"
+		}
+		return strconv.FormatFloat(*v, 'f', -1, 64)
+	},
+	"time": func(t interface{}) string {
+		switch v := t.(type) {
+		case time.Time:
+			return v.Format(time.RFC3339)
+		case *time.Time:
+			if v != nil {
+				return v.Format(time.RFC3339)
+			}
+		}
+		return "-"
+	},
+	"deref": func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	},
+	"upper": strings.ToUpper,
+}
+
+// Template renders the subject and the plain text body of an email, the host has no way to send html bodies so html
+// goes in an attachment such as HTMLSnapshot.
+type Template struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+func ParseTemplate(subject, body string) (*Template, error) {
+	t := &Template{}
+	var err error
+	if t.subject, err = template.New("subject").Funcs(funcs).Parse(subject); err != nil {
+		return nil, err
+	}
+	if t.body, err = template.New("body").Funcs(funcs).Parse(body); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *Template) Render(data *Data) (string, string, error) {
+	if data == nil {
+		data = &Data{}
+	}
+	var subject, body bytes.Buffer
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return "", "", err
+	}
+	if err := t.body.Execute(&body, data); err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(strings.ReplaceAll(subject.String(), "\n", " ")), body.String(), nil
+}
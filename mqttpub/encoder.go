@@ -0,0 +1,47 @@
+package mqttpub
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Encoder turns the values sharing a topic into one payload. Sparkplug B needs birth certificates and a session
+// sequence, publish it with sparkplug.EdgeNode instead.
+type Encoder interface {
+	Encode(values []*Value) ([]byte, error)
+	Binary() bool // binary payloads are published with PublishBinary
+}
+
+type jsonValue struct {
+	UUID        string   `json:"uuid"`
+	Name        string   `json:"name"`
+	DeviceName  string   `json:"device_name"`
+	NetworkName string   `json:"network_name"`
+	Value       *float64 `json:"value"`
+	Timestamp   string   `json:"timestamp"`
+}
+
+// JSONEncoder encodes a single value as an object and batches as an array of the same objects.
+type JSONEncoder struct{}
+
+func (JSONEncoder) Encode(values []*Value) ([]byte, error) {
+	out := make([]*jsonValue, 0, len(values))
+	for _, v := range values {
+		out = append(out, &jsonValue{
+			UUID:        v.Point.UUID,
+			Name:        v.Point.Name,
+			DeviceName:  v.Point.DeviceName,
+			NetworkName: v.Point.NetworkName,
+			Value:       v.Value,
+			Timestamp:   v.Timestamp.UTC().Format(time.RFC3339Nano),
+		})
+	}
+	if len(out) == 1 {
+		return json.Marshal(out[0])
+	}
+	return json.Marshal(out)
+}
+
+func (JSONEncoder) Binary() bool {
+	return false
+}
@@ -0,0 +1,88 @@
+package mqttpub
+
+import (
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type message struct {
+	topic   string
+	payload string
+}
+
+type fakeMarshaller struct {
+	nmodule.Marshaller
+	published []message
+	binary    [][]byte
+}
+
+func (f *fakeMarshaller) Publish(topic string, qos datatype.QOS, retain bool, payload string, opts ...*nmodule.Opts) error {
+	f.published = append(f.published, message{topic, payload})
+	return nil
+}
+
+func (f *fakeMarshaller) PublishBinary(topic string, qos datatype.QOS, retain bool, payload []byte, opts ...*nmodule.Opts) error {
+	f.binary = append(f.binary, payload)
+	return nil
+}
+
+func point(device, name string) *dto.PointWithParent {
+	return &dto.PointWithParent{UUID: "pnt_" + name, Name: name, DeviceName: device, NetworkName: "modbus/rtu"}
+}
+
+func TestTemplate(t *testing.T) {
+	template, err := ParseTemplate("rubix/{host_name}/{network_name}/{device_name}/{point_name}")
+	assert.NoError(t, err)
+	assert.Equal(t, "rubix/rc/modbus_rtu/dev/temp", template.Resolve(&Host{Name: "rc"}, point("dev", "temp")))
+
+	_, err = ParseTemplate("rubix/{host}/+")
+	assert.Error(t, err)
+	_, err = ParseTemplate("rubix/{unknown}")
+	assert.Error(t, err)
+}
+
+func TestPublishBatchesByTopic(t *testing.T) {
+	m := &fakeMarshaller{}
+	publisher, err := New(m, JSONEncoder{}, &Config{Topic: "rubix/{device_name}", BatchSize: 2})
+	assert.NoError(t, err)
+	value := 1.5
+	ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, publisher.Publish(
+		&Value{Point: point("a", "p1"), Value: &value, Timestamp: ts},
+		&Value{Point: point("b", "p2"), Value: &value, Timestamp: ts},
+		&Value{Point: point("a", "p3"), Timestamp: ts},
+		&Value{Point: point("a", "p4"), Value: &value, Timestamp: ts},
+	))
+	assert.Len(t, m.published, 3)
+	assert.Equal(t, "rubix/a", m.published[0].topic)
+	assert.Contains(t, m.published[0].payload, `"name":"p3","device_name":"a","network_name":"modbus/rtu","value":null`)
+	assert.Equal(t, "rubix/a", m.published[1].topic)
+	assert.Equal(t, "rubix/b", m.published[2].topic)
+	assert.Equal(t, `{"uuid":"pnt_p2","name":"p2","device_name":"b","network_name":"modbus/rtu","value":1.5,"timestamp":"2024-01-01T00:00:00Z"}`,
+		m.published[2].payload)
+}
+
+type binaryEncoder struct {
+	JSONEncoder
+}
+
+func (binaryEncoder) Binary() bool {
+	return true
+}
+
+func TestPublishKeepsCallerValue(t *testing.T) {
+	m := &fakeMarshaller{}
+	publisher, err := New(m, binaryEncoder{}, &Config{Topic: "rubix/{device_name}"})
+	assert.NoError(t, err)
+	value := &Value{Point: point("a", "p1")}
+	publisher.Add(value)
+	assert.NoError(t, publisher.Publish(value))
+	assert.NoError(t, publisher.Stop())
+	assert.Len(t, m.binary, 2)
+	assert.Empty(t, m.published)
+	assert.True(t, value.Timestamp.IsZero())
+}
@@ -0,0 +1,140 @@
+package mqttpub
+
+import (
+	"github.com/NubeIO/lib-module-go/loop"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+type Value struct {
+	Point     *dto.PointWithParent
+	Value     *float64
+	Timestamp time.Time // now when zero
+}
+
+type Config struct {
+	Topic     string // template, values resolving to the same topic are batched into one message
+	Host      Host
+	Qos       datatype.QOS
+	Retain    bool
+	NonBuffer bool // use PublishNonBuffer for text payloads
+	BatchSize int  // max values per message, 0 is unlimited
+}
+
+// Publisher publishes point values on templated topics, either directly with Publish or batched with Add and Start.
+type Publisher struct {
+	marshaller nmodule.Marshaller
+	encoder    Encoder
+	config     *Config
+	template   *Template
+
+	mutex   sync.Mutex
+	pending []*Value
+	loop    loop.Loop
+}
+
+func New(marshaller nmodule.Marshaller, encoder Encoder, config *Config) (*Publisher, error) {
+	template, err := ParseTemplate(config.Topic)
+	if err != nil {
+		return nil, err
+	}
+	return &Publisher{
+		marshaller: marshaller,
+		encoder:    encoder,
+		config:     config,
+		template:   template,
+	}, nil
+}
+
+// Publish sends the values straight away, one message per resolved topic and batch. A failed message doesn't stop the
+// others, the first error is returned.
+func (p *Publisher) Publish(values ...*Value) error {
+	var topics []string
+	grouped := map[string][]*Value{}
+	for _, v := range values {
+		v = stamp(v)
+		topic := p.template.Resolve(&p.config.Host, v.Point)
+		if _, ok := grouped[topic]; !ok {
+			topics = append(topics, topic)
+		}
+		grouped[topic] = append(grouped[topic], v)
+	}
+	var firstErr error
+	for _, topic := range topics {
+		group := grouped[topic]
+		for len(group) > 0 {
+			n := len(group)
+			if p.config.BatchSize > 0 && n > p.config.BatchSize {
+				n = p.config.BatchSize
+			}
+			if err := p.send(topic, group[:n]); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			group = group[n:]
+		}
+	}
+	return firstErr
+}
+
+func (p *Publisher) send(topic string, values []*Value) error {
+	payload, err := p.encoder.Encode(values)
+	if err != nil {
+		return err
+	}
+	if p.encoder.Binary() {
+		return p.marshaller.PublishBinary(topic, p.config.Qos, p.config.Retain, payload)
+	}
+	if p.config.NonBuffer {
+		return p.marshaller.PublishNonBuffer(topic, p.config.Qos, p.config.Retain, string(payload))
+	}
+	return p.marshaller.Publish(topic, p.config.Qos, p.config.Retain, string(payload))
+}
+
+// Add queues a value for the next Flush, a zero timestamp is set to the time it was added.
+func (p *Publisher) Add(value *Value) {
+	value = stamp(value)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.pending = append(p.pending, value)
+}
+
+// stamp returns a copy of the value with a zero timestamp set to now, the caller's value is left as it is.
+func stamp(value *Value) *Value {
+	if !value.Timestamp.IsZero() {
+		return value
+	}
+	c := *value
+	c.Timestamp = time.Now()
+	return &c
+}
+
+// Flush publishes the queued values.
+func (p *Publisher) Flush() error {
+	p.mutex.Lock()
+	pending := p.pending
+	p.pending = nil
+	p.mutex.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	return p.Publish(pending...)
+}
+
+// Start calls Flush every interval until Stop.
+func (p *Publisher) Start(interval time.Duration) error {
+	return p.loop.Start(interval, false, func() {
+		if err := p.Flush(); err != nil {
+			log.Errorf("mqttpub: failed to publish: %s", err)
+		}
+	})
+}
+
+// Stop stops the flushing and publishes what is still queued.
+func (p *Publisher) Stop() error {
+	p.loop.Stop()
+	return p.Flush()
+}
@@ -0,0 +1,60 @@
+package mqttpub
+
+import (
+	"fmt"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"regexp"
+	"strings"
+)
+
+// Host identifies the host in topics, PointWithParent doesn't carry it.
+type Host struct {
+	UUID string
+	Name string
+}
+
+var (
+	placeholderRegex = regexp.MustCompile(`\{([a-z_]+)}`)
+	placeholders     = map[string]func(host *Host, point *dto.PointWithParent) string{
+		"host_uuid":    func(h *Host, _ *dto.PointWithParent) string { return h.UUID },
+		"host_name":    func(h *Host, _ *dto.PointWithParent) string { return h.Name },
+		"network_uuid": func(_ *Host, p *dto.PointWithParent) string { return p.NetworkUUID },
+		"network_name": func(_ *Host, p *dto.PointWithParent) string { return p.NetworkName },
+		"device_uuid":  func(_ *Host, p *dto.PointWithParent) string { return p.DeviceUUID },
+		"device_name":  func(_ *Host, p *dto.PointWithParent) string { return p.DeviceName },
+		"point_uuid":   func(_ *Host, p *dto.PointWithParent) string { return p.UUID },
+		"point_name":   func(_ *Host, p *dto.PointWithParent) string { return p.Name },
+	}
+	levelEscaper = strings.NewReplacer("/", "_", "+", "_", "#", "_")
+)
+
+// Template is a topic with placeholders, e.g. rubix/{host_name}/{network_name}/{device_name}/{point_name}.
+type Template struct {
+	text string
+}
+
+func ParseTemplate(text string) (*Template, error) {
+	if text == "" {
+		return nil, fmt.Errorf("topic template can't be empty")
+	}
+	if strings.ContainsAny(placeholderRegex.ReplaceAllString(text, ""), "+#{}") {
+		return nil, fmt.Errorf("invalid topic template %s", text)
+	}
+	for _, match := range placeholderRegex.FindAllStringSubmatch(text, -1) {
+		if _, ok := placeholders[match[1]]; !ok {
+			return nil, fmt.Errorf("unknown placeholder %s in topic template %s", match[0], text)
+		}
+	}
+	return &Template{text: text}, nil
+}
+
+// Resolve fills the placeholders, characters which aren't allowed within a topic level are replaced by _.
+func (t *Template) Resolve(host *Host, point *dto.PointWithParent) string {
+	return placeholderRegex.ReplaceAllStringFunc(t.text, func(match string) string {
+		return levelEscaper.Replace(placeholders[match[1:len(match)-1]](host, point))
+	})
+}
+
+func (t *Template) String() string {
+	return t.text
+}
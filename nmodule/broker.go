@@ -0,0 +1,192 @@
+package nmodule
+
+import (
+	"errors"
+	"fmt"
+	"github.com/NubeIO/lib-module-go/nhttp"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrModuleCallNotSupported = errors.New("module calls are not supported by the host")
+	ErrModuleNotFound         = errors.New("module not found")
+	ErrModuleCallForbidden    = errors.New("module call is not permitted")
+)
+
+// CallerHeader is set by the Broker to the name of the calling module.
+const CallerHeader = "X-Module-Caller"
+
+// Grant allows calls to a module, limited to Methods and to url paths under Paths when they are set.
+type Grant struct {
+	Module  string
+	Methods []nhttp.Method
+	Paths   []string // e.g. /api/points matches /api/points and /api/points/abc, not /api/points-abc
+}
+
+func (g *Grant) allows(method nhttp.Method, urlPath string) bool {
+	if len(g.Methods) > 0 {
+		found := false
+		for _, m := range g.Methods {
+			found = found || m == method
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(g.Paths) == 0 {
+		return true
+	}
+	for _, p := range g.Paths {
+		p = strings.TrimSuffix(p, "/")
+		if urlPath == p || strings.HasPrefix(urlPath, p+"/") || p == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Permissions are the grants of each calling module, by module name. A module without grants can't call or
+// discover any module.
+type Permissions map[string][]*Grant
+
+// Allowed reports whether caller may call the route of module.
+func (p Permissions) Allowed(caller, module string, method nhttp.Method, urlString string) bool {
+	u, err := cleanURL(urlString)
+	if err != nil {
+		return false
+	}
+	return p.allowed(caller, module, method, u.Path)
+}
+
+func (p Permissions) allowed(caller, module string, method nhttp.Method, urlPath string) bool {
+	for _, grant := range p[caller] {
+		if grant.Module == module && grant.allows(method, urlPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanURL resolves the dot segments of the url path, so the path which is checked is the one which is called.
+func cleanURL(urlString string) (*url.URL, error) {
+	u, err := url.Parse(urlString)
+	if err != nil {
+		return nil, err
+	}
+	u.Path, u.RawPath = path.Clean("/"+u.Path), ""
+	return u, nil
+}
+
+func (p Permissions) visible(caller, module string) bool {
+	for _, grant := range p[caller] {
+		if grant.Module == module {
+			return true
+		}
+	}
+	return false
+}
+
+// Broker is a ModuleBroker for the modules running on the host, a host DBHelper can embed it. Modules on other
+// hosts are not reached, calls with a HostUUID fail unless the host forwards them itself.
+type Broker struct {
+	Permissions Permissions
+
+	mutex   sync.RWMutex
+	modules map[string]Module
+}
+
+func NewBroker(permissions Permissions) *Broker {
+	return &Broker{Permissions: permissions, modules: map[string]Module{}}
+}
+
+// Register makes a module callable by name, e.g. after the host enabled it.
+func (b *Broker) Register(name string, module Module) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.modules[name] = module
+}
+
+func (b *Broker) Unregister(name string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.modules, name)
+}
+
+func (b *Broker) BrokerCallModule(caller, module string, method nhttp.Method, urlString string, headers http.Header,
+	body []byte, opts ...*Opts) ([]byte, error) {
+	if err := remote(opts); err != nil {
+		return nil, err
+	}
+	u, err := cleanURL(urlString)
+	if err != nil || !b.Permissions.allowed(caller, module, method, u.Path) {
+		return nil, fmt.Errorf("%w: %s can't call %s %s on %s", ErrModuleCallForbidden, caller, method, urlString,
+			module)
+	}
+	b.mutex.RLock()
+	target, ok := b.modules[module]
+	b.mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrModuleNotFound, module)
+	}
+	// the caller keeps its headers as they are
+	headers = headers.Clone()
+	if headers == nil {
+		headers = http.Header{}
+	}
+	headers.Set(CallerHeader, caller)
+	return target.CallModule(method, u.String(), headers, body)
+}
+
+// BrokerGetModules returns the modules the caller has grants for, Name is the name to call them by. A module whose
+// info can't be read is listed by its name only.
+func (b *Broker) BrokerGetModules(caller string, opts ...*Opts) ([]*Info, error) {
+	if err := remote(opts); err != nil {
+		return nil, err
+	}
+	b.mutex.RLock()
+	var names []string
+	targets := map[string]Module{}
+	for name, module := range b.modules {
+		if b.Permissions.visible(caller, name) {
+			names = append(names, name)
+			targets[name] = module
+		}
+	}
+	b.mutex.RUnlock()
+	sort.Strings(names)
+	modules := make([]*Info, 0, len(names))
+	for _, name := range names {
+		module := Info{}
+		if info, err := targets[name].GetInfo(); err != nil {
+			log.Warnf("broker: failed to get the info of module %s: %s", name, err)
+		} else {
+			module = *info
+		}
+		module.Name = name
+		modules = append(modules, &module)
+	}
+	return modules, nil
+}
+
+func remote(opts []*Opts) error {
+	if len(opts) > 0 && opts[0] != nil && opts[0].HostUUID != nil {
+		return fmt.Errorf("%w: the broker doesn't reach other hosts", ErrModuleCallNotSupported)
+	}
+	return nil
+}
+
+// moduleCallError returns the broker error of a response with the sentinel it wraps, only its message crosses gRPC.
+func moduleCallError(message string) error {
+	for _, sentinel := range []error{ErrModuleCallNotSupported, ErrModuleNotFound, ErrModuleCallForbidden} {
+		if strings.HasPrefix(message, sentinel.Error()) {
+			return fmt.Errorf("%w%s", sentinel, strings.TrimPrefix(message, sentinel.Error()))
+		}
+	}
+	return errors.New(message)
+}
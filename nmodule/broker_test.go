@@ -0,0 +1,126 @@
+package nmodule
+
+import (
+	"context"
+	"errors"
+	"github.com/NubeIO/lib-module-go/nhttp"
+	"github.com/NubeIO/lib-module-go/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"net/http"
+	"testing"
+)
+
+type fakeModule struct {
+	Module
+	name string
+	fail bool
+}
+
+func (f *fakeModule) GetInfo() (*Info, error) {
+	if f.fail {
+		return nil, errors.New("module is restarting")
+	}
+	return &Info{Name: f.name, Author: "nube"}, nil
+}
+
+func (f *fakeModule) CallModule(method nhttp.Method, urlString string, headers http.Header, body []byte) ([]byte, error) {
+	return []byte(`{"caller":"` + headers.Get(CallerHeader) + `","url":"` + urlString + `"}`), nil
+}
+
+type fakeHost struct {
+	DBHelper
+	*Broker
+}
+
+func dial(t *testing.T, caller string, host DBHelper) *GRPCMarshaller {
+	listener := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	proto.RegisterDBHelperServer(s, &GRPCDBHelperServer{Impl: host, ModuleName: caller})
+	go s.Serve(listener)
+	t.Cleanup(s.Stop)
+	conn, err := grpc.Dial("bufnet", grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.Dial() }))
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return New(&GRPCDBHelperClient{proto.NewDBHelperClient(conn)})
+}
+
+func TestBroker(t *testing.T) {
+	broker := NewBroker(Permissions{
+		"reports": {
+			{Module: "bacnet", Methods: []nhttp.Method{nhttp.GET}, Paths: []string{"/api/points"}},
+			{Module: "lora"},
+		},
+	})
+	broker.Register("bacnet", &fakeModule{name: "module-core-bacnet"})
+	broker.Register("lora", &fakeModule{name: "module-core-lora", fail: true})
+	broker.Register("modbus", &fakeModule{name: "module-core-modbus"})
+	m := dial(t, "reports", &fakeHost{Broker: broker})
+
+	// a module without info is still listed
+	modules, err := m.GetModules()
+	assert.NoError(t, err)
+	assert.Len(t, modules, 2)
+	assert.Equal(t, "bacnet", modules[0].Name)
+	assert.Equal(t, "nube", modules[0].Author)
+	assert.Equal(t, "lora", modules[1].Name)
+	_, err = m.GetModule("modbus")
+	assert.True(t, errors.Is(err, ErrModuleNotFound))
+
+	type response struct {
+		Caller string `json:"caller"`
+		URL    string `json:"url"`
+	}
+	res, err := CallModuleAs[response](m, "bacnet", nhttp.GET, "/api/points/abc?with_priority=true", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, &response{Caller: "reports", URL: "/api/points/abc?with_priority=true"}, res)
+	// the path which was checked is the one which is called
+	res, err = CallModuleAs[response](m, "bacnet", nhttp.GET, "/api/networks/../points/./abc", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, &response{Caller: "reports", URL: "/api/points/abc"}, res)
+
+	for _, call := range []struct {
+		module string
+		method nhttp.Method
+		url    string
+	}{
+		{"bacnet", nhttp.POST, "/api/points"},
+		{"bacnet", nhttp.GET, "/api/points-abc"},
+		{"bacnet", nhttp.GET, "/api/points/../networks"},
+		{"modbus", nhttp.GET, "/api/points"},
+	} {
+		_, err = m.CallModule(call.module, call.method, call.url, nil)
+		assert.True(t, errors.Is(err, ErrModuleCallForbidden), call.url)
+	}
+
+	_, err = dial(t, "modbus", &fakeHost{Broker: broker}).CallModule("bacnet", nhttp.GET, "/api/points", nil)
+	assert.True(t, errors.Is(err, ErrModuleCallForbidden))
+
+	// both calls report the broker errors the same way
+	host := "hst"
+	_, err = m.CallModule("bacnet", nhttp.GET, "/api/points", nil, &Opts{HostUUID: &host})
+	assert.True(t, errors.Is(err, ErrModuleCallNotSupported))
+	_, err = m.GetModules(&Opts{HostUUID: &host})
+	assert.True(t, errors.Is(err, ErrModuleCallNotSupported))
+	_, err = broker.BrokerGetModules("reports", &Opts{HostUUID: &host})
+	assert.True(t, errors.Is(err, ErrModuleCallNotSupported))
+}
+
+func TestBrokerLeavesHeaders(t *testing.T) {
+	broker := NewBroker(Permissions{"reports": {{Module: "bacnet"}}})
+	broker.Register("bacnet", &fakeModule{name: "module-core-bacnet"})
+	headers := http.Header{"Accept": {"application/json"}}
+	res, err := broker.BrokerCallModule("reports", "bacnet", nhttp.GET, "/api/points", headers, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, string(res), `"caller":"reports"`)
+	assert.Equal(t, http.Header{"Accept": {"application/json"}}, headers)
+}
+
+func TestCallModuleNotSupported(t *testing.T) {
+	_, err := dial(t, "reports", struct{ DBHelper }{}).GetModules()
+	assert.Equal(t, ErrModuleCallNotSupported, err)
+}
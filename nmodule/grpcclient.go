@@ -5,10 +5,14 @@ import (
 	"errors"
 	"github.com/NubeIO/lib-module-go/nhttp"
 	"github.com/NubeIO/lib-module-go/proto"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
 	"github.com/NubeIO/nubeio-rubix-lib-models-go/nargs"
 	"github.com/hashicorp/go-plugin"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
 	"net/http"
 )
 
@@ -20,7 +24,7 @@ type GRPCClient struct {
 
 func (m *GRPCClient) Init(dbHelper DBHelper, moduleName string) error {
 	log.Debug("gRPC Init client has been called...")
-	dbHelperServer := &GRPCDBHelperServer{Impl: dbHelper}
+	dbHelperServer := &GRPCDBHelperServer{Impl: dbHelper, ModuleName: moduleName}
 	var s *grpc.Server
 	serverFunc := func(opts []grpc.ServerOption) *grpc.Server {
 		s = DefaultGRPCServer(opts)
@@ -145,3 +149,93 @@ func (m *GRPCDBHelperClient) CallDBHelper(method nhttp.Method, api string, body
 	}
 	return resp.R, nil
 }
+
+func (m *GRPCDBHelperClient) Subscribe(ctx context.Context, topic string, qos datatype.QOS,
+	retainHandling RetainHandling, handler MqttHandler, opts ...*Opts) error {
+	var hostUUID *string
+	if len(opts) > 0 && opts[0] != nil {
+		hostUUID = opts[0].HostUUID
+	}
+	stream, err := m.client.Subscribe(ctx, &proto.SubscribeRequest{
+		Topic:          topic,
+		Qos:            uint32(qos),
+		RetainHandling: uint32(retainHandling),
+		HostUUID:       hostUUID,
+	})
+	if err != nil {
+		return ExtractRPCErrorMessage(err)
+	}
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if status.Code(err) == codes.Unimplemented {
+			return ErrSubscribeNotSupported
+		}
+		if err != nil {
+			return ExtractRPCErrorMessage(err)
+		}
+		handler(&MqttMessage{
+			Topic:     msg.Topic,
+			Payload:   msg.Payload,
+			Qos:       datatype.QOS(msg.Qos),
+			Retained:  msg.Retained,
+			Duplicate: msg.Duplicate,
+		})
+	}
+}
+
+func (m *GRPCDBHelperClient) CallModule(module string, method nhttp.Method, urlString string, headers http.Header,
+	body []byte, opts ...*Opts) ([]byte, error) {
+	var hostUUID *string
+	if len(opts) > 0 && opts[0] != nil {
+		hostUUID = opts[0].HostUUID
+	}
+	resp, err := m.client.CallModule(context.Background(), &proto.ModuleCallRequest{
+		Module:    module,
+		Method:    string(method),
+		UrlString: urlString,
+		Headers:   ConvertHTTPToHeaders(headers),
+		Body:      body,
+		HostUUID:  hostUUID,
+	})
+	if status.Code(err) == codes.Unimplemented {
+		return nil, ErrModuleCallNotSupported
+	}
+	if err != nil {
+		return nil, ExtractRPCErrorMessage(err)
+	}
+	if resp.E != nil {
+		return nil, moduleCallError(string(resp.E))
+	}
+	return resp.R, nil
+}
+
+func (m *GRPCDBHelperClient) GetModules(opts ...*Opts) ([]*Info, error) {
+	var hostUUID *string
+	if len(opts) > 0 && opts[0] != nil {
+		hostUUID = opts[0].HostUUID
+	}
+	resp, err := m.client.GetModules(context.Background(), &proto.ModulesRequest{HostUUID: hostUUID})
+	if status.Code(err) == codes.Unimplemented {
+		return nil, ErrModuleCallNotSupported
+	}
+	if err != nil {
+		return nil, ExtractRPCErrorMessage(err)
+	}
+	if resp.E != nil {
+		return nil, moduleCallError(string(resp.E))
+	}
+	modules := make([]*Info, len(resp.Modules))
+	for i, module := range resp.Modules {
+		modules[i] = &Info{
+			Name:       module.Name,
+			Author:     module.Author,
+			Website:    module.Website,
+			License:    module.License,
+			HasNetwork: module.HasNetwork,
+		}
+	}
+	return modules, nil
+}
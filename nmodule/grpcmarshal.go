@@ -3,7 +3,9 @@ package nmodule
 import (
 	"encoding/json"
 	"github.com/NubeIO/lib-date/datelib"
+	"github.com/NubeIO/lib-module-go/aggregate"
 	"github.com/NubeIO/lib-module-go/nhttp"
+	"github.com/NubeIO/lib-module-go/pgquery"
 	"github.com/NubeIO/lib-networking/networking"
 	"github.com/NubeIO/lib-networking/scanner"
 	systats "github.com/NubeIO/lib-system"
@@ -113,13 +115,16 @@ type Marshaller interface {
 	CreateModuleDir(name string, opts ...*Opts) (*string, error)
 
 	CreateHistories(histories []*model.History, opts ...*Opts) (bool, error)
+	CreateHistoriesBatch(batch *HistoryBatch, opts ...*Opts) error
 	GetHistories(historyRequest *dto.HistoryRequest, opts ...*Opts) (*dto.HistoryResponse, error)
 	GetHistoriesFromSqlite(historyRequest *dto.HistoryRequest, opts ...*Opts) (*dto.HistoryResponse, error)
+	GetHistoriesAggregate(aggregateRequest *aggregate.Request, opts ...*Opts) (*aggregate.Response, error)
 	GetLatestHistoryByHostAndPointUUID(hostUUID, pointUUID string, opts ...*Opts) (*model.History, error)
 	GetHistoriesForSync(opts ...*Opts) (*dto.HistorySync, error)
 	DeleteHistories(opts ...*Opts) error
 
 	CreatePointHistories(histories []*model.PointHistory, opts ...*Opts) (bool, error)
+	CreatePointHistoriesBatch(batch *PointHistoryBatch, opts ...*Opts) error
 	GetPointHistories(opts ...*Opts) ([]*model.PointHistory, error)
 	GetPointHistoriesByPointUUID(pointUUID string, opts ...*Opts) ([]*model.PointHistory, error)
 	GetLatestPointHistoryByPointUUID(pointUUID string, opts ...*Opts) (*model.PointHistory, error)
@@ -190,11 +195,18 @@ type Marshaller interface {
 
 	Publish(topic string, qos datatype.QOS, retain bool, payload string, opts ...*Opts) error
 	PublishNonBuffer(topic string, qos datatype.QOS, retain bool, payload string, opts ...*Opts) error
+	PublishBinary(topic string, qos datatype.QOS, retain bool, payload []byte, opts ...*Opts) error
+	Subscribe(topic string, qos datatype.QOS, retainHandling RetainHandling, handler MqttHandler, opts ...*Opts) (*Subscription, error)
 
 	SendEmail(body *model.Email, opts ...*Opts) (*model.Email, error)
 	GetAttachmentDir(opts ...*Opts) (*string, error)
 
 	PostgresRawQuery(body *dto.QueryBody, opts ...*Opts) (*dto.QueryResponse, error)
+	PostgresQuery(body *pgquery.Query, opts ...*Opts) (*pgquery.Result, error)
+
+	CallModule(module string, method nhttp.Method, urlString string, body interface{}, opts ...*Opts) ([]byte, error)
+	GetModules(opts ...*Opts) ([]*Info, error)
+	GetModule(name string, opts ...*Opts) (*Info, error)
 }
 
 func New(dbHelper DBHelper) *GRPCMarshaller {
@@ -4,10 +4,14 @@ import (
 	"context"
 	"github.com/NubeIO/lib-module-go/nhttp"
 	"github.com/NubeIO/lib-module-go/proto"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
 	"github.com/NubeIO/nubeio-rubix-lib-models-go/nargs"
 	"github.com/hashicorp/go-plugin"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"net/http"
+	"sync"
 )
 
 // Here is the RPC server that RPCClient talks to, conforming to
@@ -108,6 +112,8 @@ func (m *GRPCServer) CallModule(ctx context.Context, req *proto.RequestModule) (
 type GRPCDBHelperServer struct {
 	// This is the real implementation
 	Impl DBHelper
+	// ModuleName is the module this server was started for, the caller of its module calls
+	ModuleName string
 }
 
 func (m *GRPCDBHelperServer) CallDBHelper(ctx context.Context, req *proto.Request) (resp *proto.Response, err error) {
@@ -134,3 +140,90 @@ func (m *GRPCDBHelperServer) CallDBHelper(ctx context.Context, req *proto.Reques
 	}
 	return &proto.Response{R: r, E: nil}, nil
 }
+
+func (m *GRPCDBHelperServer) Subscribe(req *proto.SubscribeRequest, stream proto.DBHelper_SubscribeServer) error {
+	subscriber, ok := m.Impl.(MqttSubscriber)
+	if !ok {
+		return status.Error(codes.Unimplemented, "mqtt subscribe is not supported by the host")
+	}
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	var mutex sync.Mutex
+	var sendErr error
+	handler := func(msg *MqttMessage) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if sendErr != nil {
+			return
+		}
+		sendErr = stream.Send(&proto.MqttMessage{
+			Topic:     msg.Topic,
+			Payload:   msg.Payload,
+			Qos:       uint32(msg.Qos),
+			Retained:  msg.Retained,
+			Duplicate: msg.Duplicate,
+		})
+		if sendErr != nil {
+			cancel()
+		}
+	}
+	var opts []*Opts
+	if req.HostUUID != nil {
+		opts = append(opts, &Opts{HostUUID: req.HostUUID})
+	}
+	err := subscriber.Subscribe(ctx, req.Topic, datatype.QOS(req.Qos), RetainHandling(req.RetainHandling), handler,
+		opts...)
+	mutex.Lock()
+	defer mutex.Unlock()
+	if sendErr != nil {
+		return sendErr
+	}
+	return err
+}
+
+func (m *GRPCDBHelperServer) CallModule(ctx context.Context, req *proto.ModuleCallRequest) (*proto.Response, error) {
+	broker, ok := m.Impl.(ModuleBroker)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "module calls are not supported by the host")
+	}
+	method, err := nhttp.StringToMethod(req.Method)
+	if err != nil {
+		return nil, err
+	}
+	var opts []*Opts
+	if req.HostUUID != nil {
+		opts = append(opts, &Opts{HostUUID: req.HostUUID})
+	}
+	r, err := broker.BrokerCallModule(m.ModuleName, req.Module, method, req.UrlString,
+		ConvertHeadersToHTTP(req.Headers), req.Body, opts...)
+	if err != nil {
+		return &proto.Response{R: nil, E: []byte(err.Error())}, nil
+	}
+	return &proto.Response{R: r, E: nil}, nil
+}
+
+func (m *GRPCDBHelperServer) GetModules(ctx context.Context, req *proto.ModulesRequest) (*proto.ModulesResponse, error) {
+	broker, ok := m.Impl.(ModuleBroker)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "module calls are not supported by the host")
+	}
+	var opts []*Opts
+	if req.HostUUID != nil {
+		opts = append(opts, &Opts{HostUUID: req.HostUUID})
+	}
+	modules, err := broker.BrokerGetModules(m.ModuleName, opts...)
+	if err != nil {
+		return &proto.ModulesResponse{E: []byte(err.Error())}, nil
+	}
+	resp := &proto.ModulesResponse{Modules: make([]*proto.InfoResponse, len(modules))}
+	for i, module := range modules {
+		resp.Modules[i] = &proto.InfoResponse{
+			Name:       module.Name,
+			Author:     module.Author,
+			Website:    module.Website,
+			License:    module.License,
+			HasNetwork: module.HasNetwork,
+		}
+	}
+	return resp, nil
+}
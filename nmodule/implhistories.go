@@ -3,6 +3,7 @@ package nmodule
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/NubeIO/lib-module-go/aggregate"
 	"github.com/NubeIO/lib-module-go/nhttp"
 	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
 	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
@@ -17,6 +18,19 @@ func (g *GRPCMarshaller) CreateHistories(histories []*model.History, opts ...*Op
 	return true, nil
 }
 
+// HistoryBatch carries a dedup key for each history, so the host can skip the histories of a redelivered batch. Hosts
+// without the batch api return ErrEndpointNotSupported.
+type HistoryBatch struct {
+	Keys      []string         `json:"keys"`
+	Histories []*model.History `json:"histories"`
+}
+
+func (g *GRPCMarshaller) CreateHistoriesBatch(batch *HistoryBatch, opts ...*Opts) error {
+	api := "/api/histories/batch"
+	_, err := g.CallDBHelperWithParser(nhttp.POST, api, batch, opts...)
+	return endpointError(api, err)
+}
+
 func (g *GRPCMarshaller) GetHistories(historyRequest *dto.HistoryRequest, opts ...*Opts) (*dto.HistoryResponse, error) {
 	api := "/api/histories"
 	res, err := g.CallDBHelperWithParser(nhttp.GET, api, historyRequest, opts...)
@@ -45,6 +59,20 @@ func (g *GRPCMarshaller) GetHistoriesFromSqlite(historyRequest *dto.HistoryReque
 	return history, nil
 }
 
+func (g *GRPCMarshaller) GetHistoriesAggregate(aggregateRequest *aggregate.Request, opts ...*Opts) (*aggregate.Response, error) {
+	api := "/api/histories/aggregate"
+	res, err := g.CallDBHelperWithParser(nhttp.GET, api, aggregateRequest, opts...)
+	if err != nil {
+		return nil, err
+	}
+	var aggregateResponse *aggregate.Response
+	err = json.Unmarshal(res, &aggregateResponse)
+	if err != nil {
+		return nil, err
+	}
+	return aggregateResponse, nil
+}
+
 func (g *GRPCMarshaller) GetLatestHistoryByHostAndPointUUID(hostUUID, pointUUID string, opts ...*Opts) (*model.History, error) {
 	api := fmt.Sprintf("/api/histories/point-uuid/%s/host-uuid/%s/latest", pointUUID, hostUUID)
 	res, err := g.DbHelper.CallDBHelper(nhttp.GET, api, nil, opts...)
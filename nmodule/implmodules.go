@@ -0,0 +1,77 @@
+package nmodule
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/NubeIO/lib-module-go/nhttp"
+	"net/http"
+)
+
+func (g *GRPCMarshaller) moduleCaller() (ModuleCaller, error) {
+	caller, ok := g.DbHelper.(ModuleCaller)
+	if !ok {
+		return nil, ErrModuleCallNotSupported
+	}
+	return caller, nil
+}
+
+// CallModule calls a route of another module through the host, body is sent as json unless it is nil or []byte.
+func (g *GRPCMarshaller) CallModule(module string, method nhttp.Method, urlString string, body interface{},
+	opts ...*Opts) ([]byte, error) {
+	caller, err := g.moduleCaller()
+	if err != nil {
+		return nil, err
+	}
+	headers := http.Header{}
+	var b []byte
+	switch v := body.(type) {
+	case nil:
+	case []byte:
+		b = v
+	default:
+		b, err = json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		headers.Set("Content-Type", "application/json")
+	}
+	return caller.CallModule(module, method, urlString, headers, b, opts...)
+}
+
+// GetModules returns the modules this module may call.
+func (g *GRPCMarshaller) GetModules(opts ...*Opts) ([]*Info, error) {
+	caller, err := g.moduleCaller()
+	if err != nil {
+		return nil, err
+	}
+	return caller.GetModules(opts...)
+}
+
+func (g *GRPCMarshaller) GetModule(name string, opts ...*Opts) (*Info, error) {
+	modules, err := g.GetModules(opts...)
+	if err != nil {
+		return nil, err
+	}
+	for _, module := range modules {
+		if module.Name == name {
+			return module, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrModuleNotFound, name)
+}
+
+// CallModuleAs calls a route of another module and decodes its json response, e.g.
+//
+//	points, err := nmodule.CallModuleAs[[]*model.Point](marshaller, "module-core-bacnet", nhttp.GET, "/api/points", nil)
+func CallModuleAs[T any](marshaller Marshaller, module string, method nhttp.Method, urlString string,
+	body interface{}, opts ...*Opts) (*T, error) {
+	res, err := marshaller.CallModule(module, method, urlString, body, opts...)
+	if err != nil {
+		return nil, err
+	}
+	var out T
+	if err = json.Unmarshal(res, &out); err != nil {
+		return nil, fmt.Errorf("%s %s: %w", module, urlString, err)
+	}
+	return &out, nil
+}
@@ -1,9 +1,16 @@
 package nmodule
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/NubeIO/lib-module-go/nhttp"
 	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
 	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+	"time"
 )
 
 func (g *GRPCMarshaller) Publish(topic string, qos datatype.QOS, retain bool, payload string, opts ...*Opts) error {
@@ -31,3 +38,159 @@ func (g *GRPCMarshaller) PublishNonBuffer(topic string, qos datatype.QOS, retain
 	_, err := g.CallDBHelperWithParser(nhttp.POST, api, body, opts...)
 	return err
 }
+
+type MqttBinaryBody struct {
+	Topic   string       `json:"topic"`
+	Qos     datatype.QOS `json:"qos"`
+	Retain  bool         `json:"retain"`
+	Payload []byte       `json:"payload"` // base64 in json
+}
+
+// PublishBinary publishes a payload which isn't valid utf-8, e.g. protobuf, Publish would mangle it in the json body.
+// The host must provide /api/mqtt/publish-binary, which decodes the base64 payload, older hosts return
+// ErrEndpointNotSupported.
+func (g *GRPCMarshaller) PublishBinary(topic string, qos datatype.QOS, retain bool, payload []byte, opts ...*Opts) error {
+	api := "/api/mqtt/publish-binary"
+	body := MqttBinaryBody{
+		Topic:   topic,
+		Qos:     qos,
+		Retain:  retain,
+		Payload: payload,
+	}
+
+	_, err := g.CallDBHelperWithParser(nhttp.POST, api, body, opts...)
+	return endpointError(api, err)
+}
+
+var ErrSubscribeNotSupported = errors.New("mqtt subscribe is not supported by the host")
+
+var (
+	resubscribeMinDelay = time.Second
+	resubscribeMaxDelay = 30 * time.Second
+)
+
+// Subscription delivers the messages of one topic filter until Unsubscribe is called, it subscribes again whenever
+// the stream from the host ends, e.g. when the host broker reconnects.
+type Subscription struct {
+	Topic string
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	mutex  sync.Mutex
+	err    error
+}
+
+// Subscribe subscribes to a topic filter with + and # wildcards on the host broker.
+func (g *GRPCMarshaller) Subscribe(topic string, qos datatype.QOS, retainHandling RetainHandling, handler MqttHandler, opts ...*Opts) (*Subscription, error) {
+	if err := ValidateTopicFilter(topic); err != nil {
+		return nil, err
+	}
+	if qos > datatype.ExactlyOnce {
+		return nil, fmt.Errorf("invalid qos %d", qos)
+	}
+	subscriber, ok := g.DbHelper.(MqttSubscriber)
+	if !ok {
+		return nil, ErrSubscribeNotSupported
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Subscription{Topic: topic, cancel: cancel, done: make(chan struct{})}
+	go s.run(ctx, subscriber, qos, retainHandling, handler, opts...)
+	return s, nil
+}
+
+func (s *Subscription) run(ctx context.Context, subscriber MqttSubscriber, qos datatype.QOS,
+	retainHandling RetainHandling, handler MqttHandler, opts ...*Opts) {
+	defer close(s.done)
+	resubscribed := false
+	delay := resubscribeMinDelay
+	for {
+		current := retainHandling
+		if resubscribed && retainHandling == RetainSendOnNewSubscribe {
+			current = RetainDontSend
+		}
+		started := time.Now()
+		err := subscriber.Subscribe(ctx, s.Topic, qos, current, func(msg *MqttMessage) {
+			if msg.Retained && current == RetainDontSend {
+				return // the broker may not support retain handling
+			}
+			if !MatchTopic(s.Topic, msg.Topic) {
+				return // the host may share one broker subscription between overlapping filters
+			}
+			handler(msg)
+		}, opts...)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, ErrSubscribeNotSupported) {
+			s.setErr(err)
+			return
+		}
+		if time.Since(started) > resubscribeMaxDelay {
+			delay = resubscribeMinDelay
+		}
+		log.Warnf("mqtt subscription %s ended: %v, subscribing again in %s", s.Topic, err, delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > resubscribeMaxDelay {
+			delay = resubscribeMaxDelay
+		}
+		resubscribed = true
+	}
+}
+
+func (s *Subscription) setErr(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.err = err
+}
+
+// Err returns the error which stopped the subscription for good, nil while it is active or after Unsubscribe.
+func (s *Subscription) Err() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.err
+}
+
+// Unsubscribe stops the subscription and waits until the handler is no longer called.
+func (s *Subscription) Unsubscribe() {
+	s.cancel()
+	<-s.done
+}
+
+// ValidateTopicFilter checks that + takes a whole level and # only the last level of the filter.
+func ValidateTopicFilter(filter string) error {
+	if filter == "" {
+		return errors.New("topic filter can't be empty")
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.ContainsAny(level, "+#") && len(level) > 1 {
+			return fmt.Errorf("wildcard must take a whole level in topic filter %s", filter)
+		}
+		if level == "#" && i != len(levels)-1 {
+			return fmt.Errorf("# must be the last level in topic filter %s", filter)
+		}
+	}
+	return nil
+}
+
+// MatchTopic reports whether topic matches the filter, topics starting with $ are not matched by leading wildcards.
+func MatchTopic(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	if strings.HasPrefix(topic, "$") && (filterLevels[0] == "+" || filterLevels[0] == "#") {
+		return false
+	}
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) || (level != "+" && level != topicLevels[i]) {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
@@ -0,0 +1,116 @@
+package nmodule
+
+import (
+	"context"
+	"errors"
+	"github.com/NubeIO/lib-module-go/nhttp"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeSubscriber struct {
+	DBHelper
+	mutex       sync.Mutex
+	calls       []RetainHandling
+	failures    int // streams which end with an error before one stays open
+	unsupported bool
+}
+
+func (f *fakeSubscriber) Subscribe(ctx context.Context, topic string, qos datatype.QOS, retainHandling RetainHandling,
+	handler MqttHandler, opts ...*Opts) error {
+	f.mutex.Lock()
+	f.calls = append(f.calls, retainHandling)
+	calls := len(f.calls)
+	f.mutex.Unlock()
+	if f.unsupported {
+		return ErrSubscribeNotSupported
+	}
+	handler(&MqttMessage{Topic: "rubix/temp", Payload: []byte("retained"), Retained: true})
+	handler(&MqttMessage{Topic: "other/temp", Payload: []byte("other")})
+	handler(&MqttMessage{Topic: "rubix/temp", Payload: []byte("live")})
+	if calls <= f.failures {
+		return errors.New("broker reconnected")
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (f *fakeSubscriber) retainHandlings() []RetainHandling {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]RetainHandling{}, f.calls...)
+}
+
+type fakeOldHost struct {
+	DBHelper
+}
+
+func (f *fakeOldHost) CallDBHelper(method nhttp.Method, api string, body []byte, opts ...*Opts) ([]byte, error) {
+	return nil, errors.New("404 page not found")
+}
+
+func TestPublishBinaryNotSupported(t *testing.T) {
+	err := New(&fakeOldHost{}).PublishBinary("spBv1.0/g/NBIRTH/n", datatype.AtMostOnce, false, []byte{0x08})
+	assert.True(t, errors.Is(err, ErrEndpointNotSupported))
+	assert.Contains(t, err.Error(), "/api/mqtt/publish-binary")
+}
+
+func TestValidateTopicFilter(t *testing.T) {
+	for _, filter := range []string{"rubix/+/temp", "rubix/#", "#", "+", "rubix/temp"} {
+		assert.NoError(t, ValidateTopicFilter(filter), filter)
+	}
+	for _, filter := range []string{"", "rubix/te+", "rubix/#/temp", "rubix/temp#", "rubix/++"} {
+		assert.Error(t, ValidateTopicFilter(filter), filter)
+	}
+
+	assert.True(t, MatchTopic("rubix/+/temp", "rubix/ahu/temp"))
+	assert.True(t, MatchTopic("rubix/#", "rubix/ahu/temp"))
+	assert.True(t, MatchTopic("rubix/#", "rubix"))
+	assert.False(t, MatchTopic("rubix/+", "rubix/ahu/temp"))
+	assert.False(t, MatchTopic("#", "$SYS/uptime"))
+	assert.True(t, MatchTopic("$SYS/#", "$SYS/uptime"))
+}
+
+func TestResubscribe(t *testing.T) {
+	minDelay, maxDelay := resubscribeMinDelay, resubscribeMaxDelay
+	resubscribeMinDelay, resubscribeMaxDelay = time.Millisecond, 4*time.Millisecond
+	t.Cleanup(func() { resubscribeMinDelay, resubscribeMaxDelay = minDelay, maxDelay })
+
+	f := &fakeSubscriber{failures: 2}
+	var mutex sync.Mutex
+	var payloads []string
+	s, err := New(f).Subscribe("rubix/+", datatype.AtMostOnce, RetainSendOnNewSubscribe, func(msg *MqttMessage) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		payloads = append(payloads, string(msg.Payload))
+	})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return len(f.retainHandlings()) == 3 }, time.Second, time.Millisecond)
+	s.Unsubscribe()
+
+	// retained messages only reach the handler on the first subscribe, other topics never do
+	assert.Equal(t, []RetainHandling{RetainSendOnNewSubscribe, RetainDontSend, RetainDontSend}, f.retainHandlings())
+	assert.Equal(t, []string{"retained", "live", "live", "live"}, payloads)
+	assert.NoError(t, s.Err())
+}
+
+func TestSubscribeNotSupported(t *testing.T) {
+	_, err := New(struct{ DBHelper }{}).Subscribe("rubix/#", datatype.AtMostOnce, RetainSendOnSubscribe,
+		func(msg *MqttMessage) {})
+	assert.Equal(t, ErrSubscribeNotSupported, err)
+	_, err = New(&fakeSubscriber{}).Subscribe("rubix/#/temp", datatype.AtMostOnce, RetainSendOnSubscribe,
+		func(msg *MqttMessage) {})
+	assert.Error(t, err)
+
+	// a host without the stream stops the subscription instead of retrying
+	f := &fakeSubscriber{unsupported: true}
+	s, err := New(f).Subscribe("rubix/#", datatype.AtMostOnce, RetainSendOnSubscribe, func(msg *MqttMessage) {})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return s.Err() != nil }, time.Second, time.Millisecond)
+	assert.Equal(t, ErrSubscribeNotSupported, s.Err())
+	s.Unsubscribe()
+	assert.Len(t, f.retainHandlings(), 1)
+}
@@ -16,6 +16,18 @@ func (g *GRPCMarshaller) CreatePointHistories(histories []*model.PointHistory, o
 	return true, nil
 }
 
+// PointHistoryBatch carries a dedup key for each point history, like HistoryBatch.
+type PointHistoryBatch struct {
+	Keys           []string              `json:"keys"`
+	PointHistories []*model.PointHistory `json:"point_histories"`
+}
+
+func (g *GRPCMarshaller) CreatePointHistoriesBatch(batch *PointHistoryBatch, opts ...*Opts) error {
+	api := "/api/histories/points/batch"
+	_, err := g.CallDBHelperWithParser(nhttp.POST, api, batch, opts...)
+	return endpointError(api, err)
+}
+
 func (g *GRPCMarshaller) GetPointHistories(opts ...*Opts) ([]*model.PointHistory, error) {
 	api := "/api/histories/points"
 	res, err := g.DbHelper.CallDBHelper(nhttp.GET, api, nil, opts...)
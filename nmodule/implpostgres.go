@@ -3,9 +3,11 @@ package nmodule
 import (
 	"encoding/json"
 	"github.com/NubeIO/lib-module-go/nhttp"
+	"github.com/NubeIO/lib-module-go/pgquery"
 	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
 )
 
+// PostgresRawQuery runs the queries as they are, only use it with trusted sql.
 func (g *GRPCMarshaller) PostgresRawQuery(body *dto.QueryBody, opts ...*Opts) (*dto.QueryResponse, error) {
 	api := "/api/postgres/query-data"
 	res, err := g.CallDBHelperWithParser(nhttp.POST, api, body, opts...)
@@ -19,3 +21,21 @@ func (g *GRPCMarshaller) PostgresRawQuery(body *dto.QueryBody, opts ...*Opts) (*
 	}
 	return history, nil
 }
+
+// PostgresQuery runs a single parameterised statement, it is validated here before it is sent to the host.
+func (g *GRPCMarshaller) PostgresQuery(body *pgquery.Query, opts ...*Opts) (*pgquery.Result, error) {
+	if err := body.Validate(); err != nil {
+		return nil, err
+	}
+	api := "/api/postgres/query"
+	res, err := g.CallDBHelperWithParser(nhttp.POST, api, body, opts...)
+	if err != nil {
+		return nil, err
+	}
+	var result *pgquery.Result
+	err = json.Unmarshal(res, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/NubeIO/lib-module-go/nhttp"
 	"github.com/NubeIO/lib-module-go/proto"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
 	"github.com/hashicorp/go-plugin"
 	"google.golang.org/grpc"
 	"net/http"
@@ -19,6 +20,47 @@ type DBHelper interface {
 	CallDBHelper(method nhttp.Method, api string, body []byte, opts ...*Opts) ([]byte, error)
 }
 
+// RetainHandling follows the mqtt v5 retain handling subscription option.
+type RetainHandling uint32
+
+const (
+	RetainSendOnSubscribe    RetainHandling = iota // retained messages are sent on every (re)subscribe
+	RetainSendOnNewSubscribe                       // retained messages are only sent on the first subscribe
+	RetainDontSend                                 // retained messages are never sent
+)
+
+type MqttMessage struct {
+	Topic     string
+	Payload   []byte
+	Qos       datatype.QOS
+	Retained  bool
+	Duplicate bool
+}
+
+type MqttHandler func(msg *MqttMessage)
+
+// MqttSubscriber is implemented by a DBHelper which can deliver mqtt messages from the host broker. Subscribe blocks
+// and calls handler for every message until ctx is done, it returns an error when the broker connection is lost.
+type MqttSubscriber interface {
+	Subscribe(ctx context.Context, topic string, qos datatype.QOS, retainHandling RetainHandling, handler MqttHandler,
+		opts ...*Opts) error
+}
+
+// ModuleCaller is implemented by a DBHelper which can call the routes of other modules through the host.
+type ModuleCaller interface {
+	CallModule(module string, method nhttp.Method, urlString string, headers http.Header, body []byte,
+		opts ...*Opts) ([]byte, error)
+	GetModules(opts ...*Opts) ([]*Info, error)
+}
+
+// ModuleBroker is implemented by a host DBHelper which brokers calls between modules. caller is the name the host
+// started the calling module with, so a module can't call as another module.
+type ModuleBroker interface {
+	BrokerCallModule(caller, module string, method nhttp.Method, urlString string, headers http.Header, body []byte,
+		opts ...*Opts) ([]byte, error)
+	BrokerGetModules(caller string, opts ...*Opts) ([]*Info, error)
+}
+
 type Info struct {
 	Name       string
 	Author     string
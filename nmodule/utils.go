@@ -2,12 +2,25 @@ package nmodule
 
 import (
 	"errors"
+	"fmt"
 	"google.golang.org/grpc"
 	"strings"
 )
 
 const MaxMessageSize = 50
 
+// ErrEndpointNotSupported is returned by calls to an api an older host doesn't provide yet.
+var ErrEndpointNotSupported = errors.New("api is not supported by the host")
+
+// endpointError wraps the error of a host without the api in ErrEndpointNotSupported, its router answers unknown
+// routes with "404 page not found".
+func endpointError(api string, err error) error {
+	if err != nil && strings.Contains(err.Error(), "404 page not found") {
+		return fmt.Errorf("%w: %s", ErrEndpointNotSupported, api)
+	}
+	return err
+}
+
 func ExtractRPCErrorMessage(err error) error {
 	if err == nil {
 		return nil
@@ -0,0 +1,219 @@
+package pgquery
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultTimeout = 30 * time.Second
+	DefaultMaxRows = 10000
+)
+
+// Query is a single read-only parameterised statement, params are bound to $1, $2, ... and never interpolated into
+// the sql.
+type Query struct {
+	SQL       string        `json:"sql"`
+	Params    []interface{} `json:"params,omitempty"`
+	TimeoutMs int           `json:"timeout_ms,omitempty"`
+	MaxRows   int           `json:"max_rows,omitempty"`
+}
+
+type Result struct {
+	Columns   []string                 `json:"columns"`
+	Rows      []map[string]interface{} `json:"rows"`
+	Truncated bool                     `json:"truncated"`
+}
+
+// NewQuery creates a query with the default timeout and row limit.
+func NewQuery(sql string, params ...interface{}) *Query {
+	return &Query{
+		SQL:       sql,
+		Params:    params,
+		TimeoutMs: int(DefaultTimeout / time.Millisecond),
+		MaxRows:   DefaultMaxRows,
+	}
+}
+
+var (
+	placeholderRegex = regexp.MustCompile(`\$(\d+)`)
+	readOnlyKeywords = map[string]struct{}{"select": {}, "with": {}, "explain": {}, "show": {}, "values": {},
+		"table": {}}
+)
+
+// Validate rejects multiple statements, placeholders without a param and statements which don't start with a reading
+// keyword. The read-only transaction of Execute is what enforces it in the end.
+func (q *Query) Validate() error {
+	stripped, err := stripLiterals(q.SQL)
+	if err != nil {
+		return err
+	}
+	statement := strings.TrimSpace(stripped)
+	statement = strings.TrimSpace(strings.TrimSuffix(statement, ";"))
+	if statement == "" {
+		return errors.New("query is empty")
+	}
+	if strings.Contains(statement, ";") {
+		return errors.New("query must be a single statement")
+	}
+	highest := 0
+	for _, match := range placeholderRegex.FindAllStringSubmatch(statement, -1) {
+		n, _ := strconv.Atoi(match[1])
+		if n == 0 {
+			return errors.New("placeholders start at $1")
+		}
+		if n > highest {
+			highest = n
+		}
+	}
+	if highest != len(q.Params) {
+		return fmt.Errorf("query has %d placeholders but %d params", highest, len(q.Params))
+	}
+	for i, p := range q.Params {
+		switch p.(type) {
+		case nil, bool, string, float64, float32, int, int32, int64, uint, uint32, uint64, time.Time:
+		default:
+			return fmt.Errorf("unsupported type %T of param $%d", p, i+1)
+		}
+	}
+	keyword := strings.ToLower(strings.Fields(statement)[0])
+	if _, ok := readOnlyKeywords[keyword]; !ok {
+		return fmt.Errorf("read-only query can't start with %s", keyword)
+	}
+	if q.TimeoutMs < 0 || q.MaxRows < 0 {
+		return errors.New("timeout and max rows can't be negative")
+	}
+	return nil
+}
+
+// stripLiterals blanks out quoted strings, quoted identifiers and comments so they are ignored by Validate.
+func stripLiterals(query string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"':
+			end := i + 1
+			for ; end < len(query); end++ {
+				if query[end] == c {
+					if end+1 < len(query) && query[end+1] == c {
+						end++
+						continue
+					}
+					break
+				}
+			}
+			if end >= len(query) {
+				return "", errors.New("unterminated quote in query")
+			}
+			b.WriteString(" ")
+			i = end
+		case c == '-' && i+1 < len(query) && query[i+1] == '-':
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end
+			}
+			b.WriteString(" ")
+		case c == '/' && i+1 < len(query) && query[i+1] == '*':
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return "", errors.New("unterminated comment in query")
+			}
+			i += end + 3
+			b.WriteString(" ")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+// Scan decodes the rows into dest, a pointer to a slice of structs or maps, matching columns by json tag.
+func (r *Result) Scan(dest interface{}) error {
+	data, err := json.Marshal(r.Rows)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dest)
+}
+
+// Execute runs the query on db, it is the host side of PostgresQuery. Every query runs in a read-only transaction
+// whatever the module sent, the timeout is applied as statement_timeout and rows past MaxRows are dropped with
+// Truncated set.
+func Execute(ctx context.Context, db *sql.DB, q *Query) (*Result, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	timeout := time.Duration(q.TimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	maxRows := q.MaxRows
+	if maxRows <= 0 {
+		maxRows = DefaultMaxRows
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", timeout.Milliseconds())); err != nil {
+		return nil, err
+	}
+	rows, err := tx.QueryContext(ctx, q.SQL, q.Params...)
+	if err != nil {
+		return nil, err
+	}
+	result, err := collect(rows, maxRows)
+	if closeErr := rows.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, tx.Commit()
+}
+
+func collect(rows *sql.Rows, maxRows int) (*Result, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{Columns: columns, Rows: []map[string]interface{}{}}
+	for rows.Next() {
+		if len(result.Rows) >= maxRows {
+			result.Truncated = true
+			break
+		}
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err = rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			if b, ok := values[i].([]byte); ok {
+				row[column] = string(b)
+			} else {
+				row[column] = values[i]
+			}
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	return result, rows.Err()
+}
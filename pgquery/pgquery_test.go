@@ -0,0 +1,106 @@
+package pgquery
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, NewQuery("SELECT * FROM histories WHERE point_uuid = $1 AND value > $2", "pnt", 1.5).Validate())
+	assert.NoError(t, NewQuery("select ';' as x, \"a;b\" from t -- trailing; comment\n;").Validate())
+	assert.Error(t, NewQuery("SELECT 1; DROP TABLE histories").Validate())
+	assert.Error(t, NewQuery("DELETE FROM histories").Validate())
+	assert.Error(t, NewQuery("SELECT * FROM histories WHERE point_uuid = $1").Validate())
+	assert.Error(t, NewQuery("SELECT 'unterminated").Validate())
+}
+
+func TestScan(t *testing.T) {
+	result := &Result{Rows: []map[string]interface{}{
+		{"point_uuid": "pnt", "value": 1.5},
+		{"point_uuid": "pnt", "value": nil},
+	}}
+	var rows []struct {
+		PointUUID string   `json:"point_uuid"`
+		Value     *float64 `json:"value"`
+	}
+	assert.NoError(t, result.Scan(&rows))
+	assert.Len(t, rows, 2)
+	assert.Equal(t, 1.5, *rows[0].Value)
+	assert.Nil(t, rows[1].Value)
+}
+
+// fakeConn is a database/sql driver that records the transactions and answers every query with one row.
+type fakeConn struct {
+	readOnly []bool
+	queries  []string
+}
+
+func (c *fakeConn) Connect(context.Context) (driver.Conn, error) { return c, nil }
+
+func (c *fakeConn) Driver() driver.Driver { return nil }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+func (c *fakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.readOnly = append(c.readOnly, opts.ReadOnly)
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error { return nil }
+
+func (c *fakeConn) Rollback() error { return nil }
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.queries = append(c.queries, query)
+	return &fakeRows{}, nil
+}
+
+type fakeRows struct {
+	done bool
+}
+
+func (r *fakeRows) Columns() []string { return []string{"value"} }
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = 1.5
+	return nil
+}
+
+func TestExecuteIsReadOnly(t *testing.T) {
+	conn := &fakeConn{}
+	db := sql.OpenDB(conn)
+
+	// a module can't opt out of read-only, the field is not part of the query
+	var q *Query
+	assert.NoError(t, json.Unmarshal([]byte(`{"sql": "DELETE FROM histories", "read_only": false}`), &q))
+	_, err := Execute(context.Background(), db, q)
+	assert.Error(t, err)
+	assert.Empty(t, conn.queries)
+
+	result, err := Execute(context.Background(), db, NewQuery("SELECT value FROM histories"))
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true}, conn.readOnly)
+	assert.Equal(t, 1.5, result.Rows[0]["value"])
+}
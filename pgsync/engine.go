@@ -0,0 +1,272 @@
+package pgsync
+
+import (
+	"fmt"
+	"github.com/NubeIO/lib-module-go/loop"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/nargs"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Store is the postgres side of the sync. InsertHistories must ignore rows it already has, so a batch replayed after
+// a crash between insert and checkpoint doesn't fail the sync.
+type Store interface {
+	UpsertPoints(points []*dto.PointForPostgresSync) error
+	UpsertNetworkTags(tags []*dto.NetworkTagForPostgresSync) error
+	UpsertDeviceTags(tags []*dto.DeviceTagForPostgresSync) error
+	UpsertPointTags(tags []*dto.PointTagForPostgresSync) error
+	UpsertNetworkMetaTags(metaTags []*dto.NetworkMetaTagForPostgresSync) error
+	UpsertDeviceMetaTags(metaTags []*dto.DeviceMetaTagForPostgresSync) error
+	UpsertPointMetaTags(metaTags []*dto.PointMetaTagForPostgresSync) error
+	InsertHistories(histories []*model.History) error
+}
+
+type Config struct {
+	FetchSize int           // histories fetched from the host per round trip
+	BatchSize int           // histories inserted and checkpointed together
+	Interval  time.Duration // how often Start runs a sync
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		FetchSize: 10000,
+		BatchSize: 1000,
+		Interval:  5 * time.Minute,
+	}
+}
+
+type Result struct {
+	Points     int       `json:"points"`
+	Histories  int       `json:"histories"`
+	LastSyncID int       `json:"last_sync_id"`
+	StartedAt  time.Time `json:"started_at"`
+	Took       string    `json:"took"`
+}
+
+type Engine struct {
+	marshaller nmodule.Marshaller
+	store      Store
+	config     *Config
+
+	syncMutex sync.Mutex
+	mutex     sync.Mutex
+	last      *Result
+	loop      loop.Loop
+}
+
+func New(marshaller nmodule.Marshaller, store Store, config *Config) *Engine {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	return &Engine{
+		marshaller: marshaller,
+		store:      store,
+		config:     config,
+	}
+}
+
+// Sync upserts the dimension tables and then copies the histories after the last checkpoint in batches, every batch
+// is checkpointed through UpdateLastSyncHistoryRowForPostgresSync once it is stored.
+func (e *Engine) Sync() (*Result, error) {
+	e.syncMutex.Lock()
+	defer e.syncMutex.Unlock()
+
+	result := &Result{StartedAt: time.Now()}
+	points, err := e.syncDimensions()
+	if err != nil {
+		return nil, err
+	}
+	result.Points = points
+
+	lastSyncID, err := e.marshaller.GetLastSyncHistoryIdForPostgresSync()
+	if err != nil {
+		return nil, err
+	}
+	cursor := &Cursor{Marshaller: e.marshaller, FetchSize: e.config.FetchSize, BatchSize: e.batchSize()}
+	result.Histories, err = cursor.Each(lastSyncID, func(batch []*model.History) error {
+		if err := e.store.InsertHistories(batch); err != nil {
+			return err
+		}
+		if err := e.checkpoint(batch[len(batch)-1]); err != nil {
+			return err
+		}
+		lastSyncID = batch[len(batch)-1].HistoryID
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.LastSyncID = lastSyncID
+	result.Took = time.Since(result.StartedAt).String()
+
+	e.mutex.Lock()
+	e.last = result
+	e.mutex.Unlock()
+	return result, nil
+}
+
+func (e *Engine) syncDimensions() (int, error) {
+	points, err := e.marshaller.GetPointsForPostgresSync()
+	if err != nil {
+		return 0, err
+	}
+	if err = e.store.UpsertPoints(points); err != nil {
+		return 0, err
+	}
+	networkTags, err := e.marshaller.GetNetworksTagsForPostgresSync()
+	if err != nil {
+		return 0, err
+	}
+	if err = e.store.UpsertNetworkTags(networkTags); err != nil {
+		return 0, err
+	}
+	deviceTags, err := e.marshaller.GetDevicesTagsForPostgresSync()
+	if err != nil {
+		return 0, err
+	}
+	if err = e.store.UpsertDeviceTags(deviceTags); err != nil {
+		return 0, err
+	}
+	pointTags, err := e.marshaller.GetPointsTagsForPostgresSync()
+	if err != nil {
+		return 0, err
+	}
+	if err = e.store.UpsertPointTags(pointTags); err != nil {
+		return 0, err
+	}
+	networkMetaTags, err := e.marshaller.GetNetworksMetaTagsForPostgresSync()
+	if err != nil {
+		return 0, err
+	}
+	if err = e.store.UpsertNetworkMetaTags(networkMetaTags); err != nil {
+		return 0, err
+	}
+	deviceMetaTags, err := e.marshaller.GetDevicesMetaTagsForPostgresSync()
+	if err != nil {
+		return 0, err
+	}
+	if err = e.store.UpsertDeviceMetaTags(deviceMetaTags); err != nil {
+		return 0, err
+	}
+	pointMetaTags, err := e.marshaller.GetPointsMetaTagsForPostgresSync()
+	if err != nil {
+		return 0, err
+	}
+	if err = e.store.UpsertPointMetaTags(pointMetaTags); err != nil {
+		return 0, err
+	}
+	return len(points), nil
+}
+
+// Cursor walks the histories of the host after a sync id, it feeds the postgres sync and the export sinks.
+type Cursor struct {
+	Marshaller nmodule.Marshaller
+	FetchSize  int // histories fetched from the host per round trip, all of them when 0
+	BatchSize  int // histories handed to fn together, the whole fetch when 0
+}
+
+// Each calls fn with every batch of histories after lastSyncID in id order, until fn fails or there are no more. It
+// returns how many histories fn accepted.
+func (c *Cursor) Each(lastSyncID int, fn func(batch []*model.History) error) (int, error) {
+	done := 0
+	for {
+		histories, err := c.fetch(lastSyncID)
+		if err != nil {
+			return done, err
+		}
+		if len(histories) == 0 {
+			return done, nil
+		}
+		batchSize := c.BatchSize
+		if batchSize <= 0 {
+			batchSize = len(histories)
+		}
+		for start := 0; start < len(histories); start += batchSize {
+			end := start + batchSize
+			if end > len(histories) {
+				end = len(histories)
+			}
+			batch := histories[start:end]
+			if err = fn(batch); err != nil {
+				return done, err
+			}
+			lastSyncID = batch[len(batch)-1].HistoryID
+			done += len(batch)
+		}
+		if c.FetchSize <= 0 || len(histories) < c.FetchSize {
+			return done, nil
+		}
+	}
+}
+
+// fetch returns the histories after lastSyncID ordered by id, rows at or before the cursor mean the host ignored the
+// id_gt arg and the sync would never make progress.
+func (c *Cursor) fetch(lastSyncID int) ([]*model.History, error) {
+	idGt := strconv.Itoa(lastSyncID)
+	args := &nargs.Args{IdGt: &idGt}
+	if c.FetchSize > 0 {
+		limit := c.FetchSize
+		args.Limit = &limit
+	}
+	histories, err := c.Marshaller.GetHistoriesForPostgresSync(&nmodule.Opts{Args: args})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*model.History, 0, len(histories))
+	for _, h := range histories {
+		if h == nil {
+			continue
+		}
+		if h.HistoryID <= lastSyncID {
+			return nil, fmt.Errorf("host returned history %d at or before the cursor %d, id_gt is not supported",
+				h.HistoryID, lastSyncID)
+		}
+		out = append(out, h)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].HistoryID < out[j].HistoryID })
+	return out, nil
+}
+
+func (e *Engine) checkpoint(last *model.History) error {
+	_, err := e.marshaller.UpdateLastSyncHistoryRowForPostgresSync(&model.HistoryPostgresLog{
+		ID:        last.HistoryID,
+		PointUUID: last.PointUUID,
+		HostUUID:  last.HostUUID,
+		Value:     last.Value,
+		Timestamp: last.Timestamp,
+	})
+	return err
+}
+
+func (e *Engine) batchSize() int {
+	if e.config.BatchSize <= 0 {
+		return 1000
+	}
+	return e.config.BatchSize
+}
+
+// LastResult returns the result of the last successful sync, nil before the first one.
+func (e *Engine) LastResult() *Result {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.last
+}
+
+// Start runs Sync every Interval until Stop, a failed sync is logged and resumed from the last checkpoint next time.
+func (e *Engine) Start() error {
+	return e.loop.Start(e.config.Interval, true, func() {
+		if _, err := e.Sync(); err != nil {
+			log.Errorf("postgres sync: %s", err)
+		}
+	})
+}
+
+func (e *Engine) Stop() {
+	e.loop.Stop()
+}
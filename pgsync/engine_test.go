@@ -0,0 +1,197 @@
+package pgsync
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+)
+
+type fakeMarshaller struct {
+	nmodule.Marshaller
+	histories  []*model.History
+	lastSyncID int
+}
+
+func (f *fakeMarshaller) GetPointsForPostgresSync(opts ...*nmodule.Opts) ([]*dto.PointForPostgresSync, error) {
+	return []*dto.PointForPostgresSync{{UUID: "pnt"}}, nil
+}
+
+func (f *fakeMarshaller) GetNetworksTagsForPostgresSync(opts ...*nmodule.Opts) ([]*dto.NetworkTagForPostgresSync, error) {
+	return nil, nil
+}
+
+func (f *fakeMarshaller) GetDevicesTagsForPostgresSync(opts ...*nmodule.Opts) ([]*dto.DeviceTagForPostgresSync, error) {
+	return nil, nil
+}
+
+func (f *fakeMarshaller) GetPointsTagsForPostgresSync(opts ...*nmodule.Opts) ([]*dto.PointTagForPostgresSync, error) {
+	return nil, nil
+}
+
+func (f *fakeMarshaller) GetNetworksMetaTagsForPostgresSync(opts ...*nmodule.Opts) ([]*dto.NetworkMetaTagForPostgresSync, error) {
+	return nil, nil
+}
+
+func (f *fakeMarshaller) GetDevicesMetaTagsForPostgresSync(opts ...*nmodule.Opts) ([]*dto.DeviceMetaTagForPostgresSync, error) {
+	return nil, nil
+}
+
+func (f *fakeMarshaller) GetPointsMetaTagsForPostgresSync(opts ...*nmodule.Opts) ([]*dto.PointMetaTagForPostgresSync, error) {
+	return nil, nil
+}
+
+func (f *fakeMarshaller) GetLastSyncHistoryIdForPostgresSync(opts ...*nmodule.Opts) (int, error) {
+	return f.lastSyncID, nil
+}
+
+func (f *fakeMarshaller) GetHistoriesForPostgresSync(opts ...*nmodule.Opts) ([]*model.History, error) {
+	idGt, _ := strconv.Atoi(*opts[0].Args.IdGt)
+	var out []*model.History
+	for _, h := range f.histories {
+		if h.HistoryID > idGt && len(out) < *opts[0].Args.Limit {
+			out = append(out, h)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeMarshaller) UpdateLastSyncHistoryRowForPostgresSync(log *model.HistoryPostgresLog, opts ...*nmodule.Opts) (*model.HistoryPostgresLog, error) {
+	f.lastSyncID = log.ID
+	return log, nil
+}
+
+type fakeStore struct {
+	Store
+	histories []*model.History
+	failAfter int
+}
+
+func (f *fakeStore) UpsertPoints(points []*dto.PointForPostgresSync) error { return nil }
+
+func (f *fakeStore) UpsertNetworkTags(tags []*dto.NetworkTagForPostgresSync) error { return nil }
+
+func (f *fakeStore) UpsertDeviceTags(tags []*dto.DeviceTagForPostgresSync) error { return nil }
+
+func (f *fakeStore) UpsertPointTags(tags []*dto.PointTagForPostgresSync) error { return nil }
+
+func (f *fakeStore) UpsertNetworkMetaTags(metaTags []*dto.NetworkMetaTagForPostgresSync) error {
+	return nil
+}
+
+func (f *fakeStore) UpsertDeviceMetaTags(metaTags []*dto.DeviceMetaTagForPostgresSync) error {
+	return nil
+}
+
+func (f *fakeStore) UpsertPointMetaTags(metaTags []*dto.PointMetaTagForPostgresSync) error {
+	return nil
+}
+
+func (f *fakeStore) InsertHistories(histories []*model.History) error {
+	if f.failAfter >= 0 && len(f.histories) >= f.failAfter {
+		return errors.New("connection reset")
+	}
+	f.histories = append(f.histories, histories...)
+	return nil
+}
+
+func TestSyncResumesFromCheckpoint(t *testing.T) {
+	m := &fakeMarshaller{}
+	for i := 1; i <= 7; i++ {
+		m.histories = append(m.histories, &model.History{HistoryID: i, PointUUID: "pnt"})
+	}
+	store := &fakeStore{failAfter: 4}
+	engine := New(m, store, &Config{FetchSize: 3, BatchSize: 2})
+
+	_, err := engine.Sync()
+	assert.Error(t, err)
+	assert.Equal(t, 5, m.lastSyncID)
+
+	store.failAfter = -1
+	result, err := engine.Sync()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Histories)
+	assert.Equal(t, 7, result.LastSyncID)
+	assert.Len(t, store.histories, 7)
+}
+
+type ignoringMarshaller struct {
+	fakeMarshaller
+}
+
+func (f *ignoringMarshaller) GetHistoriesForPostgresSync(opts ...*nmodule.Opts) ([]*model.History, error) {
+	return f.histories, nil
+}
+
+func TestSyncFailsWhenIdGtIsIgnored(t *testing.T) {
+	m := &ignoringMarshaller{fakeMarshaller{lastSyncID: 1}}
+	m.histories = []*model.History{{HistoryID: 1}, {HistoryID: 2}}
+	_, err := New(m, &fakeStore{failAfter: -1}, &Config{FetchSize: 10}).Sync()
+	assert.Error(t, err)
+	assert.Equal(t, 1, m.lastSyncID)
+}
+
+// recorder is a database/sql driver that records the statements it executes.
+type recorder struct {
+	statements []string
+	args       [][]driver.Value
+}
+
+func (r *recorder) Connect(context.Context) (driver.Conn, error) { return r, nil }
+
+func (r *recorder) Driver() driver.Driver { return nil }
+
+func (r *recorder) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (r *recorder) Close() error { return nil }
+
+func (r *recorder) Begin() (driver.Tx, error) { return r, nil }
+
+func (r *recorder) Commit() error { return nil }
+
+func (r *recorder) Rollback() error { return nil }
+
+func (r *recorder) Exec(query string, args []driver.Value) (driver.Result, error) {
+	r.statements = append(r.statements, query)
+	r.args = append(r.args, args)
+	return driver.RowsAffected(0), nil
+}
+
+func TestInsertStatement(t *testing.T) {
+	rows := [][]interface{}{{"a", 1}, {"b", 2}}
+	query, args := insertStatement("points", []string{"uuid", "name"}, []string{"uuid"}, true, rows)
+	assert.Equal(t, "INSERT INTO points (uuid, name) VALUES ($1, $2), ($3, $4) ON CONFLICT (uuid) DO UPDATE SET "+
+		"name = EXCLUDED.name", query)
+	assert.Equal(t, []interface{}{"a", 1, "b", 2}, args)
+
+	query, _ = insertStatement("point_tags", []string{"host_uuid", "tag"}, []string{"host_uuid", "tag"}, false,
+		rows[:1])
+	assert.Equal(t, "INSERT INTO point_tags (host_uuid, tag) VALUES ($1, $2) ON CONFLICT (host_uuid, tag) DO NOTHING",
+		query)
+}
+
+func TestUpsertPointsDedupes(t *testing.T) {
+	r := &recorder{}
+	store := NewSQLStore(sql.OpenDB(r))
+	err := store.UpsertPoints([]*dto.PointForPostgresSync{{UUID: "a", Name: "old"}, {UUID: "b"}, {UUID: "a", Name: "new"}})
+	assert.NoError(t, err)
+	assert.Len(t, r.statements, 1)
+	assert.Len(t, r.args[0], 2*19)
+	assert.Equal(t, "new", r.args[0][1])
+}
+
+func TestTagReplaceClearsEmptyHosts(t *testing.T) {
+	r := &recorder{}
+	store := NewSQLStore(sql.OpenDB(r))
+	assert.NoError(t, store.UpsertPointTags(nil))
+	// a host whose tags were all removed is not in the rows, its old tags still have to go
+	assert.Equal(t, []string{"DELETE FROM point_tags"}, r.statements)
+}
@@ -0,0 +1,264 @@
+package pgsync
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"strings"
+)
+
+// maxParams is the postgres limit of bind parameters per statement.
+const maxParams = 65535
+
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS points (
+		uuid VARCHAR(255) PRIMARY KEY,
+		name TEXT,
+		description TEXT,
+		device_uuid VARCHAR(255),
+		device_name TEXT,
+		device_description TEXT,
+		network_uuid VARCHAR(255),
+		network_name TEXT,
+		network_description TEXT,
+		global_uuid VARCHAR(255),
+		host_uuid VARCHAR(255),
+		host_name TEXT,
+		host_description TEXT,
+		group_uuid VARCHAR(255),
+		group_name TEXT,
+		group_description TEXT,
+		location_uuid VARCHAR(255),
+		location_name TEXT,
+		location_description TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS network_tags (
+		host_uuid VARCHAR(255), network_uuid VARCHAR(255), tag TEXT, PRIMARY KEY (host_uuid, network_uuid, tag)
+	)`,
+	`CREATE TABLE IF NOT EXISTS device_tags (
+		host_uuid VARCHAR(255), device_uuid VARCHAR(255), tag TEXT, PRIMARY KEY (host_uuid, device_uuid, tag)
+	)`,
+	`CREATE TABLE IF NOT EXISTS point_tags (
+		host_uuid VARCHAR(255), point_uuid VARCHAR(255), tag TEXT, PRIMARY KEY (host_uuid, point_uuid, tag)
+	)`,
+	`CREATE TABLE IF NOT EXISTS network_meta_tags (
+		host_uuid VARCHAR(255), network_uuid VARCHAR(255), key TEXT, value TEXT,
+		PRIMARY KEY (host_uuid, network_uuid, key)
+	)`,
+	`CREATE TABLE IF NOT EXISTS device_meta_tags (
+		host_uuid VARCHAR(255), device_uuid VARCHAR(255), key TEXT, value TEXT,
+		PRIMARY KEY (host_uuid, device_uuid, key)
+	)`,
+	`CREATE TABLE IF NOT EXISTS point_meta_tags (
+		host_uuid VARCHAR(255), point_uuid VARCHAR(255), key TEXT, value TEXT,
+		PRIMARY KEY (host_uuid, point_uuid, key)
+	)`,
+	`CREATE TABLE IF NOT EXISTS histories (
+		id BIGINT,
+		point_uuid VARCHAR(255),
+		host_uuid VARCHAR(255),
+		value DOUBLE PRECISION,
+		timestamp TIMESTAMPTZ,
+		PRIMARY KEY (point_uuid, host_uuid, timestamp)
+	)`,
+}
+
+// SQLStore is a Store on a postgres *sql.DB, the module opens the connection with the driver of its choice.
+type SQLStore struct {
+	db *sql.DB
+}
+
+func NewSQLStore(db *sql.DB) *SQLStore {
+	return &SQLStore{db: db}
+}
+
+// Migrate creates the tables when they don't exist yet.
+func (s *SQLStore) Migrate() error {
+	for _, statement := range schema {
+		if _, err := s.db.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) UpsertPoints(points []*dto.PointForPostgresSync) error {
+	columns := []string{"uuid", "name", "description", "device_uuid", "device_name", "device_description",
+		"network_uuid", "network_name", "network_description", "global_uuid", "host_uuid", "host_name",
+		"host_description", "group_uuid", "group_name", "group_description", "location_uuid", "location_name",
+		"location_description"}
+	rows := make([][]interface{}, 0, len(points))
+	for _, p := range points {
+		rows = append(rows, []interface{}{p.UUID, p.Name, p.Description, p.DeviceUUID, p.DeviceName,
+			p.DeviceDescription, p.NetworkUUID, p.NetworkName, p.NetworkDescription, p.GlobalUUID, p.HostUUID,
+			p.HostName, p.HostDescription, p.GroupUUID, p.GroupName, p.GroupDescription, p.LocationUUID,
+			p.LocationName, p.LocationDescription})
+	}
+	return s.withTx(func(tx *sql.Tx) error {
+		return insert(tx, "points", columns, []string{"uuid"}, true, unique(rows))
+	})
+}
+
+func (s *SQLStore) UpsertNetworkTags(tags []*dto.NetworkTagForPostgresSync) error {
+	rows := make([][]interface{}, 0, len(tags))
+	for _, t := range tags {
+		rows = append(rows, []interface{}{t.HostUUID, t.NetworkUUID, t.Tag})
+	}
+	columns := []string{"host_uuid", "network_uuid", "tag"}
+	return s.replace("network_tags", columns, columns, rows)
+}
+
+func (s *SQLStore) UpsertDeviceTags(tags []*dto.DeviceTagForPostgresSync) error {
+	rows := make([][]interface{}, 0, len(tags))
+	for _, t := range tags {
+		rows = append(rows, []interface{}{t.HostUUID, t.DeviceUUID, t.Tag})
+	}
+	columns := []string{"host_uuid", "device_uuid", "tag"}
+	return s.replace("device_tags", columns, columns, rows)
+}
+
+func (s *SQLStore) UpsertPointTags(tags []*dto.PointTagForPostgresSync) error {
+	rows := make([][]interface{}, 0, len(tags))
+	for _, t := range tags {
+		rows = append(rows, []interface{}{t.HostUUID, t.PointUUID, t.Tag})
+	}
+	columns := []string{"host_uuid", "point_uuid", "tag"}
+	return s.replace("point_tags", columns, columns, rows)
+}
+
+func (s *SQLStore) UpsertNetworkMetaTags(metaTags []*dto.NetworkMetaTagForPostgresSync) error {
+	rows := make([][]interface{}, 0, len(metaTags))
+	for _, t := range metaTags {
+		rows = append(rows, []interface{}{t.HostUUID, t.NetworkUUID, t.Key, t.Value})
+	}
+	columns := []string{"host_uuid", "network_uuid", "key", "value"}
+	return s.replace("network_meta_tags", columns, columns[:3], rows)
+}
+
+func (s *SQLStore) UpsertDeviceMetaTags(metaTags []*dto.DeviceMetaTagForPostgresSync) error {
+	rows := make([][]interface{}, 0, len(metaTags))
+	for _, t := range metaTags {
+		rows = append(rows, []interface{}{t.HostUUID, t.DeviceUUID, t.Key, t.Value})
+	}
+	columns := []string{"host_uuid", "device_uuid", "key", "value"}
+	return s.replace("device_meta_tags", columns, columns[:3], rows)
+}
+
+func (s *SQLStore) UpsertPointMetaTags(metaTags []*dto.PointMetaTagForPostgresSync) error {
+	rows := make([][]interface{}, 0, len(metaTags))
+	for _, t := range metaTags {
+		rows = append(rows, []interface{}{t.HostUUID, t.PointUUID, t.Key, t.Value})
+	}
+	columns := []string{"host_uuid", "point_uuid", "key", "value"}
+	return s.replace("point_meta_tags", columns, columns[:3], rows)
+}
+
+func (s *SQLStore) InsertHistories(histories []*model.History) error {
+	columns := []string{"id", "point_uuid", "host_uuid", "value", "timestamp"}
+	rows := make([][]interface{}, 0, len(histories))
+	for _, h := range histories {
+		rows = append(rows, []interface{}{h.HistoryID, h.PointUUID, h.HostUUID, h.Value, h.Timestamp})
+	}
+	return s.withTx(func(tx *sql.Tx) error {
+		return insert(tx, "histories", columns, []string{"point_uuid", "host_uuid", "timestamp"}, false, rows)
+	})
+}
+
+// replace swaps the rows of a tag table, the host returns every tag of every host so tags removed on the host,
+// including the last one of a host, are removed here too.
+func (s *SQLStore) replace(table string, columns, conflict []string, rows [][]interface{}) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s", table)); err != nil {
+			return err
+		}
+		return insert(tx, table, columns, conflict, false, rows)
+	})
+}
+
+// unique keeps the last row of every key in the first column, postgres refuses to update a row twice in one
+// statement.
+func unique(rows [][]interface{}) [][]interface{} {
+	index := make(map[interface{}]int, len(rows))
+	out := make([][]interface{}, 0, len(rows))
+	for _, row := range rows {
+		if i, ok := index[row[0]]; ok {
+			out[i] = row
+			continue
+		}
+		index[row[0]] = len(out)
+		out = append(out, row)
+	}
+	return out
+}
+
+func (s *SQLStore) withTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// insert writes rows with multi-row INSERT statements, conflicting rows are updated when update is set, otherwise
+// they are skipped.
+func insert(tx *sql.Tx, table string, columns, conflict []string, update bool, rows [][]interface{}) error {
+	perStatement := maxParams / len(columns)
+	for start := 0; start < len(rows); start += perStatement {
+		end := start + perStatement
+		if end > len(rows) {
+			end = len(rows)
+		}
+		query, args := insertStatement(table, columns, conflict, update, rows[start:end])
+		if _, err := tx.Exec(query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertStatement(table string, columns, conflict []string, update bool, rows [][]interface{}) (string, []interface{}) {
+	var b strings.Builder
+	args := make([]interface{}, 0, len(rows)*len(columns))
+	fmt.Fprintf(&b, "INSERT INTO %s (%s) VALUES ", table, strings.Join(columns, ", "))
+	for i, row := range rows {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString("(")
+		for j, value := range row {
+			if j > 0 {
+				b.WriteString(", ")
+			}
+			args = append(args, value)
+			fmt.Fprintf(&b, "$%d", len(args))
+		}
+		b.WriteString(")")
+	}
+	fmt.Fprintf(&b, " ON CONFLICT (%s) DO ", strings.Join(conflict, ", "))
+	if !update {
+		b.WriteString("NOTHING")
+		return b.String(), args
+	}
+	var set []string
+	for _, column := range columns {
+		if !contains(conflict, column) {
+			set = append(set, fmt.Sprintf("%s = EXCLUDED.%s", column, column))
+		}
+	}
+	b.WriteString("UPDATE SET " + strings.Join(set, ", "))
+	return b.String(), args
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,567 @@
+package poller
+
+import (
+	"container/heap"
+	"errors"
+	"github.com/NubeIO/lib-module-go/cov"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	log "github.com/sirupsen/logrus"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Handler does the protocol specific I/O; the Poller takes care of when to call it and of reporting the outcome.
+type Handler interface {
+	ReadPoint(device *model.Device, point *model.Point) (float64, error)
+	WritePoint(device *model.Device, point *model.Point, value float64) (float64, error)
+}
+
+type Config struct {
+	FastRate   time.Duration // used when the device has no fast_poll_rate
+	NormalRate time.Duration // used when the device has no normal_poll_rate
+	SlowRate   time.Duration // used when the device has no slow_poll_rate
+
+	DeviceDelay time.Duration // minimum gap between two operations on one device, device delay_between_points_ms wins
+	Jitter      float64       // fraction of the interval added at random to spread the load, 0 disables
+
+	AdaptiveFactor float64 // interval is stretched to execution time * AdaptiveFactor on slow devices, 0 disables
+	MaxStretch     float64 // upper bound of the stretched interval as a multiple of the configured one
+
+	DeviceFaultThreshold int           // consecutive failures before a device is faulted and backed off
+	BackoffBase          time.Duration // first back-off step of a faulted device
+	BackoffMax           time.Duration // back-off cap of a faulted device
+
+	Workers int // devices polled at once, 8 when zero, operations on one device never overlap
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		FastRate:             5 * time.Second,
+		NormalRate:           30 * time.Second,
+		SlowRate:             120 * time.Second,
+		DeviceDelay:          0,
+		Jitter:               0.1,
+		AdaptiveFactor:       2,
+		MaxStretch:           10,
+		DeviceFaultThreshold: 3,
+		BackoffBase:          10 * time.Second,
+		BackoffMax:           10 * time.Minute,
+		Workers:              8,
+	}
+}
+
+type deviceState struct {
+	device       *model.Device
+	nextAllowed  time.Time
+	failures     int
+	inFault      bool
+	backoffUntil time.Time
+	busy         bool
+}
+
+type pointState struct {
+	item     *item
+	inFault  bool
+	reported datatype.PointState // last state sent to the host
+}
+
+type Poller struct {
+	marshaller nmodule.Marshaller
+	handler    Handler
+	config     *Config
+	filter     *cov.Filter
+
+	mutex    sync.Mutex
+	queue    *schedules
+	devices  map[string]*deviceState
+	points   map[string]*pointState
+	inFlight int
+	wake     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	running  bool
+}
+
+// New creates a Poller, a nil config falls back to DefaultConfig.
+func New(marshaller nmodule.Marshaller, handler Handler, config *Config) *Poller {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	return &Poller{
+		marshaller: marshaller,
+		handler:    handler,
+		config:     config,
+		queue:      newSchedules(),
+		devices:    make(map[string]*deviceState),
+		points:     make(map[string]*pointState),
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// Load registers every enabled device and point of the network, it expects the network to be fetched with devices and
+// points.
+func (p *Poller) Load(network *model.Network) {
+	for _, device := range network.Devices {
+		if !isEnabled(device.Enable) {
+			continue
+		}
+		p.UpsertDevice(device)
+		for _, point := range device.Points {
+			if !isEnabled(point.Enable) {
+				continue
+			}
+			p.UpsertPoint(point)
+		}
+	}
+}
+
+// SetFilter routes read values through the cov filter so only real changes reach the host, writes are always sent.
+func (p *Poller) SetFilter(filter *cov.Filter) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.filter = filter
+}
+
+// UpsertDevice adds or replaces a device, points upserted before their device are polled from now on.
+func (p *Poller) UpsertDevice(device *model.Device) {
+	p.mutex.Lock()
+	if ds, ok := p.devices[device.UUID]; ok {
+		ds.device = device
+		p.mutex.Unlock()
+		return
+	}
+	p.devices[device.UUID] = &deviceState{device: device}
+	for _, ps := range p.points {
+		if ps.item.parked && ps.item.deviceUUID == device.UUID {
+			p.queue.schedule(ps.item, time.Now())
+		}
+	}
+	p.mutex.Unlock()
+	p.signal()
+}
+
+// RemoveDevice stops polling the device and all of its points.
+func (p *Poller) RemoveDevice(deviceUUID string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for uuid, ps := range p.points {
+		if ps.item.deviceUUID == deviceUUID {
+			p.queue.remove(ps.item)
+			delete(p.points, uuid)
+		}
+	}
+	delete(p.devices, deviceUUID)
+}
+
+// UpsertPoint adds or replaces a point; points flagged with write_required are queued for writing ahead of all
+// polls.
+func (p *Poller) UpsertPoint(point *model.Point) {
+	p.mutex.Lock()
+	ps, ok := p.points[point.UUID]
+	if !ok {
+		ps = &pointState{item: &item{index: -1}}
+		p.points[point.UUID] = ps
+	}
+	ps.item.point = point
+	ps.item.deviceUUID = point.DeviceUUID
+	ps.item.writePending = isEnabled(point.WritePollRequired) && point.WriteValue != nil
+	if !ok || ps.item.writePending {
+		p.queue.schedule(ps.item, time.Now())
+	}
+	p.mutex.Unlock()
+	p.signal()
+}
+
+// RequestWrite queues a pending write for a copy of the point, it is typically called from a module route once the
+// host has written a new value.
+func (p *Poller) RequestWrite(point *model.Point) {
+	writeRequired := true
+	pending := *point
+	pending.WritePollRequired = &writeRequired
+	p.UpsertPoint(&pending)
+}
+
+func (p *Poller) RemovePoint(pointUUID string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if ps, ok := p.points[pointUUID]; ok {
+		p.queue.remove(ps.item)
+		delete(p.points, pointUUID)
+	}
+}
+
+func (p *Poller) Start() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.running {
+		return errors.New("poller is already running")
+	}
+	p.running = true
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+	go p.run()
+	return nil
+}
+
+// Stop waits for the in-flight operation to finish before returning.
+func (p *Poller) Stop() {
+	p.mutex.Lock()
+	if !p.running {
+		p.mutex.Unlock()
+		return
+	}
+	p.running = false
+	close(p.stop)
+	p.mutex.Unlock()
+	<-p.done
+}
+
+func (p *Poller) signal() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *Poller) run() {
+	defer close(p.done)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		next, wait := p.next()
+		if next != nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				p.execute(next)
+			}()
+			continue
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-p.stop:
+			return
+		case <-p.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// next returns a due item whose device is idle and allowed to be polled, otherwise it returns how long to sleep.
+// Finished operations wake the run loop, so a busy device or a full worker pool doesn't need a timeout.
+func (p *Poller) next() (*item, time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.inFlight >= p.workers() {
+		return nil, time.Hour
+	}
+	now := time.Now()
+	p.queue.promote(now)
+	var busy []*item
+	defer func() {
+		for _, it := range busy {
+			heap.Push(p.queue.ready, it)
+		}
+	}()
+	for p.queue.ready.Len() > 0 {
+		head := heap.Pop(p.queue.ready).(*item)
+		ds, ok := p.devices[head.deviceUUID]
+		if !ok {
+			log.Debugf("poller: point %s waits for its device %s", head.point.UUID, head.deviceUUID)
+			head.parked = true
+			continue
+		}
+		if ds.busy {
+			busy = append(busy, head)
+			continue
+		}
+		if gate := ds.gate(); gate.After(now) {
+			p.queue.schedule(head, gate)
+			continue
+		}
+		ds.busy = true
+		p.inFlight++
+		return head, 0
+	}
+	if p.queue.waiting.Len() > 0 {
+		return nil, p.queue.waiting.head().due.Sub(now)
+	}
+	return nil, time.Hour
+}
+
+func (p *Poller) workers() int {
+	if p.config.Workers <= 0 {
+		return 8
+	}
+	return p.config.Workers
+}
+
+func (ds *deviceState) gate() time.Time {
+	if ds.backoffUntil.After(ds.nextAllowed) {
+		return ds.backoffUntil
+	}
+	return ds.nextAllowed
+}
+
+func (p *Poller) execute(it *item) {
+	defer p.signal()
+	p.mutex.Lock()
+	ds, ok := p.devices[it.deviceUUID]
+	if !ok {
+		p.inFlight--
+		p.mutex.Unlock()
+		return
+	}
+	device, point, write := ds.device, it.point, it.writePending
+	p.mutex.Unlock()
+
+	start := time.Now()
+	var value float64
+	var err error
+	if write {
+		value, err = p.handler.WritePoint(device, point, *point.WriteValue)
+	} else {
+		value, err = p.handler.ReadPoint(device, point)
+	}
+	took := time.Since(start)
+
+	p.mutex.Lock()
+	ds.busy = false
+	p.inFlight--
+	ps, tracked := p.points[point.UUID]
+	if tracked && ps.item != it {
+		tracked = false
+	}
+	deviceFaultChanged := ds.record(err, p.config, p.deviceDelay(device))
+	deviceInFault := ds.inFault
+	pointFaultChanged := false
+	if tracked {
+		pointFaultChanged = ps.inFault != (err != nil)
+		ps.inFault = err != nil
+		if err == nil && write && it.point == point {
+			it.writePending = false
+		}
+		base := p.interval(device, point)
+		it.interval = p.adapt(base, took)
+		if !it.writePending {
+			p.queue.schedule(it, time.Now().Add(p.jitter(it.interval)))
+		} else {
+			p.queue.schedule(it, time.Now())
+		}
+	}
+	p.mutex.Unlock()
+
+	if deviceFaultChanged {
+		p.reportDeviceFault(device, deviceInFault, err)
+	}
+	if tracked {
+		p.reportPoint(point, write, value, err, pointFaultChanged)
+	}
+}
+
+// record updates the device health after an operation and reports whether its fault state changed.
+func (ds *deviceState) record(err error, config *Config, delay time.Duration) bool {
+	now := time.Now()
+	ds.nextAllowed = now.Add(delay)
+	if err == nil {
+		ds.failures = 0
+		ds.backoffUntil = time.Time{}
+		if ds.inFault {
+			ds.inFault = false
+			return true
+		}
+		return false
+	}
+	ds.failures++
+	if config.DeviceFaultThreshold <= 0 || ds.failures < config.DeviceFaultThreshold {
+		return false
+	}
+	backoff := config.BackoffBase << uint(ds.failures-config.DeviceFaultThreshold)
+	if backoff <= 0 || backoff > config.BackoffMax {
+		backoff = config.BackoffMax
+	}
+	ds.backoffUntil = now.Add(backoff)
+	if !ds.inFault {
+		ds.inFault = true
+		return true
+	}
+	return false
+}
+
+func (p *Poller) deviceDelay(device *model.Device) time.Duration {
+	if device.DelayBetweenPointsMs != nil {
+		return time.Duration(*device.DelayBetweenPointsMs) * time.Millisecond
+	}
+	return p.config.DeviceDelay
+}
+
+// interval resolves the point poll rate against the device rates (in seconds), falling back to the config.
+func (p *Poller) interval(device *model.Device, point *model.Point) time.Duration {
+	switch point.PollRate {
+	case datatype.RateFast:
+		return rateOrDefault(device.FastPollRate, p.config.FastRate)
+	case datatype.RateSlow:
+		return rateOrDefault(device.SlowPollRate, p.config.SlowRate)
+	default:
+		return rateOrDefault(device.NormalPollRate, p.config.NormalRate)
+	}
+}
+
+func (p *Poller) adapt(base, took time.Duration) time.Duration {
+	if p.config.AdaptiveFactor <= 0 {
+		return base
+	}
+	stretched := time.Duration(float64(took) * p.config.AdaptiveFactor)
+	if stretched <= base {
+		return base
+	}
+	if p.config.MaxStretch > 1 {
+		if limit := time.Duration(float64(base) * p.config.MaxStretch); stretched > limit {
+			return limit
+		}
+	}
+	return stretched
+}
+
+func (p *Poller) jitter(interval time.Duration) time.Duration {
+	if p.config.Jitter <= 0 || interval <= 0 {
+		return interval
+	}
+	return interval + time.Duration(rand.Float64()*p.config.Jitter*float64(interval))
+}
+
+func (p *Poller) reportPoint(point *model.Point, write bool, value float64, err error, faultChanged bool) {
+	if err != nil {
+		if faultChanged {
+			fault := &model.CommonFault{
+				InFault:      true,
+				MessageLevel: dto.MessageLevel.Fail,
+				MessageCode:  dto.CommonFaultCode.PointError,
+				Message:      err.Error(),
+				LastFail:     time.Now().UTC(),
+			}
+			if write {
+				fault.MessageCode = dto.CommonFaultCode.PointWriteError
+			}
+			if e := p.marshaller.UpdatePointFault(point.UUID, fault); e != nil {
+				log.Errorf("poller: failed to update fault of point %s: %s", point.UUID, e)
+			}
+		}
+		if e := p.marshaller.UpdatePointState(point.UUID, datatype.PointStatePollFailed); e != nil {
+			log.Errorf("poller: failed to update state of point %s: %s", point.UUID, e)
+		} else {
+			p.setReported(point.UUID, datatype.PointStatePollFailed)
+		}
+		return
+	}
+	p.mutex.Lock()
+	filter := p.filter
+	p.mutex.Unlock()
+	pollState := datatype.PointStatePollOk
+	if write {
+		pollState = datatype.PointStateWriteOk
+	}
+	if filter != nil && !write {
+		// the filter only holds back the value, the poll state still reaches the host, e.g. after a failed poll
+		sent, e := filter.Update(point.UUID, value)
+		if e != nil {
+			log.Errorf("poller: failed to write value of point %s: %s", point.UUID, e)
+		} else if sent {
+			p.setReported(point.UUID, pollState)
+		} else if p.reportedState(point.UUID) != pollState {
+			if e = p.marshaller.UpdatePointState(point.UUID, pollState); e != nil {
+				log.Errorf("poller: failed to update state of point %s: %s", point.UUID, e)
+			} else {
+				p.setReported(point.UUID, pollState)
+			}
+		}
+	} else if _, e := p.marshaller.PointWrite(point.UUID, &dto.PointWriter{
+		OriginalValue: &value,
+		PollState:     pollState,
+		Message:       "",
+		Fault:         false,
+	}); e != nil {
+		log.Errorf("poller: failed to write value of point %s: %s", point.UUID, e)
+	} else {
+		p.setReported(point.UUID, pollState)
+	}
+	if write {
+		writeRequired := false
+		if e := p.marshaller.UpdatePointPollState(point.UUID, dto.PointPollState{
+			WritePollRequired: &writeRequired,
+		}); e != nil {
+			log.Errorf("poller: failed to update poll state of point %s: %s", point.UUID, e)
+		}
+	}
+	if faultChanged {
+		if e := p.marshaller.UpdatePointFault(point.UUID, &model.CommonFault{
+			InFault:      false,
+			MessageLevel: dto.MessageLevel.Info,
+			MessageCode:  dto.CommonFaultCode.Ok,
+			LastOk:       time.Now().UTC(),
+		}); e != nil {
+			log.Errorf("poller: failed to clear fault of point %s: %s", point.UUID, e)
+		}
+	}
+}
+
+func (p *Poller) setReported(pointUUID string, state datatype.PointState) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if ps, ok := p.points[pointUUID]; ok {
+		ps.reported = state
+	}
+}
+
+func (p *Poller) reportedState(pointUUID string) datatype.PointState {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if ps, ok := p.points[pointUUID]; ok {
+		return ps.reported
+	}
+	return ""
+}
+
+func (p *Poller) reportDeviceFault(device *model.Device, inFault bool, err error) {
+	fault := &model.CommonFault{
+		InFault:      false,
+		MessageLevel: dto.MessageLevel.Info,
+		MessageCode:  dto.CommonFaultCode.Ok,
+		LastOk:       time.Now().UTC(),
+	}
+	if inFault {
+		fault = &model.CommonFault{
+			InFault:      true,
+			MessageLevel: dto.MessageLevel.Fail,
+			MessageCode:  dto.CommonFaultCode.DeviceError,
+			Message:      err.Error(),
+			LastFail:     time.Now().UTC(),
+		}
+	}
+	if e := p.marshaller.UpdateDeviceFault(device.UUID, fault); e != nil {
+		log.Errorf("poller: failed to update fault of device %s: %s", device.UUID, e)
+	}
+}
+
+func rateOrDefault(seconds *float64, fallback time.Duration) time.Duration {
+	if seconds == nil || *seconds <= 0 {
+		return fallback
+	}
+	return time.Duration(*seconds * float64(time.Second))
+}
+
+func isEnabled(b *bool) bool {
+	return b != nil && *b
+}
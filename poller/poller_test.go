@@ -0,0 +1,203 @@
+package poller
+
+import (
+	"errors"
+	"github.com/NubeIO/lib-module-go/cov"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeMarshaller struct {
+	nmodule.Marshaller
+	mutex        sync.Mutex
+	values       map[string]float64
+	pointFaults  map[string]int
+	deviceFaults []bool
+	states       []datatype.PointState
+}
+
+func (f *fakeMarshaller) PointWrite(uuid string, body *dto.PointWriter, opts ...*nmodule.Opts) (*dto.PointWriteResponse, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.values[uuid] = *body.OriginalValue
+	return &dto.PointWriteResponse{}, nil
+}
+
+func (f *fakeMarshaller) UpdatePointFault(uuid string, body *model.CommonFault, opts ...*nmodule.Opts) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.pointFaults[uuid]++
+	return nil
+}
+
+func (f *fakeMarshaller) UpdatePointState(uuid string, body datatype.PointState, opts ...*nmodule.Opts) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.states = append(f.states, body)
+	return nil
+}
+
+func (f *fakeMarshaller) UpdatePointPollState(uuid string, body dto.PointPollState, opts ...*nmodule.Opts) error {
+	return nil
+}
+
+func (f *fakeMarshaller) UpdateDeviceFault(uuid string, body *model.CommonFault, opts ...*nmodule.Opts) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.deviceFaults = append(f.deviceFaults, body.InFault)
+	return nil
+}
+
+type fakeHandler struct {
+	mutex sync.Mutex
+	order []string
+	fail  bool
+}
+
+func (h *fakeHandler) ReadPoint(device *model.Device, point *model.Point) (float64, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.order = append(h.order, "read:"+point.UUID)
+	if h.fail {
+		return 0, errors.New("timeout")
+	}
+	return 1, nil
+}
+
+func (h *fakeHandler) WritePoint(device *model.Device, point *model.Point, value float64) (float64, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.order = append(h.order, "write:"+point.UUID)
+	return value, nil
+}
+
+func newFakeMarshaller() *fakeMarshaller {
+	return &fakeMarshaller{values: map[string]float64{}, pointFaults: map[string]int{}}
+}
+
+func TestWritePendingIsPolledFirst(t *testing.T) {
+	m := newFakeMarshaller()
+	h := &fakeHandler{}
+	p := New(m, h, &Config{NormalRate: time.Hour})
+	p.UpsertDevice(&model.Device{CommonUUID: model.CommonUUID{UUID: "dev"}})
+
+	writeValue := 22.0
+	writeRequired := true
+	p.UpsertPoint(&model.Point{CommonUUID: model.CommonUUID{UUID: "low"}, DeviceUUID: "dev",
+		PollPriority: datatype.PriorityLow})
+	p.UpsertPoint(&model.Point{CommonUUID: model.CommonUUID{UUID: "asap"}, DeviceUUID: "dev",
+		PollPriority: datatype.PriorityASAP})
+	p.UpsertPoint(&model.Point{CommonUUID: model.CommonUUID{UUID: "out"}, DeviceUUID: "dev",
+		PollPriority: datatype.PriorityLow, WriteValue: &writeValue, WritePollRequired: &writeRequired})
+
+	// the polls are overdue, the write is only due now and still goes first
+	now := time.Now()
+	p.queue.schedule(p.points["low"].item, now.Add(-time.Minute))
+	p.queue.schedule(p.points["asap"].item, now.Add(-time.Second))
+	p.queue.schedule(p.points["out"].item, now)
+
+	assert.NoError(t, p.Start())
+	assert.Eventually(t, func() bool {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		return len(h.order) == 3
+	}, time.Second, 5*time.Millisecond)
+	p.Stop()
+
+	assert.Equal(t, []string{"write:out", "read:asap", "read:low"}, h.order)
+	assert.Equal(t, 22.0, m.values["out"])
+}
+
+func TestDeviceFaultAndBackoff(t *testing.T) {
+	m := newFakeMarshaller()
+	h := &fakeHandler{fail: true}
+	p := New(m, h, &Config{NormalRate: time.Millisecond, DeviceFaultThreshold: 2, BackoffBase: time.Hour,
+		BackoffMax: time.Hour})
+	p.UpsertDevice(&model.Device{CommonUUID: model.CommonUUID{UUID: "dev"}})
+	p.UpsertPoint(&model.Point{CommonUUID: model.CommonUUID{UUID: "pnt"}, DeviceUUID: "dev"})
+
+	assert.NoError(t, p.Start())
+	assert.Eventually(t, func() bool {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		return len(m.deviceFaults) == 1
+	}, time.Second, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	p.Stop()
+
+	// the device is backed off after the threshold so no more reads happen
+	assert.Len(t, h.order, 2)
+	assert.Equal(t, []bool{true}, m.deviceFaults)
+	// the point fault is only written on the transition
+	assert.Equal(t, 1, m.pointFaults["pnt"])
+}
+
+func TestPointBeforeDevice(t *testing.T) {
+	m := newFakeMarshaller()
+	h := &fakeHandler{}
+	p := New(m, h, &Config{NormalRate: time.Hour})
+	point := &model.Point{CommonUUID: model.CommonUUID{UUID: "pnt"}, DeviceUUID: "dev"}
+	p.RequestWrite(point)
+	assert.Nil(t, point.WritePollRequired)
+
+	assert.NoError(t, p.Start())
+	defer p.Stop()
+	time.Sleep(10 * time.Millisecond)
+	p.UpsertDevice(&model.Device{CommonUUID: model.CommonUUID{UUID: "dev"}})
+	assert.Eventually(t, func() bool {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		return len(h.order) == 1
+	}, time.Second, 5*time.Millisecond)
+}
+
+type slowHandler struct {
+	fakeHandler
+	block chan struct{}
+}
+
+func (h *slowHandler) ReadPoint(device *model.Device, point *model.Point) (float64, error) {
+	if device.UUID == "slow" {
+		<-h.block
+	}
+	return h.fakeHandler.ReadPoint(device, point)
+}
+
+func TestSlowDeviceDoesNotStallOthers(t *testing.T) {
+	m := newFakeMarshaller()
+	h := &slowHandler{block: make(chan struct{})}
+	p := New(m, h, &Config{NormalRate: time.Hour})
+	for _, uuid := range []string{"slow", "fast"} {
+		p.UpsertDevice(&model.Device{CommonUUID: model.CommonUUID{UUID: uuid}})
+		p.UpsertPoint(&model.Point{CommonUUID: model.CommonUUID{UUID: uuid + "-pnt"}, DeviceUUID: uuid})
+	}
+	assert.NoError(t, p.Start())
+	assert.Eventually(t, func() bool {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		return len(h.order) == 1 && h.order[0] == "read:fast-pnt"
+	}, time.Second, 5*time.Millisecond)
+	close(h.block)
+	p.Stop()
+}
+
+func TestRecoveredPointStateWithFilter(t *testing.T) {
+	m := newFakeMarshaller()
+	p := New(m, &fakeHandler{}, &Config{NormalRate: time.Hour})
+	p.SetFilter(cov.New(m, nil))
+	p.UpsertDevice(&model.Device{CommonUUID: model.CommonUUID{UUID: "dev"}})
+	p.UpsertPoint(&model.Point{CommonUUID: model.CommonUUID{UUID: "pnt"}, DeviceUUID: "dev"})
+
+	p.reportPoint(p.points["pnt"].item.point, false, 1, nil, false)
+	p.reportPoint(p.points["pnt"].item.point, false, 0, errors.New("timeout"), true)
+	// the value didn't change so the filter drops it, the state must still recover
+	p.reportPoint(p.points["pnt"].item.point, false, 1, nil, true)
+	p.reportPoint(p.points["pnt"].item.point, false, 1, nil, false)
+	assert.Equal(t, []datatype.PointState{datatype.PointStatePollFailed, datatype.PointStatePollOk}, m.states)
+}
@@ -0,0 +1,121 @@
+package poller
+
+import (
+	"container/heap"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"time"
+)
+
+// writePendingRank sorts write-pending points ahead of every poll priority.
+const writePendingRank = -1
+
+var priorityRank = map[datatype.PollPriority]int{
+	datatype.PriorityASAP:   0,
+	datatype.PriorityHigh:   1,
+	datatype.PriorityNormal: 2,
+	datatype.PriorityLow:    3,
+}
+
+type item struct {
+	point        *model.Point
+	deviceUUID   string
+	due          time.Time
+	interval     time.Duration
+	writePending bool
+	parked       bool // waiting for its device to be upserted
+	queue        *queue
+	index        int
+}
+
+func (i *item) rank() int {
+	if i.writePending {
+		return writePendingRank
+	}
+	if r, ok := priorityRank[i.point.PollPriority]; ok {
+		return r
+	}
+	return priorityRank[datatype.PriorityNormal]
+}
+
+func byDue(a, b *item) bool {
+	if a.due.Equal(b.due) {
+		return a.rank() < b.rank()
+	}
+	return a.due.Before(b.due)
+}
+
+// byRank orders the items which are already due, so a pending write goes ahead of every overdue poll.
+func byRank(a, b *item) bool {
+	if a.rank() == b.rank() {
+		return a.due.Before(b.due)
+	}
+	return a.rank() < b.rank()
+}
+
+// queue is a min-heap of items ordered by less.
+type queue struct {
+	items []*item
+	less  func(a, b *item) bool
+}
+
+func (q *queue) Len() int { return len(q.items) }
+
+func (q *queue) Less(i, j int) bool { return q.less(q.items[i], q.items[j]) }
+
+func (q *queue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+	q.items[i].index = i
+	q.items[j].index = j
+}
+
+func (q *queue) Push(x interface{}) {
+	it := x.(*item)
+	it.index = len(q.items)
+	it.queue = q
+	q.items = append(q.items, it)
+}
+
+func (q *queue) Pop() interface{} {
+	n := len(q.items)
+	it := q.items[n-1]
+	q.items[n-1] = nil
+	it.index = -1
+	it.queue = nil
+	q.items = q.items[:n-1]
+	return it
+}
+
+func (q *queue) head() *item {
+	return q.items[0]
+}
+
+// schedules holds the items waiting for their due time and the items which are due and wait for their device.
+type schedules struct {
+	waiting *queue
+	ready   *queue
+}
+
+func newSchedules() *schedules {
+	return &schedules{waiting: &queue{less: byDue}, ready: &queue{less: byRank}}
+}
+
+func (s *schedules) schedule(it *item, due time.Time) {
+	s.remove(it)
+	it.parked = false
+	it.due = due
+	heap.Push(s.waiting, it)
+}
+
+func (s *schedules) remove(it *item) {
+	if it.queue != nil {
+		heap.Remove(it.queue, it.index)
+	}
+}
+
+// promote moves the items which are due at now to the ready queue.
+func (s *schedules) promote(now time.Time) {
+	for s.waiting.Len() > 0 && !s.waiting.head().due.After(now) {
+		heap.Push(s.ready, heap.Pop(s.waiting))
+	}
+}
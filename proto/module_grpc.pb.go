@@ -296,6 +296,9 @@ var Module_ServiceDesc = grpc.ServiceDesc{
 
 const (
 	DBHelper_CallDBHelper_FullMethodName = "/proto.DBHelper/CallDBHelper"
+	DBHelper_Subscribe_FullMethodName    = "/proto.DBHelper/Subscribe"
+	DBHelper_CallModule_FullMethodName   = "/proto.DBHelper/CallModule"
+	DBHelper_GetModules_FullMethodName   = "/proto.DBHelper/GetModules"
 )
 
 // DBHelperClient is the client API for DBHelper service.
@@ -303,6 +306,9 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type DBHelperClient interface {
 	CallDBHelper(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error)
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (DBHelper_SubscribeClient, error)
+	CallModule(ctx context.Context, in *ModuleCallRequest, opts ...grpc.CallOption) (*Response, error)
+	GetModules(ctx context.Context, in *ModulesRequest, opts ...grpc.CallOption) (*ModulesResponse, error)
 }
 
 type dBHelperClient struct {
@@ -322,11 +328,64 @@ func (c *dBHelperClient) CallDBHelper(ctx context.Context, in *Request, opts ...
 	return out, nil
 }
 
+func (c *dBHelperClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (DBHelper_SubscribeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &DBHelper_ServiceDesc.Streams[0], DBHelper_Subscribe_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &dBHelperSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type DBHelper_SubscribeClient interface {
+	Recv() (*MqttMessage, error)
+	grpc.ClientStream
+}
+
+type dBHelperSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *dBHelperSubscribeClient) Recv() (*MqttMessage, error) {
+	m := new(MqttMessage)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *dBHelperClient) CallModule(ctx context.Context, in *ModuleCallRequest, opts ...grpc.CallOption) (*Response, error) {
+	out := new(Response)
+	err := c.cc.Invoke(ctx, DBHelper_CallModule_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dBHelperClient) GetModules(ctx context.Context, in *ModulesRequest, opts ...grpc.CallOption) (*ModulesResponse, error) {
+	out := new(ModulesResponse)
+	err := c.cc.Invoke(ctx, DBHelper_GetModules_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // DBHelperServer is the server API for DBHelper service.
 // All implementations should embed UnimplementedDBHelperServer
 // for forward compatibility
 type DBHelperServer interface {
 	CallDBHelper(context.Context, *Request) (*Response, error)
+	Subscribe(*SubscribeRequest, DBHelper_SubscribeServer) error
+	CallModule(context.Context, *ModuleCallRequest) (*Response, error)
+	GetModules(context.Context, *ModulesRequest) (*ModulesResponse, error)
 }
 
 // UnimplementedDBHelperServer should be embedded to have forward compatible implementations.
@@ -336,6 +395,15 @@ type UnimplementedDBHelperServer struct {
 func (UnimplementedDBHelperServer) CallDBHelper(context.Context, *Request) (*Response, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method CallDBHelper not implemented")
 }
+func (UnimplementedDBHelperServer) Subscribe(*SubscribeRequest, DBHelper_SubscribeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedDBHelperServer) CallModule(context.Context, *ModuleCallRequest) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CallModule not implemented")
+}
+func (UnimplementedDBHelperServer) GetModules(context.Context, *ModulesRequest) (*ModulesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetModules not implemented")
+}
 
 // UnsafeDBHelperServer may be embedded to opt out of forward compatibility for this service.
 // Use of this interface is not recommended, as added methods to DBHelperServer will
@@ -366,6 +434,63 @@ func _DBHelper_CallDBHelper_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _DBHelper_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DBHelperServer).Subscribe(m, &dBHelperSubscribeServer{stream})
+}
+
+type DBHelper_SubscribeServer interface {
+	Send(*MqttMessage) error
+	grpc.ServerStream
+}
+
+type dBHelperSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *dBHelperSubscribeServer) Send(m *MqttMessage) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _DBHelper_CallModule_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ModuleCallRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DBHelperServer).CallModule(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DBHelper_CallModule_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DBHelperServer).CallModule(ctx, req.(*ModuleCallRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DBHelper_GetModules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ModulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DBHelperServer).GetModules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DBHelper_GetModules_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DBHelperServer).GetModules(ctx, req.(*ModulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // DBHelper_ServiceDesc is the grpc.ServiceDesc for DBHelper service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -377,7 +502,21 @@ var DBHelper_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "CallDBHelper",
 			Handler:    _DBHelper_CallDBHelper_Handler,
 		},
+		{
+			MethodName: "CallModule",
+			Handler:    _DBHelper_CallModule_Handler,
+		},
+		{
+			MethodName: "GetModules",
+			Handler:    _DBHelper_GetModules_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _DBHelper_Subscribe_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "module.proto",
 }
@@ -0,0 +1,323 @@
+package reboot
+
+import (
+	"context"
+	"fmt"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	systats "github.com/NubeIO/lib-system"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/nargs"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Check string
+
+const (
+	CheckPendingWrites Check = "pending-writes" // points with a write not yet sent to the field
+	CheckActiveAlerts  Check = "active-alerts"  // active alerts raised on the host
+)
+
+type Status string
+
+const (
+	Blocked     Status = "blocked"      // a pre-check failed, the host was not rebooted
+	Failed      Status = "failed"       // the host was unreachable or refused the reboot
+	Returned    Status = "returned"     // the host rebooted and came back
+	NotReturned Status = "not-returned" // the host rebooted and did not come back within ReturnTimeout
+	Cancelled   Status = "cancelled"    // the rollout halted before the host was reached or while it was awaited
+)
+
+type Config struct {
+	Checks        []Check       // pre-checks run before each stage, all checks when nil
+	StageSize     int           // hosts rebooted at once, 1 when zero
+	FirstStage    int           // size of the first stage to reboot a few hosts first, StageSize when zero
+	StageDelay    time.Duration // wait between stages
+	MaxFailures   int           // hosts allowed to fail or not return before the rollout halts
+	ReturnTimeout time.Duration // 10m when zero
+	PollInterval  time.Duration // of GetSystem while waiting for a host, 15s when zero
+}
+
+type Result struct {
+	Host     *model.Host   `json:"host"`
+	Stage    int           `json:"stage"`
+	Status   Status        `json:"status"`
+	Reasons  []string      `json:"reasons,omitempty"` // failed pre-checks
+	Err      string        `json:"error,omitempty"`
+	BootedAt time.Time     `json:"booted_at,omitempty"`
+	Duration time.Duration `json:"duration"` // from the reboot until the host came back
+}
+
+type Report struct {
+	Results []*Result `json:"results"` // in the order of the hosts
+	Halted  string    `json:"halted,omitempty"`
+}
+
+func (r *Report) filter(status Status) []*Result {
+	var results []*Result
+	for _, result := range r.Results {
+		if result.Status == status {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// NotReturned returns the hosts which rebooted and did not come back.
+func (r *Report) NotReturned() []*Result {
+	return r.filter(NotReturned)
+}
+
+func (r *Report) Failed() []*Result {
+	return r.filter(Failed)
+}
+
+func (r *Report) Blocked() []*Result {
+	return r.filter(Blocked)
+}
+
+// Orchestrator reboots hosts in stages. Each host must pass the pre-checks and is only counted as rebooted once it
+// was seen going down or GetSystem reports a lower uptime.
+type Orchestrator struct {
+	marshaller nmodule.Marshaller
+	config     *Config
+}
+
+func New(marshaller nmodule.Marshaller, config *Config) *Orchestrator {
+	if config == nil {
+		config = &Config{}
+	}
+	if config.Checks == nil {
+		config.Checks = []Check{CheckPendingWrites, CheckActiveAlerts}
+	}
+	if config.StageSize <= 0 {
+		config.StageSize = 1
+	}
+	if config.FirstStage <= 0 {
+		config.FirstStage = config.StageSize
+	}
+	if config.ReturnTimeout <= 0 {
+		config.ReturnTimeout = 10 * time.Minute
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = 15 * time.Second
+	}
+	return &Orchestrator{marshaller: marshaller, config: config}
+}
+
+// PreCheck returns the reasons the host should not be rebooted, none when it is safe.
+func (o *Orchestrator) PreCheck(host *model.Host) ([]string, error) {
+	var reasons []string
+	if host.IsOnline != nil && !*host.IsOnline {
+		reasons = append(reasons, "host is offline")
+	}
+	for _, check := range o.config.Checks {
+		switch check {
+		case CheckPendingWrites:
+			hostUUID := host.UUID
+			points, err := o.marshaller.GetPoints(nil, &nmodule.Opts{HostUUID: &hostUUID})
+			if err != nil {
+				return nil, err
+			}
+			pending := 0
+			for _, point := range points {
+				if (point.WritePollRequired != nil && *point.WritePollRequired) ||
+					(point.InSync != nil && !*point.InSync) {
+					pending++
+				}
+			}
+			if pending > 0 {
+				reasons = append(reasons, fmt.Sprintf("%d points have pending writes", pending))
+			}
+		case CheckActiveAlerts:
+			alerts, err := o.marshaller.GetAlerts(&nmodule.Opts{Args: &nargs.Args{HostUUID: &host.UUID}})
+			if err != nil {
+				return nil, err
+			}
+			active := 0
+			for _, alert := range alerts {
+				if alert.HostUUID == host.UUID && alert.Status == datatype.AlertStatusActive {
+					active++
+				}
+			}
+			if active > 0 {
+				reasons = append(reasons, fmt.Sprintf("%d active alerts", active))
+			}
+		default:
+			return nil, fmt.Errorf("unknown check %s", check)
+		}
+	}
+	return reasons, nil
+}
+
+// Reboot reboots the hosts stage by stage and waits for each stage to come back before the next. The rollout halts
+// once more than MaxFailures hosts failed or did not return, the remaining hosts are cancelled.
+func (o *Orchestrator) Reboot(ctx context.Context, hosts []*model.Host) *Report {
+	report := &Report{Results: make([]*Result, len(hosts))}
+	for i, host := range hosts {
+		report.Results[i] = &Result{Host: host, Status: Cancelled}
+	}
+	failures := 0
+	size := o.config.FirstStage
+	for start, stage := 0, 1; start < len(hosts); start, stage = start+size, stage+1 {
+		if stage > 1 {
+			size = o.config.StageSize
+			if !sleep(ctx, o.config.StageDelay) {
+				report.Halted = ctx.Err().Error()
+				break
+			}
+		}
+		if ctx.Err() != nil {
+			report.Halted = ctx.Err().Error()
+			break
+		}
+		end := start + size
+		if end > len(hosts) {
+			end = len(hosts)
+		}
+		results := report.Results[start:end]
+		var wg sync.WaitGroup
+		for _, result := range results {
+			wg.Add(1)
+			go func(result *Result) {
+				defer wg.Done()
+				result.Stage = stage
+				o.reboot(ctx, result)
+			}(result)
+		}
+		wg.Wait()
+		for _, result := range results {
+			if result.Status == Failed || result.Status == NotReturned {
+				failures++
+			}
+		}
+		if failures > o.config.MaxFailures {
+			report.Halted = fmt.Sprintf("%d hosts failed after stage %d", failures, stage)
+			break
+		}
+	}
+	return report
+}
+
+// RebootGroup reboots the hosts of a group, sorted by name.
+func (o *Orchestrator) RebootGroup(ctx context.Context, groupUUID string) (*Report, error) {
+	group, err := o.marshaller.GetGroup(groupUUID, &nmodule.Opts{Args: &nargs.Args{WithHosts: true}})
+	if err != nil {
+		return nil, err
+	}
+	hosts := group.Hosts
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Name < hosts[j].Name })
+	return o.Reboot(ctx, hosts), nil
+}
+
+func (o *Orchestrator) reboot(ctx context.Context, result *Result) {
+	opts := &nmodule.Opts{HostUUID: &result.Host.UUID}
+	reasons, err := o.PreCheck(result.Host)
+	if err != nil {
+		result.Status, result.Reasons = Blocked, []string{"pre-check failed: " + err.Error()}
+		return
+	}
+	if len(reasons) > 0 {
+		result.Status, result.Reasons = Blocked, reasons
+		return
+	}
+	before, err := o.marshaller.GetSystem(opts)
+	if err != nil {
+		result.Status, result.Err = Failed, err.Error()
+		return
+	}
+	start := time.Now()
+	if err = o.marshaller.RebootHost(opts); err != nil {
+		result.Status, result.Err = Failed, err.Error()
+		return
+	}
+	after, err := o.waitReturn(ctx, before, opts)
+	result.Duration = time.Since(start)
+	if err != nil {
+		result.Status, result.Err = NotReturned, err.Error()
+		if ctx.Err() != nil {
+			result.Status = Cancelled
+		}
+		return
+	}
+	result.Status, result.BootedAt = Returned, after.LastBootDate
+}
+
+// waitReturn polls GetSystem until the host answers after it was seen going down or with a lower uptime than before
+// the reboot. The boot date alone isn't trusted as it moves with the clock of the host.
+func (o *Orchestrator) waitReturn(ctx context.Context, before *systats.System, opts *nmodule.Opts) (*systats.System,
+	error) {
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, o.config.ReturnTimeout)
+	defer cancel()
+	upTime, hasUpTime := parseUpTime(before.UpTime)
+	wentDown := false
+	var lastErr error
+	for {
+		if !sleep(ctx, o.config.PollInterval) {
+			if parent.Err() != nil {
+				return nil, fmt.Errorf("cancelled while waiting for the host: %s", parent.Err())
+			}
+			if lastErr != nil {
+				return nil, fmt.Errorf("did not return within %s: %s", o.config.ReturnTimeout, lastErr)
+			}
+			return nil, fmt.Errorf("did not return within %s", o.config.ReturnTimeout)
+		}
+		system, err := o.marshaller.GetSystem(opts)
+		if err != nil {
+			wentDown, lastErr = true, err
+			continue
+		}
+		lastErr = nil
+		if wentDown {
+			return system, nil
+		}
+		if now, ok := parseUpTime(system.UpTime); ok && hasUpTime && now < upTime {
+			return system, nil
+		}
+	}
+}
+
+// upTimeUnits are the units of the uptime GetSystem reports, e.g. "just now", "5 minutes ago" or "yesterday".
+var upTimeUnits = map[string]time.Duration{
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+	"week":   7 * 24 * time.Hour,
+	"month":  30 * 24 * time.Hour,
+	"year":   365 * 24 * time.Hour,
+}
+
+// parseUpTime reads the uptime of GetSystem, it is only as precise as its unit.
+func parseUpTime(s string) (time.Duration, bool) {
+	switch s = strings.TrimSpace(s); s {
+	case "just now", "not yet":
+		return 0, true
+	case "yesterday":
+		return 24 * time.Hour, true
+	}
+	var n int
+	var unit string
+	if _, err := fmt.Sscanf(s, "%d %s ago", &n, &unit); err != nil {
+		return 0, false
+	}
+	d, ok := upTimeUnits[strings.TrimSuffix(unit, "s")]
+	return time.Duration(n) * d, ok
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,123 @@
+package reboot
+
+import (
+	"context"
+	"errors"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	systats "github.com/NubeIO/lib-system"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+var boot = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type fakeMarshaller struct {
+	nmodule.Marshaller
+	mutex    sync.Mutex
+	rebooted map[string]int // polls since the reboot
+}
+
+func (f *fakeMarshaller) GetPoints(body *dto.Filter, opts ...*nmodule.Opts) ([]*model.Point, error) {
+	required := *opts[0].HostUUID == "writes"
+	return []*model.Point{{WritePollRequired: &required}}, nil
+}
+
+func (f *fakeMarshaller) GetAlerts(opts ...*nmodule.Opts) ([]*model.Alert, error) {
+	return []*model.Alert{
+		{HostUUID: "alerts", Status: datatype.AlertStatusActive},
+		{HostUUID: "ok", Status: datatype.AlertStatusClosed},
+	}, nil
+}
+
+func (f *fakeMarshaller) GetSystem(opts ...*nmodule.Opts) (*systats.System, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	hostUUID := *opts[0].HostUUID
+	polls, ok := f.rebooted[hostUUID]
+	if !ok {
+		return &systats.System{LastBootDate: boot, UpTime: "3 hours ago"}, nil
+	}
+	f.rebooted[hostUUID]++
+	switch hostUUID {
+	case "clock":
+		// the clock of the host moved, it never went down
+		return &systats.System{LastBootDate: boot.Add(time.Hour), UpTime: "3 hours ago"}, nil
+	case "fast":
+		// back before a poll failed
+		return &systats.System{LastBootDate: boot.Add(time.Hour), UpTime: "just now"}, nil
+	}
+	if hostUUID == "gone" || polls < 2 {
+		return nil, errors.New("connection refused")
+	}
+	return &systats.System{LastBootDate: boot.Add(time.Hour)}, nil
+}
+
+func (f *fakeMarshaller) RebootHost(opts ...*nmodule.Opts) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.rebooted[*opts[0].HostUUID] = 0
+	return nil
+}
+
+func hosts(uuids ...string) []*model.Host {
+	var hosts []*model.Host
+	for _, uuid := range uuids {
+		hosts = append(hosts, &model.Host{UUID: uuid, Name: uuid})
+	}
+	return hosts
+}
+
+func TestReboot(t *testing.T) {
+	m := &fakeMarshaller{rebooted: map[string]int{}}
+	o := New(m, &Config{PollInterval: time.Millisecond, ReturnTimeout: 50 * time.Millisecond})
+	report := o.Reboot(context.Background(), hosts("ok", "writes", "alerts", "gone", "later"))
+	statuses := []Status{Returned, Blocked, Blocked, NotReturned, Cancelled}
+	for i, result := range report.Results {
+		assert.Equal(t, statuses[i], result.Status, result.Host.UUID)
+	}
+	assert.Equal(t, boot.Add(time.Hour), report.Results[0].BootedAt)
+	assert.Equal(t, []string{"1 points have pending writes"}, report.Results[1].Reasons)
+	assert.Equal(t, []string{"1 active alerts"}, report.Results[2].Reasons)
+	assert.Len(t, report.NotReturned(), 1)
+	assert.NotEmpty(t, report.Halted)
+	_, ok := m.rebooted["later"]
+	assert.False(t, ok)
+}
+
+func TestRebootStages(t *testing.T) {
+	m := &fakeMarshaller{rebooted: map[string]int{}}
+	o := New(m, &Config{Checks: []Check{}, FirstStage: 1, StageSize: 2, MaxFailures: 1,
+		PollInterval: time.Millisecond, ReturnTimeout: 50 * time.Millisecond})
+	report := o.Reboot(context.Background(), hosts("ok", "gone", "writes", "alerts"))
+	stages := []int{1, 2, 2, 3}
+	for i, result := range report.Results {
+		assert.Equal(t, stages[i], result.Stage, result.Host.UUID)
+	}
+	assert.Equal(t, Returned, report.Results[2].Status)
+	assert.Equal(t, Returned, report.Results[3].Status)
+	assert.Empty(t, report.Halted)
+}
+
+func TestRebootNeedsOutageOrLowerUpTime(t *testing.T) {
+	m := &fakeMarshaller{rebooted: map[string]int{}}
+	o := New(m, &Config{Checks: []Check{}, PollInterval: time.Millisecond, ReturnTimeout: 20 * time.Millisecond})
+	report := o.Reboot(context.Background(), hosts("fast", "clock"))
+	assert.Equal(t, Returned, report.Results[0].Status)
+	assert.Equal(t, NotReturned, report.Results[1].Status)
+}
+
+func TestRebootCancelledWhileWaiting(t *testing.T) {
+	m := &fakeMarshaller{rebooted: map[string]int{}}
+	o := New(m, &Config{Checks: []Check{}, PollInterval: time.Millisecond, ReturnTimeout: time.Minute})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	report := o.Reboot(ctx, hosts("gone"))
+	assert.Equal(t, Cancelled, report.Results[0].Status)
+	assert.Contains(t, report.Results[0].Err, "cancelled")
+	assert.Empty(t, report.NotReturned())
+}
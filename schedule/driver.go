@@ -0,0 +1,149 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"github.com/NubeIO/lib-module-go/loop"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// Binding writes the value of a schedule to a point at a priority, 16 when zero.
+type Binding struct {
+	ScheduleUUID string
+	PointUUID    string
+	Priority     int
+}
+
+func (b *Binding) key() string {
+	return b.ScheduleUUID + "/" + b.PointUUID
+}
+
+// Driver writes the points bound to schedules whenever the state of a schedule changes.
+type Driver struct {
+	marshaller nmodule.Marshaller
+	location   *time.Location
+
+	checkMutex sync.Mutex
+	mutex      sync.Mutex
+	bindings   map[string]*Binding
+	written    map[string]State // by binding key, the last state written
+	loop       loop.Loop
+	now        func() time.Time
+}
+
+// NewDriver evaluates schedules without a timezone in location.
+func NewDriver(marshaller nmodule.Marshaller, location *time.Location) *Driver {
+	return &Driver{
+		marshaller: marshaller,
+		location:   location,
+		bindings:   map[string]*Binding{},
+		written:    map[string]State{},
+		now:        time.Now,
+	}
+}
+
+func (d *Driver) Bind(binding *Binding) error {
+	if binding.ScheduleUUID == "" || binding.PointUUID == "" {
+		return errors.New("binding needs a schedule and a point")
+	}
+	if binding.Priority == 0 {
+		binding.Priority = 16
+	}
+	if binding.Priority < 1 || binding.Priority > 16 {
+		return fmt.Errorf("priority %d is not between 1 and 16", binding.Priority)
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.bindings[binding.key()] = binding
+	delete(d.written, binding.key())
+	return nil
+}
+
+func (d *Driver) Unbind(scheduleUUID, pointUUID string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	key := (&Binding{ScheduleUUID: scheduleUUID, PointUUID: pointUUID}).key()
+	delete(d.bindings, key)
+	delete(d.written, key)
+}
+
+// Check evaluates the bound schedules and writes the points whose schedule changed. The first check after a bind
+// always writes, so a restart brings the points back in line. The host is called without holding the mutex, a
+// binding changed meanwhile is written again by the next check.
+func (d *Driver) Check() error {
+	d.checkMutex.Lock()
+	defer d.checkMutex.Unlock()
+	d.mutex.Lock()
+	now := d.now()
+	bindings := make(map[string]*Binding, len(d.bindings))
+	for key, binding := range d.bindings {
+		bindings[key] = binding
+	}
+	d.mutex.Unlock()
+
+	results := map[string]*Result{}
+	var firstErr error
+	for key, binding := range bindings {
+		result, ok := results[binding.ScheduleUUID]
+		if !ok {
+			var err error
+			if result, err = d.evaluate(binding.ScheduleUUID, now); err != nil {
+				log.Errorf("schedule: %s", err)
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			results[binding.ScheduleUUID] = result
+		}
+		d.mutex.Lock()
+		written, ok := d.written[key]
+		current := d.bindings[key] == binding
+		d.mutex.Unlock()
+		if !current || ok && written == result.State {
+			continue
+		}
+		value := result.Value
+		_, err := d.marshaller.PointWrite(binding.PointUUID, &dto.PointWriter{
+			Priority: &map[string]*float64{fmt.Sprintf("_%d", binding.Priority): &value},
+		})
+		if err != nil {
+			log.Errorf("schedule: failed to write point %s: %s", binding.PointUUID, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		d.mutex.Lock()
+		if d.bindings[key] == binding {
+			d.written[key] = result.State
+		}
+		d.mutex.Unlock()
+	}
+	return firstErr
+}
+
+func (d *Driver) evaluate(scheduleUUID string, now time.Time) (*Result, error) {
+	s, err := d.marshaller.GetSchedule(scheduleUUID)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := Parse(s, d.location)
+	if err != nil {
+		return nil, err
+	}
+	return parsed.Evaluate(now), nil
+}
+
+// Start calls Check every tick until Stop.
+func (d *Driver) Start(tick time.Duration) error {
+	return d.loop.Start(tick, false, func() { _ = d.Check() })
+}
+
+func (d *Driver) Stop() {
+	d.loop.Stop()
+}
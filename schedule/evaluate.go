@@ -0,0 +1,127 @@
+package schedule
+
+import (
+	"sort"
+	"time"
+)
+
+// State is the output of a schedule. Value is 1 or 0 for Active unless the schedule has EnablePayload, then it is
+// the value of the entry or the default payload when nothing is active.
+type State struct {
+	Active bool    `json:"active"`
+	Value  float64 `json:"value"`
+	Source Source  `json:"source"`
+	Name   string  `json:"name"` // of the weekly entry, event or exception
+}
+
+type Result struct {
+	State
+	Next   *State    `json:"next"`    // nil when the schedule doesn't change again
+	NextAt time.Time `json:"next_at"` // when Next starts
+}
+
+// horizon limits how far ahead Evaluate looks for the next change.
+const horizon = 2 * 365 * 24 * time.Hour
+
+// Evaluate returns the state of the schedule at t and the next change after t.
+func (s *Schedule) Evaluate(t time.Time) *Result {
+	result := &Result{State: s.state(t)}
+	if !s.enable {
+		return result
+	}
+	for _, b := range s.boundaries(t) {
+		if next := s.state(b); next != result.State {
+			result.Next = &next
+			result.NextAt = b
+			break
+		}
+	}
+	return result
+}
+
+// state returns the output at t: exceptions win over events, events over weekly entries. Of overlapping entries of
+// the same kind the last one to start wins.
+func (s *Schedule) state(t time.Time) State {
+	if !s.enable {
+		return s.output(nil)
+	}
+	var active *period
+	for _, source := range []Source{Exception, Event} {
+		for _, p := range s.dated {
+			if p.source == source && covers(p, t) && (active == nil || p.start.After(active.start)) {
+				active = p
+			}
+		}
+		if active != nil {
+			return s.output(active)
+		}
+	}
+	for _, p := range s.weeklyPeriods(t.Add(-48*time.Hour), t) {
+		if covers(p, t) && (active == nil || p.start.After(active.start)) {
+			active = p
+		}
+	}
+	return s.output(active)
+}
+
+func covers(p *period, t time.Time) bool {
+	return !t.Before(p.start) && t.Before(p.end)
+}
+
+func (s *Schedule) output(p *period) State {
+	if p == nil {
+		return State{Value: s.payload(s.defaultPayload, 0)}
+	}
+	state := State{Active: p.source != Exception, Source: p.source, Name: p.name}
+	if state.Active {
+		state.Value = s.payload(p.value, 1)
+	} else {
+		state.Value = s.payload(p.value, 0)
+	}
+	return state
+}
+
+func (s *Schedule) payload(value, binary float64) float64 {
+	if !s.enablePayload {
+		return binary
+	}
+	if s.maxPayload > s.minPayload {
+		if value < s.minPayload {
+			return s.minPayload
+		}
+		if value > s.maxPayload {
+			return s.maxPayload
+		}
+	}
+	return value
+}
+
+// boundaries returns the sorted starts and ends of all periods after t, which are the only times the state can
+// change. Weekly periods run a week past the last event or exception, so a change after them is found too.
+func (s *Schedule) boundaries(t time.Time) []time.Time {
+	last := t
+	var times []time.Time
+	for _, p := range s.dated {
+		for _, b := range []time.Time{p.start, p.end} {
+			if b.After(t) {
+				times = append(times, b)
+				if b.After(last) {
+					last = b
+				}
+			}
+		}
+	}
+	to := last.Add(8 * 24 * time.Hour)
+	if to.After(t.Add(horizon)) {
+		to = t.Add(horizon)
+	}
+	for _, p := range s.weeklyPeriods(t.Add(-48*time.Hour), to) {
+		for _, b := range []time.Time{p.start, p.end} {
+			if b.After(t) {
+				times = append(times, b)
+			}
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return times
+}
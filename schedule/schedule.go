@@ -0,0 +1,266 @@
+package schedule
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Source string
+
+const (
+	None      Source = ""
+	Weekly    Source = "weekly"
+	Event     Source = "event"
+	Exception Source = "exception" // a holiday, it holds the schedule inactive over weekly entries and events
+)
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+}
+
+// dateLayouts are tried in order, dates without an offset are in the location of the schedule.
+var dateLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05",
+	"2006-01-02 15:04", "2006-01-02"}
+
+type weeklyEntry struct {
+	name  string
+	days  map[time.Weekday]bool
+	start time.Duration // time of day
+	end   time.Duration // time of day, at or before start when the entry runs past midnight
+	value float64
+}
+
+type period struct {
+	source Source
+	name   string
+	start  time.Time
+	end    time.Time
+	value  float64
+}
+
+// Schedule is the parsed json of a model.Schedule.
+type Schedule struct {
+	UUID     string
+	Location *time.Location
+
+	enable         bool
+	enablePayload  bool
+	minPayload     float64
+	maxPayload     float64
+	defaultPayload float64
+	weekly         []*weeklyEntry
+	dated          []*period // events and exceptions
+}
+
+type scheduleJSON struct {
+	dto.ScheduleData
+	Timezone string `json:"timezone"`
+}
+
+type configJSON struct {
+	Timezone string `json:"timezone"`
+}
+
+// Location returns the timezone of a schedule the way Parse resolves it.
+func Location(schedule *model.Schedule, location *time.Location) (*time.Location, error) {
+	var data scheduleJSON
+	if len(schedule.Schedule) > 0 {
+		if err := json.Unmarshal(schedule.Schedule, &data); err != nil {
+			return nil, fmt.Errorf("schedule %s: %s", schedule.UUID, err)
+		}
+	}
+	loc, err := data.location(location)
+	if err != nil {
+		return nil, fmt.Errorf("schedule %s: %s", schedule.UUID, err)
+	}
+	return loc, nil
+}
+
+// location returns the timezone of the config, else the top-level one, else location and UTC when it is nil.
+func (data *scheduleJSON) location(location *time.Location) (*time.Location, error) {
+	timezone := data.Timezone
+	if len(data.Config) > 0 {
+		var config configJSON
+		if err := json.Unmarshal(data.Config, &config); err == nil && config.Timezone != "" {
+			timezone = config.Timezone
+		}
+	}
+	if timezone != "" {
+		return time.LoadLocation(timezone)
+	}
+	if location == nil {
+		return time.UTC, nil
+	}
+	return location, nil
+}
+
+// Parse reads the weekly entries, events and exceptions of a schedule. A timezone in the schedule json takes precedence
+// over location, UTC is used when there is neither. Disabled entries are left out.
+func Parse(schedule *model.Schedule, location *time.Location) (*Schedule, error) {
+	var data scheduleJSON
+	if len(schedule.Schedule) > 0 {
+		if err := json.Unmarshal(schedule.Schedule, &data); err != nil {
+			return nil, fmt.Errorf("schedule %s: %s", schedule.UUID, err)
+		}
+	}
+	location, err := data.location(location)
+	if err != nil {
+		return nil, fmt.Errorf("schedule %s: %s", schedule.UUID, err)
+	}
+	s := &Schedule{
+		UUID:           schedule.UUID,
+		Location:       location,
+		enable:         schedule.Enable == nil || *schedule.Enable,
+		enablePayload:  schedule.EnablePayload,
+		minPayload:     schedule.MinPayload,
+		maxPayload:     schedule.MaxPayload,
+		defaultPayload: schedule.DefaultPayload,
+	}
+	for _, key := range sortedKeys(data.Schedules.Weekly) {
+		w := data.Schedules.Weekly[key]
+		if w.Enable != nil && !*w.Enable {
+			continue
+		}
+		entry, err := parseWeekly(w)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %s: weekly %s: %s", schedule.UUID, key, err)
+		}
+		s.weekly = append(s.weekly, entry)
+	}
+	for _, key := range sortedKeys(data.Schedules.Events) {
+		e := data.Schedules.Events[key]
+		if e.Enable != nil && !*e.Enable {
+			continue
+		}
+		for _, d := range e.Dates {
+			p, err := parsePeriod(Event, e.Name, d.Start, d.End, e.Value, location)
+			if err != nil {
+				return nil, fmt.Errorf("schedule %s: event %s: %s", schedule.UUID, key, err)
+			}
+			s.dated = append(s.dated, p)
+		}
+	}
+	for _, key := range sortedKeys(data.Schedules.Exception) {
+		e := data.Schedules.Exception[key]
+		if e.Enable != nil && !*e.Enable {
+			continue
+		}
+		for _, d := range e.Dates {
+			p, err := parsePeriod(Exception, e.Name, d.Start, d.End, e.Value, location)
+			if err != nil {
+				return nil, fmt.Errorf("schedule %s: exception %s: %s", schedule.UUID, key, err)
+			}
+			s.dated = append(s.dated, p)
+		}
+	}
+	return s, nil
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func parseWeekly(w dto.Weekly) (*weeklyEntry, error) {
+	entry := &weeklyEntry{name: w.Name, days: map[time.Weekday]bool{}, value: w.Value}
+	for _, day := range w.Days {
+		weekday, err := ParseWeekday(day)
+		if err != nil {
+			return nil, err
+		}
+		entry.days[weekday] = true
+	}
+	var err error
+	if entry.start, err = ParseTimeOfDay(w.Start); err != nil {
+		return nil, err
+	}
+	if entry.end, err = ParseTimeOfDay(w.End); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// ParseWeekday reads a day name of a weekly entry, either in full or abbreviated to at least three letters.
+func ParseWeekday(day string) (time.Weekday, error) {
+	day = strings.ToLower(day)
+	if weekday, ok := weekdays[day]; ok {
+		return weekday, nil
+	}
+	for name, weekday := range weekdays {
+		if len(day) >= 3 && strings.HasPrefix(name, day) {
+			return weekday, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown day %s", day)
+}
+
+// ParseTimeOfDay reads HH:MM or HH:MM:SS, 24:00 is the end of the day.
+func ParseTimeOfDay(s string) (time.Duration, error) {
+	var h, m, sec int
+	n, _ := fmt.Sscanf(s, "%d:%d:%d", &h, &m, &sec)
+	if n < 2 || h < 0 || h > 24 || m < 0 || m > 59 || sec < 0 || sec > 59 || (h == 24 && (m > 0 || sec > 0)) {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec)*time.Second, nil
+}
+
+func parsePeriod(source Source, name, start, end string, value float64, location *time.Location) (*period, error) {
+	p := &period{source: source, name: name, value: value}
+	var err error
+	if p.start, err = ParseDate(start, location); err != nil {
+		return nil, err
+	}
+	if p.end, err = ParseDate(end, location); err != nil {
+		return nil, err
+	}
+	if !p.end.After(p.start) {
+		return nil, fmt.Errorf("%s ends before it starts", start)
+	}
+	return p, nil
+}
+
+// ParseDate reads the start or end of an event or exception, dates without an offset are in location.
+func ParseDate(s string, location *time.Location) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, location); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", s)
+}
+
+// at returns the wall clock time of day on the date, so 08:00 stays 08:00 on the day of a DST change.
+func at(date time.Time, timeOfDay time.Duration, location *time.Location) time.Time {
+	h, m, sec := int(timeOfDay/time.Hour), int(timeOfDay%time.Hour/time.Minute), int(timeOfDay%time.Minute/time.Second)
+	return time.Date(date.Year(), date.Month(), date.Day(), h, m, sec, 0, location)
+}
+
+// weeklyPeriods returns the periods of the weekly entries which start on the days from..to.
+func (s *Schedule) weeklyPeriods(from, to time.Time) []*period {
+	var periods []*period
+	from = from.In(s.Location)
+	for day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, s.Location); !day.After(to); day = day.AddDate(0, 0, 1) {
+		for _, w := range s.weekly {
+			if !w.days[day.Weekday()] {
+				continue
+			}
+			start := at(day, w.start, s.Location)
+			end := at(day, w.end, s.Location)
+			if w.end <= w.start {
+				end = at(day.AddDate(0, 0, 1), w.end, s.Location)
+			}
+			periods = append(periods, &period{source: Weekly, name: w.name, start: start, end: end, value: w.value})
+		}
+	}
+	return periods
+}
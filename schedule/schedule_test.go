@@ -0,0 +1,147 @@
+package schedule
+
+import (
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+const scheduleJSON1 = `{
+	"schedules": {
+		"weekly": {
+			"a": {"name": "office", "days": ["monday", "tuesday", "wednesday", "thursday", "friday"], "start": "08:00", "end": "17:00", "value": 21},
+			"b": {"name": "night", "days": ["sun"], "start": "22:00", "end": "02:00", "value": 18},
+			"c": {"name": "off", "days": ["saturday"], "start": "08:00", "end": "12:00", "value": 5, "enable": false}
+		},
+		"events": {
+			"e": {"name": "open day", "dates": [{"start": "2024-03-16T10:00", "end": "2024-03-16T14:00"}], "value": 19}
+		},
+		"exception": {
+			"x": {"name": "holiday", "dates": [{"start": "2024-03-18", "end": "2024-03-19"}], "value": 12}
+		}
+	},
+	"config": {"timezone": "America/New_York"}
+}`
+
+func parse(t *testing.T, schedule *model.Schedule) *Schedule {
+	s, err := Parse(schedule, time.UTC)
+	assert.NoError(t, err)
+	return s
+}
+
+func TestEvaluate(t *testing.T) {
+	s := parse(t, &model.Schedule{Schedule: []byte(scheduleJSON1), EnablePayload: true, DefaultPayload: 15})
+	ny := s.Location
+	assert.Equal(t, "America/New_York", ny.String())
+
+	// thursday 10:00, after the DST change on sunday 10th
+	r := s.Evaluate(time.Date(2024, 3, 14, 10, 0, 0, 0, ny))
+	assert.Equal(t, State{Active: true, Value: 21, Source: Weekly, Name: "office"}, r.State)
+	assert.Equal(t, time.Date(2024, 3, 14, 17, 0, 0, 0, ny), r.NextAt)
+	assert.Equal(t, State{Value: 15}, *r.Next)
+
+	// saturday: the disabled entry is skipped, the event runs
+	r = s.Evaluate(time.Date(2024, 3, 16, 9, 0, 0, 0, ny))
+	assert.False(t, r.Active)
+	assert.Equal(t, time.Date(2024, 3, 16, 10, 0, 0, 0, ny), r.NextAt)
+	assert.Equal(t, Event, r.Next.Source)
+	assert.Equal(t, 19.0, r.Next.Value)
+
+	// sunday night runs past midnight into the holiday, which holds monday inactive
+	r = s.Evaluate(time.Date(2024, 3, 17, 23, 0, 0, 0, ny))
+	assert.Equal(t, "night", r.Name)
+	assert.Equal(t, time.Date(2024, 3, 18, 0, 0, 0, 0, ny), r.NextAt)
+	assert.Equal(t, State{Value: 12, Source: Exception, Name: "holiday"}, *r.Next)
+	r = s.Evaluate(time.Date(2024, 3, 18, 12, 0, 0, 0, ny))
+	assert.False(t, r.Active)
+	assert.Equal(t, time.Date(2024, 3, 19, 0, 0, 0, 0, ny), r.NextAt)
+	r = s.Evaluate(r.NextAt)
+	assert.Equal(t, time.Date(2024, 3, 19, 8, 0, 0, 0, ny), r.NextAt)
+	assert.Equal(t, "office", r.Next.Name)
+}
+
+func TestEvaluateDST(t *testing.T) {
+	s := parse(t, &model.Schedule{Schedule: []byte(scheduleJSON1)})
+	ny := s.Location
+	// friday 17:00 EST to monday 08:00 EDT: the change is at the wall clock time, not 63 hours later
+	r := s.Evaluate(time.Date(2024, 3, 8, 18, 0, 0, 0, ny))
+	assert.Equal(t, time.Date(2024, 3, 10, 22, 0, 0, 0, ny), r.NextAt)
+	r = s.Evaluate(time.Date(2024, 3, 11, 3, 0, 0, 0, ny))
+	assert.Equal(t, State{}, r.State)
+	assert.Equal(t, time.Date(2024, 3, 11, 12, 0, 0, 0, time.UTC), r.NextAt.UTC())
+	assert.Equal(t, State{Active: true, Value: 1, Source: Weekly, Name: "office"}, *r.Next)
+}
+
+func TestParse(t *testing.T) {
+	enable := false
+	s := parse(t, &model.Schedule{CommonEnable: model.CommonEnable{Enable: &enable}, Schedule: []byte(scheduleJSON1)})
+	r := s.Evaluate(time.Date(2024, 3, 14, 10, 0, 0, 0, s.Location))
+	assert.False(t, r.Active)
+	assert.Nil(t, r.Next)
+
+	s = parse(t, &model.Schedule{})
+	assert.Nil(t, s.Evaluate(time.Now()).Next)
+
+	_, err := Parse(&model.Schedule{Schedule: []byte(`{"schedules": {"weekly": {"a": {"days": ["funday"], "start": "08:00", "end": "09:00"}}}}`)}, nil)
+	assert.Error(t, err)
+	_, err = Parse(&model.Schedule{Schedule: []byte(`{"schedules": {"weekly": {"a": {"days": ["mon"], "start": "8am", "end": "09:00"}}}}`)}, nil)
+	assert.Error(t, err)
+	_, err = Parse(&model.Schedule{Schedule: []byte(`{"schedules": {"events": {"a": {"dates": [{"start": "2024-01-02", "end": "2024-01-01"}]}}}}`)}, nil)
+	assert.Error(t, err)
+}
+
+type fakeMarshaller struct {
+	nmodule.Marshaller
+	writes  map[string][]float64
+	onWrite func()
+}
+
+func (f *fakeMarshaller) GetSchedule(uuid string, opts ...*nmodule.Opts) (*model.Schedule, error) {
+	return &model.Schedule{CommonUUID: model.CommonUUID{UUID: uuid}, Schedule: []byte(scheduleJSON1)}, nil
+}
+
+func (f *fakeMarshaller) PointWrite(uuid string, body *dto.PointWriter, opts ...*nmodule.Opts) (*dto.PointWriteResponse, error) {
+	f.writes[uuid] = append(f.writes[uuid], *(*body.Priority)["_10"])
+	if f.onWrite != nil {
+		f.onWrite()
+	}
+	return &dto.PointWriteResponse{}, nil
+}
+
+func TestDriver(t *testing.T) {
+	m := &fakeMarshaller{writes: map[string][]float64{}}
+	d := NewDriver(m, nil)
+	ny, _ := time.LoadLocation("America/New_York")
+	now := time.Date(2024, 3, 14, 7, 0, 0, 0, ny)
+	d.now = func() time.Time { return now }
+	assert.Error(t, d.Bind(&Binding{ScheduleUUID: "sch", PointUUID: "pnt", Priority: 17}))
+	assert.NoError(t, d.Bind(&Binding{ScheduleUUID: "sch", PointUUID: "pnt", Priority: 10}))
+
+	assert.NoError(t, d.Check())
+	assert.NoError(t, d.Check())
+	now = now.Add(2 * time.Hour)
+	assert.NoError(t, d.Check())
+	assert.NoError(t, d.Check())
+	assert.Equal(t, []float64{0, 1}, m.writes["pnt"])
+}
+
+func TestDriverRebindDuringWrite(t *testing.T) {
+	m := &fakeMarshaller{writes: map[string][]float64{}}
+	d := NewDriver(m, nil)
+	ny, _ := time.LoadLocation("America/New_York")
+	d.now = func() time.Time { return time.Date(2024, 3, 14, 7, 0, 0, 0, ny) }
+	assert.NoError(t, d.Bind(&Binding{ScheduleUUID: "sch", PointUUID: "pnt", Priority: 10}))
+
+	// the host is called without the lock, a bind during the write doesn't block and is written again
+	m.onWrite = func() {
+		m.onWrite = nil
+		assert.NoError(t, d.Bind(&Binding{ScheduleUUID: "sch", PointUUID: "pnt", Priority: 10}))
+	}
+	assert.NoError(t, d.Check())
+	assert.NoError(t, d.Check())
+	assert.NoError(t, d.Check())
+	assert.Equal(t, []float64{0, 0}, m.writes["pnt"])
+}
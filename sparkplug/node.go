@@ -0,0 +1,359 @@
+package sparkplug
+
+import (
+	"errors"
+	"fmt"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"sync"
+	"time"
+)
+
+const (
+	bdSeqMetric   = "bdSeq"
+	rebirthMetric = "Node Control/Rebirth"
+)
+
+type PublishFunc func(topic string, payload []byte) error
+
+// MarshallerPublisher publishes through the host broker, sparkplug messages are qos 0 and never retained. The payloads
+// are protobuf so they go through PublishBinary, which needs a host providing /api/mqtt/publish-binary; on an older
+// host every publish fails with nmodule.ErrEndpointNotSupported.
+func MarshallerPublisher(marshaller nmodule.Marshaller, opts ...*nmodule.Opts) PublishFunc {
+	return func(topic string, payload []byte) error {
+		return marshaller.PublishBinary(topic, datatype.AtMostOnce, false, payload, opts...)
+	}
+}
+
+type metricRef struct {
+	pointUUID string
+	name      string
+	alias     uint64
+	dataType  DataType
+}
+
+type device struct {
+	id      string
+	metrics map[string]*metricRef // by point uuid
+}
+
+// EdgeNode maps a network to a sparkplug edge node, its devices to sparkplug devices and their points to metrics
+// with aliases. It keeps the sequence numbers of one session, a session starts with NewSession and Birth.
+type EdgeNode struct {
+	GroupID string
+	NodeID  string
+
+	publish PublishFunc
+	mutex   sync.Mutex
+	seq     uint64
+	bdSeq   uint64
+	born    bool
+	devices map[string]*device // by device uuid
+	aliases map[uint64]*metricRef
+	ids     map[string]string // device id to device uuid
+	now     func() time.Time
+}
+
+func NewEdgeNode(groupID string, network *model.Network, publish PublishFunc) *EdgeNode {
+	return &EdgeNode{
+		GroupID: ID(groupID),
+		NodeID:  ID(network.Name),
+		publish: publish,
+		bdSeq:   255, // the first NewSession starts with 0
+		now:     time.Now,
+	}
+}
+
+// NewSession starts a session and returns the NDEATH topic and payload, to be set as the will of the broker connection
+// before Birth is published. Modules publishing through the host with MarshallerPublisher have no way to set a will,
+// so host applications only get the NDEATH on a graceful Death; an ungraceful disconnect shows as stale data until the
+// next Birth.
+func (n *EdgeNode) NewSession() (string, []byte, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.bdSeq = (n.bdSeq + 1) % 256
+	n.born = false
+	payload, err := n.death().Marshal()
+	if err != nil {
+		return "", nil, err
+	}
+	return n.topic(NDEATH, ""), payload, nil
+}
+
+// Death publishes the NDEATH of the session, call it on shutdown since the host connection carries no will.
+func (n *EdgeNode) Death() error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if !n.born {
+		return nil
+	}
+	n.born = false
+	return n.send(NDEATH, "", n.death())
+}
+
+// death is the NDEATH payload, it carries the bdSeq of the session and no seq.
+func (n *EdgeNode) death() *Payload {
+	return &Payload{
+		Timestamp: n.now(),
+		Metrics:   []*Metric{{Name: bdSeqMetric, DataType: Int64, Value: n.bdSeq}},
+	}
+}
+
+func (n *EdgeNode) topic(messageType MessageType, deviceID string) string {
+	return (&Topic{GroupID: n.GroupID, Type: messageType, EdgeNodeID: n.NodeID, DeviceID: deviceID}).String()
+}
+
+// nextSeq must be called with the lock held.
+func (n *EdgeNode) nextSeq() *uint64 {
+	seq := n.seq
+	n.seq = (n.seq + 1) % 256
+	return &seq
+}
+
+// Birth publishes NBIRTH and a DBIRTH for every device of the network, the seq restarts at 0. It is also the answer
+// to a rebirth command.
+func (n *EdgeNode) Birth(network *model.Network) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.seq = 0
+	n.devices = map[string]*device{}
+	n.aliases = map[uint64]*metricRef{}
+	n.ids = map[string]string{}
+	now := n.now()
+	err := n.send(NBIRTH, "", &Payload{
+		Timestamp: now,
+		Metrics: []*Metric{
+			{Name: bdSeqMetric, DataType: Int64, Value: n.bdSeq},
+			{Name: rebirthMetric, DataType: Boolean, Value: false},
+		},
+		Seq: n.nextSeq(),
+	})
+	if err != nil {
+		return err
+	}
+	n.born = true
+	for _, dev := range network.Devices {
+		if err = n.deviceBirth(dev, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeviceBirth publishes the DBIRTH of a device added after Birth, or again after its points changed.
+func (n *EdgeNode) DeviceBirth(dev *model.Device) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if !n.born {
+		return errors.New("edge node isn't born")
+	}
+	return n.deviceBirth(dev, n.now())
+}
+
+func (n *EdgeNode) deviceBirth(dev *model.Device, now time.Time) error {
+	d := &device{id: ID(dev.Name), metrics: map[string]*metricRef{}}
+	if uuid, ok := n.ids[d.id]; ok && uuid != dev.UUID {
+		return fmt.Errorf("devices %s and %s have the same sparkplug id %s", uuid, dev.UUID, d.id)
+	}
+	if old, ok := n.devices[dev.UUID]; ok {
+		for _, ref := range old.metrics {
+			delete(n.aliases, ref.alias)
+		}
+	}
+	payload := &Payload{Timestamp: now}
+	for _, point := range dev.Points {
+		ref := &metricRef{pointUUID: point.UUID, name: ID(point.Name), alias: n.nextAlias(), dataType: Double}
+		if point.IsTypeBool != nil && *point.IsTypeBool {
+			ref.dataType = Boolean
+		}
+		d.metrics[point.UUID] = ref
+		n.aliases[ref.alias] = ref
+		alias := ref.alias
+		payload.Metrics = append(payload.Metrics, &Metric{
+			Name:      ref.name,
+			Alias:     &alias,
+			Timestamp: now,
+			DataType:  ref.dataType,
+			Value:     metricValue(ref.dataType, point.PresentValue),
+		})
+	}
+	payload.Seq = n.nextSeq()
+	n.devices[dev.UUID] = d
+	n.ids[d.id] = dev.UUID
+	return n.send(DBIRTH, d.id, payload)
+}
+
+func (n *EdgeNode) nextAlias() uint64 {
+	var alias uint64
+	for {
+		if _, ok := n.aliases[alias]; !ok {
+			return alias
+		}
+		alias++
+	}
+}
+
+func metricValue(dataType DataType, value *float64) interface{} {
+	if value == nil {
+		return nil
+	}
+	if dataType == Boolean {
+		return *value != 0
+	}
+	return *value
+}
+
+// DeviceData publishes DDATA with the changed point values of a device, keyed by point uuid.
+func (n *EdgeNode) DeviceData(deviceUUID string, values map[string]*float64) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if !n.born {
+		return errors.New("edge node isn't born")
+	}
+	d, ok := n.devices[deviceUUID]
+	if !ok {
+		return fmt.Errorf("device %s isn't born", deviceUUID)
+	}
+	now := n.now()
+	payload := &Payload{Timestamp: now}
+	for pointUUID, value := range values {
+		ref, ok := d.metrics[pointUUID]
+		if !ok {
+			return fmt.Errorf("point %s isn't in the birth of device %s", pointUUID, deviceUUID)
+		}
+		alias := ref.alias
+		payload.Metrics = append(payload.Metrics, &Metric{
+			Alias:     &alias,
+			Timestamp: now,
+			DataType:  ref.dataType,
+			Value:     metricValue(ref.dataType, value),
+		})
+	}
+	payload.Seq = n.nextSeq()
+	return n.send(DDATA, d.id, payload)
+}
+
+// NodeData publishes NDATA with metrics of the edge node itself.
+func (n *EdgeNode) NodeData(metrics []*Metric) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if !n.born {
+		return errors.New("edge node isn't born")
+	}
+	return n.send(NDATA, "", &Payload{Timestamp: n.now(), Metrics: metrics, Seq: n.nextSeq()})
+}
+
+// DeviceDeath publishes DDEATH, e.g. when the device goes into fault or is removed.
+func (n *EdgeNode) DeviceDeath(deviceUUID string) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	d, ok := n.devices[deviceUUID]
+	if !ok {
+		return fmt.Errorf("device %s isn't born", deviceUUID)
+	}
+	for _, ref := range d.metrics {
+		delete(n.aliases, ref.alias)
+	}
+	delete(n.devices, deviceUUID)
+	delete(n.ids, d.id)
+	return n.send(DDEATH, d.id, &Payload{Timestamp: n.now(), Seq: n.nextSeq()})
+}
+
+func (n *EdgeNode) send(messageType MessageType, deviceID string, payload *Payload) error {
+	b, err := payload.Marshal()
+	if err != nil {
+		return err
+	}
+	return n.publish(n.topic(messageType, deviceID), b)
+}
+
+type PointWrite struct {
+	PointUUID string
+	Value     *float64
+}
+
+// Command is a decoded NCMD or DCMD addressed to this edge node.
+type Command struct {
+	Type       MessageType
+	DeviceUUID string        // set for DCMD
+	Rebirth    bool          // the host application asks for Birth to be published again
+	Writes     []*PointWrite // DCMD metrics resolved to points
+	Metrics    []*Metric     // NCMD metrics other than the rebirth request
+}
+
+// DecodeCommand decodes a NCMD or DCMD, metrics are resolved by alias or by name.
+func (n *EdgeNode) DecodeCommand(topic string, payload []byte) (*Command, error) {
+	t, err := ParseTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	if t.GroupID != n.GroupID || t.EdgeNodeID != n.NodeID {
+		return nil, fmt.Errorf("command %s isn't for edge node %s/%s", topic, n.GroupID, n.NodeID)
+	}
+	if t.Type != NCMD && t.Type != DCMD {
+		return nil, fmt.Errorf("%s isn't a command", t.Type)
+	}
+	p, err := Unmarshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	command := &Command{Type: t.Type}
+	if t.Type == NCMD {
+		for _, m := range p.Metrics {
+			if m.Name == rebirthMetric {
+				rebirth, _ := m.Value.(bool)
+				command.Rebirth = command.Rebirth || rebirth
+			} else {
+				command.Metrics = append(command.Metrics, m)
+			}
+		}
+		return command, nil
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	deviceUUID, ok := n.ids[t.DeviceID]
+	if !ok {
+		return nil, fmt.Errorf("device %s isn't born", t.DeviceID)
+	}
+	command.DeviceUUID = deviceUUID
+	d := n.devices[deviceUUID]
+	for _, m := range p.Metrics {
+		ref := n.resolve(d, m)
+		if ref == nil {
+			return nil, fmt.Errorf("unknown metric %s of device %s", m.Name, t.DeviceID)
+		}
+		write := &PointWrite{PointUUID: ref.pointUUID}
+		if !m.IsNull {
+			value, ok := toFloat64(m.Value)
+			if b, isBool := m.Value.(bool); isBool {
+				value, ok = 0, true
+				if b {
+					value = 1
+				}
+			}
+			if !ok {
+				return nil, fmt.Errorf("metric %s of device %s has a non numeric value", m.Name, t.DeviceID)
+			}
+			write.Value = &value
+		}
+		command.Writes = append(command.Writes, write)
+	}
+	return command, nil
+}
+
+func (n *EdgeNode) resolve(d *device, m *Metric) *metricRef {
+	if m.Alias != nil {
+		if ref, ok := n.aliases[*m.Alias]; ok && d.metrics[ref.pointUUID] == ref {
+			return ref
+		}
+		return nil
+	}
+	for _, ref := range d.metrics {
+		if ref.name == m.Name {
+			return ref
+		}
+	}
+	return nil
+}
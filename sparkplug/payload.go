@@ -0,0 +1,397 @@
+package sparkplug
+
+import (
+	"fmt"
+	"google.golang.org/protobuf/encoding/protowire"
+	"math"
+	"time"
+)
+
+// DataType is the sparkplug b metric data type.
+type DataType uint32
+
+const (
+	Int8     DataType = 1
+	Int16    DataType = 2
+	Int32    DataType = 3
+	Int64    DataType = 4
+	UInt8    DataType = 5
+	UInt16   DataType = 6
+	UInt32   DataType = 7
+	UInt64   DataType = 8
+	Float    DataType = 9
+	Double   DataType = 10
+	Boolean  DataType = 11
+	String   DataType = 12
+	DateTime DataType = 13
+	Text     DataType = 14
+)
+
+// Metric is a single sparkplug b metric, Value holds a Go value matching DataType and is ignored when IsNull is set.
+type Metric struct {
+	Name      string
+	Alias     *uint64
+	Timestamp time.Time
+	DataType  DataType
+	IsNull    bool
+	Value     interface{}
+}
+
+type Payload struct {
+	Timestamp time.Time
+	Metrics   []*Metric
+	Seq       *uint64
+	UUID      string
+	Body      []byte
+}
+
+// payload and metric field numbers of sparkplug_b.proto
+const (
+	payloadTimestamp = 1
+	payloadMetrics   = 2
+	payloadSeq       = 3
+	payloadUUID      = 4
+	payloadBody      = 5
+
+	metricName         = 1
+	metricAlias        = 2
+	metricTimestamp    = 3
+	metricDataType     = 4
+	metricIsNull       = 7
+	metricIntValue     = 10
+	metricLongValue    = 11
+	metricFloatValue   = 12
+	metricDoubleValue  = 13
+	metricBooleanValue = 14
+	metricStringValue  = 15
+)
+
+// Marshal encodes the payload in the protobuf wire format of sparkplug_b.proto.
+func (p *Payload) Marshal() ([]byte, error) {
+	var b []byte
+	if !p.Timestamp.IsZero() {
+		b = protowire.AppendTag(b, payloadTimestamp, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(p.Timestamp.UnixMilli()))
+	}
+	for _, m := range p.Metrics {
+		metric, err := m.marshal()
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, payloadMetrics, protowire.BytesType)
+		b = protowire.AppendBytes(b, metric)
+	}
+	if p.Seq != nil {
+		b = protowire.AppendTag(b, payloadSeq, protowire.VarintType)
+		b = protowire.AppendVarint(b, *p.Seq)
+	}
+	if p.UUID != "" {
+		b = protowire.AppendTag(b, payloadUUID, protowire.BytesType)
+		b = protowire.AppendString(b, p.UUID)
+	}
+	if p.Body != nil {
+		b = protowire.AppendTag(b, payloadBody, protowire.BytesType)
+		b = protowire.AppendBytes(b, p.Body)
+	}
+	return b, nil
+}
+
+func (m *Metric) marshal() ([]byte, error) {
+	var b []byte
+	if m.Name != "" {
+		b = protowire.AppendTag(b, metricName, protowire.BytesType)
+		b = protowire.AppendString(b, m.Name)
+	}
+	if m.Alias != nil {
+		b = protowire.AppendTag(b, metricAlias, protowire.VarintType)
+		b = protowire.AppendVarint(b, *m.Alias)
+	}
+	if !m.Timestamp.IsZero() {
+		b = protowire.AppendTag(b, metricTimestamp, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(m.Timestamp.UnixMilli()))
+	}
+	b = protowire.AppendTag(b, metricDataType, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(m.DataType))
+	if m.IsNull || m.Value == nil {
+		b = protowire.AppendTag(b, metricIsNull, protowire.VarintType)
+		return protowire.AppendVarint(b, 1), nil
+	}
+	switch m.DataType {
+	case Int8, Int16, Int32, UInt8, UInt16, UInt32:
+		v, ok := toInt64(m.Value)
+		if !ok {
+			return nil, m.typeError()
+		}
+		b = protowire.AppendTag(b, metricIntValue, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(uint32(v)))
+	case Int64, UInt64:
+		v, ok := toInt64(m.Value)
+		if !ok {
+			return nil, m.typeError()
+		}
+		b = protowire.AppendTag(b, metricLongValue, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(v))
+	case DateTime:
+		v, ok := m.Value.(time.Time)
+		if !ok {
+			return nil, m.typeError()
+		}
+		b = protowire.AppendTag(b, metricLongValue, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(v.UnixMilli()))
+	case Float:
+		v, ok := toFloat64(m.Value)
+		if !ok {
+			return nil, m.typeError()
+		}
+		b = protowire.AppendTag(b, metricFloatValue, protowire.Fixed32Type)
+		b = protowire.AppendFixed32(b, math.Float32bits(float32(v)))
+	case Double:
+		v, ok := toFloat64(m.Value)
+		if !ok {
+			return nil, m.typeError()
+		}
+		b = protowire.AppendTag(b, metricDoubleValue, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(v))
+	case Boolean:
+		v, ok := m.Value.(bool)
+		if !ok {
+			return nil, m.typeError()
+		}
+		b = protowire.AppendTag(b, metricBooleanValue, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(v))
+	case String, Text:
+		v, ok := m.Value.(string)
+		if !ok {
+			return nil, m.typeError()
+		}
+		b = protowire.AppendTag(b, metricStringValue, protowire.BytesType)
+		b = protowire.AppendString(b, v)
+	default:
+		return nil, fmt.Errorf("metric %s has unsupported data type %d", m.Name, m.DataType)
+	}
+	return b, nil
+}
+
+func (m *Metric) typeError() error {
+	return fmt.Errorf("metric %s has value of type %T for data type %d", m.Name, m.Value, m.DataType)
+}
+
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return int64(v), true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), true
+	case float64:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+func toFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	if v, ok := toInt64(value); ok {
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// Unmarshal decodes a sparkplug b payload, fields this package doesn't model are skipped.
+func Unmarshal(b []byte) (*Payload, error) {
+	p := &Payload{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == payloadTimestamp && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			p.Timestamp = time.UnixMilli(int64(v))
+			b = b[n:]
+		case num == payloadMetrics && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			metric, err := unmarshalMetric(v)
+			if err != nil {
+				return nil, err
+			}
+			p.Metrics = append(p.Metrics, metric)
+			b = b[n:]
+		case num == payloadSeq && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			p.Seq = &v
+			b = b[n:]
+		case num == payloadUUID && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			p.UUID = v
+			b = b[n:]
+		case num == payloadBody && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			p.Body = append([]byte{}, v...)
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return p, nil
+}
+
+func unmarshalMetric(b []byte) (*Metric, error) {
+	m := &Metric{}
+	var raw interface{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == metricName && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			m.Name = v
+			b = b[n:]
+		case num == metricFloatValue && typ == protowire.Fixed32Type:
+			v, n := protowire.ConsumeFixed32(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			raw = math.Float32frombits(v)
+			b = b[n:]
+		case num == metricDoubleValue && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			raw = math.Float64frombits(v)
+			b = b[n:]
+		case num == metricStringValue && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			raw = v
+			b = b[n:]
+		case typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			switch num {
+			case metricAlias:
+				alias := v
+				m.Alias = &alias
+			case metricTimestamp:
+				m.Timestamp = time.UnixMilli(int64(v))
+			case metricDataType:
+				m.DataType = DataType(v)
+			case metricIsNull:
+				m.IsNull = protowire.DecodeBool(v)
+			case metricIntValue, metricLongValue, metricBooleanValue:
+				raw = v
+			}
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	if m.IsNull || raw == nil {
+		m.IsNull = true
+		return m, nil
+	}
+	value, err := typedValue(m.DataType, raw)
+	if err != nil {
+		return nil, fmt.Errorf("metric %s: %s", m.Name, err)
+	}
+	m.Value = value
+	return m, nil
+}
+
+// typedValue converts the raw wire value to the Go type used by Metric.Value for the data type.
+func typedValue(dataType DataType, raw interface{}) (interface{}, error) {
+	switch v := raw.(type) {
+	case uint64:
+		switch dataType {
+		case Int8:
+			return int8(v), nil
+		case Int16:
+			return int16(v), nil
+		case Int32:
+			return int32(v), nil
+		case Int64:
+			return int64(v), nil
+		case UInt8:
+			return uint8(v), nil
+		case UInt16:
+			return uint16(v), nil
+		case UInt32:
+			return uint32(v), nil
+		case UInt64:
+			return v, nil
+		case DateTime:
+			return time.UnixMilli(int64(v)), nil
+		case Boolean:
+			return v != 0, nil
+		}
+	case float32:
+		if dataType == Float {
+			return v, nil
+		}
+	case float64:
+		if dataType == Double {
+			return v, nil
+		}
+	case string:
+		if dataType == String || dataType == Text {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("value of type %T doesn't match data type %d", raw, dataType)
+}
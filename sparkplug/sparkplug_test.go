@@ -0,0 +1,136 @@
+package sparkplug
+
+import (
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type message struct {
+	topic   string
+	payload *Payload
+}
+
+func network() *model.Network {
+	value, isBool := 21.5, true
+	return &model.Network{Name: "modbus", Devices: []*model.Device{{
+		CommonUUID: model.CommonUUID{UUID: "dev_1"},
+		Name:       "ahu/1",
+		Points: []*model.Point{
+			{CommonUUID: model.CommonUUID{UUID: "pnt_1"}, Name: "temp", PresentValue: &value},
+			{CommonUUID: model.CommonUUID{UUID: "pnt_2"}, Name: "fan", IsTypeBool: &isBool},
+		},
+	}}}
+}
+
+func TestPayloadRoundTrip(t *testing.T) {
+	alias, seq := uint64(3), uint64(7)
+	ts := time.UnixMilli(1700000000123)
+	in := &Payload{Timestamp: ts, Seq: &seq, Metrics: []*Metric{
+		{Name: "a", Alias: &alias, Timestamp: ts, DataType: Double, Value: 1.5},
+		{Name: "b", DataType: Int16, Value: -2},
+		{Name: "c", DataType: Boolean, Value: true},
+		{Name: "d", DataType: String, Value: "on"},
+		{Name: "e", DataType: Float, IsNull: true},
+	}}
+	b, err := in.Marshal()
+	assert.NoError(t, err)
+	out, err := Unmarshal(b)
+	assert.NoError(t, err)
+	assert.Equal(t, seq, *out.Seq)
+	assert.True(t, out.Timestamp.Equal(ts))
+	assert.Equal(t, 1.5, out.Metrics[0].Value)
+	assert.Equal(t, alias, *out.Metrics[0].Alias)
+	assert.Equal(t, int16(-2), out.Metrics[1].Value)
+	assert.Equal(t, true, out.Metrics[2].Value)
+	assert.Equal(t, "on", out.Metrics[3].Value)
+	assert.True(t, out.Metrics[4].IsNull)
+
+	_, err = (&Payload{Metrics: []*Metric{{Name: "x", DataType: Boolean, Value: 1.0}}}).Marshal()
+	assert.Error(t, err)
+}
+
+func TestTopic(t *testing.T) {
+	topic, err := ParseTopic("spBv1.0/rubix/DDATA/modbus/ahu_1")
+	assert.NoError(t, err)
+	assert.Equal(t, &Topic{GroupID: "rubix", Type: DDATA, EdgeNodeID: "modbus", DeviceID: "ahu_1"}, topic)
+	assert.Equal(t, "spBv1.0/rubix/DDATA/modbus/ahu_1", topic.String())
+	_, err = ParseTopic("spBv1.0/rubix/NDATA/modbus/ahu_1")
+	assert.Error(t, err)
+	_, err = ParseTopic("spBv1.0/rubix/XDATA/modbus")
+	assert.Error(t, err)
+}
+
+func TestEdgeNodeSession(t *testing.T) {
+	var sent []message
+	node := NewEdgeNode("rubix", network(), func(topic string, b []byte) error {
+		p, err := Unmarshal(b)
+		assert.NoError(t, err)
+		sent = append(sent, message{topic, p})
+		return nil
+	})
+	willTopic, will, err := node.NewSession()
+	assert.NoError(t, err)
+	assert.Equal(t, "spBv1.0/rubix/NDEATH/modbus", willTopic)
+	death, _ := Unmarshal(will)
+	assert.Equal(t, int64(0), death.Metrics[0].Value)
+
+	assert.Error(t, node.DeviceData("dev_1", nil), "not born yet")
+	assert.NoError(t, node.Birth(network()))
+	value := 22.0
+	assert.NoError(t, node.DeviceData("dev_1", map[string]*float64{"pnt_1": &value}))
+
+	assert.Len(t, sent, 3)
+	assert.Equal(t, "spBv1.0/rubix/NBIRTH/modbus", sent[0].topic)
+	assert.Equal(t, uint64(0), *sent[0].payload.Seq)
+	assert.Equal(t, "spBv1.0/rubix/DBIRTH/modbus/ahu_1", sent[1].topic)
+	assert.Equal(t, uint64(1), *sent[1].payload.Seq)
+	assert.Equal(t, 21.5, sent[1].payload.Metrics[0].Value)
+	assert.Equal(t, Boolean, sent[1].payload.Metrics[1].DataType)
+	assert.True(t, sent[1].payload.Metrics[1].IsNull)
+	assert.Equal(t, "spBv1.0/rubix/DDATA/modbus/ahu_1", sent[2].topic)
+	assert.Equal(t, uint64(2), *sent[2].payload.Seq)
+	assert.Equal(t, *sent[1].payload.Metrics[0].Alias, *sent[2].payload.Metrics[0].Alias)
+	assert.Equal(t, 22.0, sent[2].payload.Metrics[0].Value)
+
+	// the seq wraps after 255
+	for i := 0; i < 254; i++ {
+		assert.NoError(t, node.NodeData(nil))
+	}
+	assert.Equal(t, uint64(0), *sent[len(sent)-1].payload.Seq)
+
+	// without a will on the host connection the NDEATH is published by Death
+	assert.NoError(t, node.Death())
+	assert.Equal(t, "spBv1.0/rubix/NDEATH/modbus", sent[len(sent)-1].topic)
+	assert.Equal(t, int64(0), sent[len(sent)-1].payload.Metrics[0].Value)
+	assert.Nil(t, sent[len(sent)-1].payload.Seq)
+	assert.Error(t, node.NodeData(nil), "dead")
+}
+
+func TestDecodeCommand(t *testing.T) {
+	node := NewEdgeNode("rubix", network(), func(string, []byte) error { return nil })
+	_, _, _ = node.NewSession()
+	assert.NoError(t, node.Birth(network()))
+
+	b, _ := (&Payload{Metrics: []*Metric{{Name: rebirthMetric, DataType: Boolean, Value: true}}}).Marshal()
+	command, err := node.DecodeCommand("spBv1.0/rubix/NCMD/modbus", b)
+	assert.NoError(t, err)
+	assert.True(t, command.Rebirth)
+
+	alias := uint64(1)
+	b, _ = (&Payload{Metrics: []*Metric{
+		{Name: "temp", DataType: Double, Value: 19.5},
+		{Alias: &alias, DataType: Boolean, Value: true},
+	}}).Marshal()
+	command, err = node.DecodeCommand("spBv1.0/rubix/DCMD/modbus/ahu_1", b)
+	assert.NoError(t, err)
+	assert.Equal(t, "dev_1", command.DeviceUUID)
+	assert.Equal(t, "pnt_1", command.Writes[0].PointUUID)
+	assert.Equal(t, 19.5, *command.Writes[0].Value)
+	assert.Equal(t, "pnt_2", command.Writes[1].PointUUID)
+	assert.Equal(t, 1.0, *command.Writes[1].Value)
+
+	_, err = node.DecodeCommand("spBv1.0/rubix/DCMD/other/ahu_1", b)
+	assert.Error(t, err)
+}
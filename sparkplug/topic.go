@@ -0,0 +1,75 @@
+package sparkplug
+
+import (
+	"fmt"
+	"strings"
+)
+
+const Namespace = "spBv1.0"
+
+type MessageType string
+
+const (
+	NBIRTH MessageType = "NBIRTH"
+	NDEATH MessageType = "NDEATH"
+	DBIRTH MessageType = "DBIRTH"
+	DDEATH MessageType = "DDEATH"
+	NDATA  MessageType = "NDATA"
+	DDATA  MessageType = "DDATA"
+	NCMD   MessageType = "NCMD"
+	DCMD   MessageType = "DCMD"
+)
+
+func (t MessageType) valid() bool {
+	switch t {
+	case NBIRTH, NDEATH, DBIRTH, DDEATH, NDATA, DDATA, NCMD, DCMD:
+		return true
+	}
+	return false
+}
+
+// IsDevice reports whether the message type is addressed to a device rather than the edge node.
+func (t MessageType) IsDevice() bool {
+	return strings.HasPrefix(string(t), "D")
+}
+
+// Topic is spBv1.0/<group_id>/<message_type>/<edge_node_id>[/<device_id>].
+type Topic struct {
+	GroupID    string
+	Type       MessageType
+	EdgeNodeID string
+	DeviceID   string
+}
+
+func (t *Topic) String() string {
+	topic := fmt.Sprintf("%s/%s/%s/%s", Namespace, t.GroupID, t.Type, t.EdgeNodeID)
+	if t.DeviceID != "" {
+		topic += "/" + t.DeviceID
+	}
+	return topic
+}
+
+func ParseTopic(topic string) (*Topic, error) {
+	parts := strings.Split(topic, "/")
+	if len(parts) < 4 || len(parts) > 5 || parts[0] != Namespace {
+		return nil, fmt.Errorf("%s is not a sparkplug b topic", topic)
+	}
+	t := &Topic{GroupID: parts[1], Type: MessageType(parts[2]), EdgeNodeID: parts[3]}
+	if !t.Type.valid() {
+		return nil, fmt.Errorf("unknown sparkplug message type %s", parts[2])
+	}
+	if len(parts) == 5 {
+		t.DeviceID = parts[4]
+	}
+	if t.Type.IsDevice() != (t.DeviceID != "") {
+		return nil, fmt.Errorf("sparkplug topic %s has a device id mismatch for %s", topic, t.Type)
+	}
+	return t, nil
+}
+
+// ID makes a name usable as a sparkplug id, which can't contain / + or #.
+func ID(name string) string {
+	return idEscaper.Replace(name)
+}
+
+var idEscaper = strings.NewReplacer("/", "_", "+", "_", "#", "_")
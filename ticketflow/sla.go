@@ -0,0 +1,55 @@
+package ticketflow
+
+import (
+	"fmt"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"time"
+)
+
+// SLA targets are measured from the creation of the ticket, zero has no target.
+type SLA struct {
+	Response   time.Duration // until the ticket leaves NEW
+	Resolution time.Duration // until the ticket is RESOLVED or CLOSED
+}
+
+type Breach string
+
+const (
+	BreachResponse   Breach = "response"
+	BreachResolution Breach = "resolution"
+)
+
+// BreachEvent is published as json on a breach.
+type BreachEvent struct {
+	TicketUUID string                  `json:"ticket_uuid"`
+	Subject    string                  `json:"subject"`
+	Priority   datatype.TicketPriority `json:"priority"`
+	Status     datatype.TicketStatus   `json:"status"`
+	Breach     Breach                  `json:"breach"`
+	Due        time.Time               `json:"due"`
+}
+
+func breachComment(breach Breach, due time.Time) string {
+	return fmt.Sprintf("SLA breached: %s was due %s", breach, due.Format(time.RFC3339))
+}
+
+// breaches returns the targets of the sla which the ticket missed by now, with their due times.
+func (s *SLA) breaches(ticket *model.Ticket, now time.Time) map[Breach]time.Time {
+	breaches := map[Breach]time.Time{}
+	if s == nil || ticket.CreatedAt.IsZero() {
+		return breaches
+	}
+	if s.Response > 0 && ticket.Status == datatype.TicketStatusNew {
+		if due := ticket.CreatedAt.Add(s.Response); now.After(due) {
+			breaches[BreachResponse] = due
+		}
+	}
+	resolved := ticket.Status == datatype.TicketStatusResolved || ticket.Status == datatype.TicketStatusClosed
+	if s.Resolution > 0 && !resolved {
+		if due := ticket.CreatedAt.Add(s.Resolution); now.After(due) {
+			breaches[BreachResolution] = due
+		}
+	}
+	return breaches
+}
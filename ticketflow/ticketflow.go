@@ -0,0 +1,349 @@
+package ticketflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/NubeIO/lib-module-go/loop"
+	"github.com/NubeIO/lib-module-go/mail"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/nargs"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	Owner     string                           // owner of the comments
+	Workflow  *Workflow                        // DefaultWorkflow when nil
+	SLAs      map[datatype.TicketPriority]*SLA // priorities without an SLA have no targets
+	Email     bool                             // emails the members of the ticket teams on a breach
+	MqttTopic string                           // publishes a BreachEvent on a breach when set
+	SubDir    string                           // directory created inside the module dir by Open, ticketflow when empty
+}
+
+const breachFile = "breaches.json"
+
+var breachTemplate = func() *mail.Template {
+	t, err := mail.ParseTemplate("[{{.Values.priority}}] SLA {{.Values.breach}} breached: {{.Values.subject}}",
+		"{{.Values.comment}}")
+	if err != nil {
+		panic(err)
+	}
+	return t
+}()
+
+// Manager changes tickets through the workflow and tracks their SLAs.
+type Manager struct {
+	marshaller nmodule.Marshaller
+	config     *Config
+
+	mutex    sync.Mutex
+	mailer   *mail.Mailer
+	breaches map[string]map[Breach]string // comment uuid of each handled breach by ticket uuid
+	dir      string                       // the handled breaches are only kept in memory when empty
+	loop     loop.Loop
+	now      func() time.Time
+}
+
+// New keeps the handled breaches in memory, use Open so they aren't notified again after a restart.
+func New(marshaller nmodule.Marshaller, config *Config) (*Manager, error) {
+	c := *config
+	if c.Workflow == nil {
+		c.Workflow = DefaultWorkflow()
+	}
+	config = &c
+	if err := config.Workflow.Validate(); err != nil {
+		return nil, err
+	}
+	for priority, sla := range config.SLAs {
+		if err := priority.Validate(); err != nil {
+			return nil, err
+		}
+		if sla.Response < 0 || sla.Resolution < 0 {
+			return nil, fmt.Errorf("sla of %s has a negative duration", priority)
+		}
+	}
+	return &Manager{
+		marshaller: marshaller,
+		config:     config,
+		mailer:     mail.New(marshaller, &mail.Config{}),
+		breaches:   map[string]map[Breach]string{},
+		now:        time.Now,
+	}, nil
+}
+
+// Open keeps the handled breaches in a directory of the module.
+func Open(marshaller nmodule.Marshaller, moduleName string, config *Config) (*Manager, error) {
+	moduleDir, err := marshaller.CreateModuleDir(moduleName)
+	if err != nil {
+		return nil, err
+	}
+	subDir := config.SubDir
+	if subDir == "" {
+		subDir = "ticketflow"
+	}
+	return OpenDir(marshaller, filepath.Join(*moduleDir, subDir), config)
+}
+
+// OpenDir keeps the handled breaches in dir and loads the ones of the previous runs.
+func OpenDir(marshaller nmodule.Marshaller, dir string, config *Config) (*Manager, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	m, err := New(marshaller, config)
+	if err != nil {
+		return nil, err
+	}
+	m.dir = dir
+	data, err := os.ReadFile(filepath.Join(dir, breachFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err = json.Unmarshal(data, &m.breaches); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// SetMailer replaces the mailer of the breach emails, e.g. to share its rate limit with other emails of the module.
+func (m *Manager) SetMailer(mailer *mail.Mailer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.mailer = mailer
+}
+
+// breachComments returns the uuids of the breach comments of the ticket, they don't count as a reply.
+func (m *Manager) breachComments(ticketUUID string) map[string]bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	uuids := map[string]bool{}
+	for _, uuid := range m.breaches[ticketUUID] {
+		uuids[uuid] = true
+	}
+	return uuids
+}
+
+func (m *Manager) getTicket(uuid string) (*model.Ticket, error) {
+	return m.marshaller.GetTicket(uuid, &nmodule.Opts{
+		Args: &nargs.Args{WithComments: true, WithTeams: true, WithMembers: true},
+	})
+}
+
+// SetStatus moves the ticket to the status when the workflow allows it. A non-empty comment is added along with the
+// change and counts as a required comment.
+func (m *Manager) SetStatus(uuid string, status datatype.TicketStatus, comment string) error {
+	if err := status.Validate(); err != nil {
+		return err
+	}
+	ticket, err := m.getTicket(uuid)
+	if err != nil {
+		return err
+	}
+	if ticket.Status == status {
+		return nil
+	}
+	if strings.TrimSpace(comment) != "" {
+		ticket.Comments = append(ticket.Comments, &model.TicketComment{Content: comment})
+	}
+	if err = m.config.Workflow.check(ticket, status, m.breachComments(uuid)); err != nil {
+		return err
+	}
+	if strings.TrimSpace(comment) != "" {
+		_, err = m.marshaller.CreateTicketComment(&model.TicketComment{
+			TicketUUID: uuid,
+			Owner:      m.config.Owner,
+			Content:    comment,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return m.marshaller.UpsertTicketStatus(uuid, &dto.TicketStatus{Status: string(status)})
+}
+
+// SetPriority changes the priority of a ticket which is not in a final state.
+func (m *Manager) SetPriority(uuid string, priority datatype.TicketPriority) error {
+	if err := priority.Validate(); err != nil {
+		return err
+	}
+	ticket, err := m.marshaller.GetTicket(uuid)
+	if err != nil {
+		return err
+	}
+	if ticket.Priority == priority {
+		return nil
+	}
+	if m.config.Workflow.Final(ticket.Status) {
+		return fmt.Errorf("%w: priority of a %s ticket", ErrTransition, ticket.Status)
+	}
+	return m.marshaller.UpsertTicketPriority(uuid, &dto.TicketPriority{Priority: string(priority)})
+}
+
+// Breaches returns the SLA targets the ticket has missed.
+func (m *Manager) Breaches(ticket *model.Ticket) []Breach {
+	var breaches []Breach
+	for breach := range m.config.SLAs[ticket.Priority].breaches(ticket, m.now()) {
+		breaches = append(breaches, breach)
+	}
+	sort.Slice(breaches, func(i, j int) bool { return breaches[i] < breaches[j] })
+	return breaches
+}
+
+// Check comments on and notifies about each new breach of the open tickets, a breach is handled once.
+func (m *Manager) Check() error {
+	tickets, err := m.marshaller.GetTickets(&nmodule.Opts{Args: &nargs.Args{WithComments: true, WithTeams: true}})
+	if err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := m.now()
+	changed := false
+	exists := map[string]bool{}
+	var firstErr error
+	for _, ticket := range tickets {
+		exists[ticket.UUID] = true
+		breaches := m.config.SLAs[ticket.Priority].breaches(ticket, now)
+		for _, breach := range []Breach{BreachResponse, BreachResolution} {
+			due, ok := breaches[breach]
+			if _, handled := m.breaches[ticket.UUID][breach]; !ok || handled {
+				continue
+			}
+			if err = m.breach(ticket, breach, due); err != nil {
+				log.Errorf("ticketflow: ticket %s: %s", ticket.UUID, err)
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			changed = true
+		}
+	}
+	for uuid := range m.breaches {
+		if !exists[uuid] {
+			delete(m.breaches, uuid)
+			changed = true
+		}
+	}
+	if changed {
+		if err = m.save(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// save writes the handled breaches, the caller holds the mutex.
+func (m *Manager) save() error {
+	if m.dir == "" {
+		return nil
+	}
+	data, err := json.Marshal(m.breaches)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(m.dir, breachFile)
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// breach notifies first and then comments, the breach is only recorded as handled once both worked so a failed
+// notification is retried. The caller holds the mutex.
+func (m *Manager) breach(ticket *model.Ticket, breach Breach, due time.Time) error {
+	if m.config.MqttTopic != "" {
+		payload, err := json.Marshal(&BreachEvent{
+			TicketUUID: ticket.UUID,
+			Subject:    ticket.Subject,
+			Priority:   ticket.Priority,
+			Status:     ticket.Status,
+			Breach:     breach,
+			Due:        due,
+		})
+		if err != nil {
+			return err
+		}
+		if err = m.marshaller.Publish(m.config.MqttTopic, datatype.AtLeastOnce, false, string(payload)); err != nil {
+			return err
+		}
+	}
+	if m.config.Email {
+		if err := m.email(ticket, breach, due); err != nil {
+			return err
+		}
+	}
+	comment, err := m.marshaller.CreateTicketComment(&model.TicketComment{
+		TicketUUID: ticket.UUID,
+		Owner:      m.config.Owner,
+		Content:    breachComment(breach, due),
+	})
+	if err != nil {
+		return err
+	}
+	ticket.Comments = append(ticket.Comments, comment)
+	if m.breaches[ticket.UUID] == nil {
+		m.breaches[ticket.UUID] = map[Breach]string{}
+	}
+	m.breaches[ticket.UUID][breach] = comment.UUID
+	return nil
+}
+
+func (m *Manager) email(ticket *model.Ticket, breach Breach, due time.Time) error {
+	seen := map[string]bool{}
+	var recipients []string
+	for _, t := range ticket.Teams {
+		team, err := m.marshaller.GetTeam(t.TeamUUID, &nmodule.Opts{Args: &nargs.Args{WithMembers: true}})
+		if err != nil {
+			return err
+		}
+		for _, member := range team.Members {
+			if member.Email != "" && !seen[member.Email] {
+				seen[member.Email] = true
+				recipients = append(recipients, member.Email)
+			}
+		}
+	}
+	if len(recipients) == 0 {
+		log.Warnf("ticketflow: ticket %s has nobody to email", ticket.UUID)
+		return nil
+	}
+	_, err := m.mailer.Send(&mail.Message{
+		To:       recipients,
+		Template: breachTemplate,
+		Data: &mail.Data{Values: map[string]interface{}{
+			"priority": ticket.Priority,
+			"breach":   breach,
+			"subject":  ticket.Subject,
+			"comment":  breachComment(breach, due),
+		}},
+	})
+	if err == mail.ErrRateLimited {
+		log.Warnf("ticketflow: breach emails of ticket %s are rate limited", ticket.UUID)
+		return nil
+	}
+	return err
+}
+
+// Start calls Check every tick until Stop.
+func (m *Manager) Start(tick time.Duration) error {
+	return m.loop.Start(tick, false, func() {
+		if err := m.Check(); err != nil {
+			log.Errorf("ticketflow: %s", err)
+		}
+	})
+}
+
+func (m *Manager) Stop() {
+	m.loop.Stop()
+}
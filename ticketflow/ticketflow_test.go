@@ -0,0 +1,179 @@
+package ticketflow
+
+import (
+	"errors"
+	"fmt"
+	"github.com/NubeIO/lib-module-go/mail"
+	"github.com/NubeIO/lib-module-go/nmodule"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/dto"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type fakeMarshaller struct {
+	nmodule.Marshaller
+	ticket    *model.Ticket
+	published []string
+	emails    []*model.Email
+}
+
+func (f *fakeMarshaller) GetTicket(uuid string, opts ...*nmodule.Opts) (*model.Ticket, error) {
+	ticket := *f.ticket
+	ticket.Comments = append([]*model.TicketComment{}, f.ticket.Comments...)
+	return &ticket, nil
+}
+
+func (f *fakeMarshaller) GetTickets(opts ...*nmodule.Opts) ([]*model.Ticket, error) {
+	ticket, _ := f.GetTicket(f.ticket.UUID)
+	return []*model.Ticket{ticket}, nil
+}
+
+func (f *fakeMarshaller) UpsertTicketStatus(uuid string, body *dto.TicketStatus, opts ...*nmodule.Opts) error {
+	f.ticket.Status = datatype.TicketStatus(body.Status)
+	return nil
+}
+
+func (f *fakeMarshaller) UpsertTicketPriority(uuid string, body *dto.TicketPriority, opts ...*nmodule.Opts) error {
+	f.ticket.Priority = datatype.TicketPriority(body.Priority)
+	return nil
+}
+
+func (f *fakeMarshaller) CreateTicketComment(body *model.TicketComment, opts ...*nmodule.Opts) (*model.TicketComment, error) {
+	body.UUID = fmt.Sprintf("cmt_%d", len(f.ticket.Comments))
+	f.ticket.Comments = append(f.ticket.Comments, body)
+	return body, nil
+}
+
+func (f *fakeMarshaller) Publish(topic string, qos datatype.QOS, retain bool, payload string, opts ...*nmodule.Opts) error {
+	f.published = append(f.published, payload)
+	return nil
+}
+
+func (f *fakeMarshaller) GetTeam(uuid string, opts ...*nmodule.Opts) (*model.Team, error) {
+	return &model.Team{Members: []*model.Member{{Email: "a@nube-io.com"}, {Email: "a@nube-io.com"}}}, nil
+}
+
+func (f *fakeMarshaller) SendEmail(body *model.Email, opts ...*nmodule.Opts) (*model.Email, error) {
+	f.emails = append(f.emails, body)
+	return body, nil
+}
+
+func newTicket(created time.Time) *model.Ticket {
+	ticket := &model.Ticket{Status: datatype.TicketStatusNew, Priority: datatype.TicketPriorityHigh, Subject: "ahu fault"}
+	ticket.UUID = "tkt"
+	ticket.CreatedAt = created
+	ticket.Teams = []*model.TicketTeam{{TicketUUID: "tkt", TeamUUID: "ops"}}
+	return ticket
+}
+
+func TestSetStatus(t *testing.T) {
+	m := &fakeMarshaller{ticket: newTicket(time.Now())}
+	config := &Config{Owner: "rubix"}
+	manager, err := New(m, config)
+	assert.NoError(t, err)
+	assert.Nil(t, config.Workflow, "the caller's config is left as it is")
+
+	err = manager.SetStatus("tkt", datatype.TicketStatusResolved, "")
+	assert.True(t, errors.Is(err, ErrTransition))
+	assert.Equal(t, datatype.TicketStatusNew, m.ticket.Status)
+	assert.Empty(t, m.ticket.Comments)
+
+	assert.NoError(t, manager.SetStatus("tkt", datatype.TicketStatusResolved, "replaced the fan belt"))
+	assert.Equal(t, datatype.TicketStatusResolved, m.ticket.Status)
+	assert.Len(t, m.ticket.Comments, 1)
+
+	assert.NoError(t, manager.SetStatus("tkt", datatype.TicketStatusClosed, ""))
+	err = manager.SetStatus("tkt", datatype.TicketStatusReplied, "reopen")
+	assert.True(t, errors.Is(err, ErrTransition))
+	assert.Len(t, m.ticket.Comments, 1)
+	assert.True(t, errors.Is(manager.SetPriority("tkt", datatype.TicketPriorityLow), ErrTransition))
+	assert.Error(t, manager.SetStatus("tkt", "DONE", ""))
+}
+
+func TestRequiredFields(t *testing.T) {
+	m := &fakeMarshaller{ticket: newTicket(time.Now())}
+	workflow := DefaultWorkflow()
+	workflow.Required[datatype.TicketStatusReplied] = []Field{FieldMembers}
+	manager, err := New(m, &Config{Workflow: workflow})
+	assert.NoError(t, err)
+	assert.True(t, errors.Is(manager.SetStatus("tkt", datatype.TicketStatusReplied, "on it"), ErrTransition))
+	m.ticket.Members = []*model.TicketMember{{TicketUUID: "tkt", MemberUUID: "mem"}}
+	assert.NoError(t, manager.SetStatus("tkt", datatype.TicketStatusReplied, ""))
+
+	assert.NoError(t, manager.SetPriority("tkt", datatype.TicketPriorityCritical))
+	assert.Equal(t, datatype.TicketPriorityCritical, m.ticket.Priority)
+
+	workflow.Required[datatype.TicketStatusBlocked] = []Field{"owner"}
+	_, err = New(m, &Config{Workflow: workflow})
+	assert.Error(t, err)
+}
+
+func TestSLABreach(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	m := &fakeMarshaller{ticket: newTicket(now)}
+	manager, err := New(m, &Config{
+		Owner:     "rubix",
+		SLAs:      map[datatype.TicketPriority]*SLA{datatype.TicketPriorityHigh: {Response: time.Hour, Resolution: 4 * time.Hour}},
+		Email:     true,
+		MqttTopic: "tickets/sla",
+	})
+	assert.NoError(t, err)
+	manager.now = func() time.Time { return now }
+	manager.SetMailer(mail.New(m, &mail.Config{RateLimit: 1}))
+
+	assert.NoError(t, manager.Check())
+	assert.Empty(t, m.published)
+
+	now = now.Add(2 * time.Hour)
+	assert.Equal(t, []Breach{BreachResponse}, manager.Breaches(m.ticket))
+	assert.NoError(t, manager.Check())
+	assert.Len(t, m.published, 1)
+	assert.Len(t, m.emails, 1)
+	assert.Equal(t, "a@nube-io.com", m.emails[0].To)
+	assert.Equal(t, "[HIGH] SLA response breached: "+m.ticket.Subject, m.emails[0].Subject)
+	assert.Len(t, m.ticket.Comments, 1)
+
+	assert.NoError(t, manager.Check())
+	assert.Len(t, m.published, 1)
+
+	// a breach comment isn't a reply
+	assert.True(t, errors.Is(manager.SetStatus("tkt", datatype.TicketStatusReplied, ""), ErrTransition))
+	assert.NoError(t, manager.SetStatus("tkt", datatype.TicketStatusReplied, "looking"))
+
+	now = now.Add(3 * time.Hour)
+	assert.Equal(t, []Breach{BreachResolution}, manager.Breaches(m.ticket))
+	assert.NoError(t, manager.Check())
+	assert.Len(t, m.published, 2)
+	assert.Contains(t, m.published[1], `"breach":"resolution"`)
+	assert.Len(t, m.ticket.Comments, 3)
+	assert.Len(t, m.emails, 1, "rate limited by the mailer, the breach is still handled")
+}
+
+func TestBreachesSurviveRestart(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	m := &fakeMarshaller{ticket: newTicket(now)}
+	config := &Config{
+		SLAs:      map[datatype.TicketPriority]*SLA{datatype.TicketPriorityHigh: {Response: time.Hour}},
+		MqttTopic: "tickets/sla",
+	}
+	manager, err := OpenDir(m, dir, config)
+	assert.NoError(t, err)
+	manager.now = func() time.Time { return now.Add(2 * time.Hour) }
+
+	// a user comment which looks like a breach comment is a reply and doesn't mark the breach handled
+	m.ticket.Comments = []*model.TicketComment{{Content: "SLA breached: response, sorry"}}
+	m.ticket.Comments[0].UUID = "user"
+	assert.NoError(t, manager.Check())
+	assert.Len(t, m.published, 1)
+
+	manager, err = OpenDir(m, dir, config)
+	assert.NoError(t, err)
+	manager.now = func() time.Time { return now.Add(3 * time.Hour) }
+	assert.NoError(t, manager.Check())
+	assert.Len(t, m.published, 1, "handled before the restart")
+	assert.NoError(t, manager.SetStatus("tkt", datatype.TicketStatusReplied, ""))
+}
@@ -0,0 +1,126 @@
+package ticketflow
+
+import (
+	"errors"
+	"fmt"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/datatype"
+	"github.com/NubeIO/nubeio-rubix-lib-models-go/model"
+	"strings"
+)
+
+var ErrTransition = errors.New("ticket status change not allowed")
+
+// Field is something a ticket must have before it can enter a state.
+type Field string
+
+const (
+	FieldContent Field = "content"
+	FieldTeams   Field = "teams"
+	FieldMembers Field = "members"
+	FieldComment Field = "comment" // a comment other than the SLA breach comments
+)
+
+type Workflow struct {
+	Transitions map[datatype.TicketStatus][]datatype.TicketStatus // a state without transitions is final
+	Required    map[datatype.TicketStatus][]Field
+}
+
+// DefaultWorkflow lets resolved tickets be reopened and requires a comment to reply, block or resolve.
+func DefaultWorkflow() *Workflow {
+	return &Workflow{
+		Transitions: map[datatype.TicketStatus][]datatype.TicketStatus{
+			datatype.TicketStatusNew: {
+				datatype.TicketStatusReplied, datatype.TicketStatusBlocked, datatype.TicketStatusResolved,
+				datatype.TicketStatusClosed,
+			},
+			datatype.TicketStatusReplied: {
+				datatype.TicketStatusBlocked, datatype.TicketStatusResolved, datatype.TicketStatusClosed,
+			},
+			datatype.TicketStatusBlocked: {
+				datatype.TicketStatusReplied, datatype.TicketStatusResolved, datatype.TicketStatusClosed,
+			},
+			datatype.TicketStatusResolved: {datatype.TicketStatusReplied, datatype.TicketStatusClosed},
+		},
+		Required: map[datatype.TicketStatus][]Field{
+			datatype.TicketStatusReplied:  {FieldComment},
+			datatype.TicketStatusBlocked:  {FieldComment},
+			datatype.TicketStatusResolved: {FieldComment},
+		},
+	}
+}
+
+func (w *Workflow) Validate() error {
+	for from, tos := range w.Transitions {
+		if err := from.Validate(); err != nil {
+			return err
+		}
+		for _, to := range tos {
+			if err := to.Validate(); err != nil {
+				return err
+			}
+		}
+	}
+	for status, fields := range w.Required {
+		if err := status.Validate(); err != nil {
+			return err
+		}
+		for _, field := range fields {
+			switch field {
+			case FieldContent, FieldTeams, FieldMembers, FieldComment:
+			default:
+				return fmt.Errorf("unknown ticket field %s", field)
+			}
+		}
+	}
+	return nil
+}
+
+func (w *Workflow) CanTransition(from, to datatype.TicketStatus) bool {
+	for _, s := range w.Transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Final reports whether a ticket in the status can't be changed anymore.
+func (w *Workflow) Final(status datatype.TicketStatus) bool {
+	return len(w.Transitions[status]) == 0
+}
+
+// check returns an ErrTransition when the ticket can't move to the status. The ticket must be loaded with its teams,
+// members and comments, the comments in ignored don't count as a comment.
+func (w *Workflow) check(ticket *model.Ticket, to datatype.TicketStatus, ignored map[string]bool) error {
+	if !w.CanTransition(ticket.Status, to) {
+		return fmt.Errorf("%w: %s to %s", ErrTransition, ticket.Status, to)
+	}
+	var missing []string
+	for _, field := range w.Required[to] {
+		if !hasField(ticket, field, ignored) {
+			missing = append(missing, string(field))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s requires %s", ErrTransition, to, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func hasField(ticket *model.Ticket, field Field, ignored map[string]bool) bool {
+	switch field {
+	case FieldContent:
+		return strings.TrimSpace(ticket.Content) != ""
+	case FieldTeams:
+		return len(ticket.Teams) > 0
+	case FieldMembers:
+		return len(ticket.Members) > 0
+	case FieldComment:
+		for _, c := range ticket.Comments {
+			if c.UUID == "" || !ignored[c.UUID] {
+				return true
+			}
+		}
+	}
+	return false
+}